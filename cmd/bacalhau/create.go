@@ -141,10 +141,23 @@ var createCmd = &cobra.Command{
 			return err
 		}
 
+		parsedContexts, err := model.EnsureStorageSpecsSourceTypes(jobSpec.Contexts)
+		if err != nil {
+			return err
+		}
+
 		jobSpec.Engine = engineType
 		jobSpec.Verifier = verifierType
 		jobSpec.Publisher = publisherType
 		jobSpec.Inputs = parsedInputs
+		jobSpec.Contexts = parsedContexts
+
+		if len(jobSpec.Secrets) > 0 {
+			OC.RunTimeSettings.SecretValues, err = secretValuesFromEnv(jobSpec.Secrets)
+			if err != nil {
+				return err
+			}
+		}
 
 		jobDeal := &model.JobDeal{
 			Concurrency: OC.Concurrency,
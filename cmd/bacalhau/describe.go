@@ -4,6 +4,7 @@ import (
 	"sort"
 	"time"
 
+	"github.com/c2h5oh/datasize"
 	jobutils "github.com/filecoin-project/bacalhau/pkg/job"
 	"github.com/filecoin-project/bacalhau/pkg/model"
 	"github.com/filecoin-project/bacalhau/pkg/system"
@@ -72,6 +73,19 @@ type shardNodeStateDescription struct {
 	Status   string `yaml:"Status"`
 	Verified bool   `yaml:"Verified"`
 	ResultID string `yaml:"ResultID"`
+	// the node labels that matched the job's node selectors
+	MatchedLabels []string `yaml:"Matched Labels,omitempty"`
+	// the fraction of the input data the node had locally when it bid
+	LocalityScore float64 `yaml:"Locality Score,omitempty"`
+	// what the shard actually used on the node
+	ResourceUsage *resourceUsageDescription `yaml:"Resource Usage,omitempty"`
+}
+
+type resourceUsageDescription struct {
+	CPU         string `yaml:"CPU Time"`
+	MemoryPeak  string `yaml:"Memory Peak"`
+	DiskWritten string `yaml:"Disk Written"`
+	WallTime    string `yaml:"Wall Time"`
 }
 
 type shardStateDescription struct {
@@ -92,10 +106,16 @@ type jobDescription struct {
 }
 
 type jobSpecDescription struct {
-	Engine     string                   `yaml:"Engine"`
-	Verifier   string                   `yaml:"Verifier"`
-	Docker     jobSpecDockerDescription `yaml:"Docker"`
-	Deployment jobDealDescription       `yaml:"Deployment"`
+	Engine        string                   `yaml:"Engine"`
+	Verifier      string                   `yaml:"Verifier"`
+	Docker        jobSpecDockerDescription `yaml:"Docker"`
+	Deployment    jobDealDescription       `yaml:"Deployment"`
+	NodeSelectors []string                 `yaml:"Node Selectors,omitempty"`
+	Network       string                   `yaml:"Network"`
+	Domains       []string                 `yaml:"Domains,omitempty"`
+	Timeout       string                   `yaml:"Timeout,omitempty"`
+	Checkpoint    string                   `yaml:"Checkpoint,omitempty"`
+	Secrets       []string                 `yaml:"Secrets,omitempty"`
 }
 
 type jobSpecDockerDescription struct {
@@ -181,6 +201,23 @@ var describeCmd = &cobra.Command{
 		jobSpecDesc.Verifier = j.Spec.Verifier.String()
 		jobSpecDesc.Docker = jobDockerDesc
 
+		for _, selector := range j.Spec.NodeSelectors {
+			jobSpecDesc.NodeSelectors = append(jobSpecDesc.NodeSelectors, selector.String())
+		}
+
+		jobSpecDesc.Network = model.NetworkNone.String()
+		if !j.Spec.Network.Disabled() {
+			jobSpecDesc.Network = j.Spec.Network.Type.String()
+			jobSpecDesc.Domains = j.Spec.Network.Domains
+		}
+		if j.Spec.Timeout > 0 {
+			jobSpecDesc.Timeout = secondsToDuration(j.Spec.Timeout).String()
+		}
+		jobSpecDesc.Checkpoint = j.Spec.Checkpoint.Path
+		for _, secret := range j.Spec.Secrets {
+			jobSpecDesc.Secrets = append(jobSpecDesc.Secrets, secret.Name)
+		}
+
 		jobDesc := jobDescription{}
 		jobDesc.ID = j.ID
 		jobDesc.ClientID = j.ClientID
@@ -201,11 +238,14 @@ var describeCmd = &cobra.Command{
 				}
 			}
 			shardDescription.Nodes = append(shardDescription.Nodes, shardNodeStateDescription{
-				Node:     shard.NodeID,
-				State:    shard.State.String(),
-				Status:   shard.Status,
-				Verified: shard.VerificationResult.Result,
-				ResultID: shard.PublishedResult.Cid,
+				Node:          shard.NodeID,
+				State:         shard.State.String(),
+				Status:        shard.Status,
+				Verified:      shard.VerificationResult.Result,
+				ResultID:      shard.PublishedResult.Cid,
+				MatchedLabels: model.LabelsToStrings(shard.MatchedNodeLabels),
+				LocalityScore: shard.LocalityScore,
+				ResourceUsage: describeResourceUsage(shard.ResourceUsage),
 			})
 			shardDescriptions[shard.ShardIndex] = shardDescription
 		}
@@ -258,3 +298,19 @@ var describeCmd = &cobra.Command{
 		return nil
 	},
 }
+
+func describeResourceUsage(usage *model.ShardResourceUsage) *resourceUsageDescription {
+	if usage == nil {
+		return nil
+	}
+	return &resourceUsageDescription{
+		CPU:         secondsToDuration(usage.CPUSeconds).String(),
+		MemoryPeak:  datasize.ByteSize(usage.MemoryPeak).HR(),
+		DiskWritten: datasize.ByteSize(usage.DiskWritten).HR(),
+		WallTime:    secondsToDuration(usage.WallTime).String(),
+	}
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second)).Round(time.Millisecond)
+}
@@ -48,6 +48,8 @@ type DockerRunOptions struct {
 	Inputs        []string // Array of input CIDs
 	InputUrls     []string // Array of input URLs (will be copied to IPFS)
 	InputVolumes  []string // Array of input volumes in 'CID:mount point' form
+	InputGit      []string // Array of git repositories in 'repo#commit:mount point' form
+	ContextGit    []string // Array of git repositories in 'repo#commit:mount point' form that are not sharded
 	OutputVolumes []string // Array of output volumes in 'name:mount point' form
 	Env           []string // Array of environment variables
 	Concurrency   int      // Number of concurrent jobs to run
@@ -58,6 +60,17 @@ type DockerRunOptions struct {
 	GPU           string
 	WorkingDir    string   // Working directory for docker
 	Labels        []string // Labels for the job on the Bacalhau network (for searching)
+	NodeSelector  string   // Selector (label query) to filter nodes on which this job can be executed
+	DoNotCache    bool     // Always run the job rather than using cached results from an identical job
+	Network       string   // The network access the job needs: none, allowlist or full
+	Domains       []string // The domains an allowlist job can reach
+	Timeout       float64  // How long each shard can run for in seconds
+
+	CheckpointPath     string  // Where the job's checkpoint volume is mounted, empty for none
+	CheckpointInterval float64 // How often in seconds the checkpoint volume is snapshotted
+
+	SecretEnv   []string // Env vars the job gets the value of our own env var of the same name in, kept secret
+	SecretFiles []string // Files the job can read secrets from in 'PATH=LOCALFILE' form
 
 	Image      string   // Image to execute
 	Entrypoint []string // Entrypoint to the docker image
@@ -81,6 +94,8 @@ func NewDockerRunOptions() *DockerRunOptions {
 		Inputs:             []string{},
 		InputUrls:          []string{},
 		InputVolumes:       []string{},
+		InputGit:           []string{},
+		ContextGit:         []string{},
 		OutputVolumes:      []string{},
 		Env:                []string{},
 		Concurrency:        1,
@@ -92,6 +107,15 @@ func NewDockerRunOptions() *DockerRunOptions {
 		SkipSyntaxChecking: false,
 		WorkingDir:         "",
 		Labels:             []string{},
+		NodeSelector:       "",
+		DoNotCache:         false,
+		Network:            model.NetworkNone.String(),
+		Domains:            []string{},
+		Timeout:            0,
+		CheckpointPath:     "",
+		CheckpointInterval: 0,
+		SecretEnv:          []string{},
+		SecretFiles:        []string{},
 		DownloadFlags:      *ipfs.NewIPFSDownloadSettings(),
 		RunTimeSettings:    *NewRunTimeSettings(),
 
@@ -127,12 +151,24 @@ func init() { //nolint:gochecknoinits,funlen // Using init in cobra command is i
 		&ODR.InputUrls, "input-urls", "u", ODR.InputUrls,
 		`URL:path of the input data volumes downloaded from a URL source. Mounts data at 'path' (e.g. '-u http://foo.com/bar.tar.gz:/app/bar.tar.gz'
 		mounts 'http://foo.com/bar.tar.gz' at '/app/bar.tar.gz'). URL can specify a port number (e.g. 'https://foo.com:443/bar.tar.gz:/app/bar.tar.gz')
-		and supports HTTP and HTTPS.`,
+		and supports HTTP and HTTPS. 's3://bucket/key' URLs are read from the S3-compatible service the compute nodes are set up with, if they allow the bucket: an object is mounted
+		as a file and a prefix as a directory of the objects under it, and '?versionId=' pins an object's version. An HTTP URL can end with
+		'#sha256=HEX' (or md5, sha1, sha512) to check the download, and '#explode=lines' or '#explode=manifest' if it is a list of URLs
+		(one per line, or a JSON array of {"url", "path", "checksum"}) to mount as a directory - each URL is its own shard when sharding.
+		'#extract=auto' (or tar, tar.gz, zip) mounts the contents of an archive as a directory, and shards over the files in it.`,
 	)
 	dockerRunCmd.PersistentFlags().StringSliceVarP(
 		&ODR.InputVolumes, "input-volumes", "v", ODR.InputVolumes,
 		`CID:path of the input data volumes, if you need to set the path of the mounted data.`,
 	)
+	dockerRunCmd.PersistentFlags().StringSliceVar(
+		&ODR.InputGit, "input-git", ODR.InputGit,
+		`REPO#COMMIT:path of a git repository checked out at a commit, given by its full hash, and mounted at 'path'. It can be sharded over like other inputs.`, //nolint:lll // Documentation, ok if long.
+	)
+	dockerRunCmd.PersistentFlags().StringSliceVar(
+		&ODR.ContextGit, "context-git", ODR.ContextGit,
+		`REPO#COMMIT:path of a git repository checked out at a commit and mounted at 'path' in every shard, e.g. the code the job runs.`, //nolint:lll // Documentation, ok if long.
+	)
 	dockerRunCmd.PersistentFlags().StringSliceVarP(
 		&ODR.OutputVolumes, "output-volumes", "o", ODR.OutputVolumes,
 		`name:path of the output data volumes. 'outputs:/outputs' is always added.`,
@@ -180,12 +216,54 @@ func init() { //nolint:gochecknoinits,funlen // Using init in cobra command is i
 		`List of labels for the job. Enter multiple in the format '-l a -l 2'. All characters not matching /a-zA-Z0-9_:|-/ and all emojis will be stripped.`, //nolint:lll // Documentation, ok if long.
 	)
 
+	dockerRunCmd.PersistentFlags().StringVarP(
+		&ODR.NodeSelector, "selector", "s", ODR.NodeSelector,
+		`Selector (label query) to filter nodes on which this job can be executed, supports '=', '==', '!=', 'in', 'notin' and '!key' (e.g. -s region=eu,tier in (trusted)). Only nodes whose labels satisfy all of the constraints will bid on the job.`, //nolint:lll // Documentation, ok if long.
+	)
+
+	dockerRunCmd.PersistentFlags().BoolVar(
+		&ODR.DoNotCache, "do-not-cache", ODR.DoNotCache,
+		`Always run the job, even if a compute node has cached results from an identical job.`,
+	)
+
+	dockerRunCmd.PersistentFlags().StringVar(
+		&ODR.Network, "network", ODR.Network,
+		`The network access the job needs: "none", "allowlist" (HTTP(S) to the --domain list only) or "full". Only nodes that allow this much network access will run the job.`, //nolint:lll // Documentation, ok if long.
+	)
+	dockerRunCmd.PersistentFlags().StringSliceVar(
+		&ODR.Domains, "domain", ODR.Domains,
+		`A domain an allowlist job can reach, a leading dot also allows subdomains (e.g. --domain .example.com). Can be given more than once. Domains that resolve to private or loopback addresses are refused.`, //nolint:lll // Documentation, ok if long.
+	)
+
+	dockerRunCmd.PersistentFlags().Float64Var(
+		&ODR.Timeout, "timeout", ODR.Timeout,
+		`How long each shard can run for in seconds before it is killed. 0 means the compute node's maximum.`,
+	)
+	dockerRunCmd.PersistentFlags().StringVar(
+		&ODR.CheckpointPath, "checkpoint-path", ODR.CheckpointPath,
+		`Mount a writable checkpoint volume at this path. It is snapshotted and published while the job runs and a shard that has to run again starts with its latest snapshot.`, //nolint:lll // Documentation, ok if long.
+	)
+	dockerRunCmd.PersistentFlags().Float64Var(
+		&ODR.CheckpointInterval, "checkpoint-interval", ODR.CheckpointInterval,
+		`How often in seconds the checkpoint volume is snapshotted. 0 means the compute node's default.`,
+	)
+	dockerRunCmd.PersistentFlags().StringSliceVar(
+		&ODR.SecretEnv, "secret-env", ODR.SecretEnv,
+		`Give the job the value of this env var in an env var of the same name. The value is only sent to the nodes that run the job, encrypted, and is not stored anywhere (implies --wait).`, //nolint:lll // Documentation, ok if long.
+	)
+	dockerRunCmd.PersistentFlags().StringSliceVar(
+		&ODR.SecretFiles, "secret-file", ODR.SecretFiles,
+		`Give the job the contents of a local file as a secret it can read from a path, in 'PATH=LOCALFILE' form (implies --wait).`, //nolint:lll // Documentation, ok if long.
+	)
+
 	dockerRunCmd.Flags().IntVar(&ODR.DownloadFlags.TimeoutSecs, "download-timeout-secs",
 		ODR.DownloadFlags.TimeoutSecs, "Timeout duration for IPFS downloads.")
 	dockerRunCmd.Flags().StringVar(&ODR.DownloadFlags.OutputDir, "output-dir",
 		ODR.DownloadFlags.OutputDir, "Directory to write the output to.")
 	dockerRunCmd.Flags().StringVar(&ODR.DownloadFlags.IPFSSwarmAddrs, "ipfs-swarm-addrs",
 		ODR.DownloadFlags.IPFSSwarmAddrs, "Comma-separated list of IPFS nodes to connect to.")
+	dockerRunCmd.Flags().Int64Var(&ODR.DownloadFlags.MaxLogSize, "max-log-size",
+		ODR.DownloadFlags.MaxLogSize, "The most bytes of stdout and stderr from all the shards to concatenate into the output dir.")
 
 	dockerRunCmd.PersistentFlags().StringVar(
 		&ODR.ShardingGlobPattern, "sharding-glob-pattern", ODR.ShardingGlobPattern,
@@ -272,6 +350,7 @@ func CreateJobSpecAndDeal(ctx context.Context,
 		TimeoutSecs:    odr.DownloadFlags.TimeoutSecs,
 		OutputDir:      odr.DownloadFlags.OutputDir,
 		IPFSSwarmAddrs: strings.Join(system.Envs[system.Production].IPFSSwarmAddresses, ","),
+		MaxLogSize:     odr.DownloadFlags.MaxLogSize,
 	}
 
 	if odr.RunTimeSettings.WaitForJobToFinishAndPrintOutput {
@@ -332,5 +411,48 @@ func CreateJobSpecAndDeal(ctx context.Context,
 		return &model.JobSpec{}, &model.JobDeal{}, errors.Wrap(err, "CreateJobSpecAndDeal:")
 	}
 
+	jobSpec.NodeSelectors, err = jobutils.ParseNodeSelector(odr.NodeSelector)
+	if err != nil {
+		return &model.JobSpec{}, &model.JobDeal{}, errors.Wrap(err, "CreateJobSpecAndDeal:")
+	}
+	jobSpec.DoNotCache = odr.DoNotCache
+	jobSpec.Timeout = odr.Timeout
+	jobSpec.Checkpoint = model.JobSpecCheckpoint{
+		Path:     odr.CheckpointPath,
+		Interval: odr.CheckpointInterval,
+	}
+
+	for _, volume := range odr.InputGit {
+		spec, err := jobutils.ParseGitVolume(volume)
+		if err != nil {
+			return &model.JobSpec{}, &model.JobDeal{}, errors.Wrap(err, "CreateJobSpecAndDeal:")
+		}
+		jobSpec.Inputs = append(jobSpec.Inputs, spec)
+	}
+	for _, volume := range odr.ContextGit {
+		spec, err := jobutils.ParseGitVolume(volume)
+		if err != nil {
+			return &model.JobSpec{}, &model.JobDeal{}, errors.Wrap(err, "CreateJobSpecAndDeal:")
+		}
+		jobSpec.Contexts = append(jobSpec.Contexts, spec)
+	}
+
+	jobSpec.Secrets, odr.RunTimeSettings.SecretValues, err = parseSecretFlags(odr.SecretEnv, odr.SecretFiles)
+	if err != nil {
+		return &model.JobSpec{}, &model.JobDeal{}, errors.Wrap(err, "CreateJobSpecAndDeal:")
+	}
+
+	networkType, err := model.ParseNetwork(odr.Network)
+	if err != nil {
+		return &model.JobSpec{}, &model.JobDeal{}, errors.Wrap(err, "CreateJobSpecAndDeal:")
+	}
+	if networkType != model.NetworkAllowlist && len(odr.Domains) > 0 {
+		return &model.JobSpec{}, &model.JobDeal{}, fmt.Errorf("--domain can only be used with --network=allowlist")
+	}
+	jobSpec.Network = model.NetworkConfig{
+		Type:    networkType,
+		Domains: odr.Domains,
+	}
+
 	return jobSpec, jobDeal, nil
 }
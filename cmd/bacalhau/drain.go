@@ -0,0 +1,149 @@
+package bacalhau
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/filecoin-project/bacalhau/pkg/system"
+	"github.com/filecoin-project/bacalhau/pkg/util/templates"
+	"github.com/spf13/cobra"
+	"k8s.io/kubectl/pkg/util/i18n"
+)
+
+var (
+	drainLong = templates.LongDesc(i18n.T(`
+		Put the local compute node into drain mode.
+
+		A draining node stops selecting and bidding on new jobs and withdraws
+		any bids that have not been accepted yet. Shards that are already running
+		are left to finish and publish their results. Once nothing is left in
+		progress the node is safe to shut down.
+
+		This must be run on the same machine as the node.
+`))
+
+	//nolint:lll // Documentation
+	drainExample = templates.Examples(i18n.T(`
+		# Drain the node and return straight away
+		bacalhau drain
+
+		# Drain the node and wait until it is safe to shut down
+		bacalhau drain --wait
+
+		# Start taking on new jobs again
+		bacalhau undrain`))
+
+	// Set Defaults (probably a better way to do this)
+	ODrain = NewDrainOptions()
+)
+
+type DrainOptions struct {
+	Wait        bool // Wait until the node is safe to shut down
+	WaitTimeout int  // How long to wait in seconds before giving up
+}
+
+func NewDrainOptions() *DrainOptions {
+	return &DrainOptions{
+		Wait:        false,
+		WaitTimeout: 3600,
+	}
+}
+
+const drainStatusPollInterval = time.Second
+
+func init() { //nolint:gochecknoinits // Using init in cobra command is idomatic
+	drainCmd.PersistentFlags().BoolVar(
+		&ODrain.Wait, "wait", ODrain.Wait,
+		`Wait until all running shards have finished and the node is safe to shut down.`,
+	)
+	drainCmd.PersistentFlags().IntVar(
+		&ODrain.WaitTimeout, "wait-timeout-secs", ODrain.WaitTimeout,
+		`When using --wait, how many seconds to wait for running shards to finish.`,
+	)
+}
+
+var drainCmd = &cobra.Command{
+	Use:     "drain",
+	Short:   "Stop the local compute node from taking on new jobs",
+	Long:    drainLong,
+	Example: drainExample,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cm := system.NewCleanupManager()
+		defer cm.Cleanup()
+		ctx := cmd.Context()
+
+		t := system.GetTracer()
+		ctx, rootSpan := system.NewRootSpan(ctx, t, "cmd/bacalhau/drain")
+		defer rootSpan.End()
+		cm.RegisterCallback(system.CleanupTraceProvider)
+
+		apiClient := GetAPIClient()
+		status, err := apiClient.Drain(ctx)
+		if err != nil {
+			return err
+		}
+		printDrainStatus(cmd, status)
+		if !ODrain.Wait || status.SafeToShutdown {
+			return nil
+		}
+
+		deadline := time.Now().Add(time.Duration(ODrain.WaitTimeout) * time.Second)
+		ticker := time.NewTicker(drainStatusPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			status, err = apiClient.DrainStatus(ctx)
+			if err != nil {
+				return err
+			}
+			if !status.Draining {
+				return fmt.Errorf("node was undrained while waiting")
+			}
+			if status.SafeToShutdown {
+				printDrainStatus(cmd, status)
+				return nil
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("timed out waiting for %d shards to finish", status.ShardsInProgress)
+			}
+		}
+	},
+}
+
+var undrainCmd = &cobra.Command{
+	Use:   "undrain",
+	Short: "Let the local compute node take on new jobs again",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cm := system.NewCleanupManager()
+		defer cm.Cleanup()
+		ctx := cmd.Context()
+
+		t := system.GetTracer()
+		ctx, rootSpan := system.NewRootSpan(ctx, t, "cmd/bacalhau/undrain")
+		defer rootSpan.End()
+		cm.RegisterCallback(system.CleanupTraceProvider)
+
+		status, err := GetAPIClient().Undrain(ctx)
+		if err != nil {
+			return err
+		}
+		printDrainStatus(cmd, status)
+		return nil
+	},
+}
+
+func printDrainStatus(cmd *cobra.Command, status model.DrainStatus) {
+	switch {
+	case !status.Draining:
+		cmd.Printf("Node is accepting new jobs (%d shards in progress)\n", status.ShardsInProgress)
+	case status.SafeToShutdown:
+		cmd.Printf("Node is drained and safe to shut down\n")
+	default:
+		cmd.Printf("Node is draining, waiting for %d shards to finish\n", status.ShardsInProgress)
+	}
+}
@@ -39,6 +39,7 @@ func NewGetOptions() *GetOptions {
 			TimeoutSecs:    600,
 			OutputDir:      ".",
 			IPFSSwarmAddrs: "",
+			MaxLogSize:     system.DefaultMaxLogSize,
 		},
 	}
 }
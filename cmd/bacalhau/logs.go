@@ -0,0 +1,133 @@
+package bacalhau
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/filecoin-project/bacalhau/pkg/system"
+	"github.com/filecoin-project/bacalhau/pkg/util/templates"
+	"github.com/spf13/cobra"
+	"k8s.io/kubectl/pkg/util/i18n"
+)
+
+var (
+	logsLong = templates.LongDesc(i18n.T(`
+		Show the output of a shard of a job.
+
+		The output is fetched from the compute node running the shard, so it is
+		available while the shard is running and for a short time afterwards.
+		Use 'bacalhau get' to download the output once the job has completed.
+		Short form and long form of the job id are accepted.
+`))
+
+	//nolint:lll // Documentation
+	logsExample = templates.Examples(i18n.T(`
+		# Show the output so far of the first shard of a job
+		bacalhau logs 47805f5c
+
+		# Keep following the output until the shard finishes
+		bacalhau logs -f 47805f5c
+
+		# Follow the output of the third shard
+		bacalhau logs -f 47805f5c --shard 2`))
+
+	// Set Defaults (probably a better way to do this)
+	OLogs = NewLogsOptions()
+)
+
+type LogsOptions struct {
+	Follow     bool // Keep streaming output until the shard finishes
+	ShardIndex int  // Which shard to show the output of
+}
+
+func NewLogsOptions() *LogsOptions {
+	return &LogsOptions{
+		Follow:     false,
+		ShardIndex: 0,
+	}
+}
+
+const logsRetryInterval = time.Second
+
+func init() { //nolint:gochecknoinits // Using init in cobra command is idomatic
+	logsCmd.PersistentFlags().BoolVarP(
+		&OLogs.Follow, "follow", "f", OLogs.Follow,
+		`Keep streaming the output until the shard finishes, waiting for it to start if needed.`,
+	)
+	logsCmd.PersistentFlags().IntVar(
+		&OLogs.ShardIndex, "shard", OLogs.ShardIndex,
+		`The index of the shard to show the output of.`,
+	)
+}
+
+var logsCmd = &cobra.Command{
+	Use:     "logs [id]",
+	Short:   "Show the output of a running or recently finished job",
+	Long:    logsLong,
+	Example: logsExample,
+	Args:    cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, cmdArgs []string) error {
+		cm := system.NewCleanupManager()
+		defer cm.Cleanup()
+		ctx := cmd.Context()
+
+		t := system.GetTracer()
+		ctx, rootSpan := system.NewRootSpan(ctx, t, "cmd/bacalhau/logs")
+		defer rootSpan.End()
+		cm.RegisterCallback(system.CleanupTraceProvider)
+
+		client := GetAPIClient()
+		j, ok, err := client.Get(ctx, cmdArgs[0])
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("no job ID found matching ID: %s", cmdArgs[0])
+		}
+		if OLogs.ShardIndex < 0 || OLogs.ShardIndex >= j.ExecutionPlan.TotalShards {
+			return fmt.Errorf("job %s has %d shards so there is no shard %d", j.ID, j.ExecutionPlan.TotalShards, OLogs.ShardIndex)
+		}
+
+		writeChunk := func(chunk model.LogChunk) error {
+			out := cmd.OutOrStdout()
+			if chunk.Stream == model.LogStreamStderr {
+				out = cmd.ErrOrStderr()
+			}
+			_, writeErr := out.Write(chunk.Data)
+			return writeErr
+		}
+
+		for {
+			err = client.Logs(ctx, j.ID, OLogs.ShardIndex, OLogs.Follow, writeChunk)
+			if err == nil || !OLogs.Follow {
+				return err
+			}
+
+			// the shard might not have started yet, in which case
+			// we keep trying until it has or it has given up
+			jobState, stateErr := client.GetJobState(ctx, j.ID)
+			if stateErr != nil {
+				return stateErr
+			}
+			if shardFinished(jobState, OLogs.ShardIndex) {
+				return err
+			}
+			select {
+			case <-time.After(logsRetryInterval):
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	},
+}
+
+// has any node finished running the shard
+func shardFinished(jobState model.JobState, shardIndex int) bool {
+	for _, nodeState := range jobState.Nodes {
+		if shardState, ok := nodeState.Shards[shardIndex]; ok && shardState.State.IsComplete() {
+			return true
+		}
+	}
+	return false
+}
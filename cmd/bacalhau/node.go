@@ -0,0 +1,150 @@
+package bacalhau
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/filecoin-project/bacalhau/pkg/system"
+	"github.com/filecoin-project/bacalhau/pkg/util/templates"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+	"k8s.io/kubectl/pkg/util/i18n"
+)
+
+var (
+	nodeListLong = templates.LongDesc(i18n.T(`
+		List the compute nodes that have recently sent a heartbeat to the network.
+`))
+
+	//nolint:lll // Documentation
+	nodeListExample = templates.Examples(i18n.T(`
+		# List compute nodes on the network
+		bacalhau node list
+
+		# List compute nodes and output as json
+		bacalhau node list --output json`))
+
+	// Set Defaults (probably a better way to do this)
+	ONL = NewNodeListOptions()
+)
+
+type NodeListOptions struct {
+	HideHeader   bool   // Hide the column headers
+	NoStyle      bool   // Remove all styling from table output.
+	OutputFormat string // The output format for the list of nodes (json or text)
+	OutputWide   bool   // Print full values in the table results
+}
+
+func NewNodeListOptions() *NodeListOptions {
+	return &NodeListOptions{
+		HideHeader:   false,
+		NoStyle:      false,
+		OutputFormat: "text",
+		OutputWide:   false,
+	}
+}
+
+func init() { //nolint:gochecknoinits // Using init in cobra command is idomatic
+	nodeListCmd.PersistentFlags().BoolVar(&ONL.HideHeader, "hide-header", ONL.HideHeader,
+		`do not print the column headers.`)
+	nodeListCmd.PersistentFlags().BoolVar(&ONL.NoStyle, "no-style", ONL.NoStyle, `remove all styling from table output.`)
+	nodeListCmd.PersistentFlags().StringVar(
+		&ONL.OutputFormat, "output", ONL.OutputFormat,
+		`The output format for the list of nodes (json or text)`,
+	)
+	nodeListCmd.PersistentFlags().BoolVar(
+		&ONL.OutputWide, "wide", ONL.OutputWide,
+		`Print full values in the table results`,
+	)
+
+	nodeCmd.AddCommand(nodeListCmd)
+}
+
+var nodeCmd = &cobra.Command{
+	Use:   "node",
+	Short: "Commands to inspect the compute nodes on the network",
+}
+
+var nodeListCmd = &cobra.Command{
+	Use:     "list",
+	Short:   "List compute nodes on the network",
+	Long:    nodeListLong,
+	Example: nodeListExample,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cm := system.NewCleanupManager()
+		defer cm.Cleanup()
+		ctx := cmd.Context()
+
+		t := system.GetTracer()
+		ctx, rootSpan := system.NewRootSpan(ctx, t, "cmd/bacalhau/node/list")
+		defer rootSpan.End()
+		cm.RegisterCallback(system.CleanupTraceProvider)
+
+		nodes, err := GetAPIClient().Nodes(ctx)
+		if err != nil {
+			return err
+		}
+
+		if ONL.OutputFormat == JSONFormat {
+			msgBytes, err := json.MarshalIndent(nodes, "", "    ")
+			if err != nil {
+				return err
+			}
+			cmd.Printf("%s\n", msgBytes)
+			return nil
+		}
+
+		tw := table.NewWriter()
+		tw.SetOutputMirror(cmd.OutOrStdout())
+		if !ONL.HideHeader {
+			tw.AppendHeader(table.Row{"id", "version", "labels", "engines", "free cpu", "free memory", "free gpu", "last seen"})
+		}
+
+		for i := range nodes {
+			tw.AppendRow(nodeInfoRow(&nodes[i], ONL.OutputWide))
+		}
+
+		if ONL.NoStyle {
+			tw.SetStyle(table.Style{
+				Name:   "StyleDefault",
+				Box:    table.StyleBoxDefault,
+				Color:  table.ColorOptionsDefault,
+				Format: table.FormatOptionsDefault,
+				HTML:   table.DefaultHTMLOptions,
+				Options: table.Options{
+					DrawBorder:      false,
+					SeparateColumns: false,
+					SeparateFooter:  false,
+					SeparateHeader:  false,
+					SeparateRows:    false,
+				},
+				Title: table.TitleOptionsDefault,
+			})
+		} else {
+			tw.SetStyle(table.StyleColoredGreenWhiteOnBlack)
+		}
+
+		tw.Render()
+		return nil
+	},
+}
+
+func nodeInfoRow(node *model.NodeInfo, outputWide bool) table.Row {
+	engines := []string{}
+	for _, engine := range node.Engines {
+		engines = append(engines, engine.String())
+	}
+
+	return table.Row{
+		shortID(outputWide, node.NodeID),
+		node.Version.GitVersion,
+		strings.Join(model.LabelsToStrings(node.Labels), ","),
+		strings.Join(engines, ","),
+		fmt.Sprintf("%.2f", node.FreeCapacity.CPU),
+		fmt.Sprintf("%d", node.FreeCapacity.Memory),
+		fmt.Sprintf("%d", node.FreeCapacity.GPU),
+		shortenTime(outputWide, node.EventTime),
+	}
+}
@@ -47,7 +47,11 @@ func init() { //nolint:gochecknoinits // Using init in cobra command is idomatic
 	RootCmd.AddCommand(getCmd)
 	RootCmd.AddCommand(listCmd)
 	RootCmd.AddCommand(describeCmd)
+	RootCmd.AddCommand(logsCmd)
 	RootCmd.AddCommand(devstackCmd)
+	RootCmd.AddCommand(nodeCmd)
+	RootCmd.AddCommand(drainCmd)
+	RootCmd.AddCommand(undrainCmd)
 	RootCmd.PersistentFlags().StringVar(
 		&apiHost, "api-host", defaultAPIHost,
 		`The host for the client and server to communicate on (via REST). Ignored if BACALHAU_API_HOST environment variable is set.`,
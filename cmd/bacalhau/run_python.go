@@ -11,7 +11,9 @@ import (
 	"path/filepath"
 	"time"
 
+	"github.com/filecoin-project/bacalhau/pkg/executor/language"
 	"github.com/filecoin-project/bacalhau/pkg/job"
+	"github.com/filecoin-project/bacalhau/pkg/model"
 	"github.com/filecoin-project/bacalhau/pkg/system"
 	"github.com/filecoin-project/bacalhau/pkg/util/templates"
 	"github.com/rs/zerolog/log"
@@ -21,7 +23,11 @@ import (
 
 var (
 	languageRunLong = templates.LongDesc(i18n.T(`
-		Runs a job by compiling language file to WASM on the node.
+		Runs a python program on the network.
+
+		By default the program is run deterministically by compiling it to WASM
+		on the node, which only python 3.10 supports. With --deterministic=false
+		it is run in a standard python image instead.
 		`))
 
 	languageRunExample = templates.Examples(i18n.T(`
@@ -43,8 +49,11 @@ type LanguageRunOptions struct {
 	Confidence    int      // Minimum number of nodes that must agree on a verification result
 	MinBids       int      // Minimum number of bids that must be received before any are accepted (at random)
 	Labels        []string // Labels for the job on the Bacalhau network (for searching)
+	NodeSelector  string   // Selector (label query) to filter nodes on which this job can be executed
+	DoNotCache    bool     // Always run the job rather than using cached results from an identical job
 
 	Command          string // Command to execute
+	LanguageVersion  string // Which version of python to run
 	RequirementsPath string // Path for requirements.txt for executing with Python
 	ContextPath      string // ContextPath (code) for executing with Python
 
@@ -74,7 +83,10 @@ func NewLanguageRunOptions() *LanguageRunOptions {
 		Concurrency:      1,
 		Confidence:       0,
 		Labels:           []string{},
+		NodeSelector:     "",
+		DoNotCache:       false,
 		Command:          "",
+		LanguageVersion:  "3.10",
 		RequirementsPath: "",
 		ContextPath:      ".",
 	}
@@ -90,6 +102,11 @@ func init() {
 			`in an environment where only some librarie are supported, see `+
 			`https://pyodide.org/en/stable/usage/packages-in-pyodide.html`,
 	)
+	runPythonCmd.PersistentFlags().StringVar(
+		&OLR.LanguageVersion, "python-version", OLR.LanguageVersion,
+		`The version of python to run. Only 3.10 can be run deterministically, `+
+			`other versions (3.8, 3.9 and 3.11) need --deterministic=false.`,
+	)
 	runPythonCmd.PersistentFlags().StringSliceVarP(
 		&OLR.Inputs, "inputs", "i", OLR.Inputs,
 		`CIDs to use on the job. Mounts them at '/inputs' in the execution.`,
@@ -123,7 +140,9 @@ func init() {
 	)
 	runPythonCmd.PersistentFlags().StringVarP(
 		&OLR.RequirementsPath, "requirement", "r", OLR.RequirementsPath,
-		`Install from the given requirements file. (like pip)`, // TODO: This option can be used multiple times.
+		`Install from the given requirements file (like pip), relative to the context path. `+
+			`Only installing them can reach PyPI, the program itself has no network access. `+
+			`A deterministic job can only use packages bundled with pyodide, at the versions bundled.`, // TODO: This option can be used multiple times.
 	)
 	runPythonCmd.PersistentFlags().StringVar(
 		// TODO: consider replacing this with context-glob, default to
@@ -141,6 +160,16 @@ func init() {
 		&OLR.Labels, "labels", "l", OLR.Labels,
 		`List of labels for the job. Enter multiple in the format '-l a -l 2'. All characters not matching /a-zA-Z0-9_:|-/ and all emojis will be stripped.`, //nolint:lll // Documentation, ok if long.
 	)
+
+	runPythonCmd.PersistentFlags().StringVarP(
+		&OLR.NodeSelector, "selector", "s", OLR.NodeSelector,
+		`Selector (label query) to filter nodes on which this job can be executed, supports '=', '==', '!=', 'in', 'notin' and '!key' (e.g. -s region=eu,tier in (trusted)). Only nodes whose labels satisfy all of the constraints will bid on the job.`, //nolint:lll // Documentation, ok if long.
+	)
+
+	runPythonCmd.PersistentFlags().BoolVar(
+		&OLR.DoNotCache, "do-not-cache", OLR.DoNotCache,
+		`Always run the job, even if a compute node has cached results from an identical job.`,
+	)
 }
 
 // TODO: move the adapter code (from wasm to docker) into a wasm executor, so
@@ -164,10 +193,14 @@ var runPythonCmd = &cobra.Command{
 		defer rootSpan.End()
 		cm.RegisterCallback(system.CleanupTraceProvider)
 
-		// error if determinism is false
-		if !OLR.Deterministic {
-			return fmt.Errorf("determinism=false not supported yet " +
-				"(python only supports wasm backend with forced determinism)")
+		// check we can run this before uploading anything
+		_, err := language.ResolveRuntime(model.JobSpecLanguage{
+			Language:        "python",
+			LanguageVersion: OLR.LanguageVersion,
+			Deterministic:   OLR.Deterministic,
+		})
+		if err != nil {
+			return err
 		}
 
 		// TODO: prepare context
@@ -196,7 +229,7 @@ var runPythonCmd = &cobra.Command{
 			OLR.Confidence,
 			OLR.MinBids,
 			"python",
-			"3.10",
+			OLR.LanguageVersion,
 			OLR.Command,
 			programPath,
 			OLR.RequirementsPath,
@@ -209,6 +242,12 @@ var runPythonCmd = &cobra.Command{
 			return err
 		}
 
+		spec.NodeSelectors, err = job.ParseNodeSelector(OLR.NodeSelector)
+		if err != nil {
+			return err
+		}
+		spec.DoNotCache = OLR.DoNotCache
+
 		var buf bytes.Buffer
 
 		if OLR.ContextPath == "." && OLR.RequirementsPath == "" && programPath == "" {
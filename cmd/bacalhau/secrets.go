@@ -0,0 +1,120 @@
+package bacalhau
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	jobutils "github.com/filecoin-project/bacalhau/pkg/job"
+	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/filecoin-project/bacalhau/pkg/publicapi"
+	"github.com/rs/zerolog/log"
+)
+
+// how often we look for compute nodes asking for the job's secrets
+const secretsPollInterval = time.Second
+
+// parseSecretFlags turns --secret-env NAME and --secret-file PATH=LOCALFILE
+// into the secrets of the job and their values. Env secrets take their value
+// from our own env var of the same name and file secrets are named after the
+// path the job reads them from.
+func parseSecretFlags(envNames, files []string) ([]model.JobSpecSecret, map[string]string, error) {
+	specSecrets := []model.JobSpecSecret{}
+	values := map[string]string{}
+	for _, name := range envNames {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return nil, nil, fmt.Errorf("--secret-env %s: %s is not set", name, name)
+		}
+		specSecrets = append(specSecrets, model.JobSpecSecret{Name: name, Env: name})
+		values[name] = value
+	}
+	for _, file := range files {
+		path, localFile, ok := strings.Cut(file, "=")
+		if !ok || path == "" || localFile == "" {
+			return nil, nil, fmt.Errorf("--secret-file %s: expected PATH=LOCALFILE", file)
+		}
+		data, err := os.ReadFile(localFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("--secret-file %s: %w", file, err)
+		}
+		specSecrets = append(specSecrets, model.JobSpecSecret{Name: path, Path: path})
+		values[path] = string(data)
+	}
+	return specSecrets, values, nil
+}
+
+// secretValuesFromEnv reads the values of the job's secrets from the
+// env vars named after them
+func secretValuesFromEnv(specSecrets []model.JobSpecSecret) (map[string]string, error) {
+	values := map[string]string{}
+	for _, secret := range specSecrets {
+		value, ok := os.LookupEnv(secret.Name)
+		if !ok {
+			return nil, fmt.Errorf("the value of secret %s must be set in the %s env var", secret.Name, secret.Name)
+		}
+		values[secret.Name] = value
+	}
+	return values, nil
+}
+
+// deliverSecrets sends the job's secrets to each compute node that asks for
+// them until ctx is cancelled. Every request has its own key so a node that
+// asks again, e.g. because it is rerunning a shard, is answered again. Only
+// nodes whose bid for the shard the job's requester accepted are answered -
+// anyone can publish a request.
+func deliverSecrets(ctx context.Context, apiClient *publicapi.APIClient, jobID string, values map[string]string) {
+	answered := map[string]bool{}
+	ticker := time.NewTicker(secretsPollInterval)
+	defer ticker.Stop()
+	for {
+		answerSecretsRequests(ctx, apiClient, jobID, values, answered)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func answerSecretsRequests(
+	ctx context.Context,
+	apiClient *publicapi.APIClient,
+	jobID string,
+	values map[string]string,
+	answered map[string]bool,
+) {
+	j, found, err := apiClient.Get(ctx, jobID)
+	if err != nil || !found {
+		if ctx.Err() == nil {
+			log.Debug().Msgf("Error getting job %s to look for secrets requests: %v", jobID, err)
+		}
+		return
+	}
+	events, err := apiClient.GetEvents(ctx, jobID)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Debug().Msgf("Error getting events to look for secrets requests: %s", err)
+		}
+		return
+	}
+	for _, event := range events {
+		if event.EventName != model.JobEventSecretsRequested || answered[string(event.SecretsPublicKey)] {
+			continue
+		}
+		if !jobutils.HasAcceptedBid(j, events, event.SourceNodeID, event.ShardIndex) {
+			log.Debug().Msgf("Not sending secrets to node %s for shard %d: its bid was not accepted",
+				event.SourceNodeID, event.ShardIndex)
+			continue
+		}
+		err = apiClient.DeliverSecrets(ctx, event, values)
+		if err != nil {
+			log.Warn().Msgf("Error sending secrets to node %s for shard %d: %s",
+				event.SourceNodeID, event.ShardIndex, err)
+			continue
+		}
+		answered[string(event.SecretsPublicKey)] = true
+	}
+}
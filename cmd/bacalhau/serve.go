@@ -4,12 +4,20 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/filecoin-project/bacalhau/pkg/capacitymanager"
 	"github.com/filecoin-project/bacalhau/pkg/computenode"
+	"github.com/filecoin-project/bacalhau/pkg/executor/docker"
+	"github.com/filecoin-project/bacalhau/pkg/executor/native"
 	"github.com/filecoin-project/bacalhau/pkg/ipfs"
 	"github.com/filecoin-project/bacalhau/pkg/node"
 	"github.com/filecoin-project/bacalhau/pkg/requesternode"
+	"github.com/filecoin-project/bacalhau/pkg/resultcache"
+	"github.com/filecoin-project/bacalhau/pkg/storage/archive"
+	"github.com/filecoin-project/bacalhau/pkg/storage/git"
+	"github.com/filecoin-project/bacalhau/pkg/storage/inputcache"
+	"github.com/filecoin-project/bacalhau/pkg/storage/s3"
 
 	"github.com/filecoin-project/bacalhau/pkg/model"
 	"github.com/filecoin-project/bacalhau/pkg/system"
@@ -41,23 +49,64 @@ var (
 )
 
 type ServeOptions struct {
-	PeerConnect                     string // The libp2p multiaddress to connect to.
-	IPFSConnect                     string // The IPFS multiaddress to connect to.
-	FilecoinUnsealedPath            string // The go template that can turn a filecoin CID into a local filepath with the unsealed data.
-	EstuaryAPIKey                   string // The API key used when using the estuary API.
-	HostAddress                     string // The host address to listen on.
-	SwarmPort                       int    // The host port for libp2p network.
-	JobSelectionDataLocality        string // The data locality to use for job selection.
-	JobSelectionDataRejectStateless bool   // Whether to reject jobs that don't specify any data.
-	JobSelectionProbeHTTP           string // The HTTP URL to use for job selection.
-	JobSelectionProbeExec           string // The executable to use for job selection.
-	MetricsPort                     int    // The port to listen on for metrics.
-	LimitTotalCPU                   string // The total amount of CPU the system can be using at one time.
-	LimitTotalMemory                string // The total amount of memory the system can be using at one time.
-	LimitTotalGPU                   string // The total amount of GPU the system can be using at one time.
-	LimitJobCPU                     string // The amount of CPU the system can be using at one time for a single job.
-	LimitJobMemory                  string // The amount of memory the system can be using at one time for a single job.
-	LimitJobGPU                     string // The amount of GPU the system can be using at one time for a single job.
+	PeerConnect                     string            // The libp2p multiaddress to connect to.
+	IPFSConnect                     string            // The IPFS multiaddress to connect to.
+	FilecoinUnsealedPath            string            // The go template that can turn a filecoin CID into a local filepath with the unsealed data.
+	EstuaryAPIKey                   string            // The API key used when using the estuary API.
+	HostAddress                     string            // The host address to listen on.
+	SwarmPort                       int               // The host port for libp2p network.
+	JobSelectionDataLocality        string            // The data locality to use for job selection.
+	JobSelectionLocalityThreshold   float64           // The fraction of input data that must be local when using local data locality.
+	JobSelectionDataRejectStateless bool              // Whether to reject jobs that don't specify any data.
+	JobSelectionProbeHTTP           string            // The HTTP URL to use for job selection.
+	JobSelectionProbeExec           string            // The executable to use for job selection.
+	JobSelectionMaxNetwork          string            // The most network access we will give to a job.
+	MetricsPort                     int               // The port to listen on for metrics.
+	LimitTotalCPU                   string            // The total amount of CPU the system can be using at one time.
+	LimitTotalMemory                string            // The total amount of memory the system can be using at one time.
+	LimitTotalGPU                   string            // The total amount of GPU the system can be using at one time.
+	LimitJobCPU                     string            // The amount of CPU the system can be using at one time for a single job.
+	LimitJobMemory                  string            // The amount of memory the system can be using at one time for a single job.
+	LimitJobGPU                     string            // The amount of GPU the system can be using at one time for a single job.
+	Labels                          map[string]string // Labels to apply to the node that can be used for node selection and filtering
+	ResultCacheDir                  string            // Where to cache shard results so identical shards can skip execution.
+	ResultCacheSize                 string            // The total size of the result cache, caching is disabled if empty.
+	ResultCacheMaxEntrySize         string            // Results bigger than this are not cached.
+	PrefetchInputs                  bool              // Start fetching inputs while waiting for a bid to be accepted.
+	PrefetchMaxConcurrent           int               // How many shards can be prefetching inputs at once.
+	PrefetchMaxDisk                 string            // The most disk space prefetched inputs can use.
+	PrefetchMaxBandwidth            string            // The most bytes a second prefetches can download.
+	InputCacheDir                   string            // Where to keep downloaded inputs so they can be shared between shards.
+	InputCacheSize                  string            // The total size of the input cache, caching is disabled if empty.
+	PublicAPIURL                    string            // The URL other nodes can reach our API on.
+	AllowPrivateNodeAPIs            bool              // Proxy logs from nodes whose API is on a private address.
+	MaxJobExecutionTimeout          time.Duration     // The longest a shard can run for, zero means no limit.
+	CheckpointInterval              time.Duration     // How often checkpoint volumes are snapshotted when the job doesn't say.
+	MinCheckpointInterval           time.Duration     // The most often a job's checkpoint volume can be snapshotted.
+	MaxLogSize                      string            // How much of each of stdout and stderr is kept in shard results.
+	SecretsTimeout                  time.Duration     // How long to wait for a job's secrets before giving up on a shard.
+	DockerSandbox                   string            // The sandboxing profile docker jobs start from.
+	DockerUser                      string            // The user docker jobs run as.
+	DockerUserNamespaces            bool              // Whether docker jobs must run in a user namespace.
+	DockerReadOnlyRootfs            bool              // Whether docker jobs get a read only root filesystem.
+	DockerTmpfsSize                 string            // The size of the scratch tmpfs docker jobs get.
+	DockerCapDrop                   []string          // Capabilities removed from docker jobs.
+	DockerCapAdd                    []string          // Capabilities added to docker jobs.
+	DockerSeccompProfile            string            // The seccomp profile docker jobs run under.
+	DockerAppArmorProfile           string            // The AppArmor profile docker jobs run under.
+	DockerNoNewPrivileges           bool              // Whether docker jobs can gain privileges.
+	DockerPidsLimit                 int64             // The most processes a docker job can run.
+	DockerUlimits                   []string          // Ulimits for docker jobs.
+	DockerRuntime                   string            // The OCI runtime docker jobs run in.
+	DockerRegistryAuthFile          string            // Credentials for the private registries docker jobs pull from.
+	DockerImageCacheSize            string            // The most disk the images pulled for docker jobs can use.
+	NativeCgroupRoot                string            // Where the native executor makes cgroups for jobs.
+	S3Endpoint                      string            // The S3-compatible service s3:// inputs are read from, empty for AWS.
+	S3Region                        string            // The region of the S3 service.
+	S3AllowedBuckets                []string          // The only buckets s3:// inputs can be read from.
+	GitAllowedProtocols             []string          // The protocols git inputs can be cloned over.
+	URLRequireChecksum              bool              // Only download URL inputs that have a checksum.
+	ArchiveMaxExtractedSize         string            // The most an archive input can extract to.
 }
 
 func NewServeOptions() *ServeOptions {
@@ -70,15 +119,56 @@ func NewServeOptions() *ServeOptions {
 		SwarmPort:                       DefaultSwarmPort,
 		MetricsPort:                     2112,
 		JobSelectionDataLocality:        "local",
+		JobSelectionLocalityThreshold:   1,
 		JobSelectionDataRejectStateless: false,
 		JobSelectionProbeHTTP:           "",
 		JobSelectionProbeExec:           "",
+		JobSelectionMaxNetwork:          model.NetworkNone.String(),
 		LimitTotalCPU:                   "",
 		LimitTotalMemory:                "",
 		LimitTotalGPU:                   "",
 		LimitJobCPU:                     "",
 		LimitJobMemory:                  "",
 		LimitJobGPU:                     "",
+		Labels:                          map[string]string{},
+		ResultCacheDir:                  "",
+		ResultCacheSize:                 "",
+		ResultCacheMaxEntrySize:         "",
+		PrefetchInputs:                  false,
+		PrefetchMaxConcurrent:           computenode.DefaultPrefetchMaxConcurrent,
+		PrefetchMaxDisk:                 "",
+		PrefetchMaxBandwidth:            "",
+		InputCacheDir:                   "",
+		InputCacheSize:                  "",
+		PublicAPIURL:                    "",
+		AllowPrivateNodeAPIs:            false,
+		MaxJobExecutionTimeout:          0,
+		CheckpointInterval:              computenode.DefaultCheckpointInterval,
+		MinCheckpointInterval:           computenode.DefaultMinCheckpointInterval,
+		MaxLogSize:                      "",
+		SecretsTimeout:                  computenode.DefaultSecretsTimeout,
+		DockerSandbox:                   dockerSandboxDefault,
+		DockerUser:                      "",
+		DockerUserNamespaces:            false,
+		DockerReadOnlyRootfs:            false,
+		DockerTmpfsSize:                 "",
+		DockerCapDrop:                   []string{},
+		DockerCapAdd:                    []string{},
+		DockerSeccompProfile:            "",
+		DockerAppArmorProfile:           "",
+		DockerNoNewPrivileges:           false,
+		DockerPidsLimit:                 0,
+		DockerUlimits:                   []string{},
+		DockerRuntime:                   "",
+		DockerRegistryAuthFile:          "",
+		DockerImageCacheSize:            "",
+		NativeCgroupRoot:                native.DefaultCgroupRoot,
+		S3Endpoint:                      "",
+		S3Region:                        os.Getenv("AWS_REGION"),
+		S3AllowedBuckets:                []string{},
+		GitAllowedProtocols:             git.DefaultAllowedProtocols,
+		URLRequireChecksum:              false,
+		ArchiveMaxExtractedSize:         "",
 	}
 }
 
@@ -87,6 +177,10 @@ func setupJobSelectionCLIFlags(cmd *cobra.Command) {
 		&OS.JobSelectionDataLocality, "job-selection-data-locality", OS.JobSelectionDataLocality,
 		`Only accept jobs that reference data we have locally ("local") or anywhere ("anywhere").`,
 	)
+	cmd.PersistentFlags().Float64Var(
+		&OS.JobSelectionLocalityThreshold, "job-selection-locality-threshold", OS.JobSelectionLocalityThreshold,
+		`With "local" data locality, the fraction of the job's input bytes we must already have to accept the job (e.g. 0.8), 0 for any amount.`,
+	)
 	cmd.PersistentFlags().BoolVar(
 		&OS.JobSelectionDataRejectStateless, "job-selection-reject-stateless", OS.JobSelectionDataRejectStateless,
 		`Reject jobs that don't specify any data.`,
@@ -99,6 +193,10 @@ func setupJobSelectionCLIFlags(cmd *cobra.Command) {
 		&OS.JobSelectionProbeExec, "job-selection-probe-exec", OS.JobSelectionProbeExec,
 		`Use the result of a exec an external program to decide if we should take on the job.`,
 	)
+	cmd.PersistentFlags().StringVar(
+		&OS.JobSelectionMaxNetwork, "job-selection-max-network", OS.JobSelectionMaxNetwork,
+		`The most network access we will give a job ("none", "allowlist" or "full"). Jobs that need more are rejected.`,
+	)
 }
 
 func setupCapacityManagerCLIFlags(cmd *cobra.Command) {
@@ -153,9 +251,16 @@ func getJobSelectionConfig() computenode.JobSelectionPolicy {
 		typedJobSelectionDataLocality = computenode.Anywhere
 	}
 
+	// this is validated before we get here - anything
+	// we can't parse means no network access
+	maxNetwork, _ := model.ParseNetwork(OS.JobSelectionMaxNetwork)
+	localityThreshold := OS.JobSelectionLocalityThreshold
+
 	jobSelectionPolicy := computenode.JobSelectionPolicy{
 		Locality:            typedJobSelectionDataLocality,
+		LocalityThreshold:   &localityThreshold,
 		RejectStatelessJobs: OS.JobSelectionDataRejectStateless,
+		MaxNetwork:          maxNetwork,
 		ProbeHTTP:           OS.JobSelectionProbeHTTP,
 		ProbeExec:           OS.JobSelectionProbeExec,
 	}
@@ -184,6 +289,297 @@ func getCapacityManagerConfig() capacitymanager.Config {
 	}
 }
 
+func setupResultCacheCLIFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringVar(
+		&OS.ResultCacheDir, "result-cache-dir", OS.ResultCacheDir,
+		`Where to cache shard results (defaults to ~/.bacalhau/result-cache).`,
+	)
+	cmd.PersistentFlags().StringVar(
+		&OS.ResultCacheSize, "result-cache-size", OS.ResultCacheSize,
+		`The total size of cached shard results (e.g. 10Gb). Identical shards will reuse cached results rather than running again. Caching is disabled if not set.`, //nolint:lll // Documentation, ok if long.
+	)
+	cmd.PersistentFlags().StringVar(
+		&OS.ResultCacheMaxEntrySize, "result-cache-max-entry-size", OS.ResultCacheMaxEntrySize,
+		`Results of a single shard bigger than this are not cached (e.g. 1Gb).`,
+	)
+}
+
+func setupPrefetchCLIFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().BoolVar(
+		&OS.PrefetchInputs, "prefetch-inputs", OS.PrefetchInputs,
+		`Start downloading the inputs of a shard as soon as we bid on it, rather than once the bid is accepted.`,
+	)
+	cmd.PersistentFlags().IntVar(
+		&OS.PrefetchMaxConcurrent, "prefetch-max-concurrent", OS.PrefetchMaxConcurrent,
+		`How many shards can be prefetching inputs at the same time.`,
+	)
+	cmd.PersistentFlags().StringVar(
+		&OS.PrefetchMaxDisk, "prefetch-max-disk", OS.PrefetchMaxDisk,
+		`The most disk space that prefetched inputs can use at once (e.g. 20Gb). No limit if not set.`,
+	)
+	cmd.PersistentFlags().StringVar(
+		&OS.PrefetchMaxBandwidth, "prefetch-max-bandwidth", OS.PrefetchMaxBandwidth,
+		`The most all prefetches can download a second between them (e.g. 50Mb), for IPFS, URL and S3 inputs. `+
+			`No limit if not set.`,
+	)
+}
+
+// the URL we tell other nodes to reach our API on - we can't guess
+// a reachable address if we are listening on all interfaces
+func getPublicAPIURL() string {
+	if OS.PublicAPIURL != "" {
+		return strings.TrimSuffix(OS.PublicAPIURL, "/")
+	}
+	if OS.HostAddress == "" || OS.HostAddress == "0.0.0.0" {
+		return ""
+	}
+	return fmt.Sprintf("http://%s:%d", OS.HostAddress, apiPort)
+}
+
+func getPrefetchConfig() (computenode.PrefetchConfig, error) {
+	var maxDisk uint64
+	if OS.PrefetchMaxDisk != "" {
+		maxDisk = capacitymanager.ConvertMemoryString(OS.PrefetchMaxDisk)
+		if maxDisk == 0 {
+			return computenode.PrefetchConfig{}, fmt.Errorf("invalid prefetch-max-disk: %s", OS.PrefetchMaxDisk)
+		}
+	}
+	var maxBandwidth uint64
+	if OS.PrefetchMaxBandwidth != "" {
+		maxBandwidth = capacitymanager.ConvertMemoryString(OS.PrefetchMaxBandwidth)
+		if maxBandwidth == 0 {
+			return computenode.PrefetchConfig{}, fmt.Errorf("invalid prefetch-max-bandwidth: %s", OS.PrefetchMaxBandwidth)
+		}
+	}
+	return computenode.PrefetchConfig{
+		Enabled:       OS.PrefetchInputs,
+		MaxConcurrent: OS.PrefetchMaxConcurrent,
+		MaxDiskBytes:  maxDisk,
+		MaxBandwidth:  maxBandwidth,
+	}, nil
+}
+
+func setupInputCacheCLIFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringVar(
+		&OS.InputCacheDir, "input-cache-dir", OS.InputCacheDir,
+		`Where to keep downloaded inputs (defaults to ~/.bacalhau/input-cache).`,
+	)
+	cmd.PersistentFlags().StringVar(
+		&OS.InputCacheSize, "input-cache-size", OS.InputCacheSize,
+		`The total size of downloaded inputs to keep around for other shards and jobs (e.g. 50Gb). No caching if not set.`,
+	)
+}
+
+func getInputCache() (*inputcache.InputCache, error) {
+	if OS.InputCacheSize == "" {
+		return nil, nil
+	}
+	maxSize := capacitymanager.ConvertMemoryString(OS.InputCacheSize)
+	if maxSize == 0 {
+		return nil, fmt.Errorf("invalid input-cache-size: %s", OS.InputCacheSize)
+	}
+	dir := OS.InputCacheDir
+	if dir == "" {
+		var err error
+		dir, err = system.GetSystemDirectory("input-cache")
+		if err != nil {
+			return nil, err
+		}
+	}
+	return inputcache.NewInputCache(inputcache.Config{
+		Dir:     dir,
+		MaxSize: maxSize,
+	})
+}
+
+const (
+	dockerSandboxDefault  = "default"
+	dockerSandboxHardened = "hardened"
+)
+
+func setupDockerSandboxCLIFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringVar(
+		&OS.DockerSandbox, "docker-sandbox", OS.DockerSandbox,
+		`The sandboxing profile for docker jobs: "default" keeps docker's defaults and "hardened" runs jobs as nobody `+
+			`with a read only root filesystem, a 64Mb /tmp, no capabilities, no new privileges and process limits. `+
+			`The other docker flags add to the profile.`,
+	)
+	cmd.PersistentFlags().StringVar(
+		&OS.DockerUser, "docker-user", OS.DockerUser,
+		`Run docker jobs as this user (uid or uid:gid) instead of the image's user.`,
+	)
+	cmd.PersistentFlags().BoolVar(
+		&OS.DockerUserNamespaces, "docker-user-namespaces", OS.DockerUserNamespaces,
+		`Refuse to run docker jobs unless the docker daemon puts them in a user namespace (dockerd --userns-remap).`,
+	)
+	cmd.PersistentFlags().BoolVar(
+		&OS.DockerReadOnlyRootfs, "docker-read-only-rootfs", OS.DockerReadOnlyRootfs,
+		`Give docker jobs a read only root filesystem with a tmpfs at /tmp to write to.`,
+	)
+	cmd.PersistentFlags().StringVar(
+		&OS.DockerTmpfsSize, "docker-tmpfs-size", OS.DockerTmpfsSize,
+		`The size of the tmpfs mounted at /tmp in docker jobs (e.g. 256Mb).`,
+	)
+	cmd.PersistentFlags().StringSliceVar(
+		&OS.DockerCapDrop, "docker-cap-drop", OS.DockerCapDrop,
+		`Kernel capabilities to remove from docker jobs (e.g. ALL).`,
+	)
+	cmd.PersistentFlags().StringSliceVar(
+		&OS.DockerCapAdd, "docker-cap-add", OS.DockerCapAdd,
+		`Kernel capabilities to give docker jobs.`,
+	)
+	cmd.PersistentFlags().StringVar(
+		&OS.DockerSeccompProfile, "docker-seccomp-profile", OS.DockerSeccompProfile,
+		`A seccomp profile json file for docker jobs, or "unconfined". Docker's default profile is used if not set.`,
+	)
+	cmd.PersistentFlags().StringVar(
+		&OS.DockerAppArmorProfile, "docker-apparmor-profile", OS.DockerAppArmorProfile,
+		`The AppArmor profile docker jobs run under, which must already be loaded.`,
+	)
+	cmd.PersistentFlags().BoolVar(
+		&OS.DockerNoNewPrivileges, "docker-no-new-privileges", OS.DockerNoNewPrivileges,
+		`Stop processes in docker jobs gaining privileges through setuid binaries.`,
+	)
+	cmd.PersistentFlags().Int64Var(
+		&OS.DockerPidsLimit, "docker-pids-limit", OS.DockerPidsLimit,
+		`The most processes a docker job can run. 0 means no limit.`,
+	)
+	cmd.PersistentFlags().StringSliceVar(
+		&OS.DockerUlimits, "docker-ulimit", OS.DockerUlimits,
+		`Ulimits for docker jobs (e.g. --docker-ulimit nofile=1024:2048).`,
+	)
+	cmd.PersistentFlags().StringVar(
+		&OS.DockerRuntime, "docker-runtime", OS.DockerRuntime,
+		`The OCI runtime docker jobs run in (e.g. runsc for gVisor), which must be registered with docker.`,
+	)
+	cmd.PersistentFlags().StringVar(
+		&OS.DockerRegistryAuthFile, "docker-registry-auth-file", OS.DockerRegistryAuthFile,
+		`A docker config.json style file with the credentials to pull docker job images from private registries `+
+			`(e.g. ~/.docker/config.json after docker login). Credential helpers are not supported.`,
+	)
+	cmd.PersistentFlags().StringVar(
+		&OS.DockerImageCacheSize, "docker-image-cache-size", OS.DockerImageCacheSize,
+		`The most disk the images pulled for docker jobs can use (e.g. 20Gb). The least recently used are removed `+
+			`once it is exceeded. Images that were there before the node started are never removed.`,
+	)
+}
+
+func getDockerSandboxConfig() (docker.SandboxConfig, error) {
+	var config docker.SandboxConfig
+	switch OS.DockerSandbox {
+	case dockerSandboxDefault:
+	case dockerSandboxHardened:
+		config = docker.HardenedSandboxConfig()
+	default:
+		return config, fmt.Errorf("docker-sandbox must be either '%s' or '%s'", dockerSandboxDefault, dockerSandboxHardened)
+	}
+
+	if OS.DockerUser != "" {
+		config.User = OS.DockerUser
+	}
+	config.UserNamespaces = config.UserNamespaces || OS.DockerUserNamespaces
+	config.ReadOnlyRootfs = config.ReadOnlyRootfs || OS.DockerReadOnlyRootfs
+	if OS.DockerTmpfsSize != "" {
+		config.TmpfsSize = capacitymanager.ConvertMemoryString(OS.DockerTmpfsSize)
+		if config.TmpfsSize == 0 {
+			return config, fmt.Errorf("invalid docker-tmpfs-size: %s", OS.DockerTmpfsSize)
+		}
+	}
+	config.CapDrop = append(config.CapDrop, OS.DockerCapDrop...)
+	config.CapAdd = append(config.CapAdd, OS.DockerCapAdd...)
+	if OS.DockerSeccompProfile != "" {
+		config.SeccompProfile = OS.DockerSeccompProfile
+	}
+	if OS.DockerAppArmorProfile != "" {
+		config.AppArmorProfile = OS.DockerAppArmorProfile
+	}
+	config.NoNewPrivileges = config.NoNewPrivileges || OS.DockerNoNewPrivileges
+	if OS.DockerPidsLimit != 0 {
+		config.PidsLimit = OS.DockerPidsLimit
+	}
+	config.Ulimits = append(config.Ulimits, OS.DockerUlimits...)
+	if OS.DockerRuntime != "" {
+		config.Runtime = OS.DockerRuntime
+	}
+	return config, nil
+}
+
+func getDockerImageConfig() (docker.ImageConfig, error) {
+	config := docker.ImageConfig{
+		RegistryAuthFile: OS.DockerRegistryAuthFile,
+	}
+	if OS.DockerImageCacheSize != "" {
+		config.CacheSize = capacitymanager.ConvertMemoryString(OS.DockerImageCacheSize)
+		if config.CacheSize == 0 {
+			return config, fmt.Errorf("invalid docker-image-cache-size: %s", OS.DockerImageCacheSize)
+		}
+	}
+	return config, nil
+}
+
+func getS3Config() s3.Config {
+	return s3.Config{
+		Endpoint:        OS.S3Endpoint,
+		Region:          OS.S3Region,
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		AllowedBuckets:  OS.S3AllowedBuckets,
+	}
+}
+
+func getMaxLogSize() (int64, error) {
+	if OS.MaxLogSize == "" {
+		return system.DefaultMaxLogSize, nil
+	}
+	maxSize := capacitymanager.ConvertMemoryString(OS.MaxLogSize)
+	if maxSize == 0 {
+		return 0, fmt.Errorf("invalid max-log-size: %s", OS.MaxLogSize)
+	}
+	return int64(maxSize), nil
+}
+
+func getArchiveConfig() (archive.Config, error) {
+	config := archive.Config{}
+	if OS.ArchiveMaxExtractedSize != "" {
+		config.MaxExtractedSize = capacitymanager.ConvertMemoryString(OS.ArchiveMaxExtractedSize)
+		if config.MaxExtractedSize == 0 {
+			return config, fmt.Errorf("invalid archive-max-extracted-size: %s", OS.ArchiveMaxExtractedSize)
+		}
+	}
+	return config, nil
+}
+
+func getResultCacheConfig() (resultcache.Config, error) {
+	if OS.ResultCacheSize == "" {
+		return resultcache.Config{}, nil
+	}
+	maxSize := capacitymanager.ConvertMemoryString(OS.ResultCacheSize)
+	if maxSize == 0 {
+		return resultcache.Config{}, fmt.Errorf("invalid result-cache-size: %s", OS.ResultCacheSize)
+	}
+	var maxEntrySize uint64
+	if OS.ResultCacheMaxEntrySize != "" {
+		maxEntrySize = capacitymanager.ConvertMemoryString(OS.ResultCacheMaxEntrySize)
+		if maxEntrySize == 0 {
+			return resultcache.Config{}, fmt.Errorf("invalid result-cache-max-entry-size: %s", OS.ResultCacheMaxEntrySize)
+		}
+	}
+	dir := OS.ResultCacheDir
+	if dir == "" {
+		var err error
+		dir, err = system.GetSystemDirectory("result-cache")
+		if err != nil {
+			return resultcache.Config{}, err
+		}
+	}
+	return resultcache.Config{
+		Dir:          dir,
+		MaxSize:      maxSize,
+		MaxEntrySize: maxEntrySize,
+	}, nil
+}
+
 func init() { //nolint:gochecknoinits // Using init in cobra command is idomatic
 	serveCmd.PersistentFlags().StringVar(
 		&OS.PeerConnect, "peer", OS.PeerConnect,
@@ -205,6 +601,70 @@ func init() { //nolint:gochecknoinits // Using init in cobra command is idomatic
 		&OS.HostAddress, "host", OS.HostAddress,
 		`The host to listen on (for both api and swarm connections).`,
 	)
+	serveCmd.PersistentFlags().StringVar(
+		&OS.PublicAPIURL, "public-api-url", OS.PublicAPIURL,
+		`The URL other nodes can reach this node's API on, used to stream logs from running jobs. `+
+			`Defaults to the host and api port if a specific host is set.`,
+	)
+	serveCmd.PersistentFlags().BoolVar(
+		&OS.AllowPrivateNodeAPIs, "allow-private-node-apis", OS.AllowPrivateNodeAPIs,
+		`Stream logs from nodes whose public API URL is a loopback or private address. `+
+			`Only use this if you trust every node on the network, as nodes choose their own URL.`,
+	)
+	serveCmd.PersistentFlags().DurationVar(
+		&OS.MaxJobExecutionTimeout, "max-job-execution-timeout", OS.MaxJobExecutionTimeout,
+		`The longest a shard can run for before it is killed (e.g. 30m). Jobs that ask for longer are not bid on `+
+			`and jobs with no timeout get this one. 0 means no limit.`,
+	)
+	serveCmd.PersistentFlags().DurationVar(
+		&OS.CheckpointInterval, "checkpoint-interval", OS.CheckpointInterval,
+		`How often the checkpoint volume of a running shard is snapshotted and published when its job doesn't say.`,
+	)
+	serveCmd.PersistentFlags().DurationVar(
+		&OS.MinCheckpointInterval, "min-checkpoint-interval", OS.MinCheckpointInterval,
+		`The most often the checkpoint volume of a running shard is snapshotted, whatever its job asks for.`,
+	)
+	serveCmd.PersistentFlags().StringVar(
+		&OS.MaxLogSize, "max-log-size", OS.MaxLogSize,
+		`How much of each of stdout and stderr is kept in the results of a shard (e.g. 100Mb). `+
+			`Anything after that is dropped and the results say the log was truncated.`,
+	)
+	serveCmd.PersistentFlags().DurationVar(
+		&OS.SecretsTimeout, "secrets-timeout", OS.SecretsTimeout,
+		`How long to wait for the client to send the secrets of a job before giving up on running a shard of it.`,
+	)
+	serveCmd.PersistentFlags().StringVar(
+		&OS.S3Endpoint, "s3-endpoint", OS.S3Endpoint,
+		`The URL of the S3-compatible service s3:// inputs are read from (e.g. http://localhost:9000), AWS if not set. `+
+			`The credentials are read from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN.`,
+	)
+	serveCmd.PersistentFlags().StringVar(
+		&OS.S3Region, "s3-region", OS.S3Region,
+		`The region of the S3 service, defaults to AWS_REGION or us-east-1.`,
+	)
+	serveCmd.PersistentFlags().StringSliceVar(
+		&OS.S3AllowedBuckets, "s3-allowed-buckets", OS.S3AllowedBuckets,
+		`The only buckets s3:// inputs can be read from. If the node has S3 credentials jobs can't read any bucket `+
+			`unless it is listed here, without them jobs can read any public bucket.`,
+	)
+	serveCmd.PersistentFlags().StringSliceVar(
+		&OS.GitAllowedProtocols, "git-allowed-protocols", OS.GitAllowedProtocols,
+		`The protocols git repositories used as job inputs can be cloned over. Add "ssh" to clone with this node's ssh keys `+
+			`and config, or "file" to allow repositories on this node.`,
+	)
+	serveCmd.PersistentFlags().BoolVar(
+		&OS.URLRequireChecksum, "url-require-checksum", OS.URLRequireChecksum,
+		`Only download URL inputs that have a checksum to check them against. `+
+			`A list of URLs needs its own checksum too, even if every URL in it has one.`,
+	)
+	serveCmd.PersistentFlags().StringVar(
+		&OS.ArchiveMaxExtractedSize, "archive-max-extracted-size", OS.ArchiveMaxExtractedSize,
+		`The most an archive input can extract to (e.g. 50Gb), however small the archive is. Defaults to 10Gb.`,
+	)
+	serveCmd.PersistentFlags().StringVar(
+		&OS.NativeCgroupRoot, "native-cgroup-root", OS.NativeCgroupRoot,
+		`The cgroup v2 directory the native executor limits jobs' cpu and memory in, which this node must be able to write to.`,
+	)
 	serveCmd.PersistentFlags().IntVar(
 		&OS.SwarmPort, "swarm-port", OS.SwarmPort,
 		`The port to listen on for swarm connections.`,
@@ -213,9 +673,17 @@ func init() { //nolint:gochecknoinits // Using init in cobra command is idomatic
 		&OS.MetricsPort, "metrics-port", OS.MetricsPort,
 		`The port to serve prometheus metrics on.`,
 	)
+	serveCmd.PersistentFlags().StringToStringVar(
+		&OS.Labels, "labels", OS.Labels,
+		`Labels to be associated with the node that can be used for node selection and filtering. (e.g. --labels key1=value1,key2=value2)`,
+	)
 
 	setupJobSelectionCLIFlags(serveCmd)
 	setupCapacityManagerCLIFlags(serveCmd)
+	setupResultCacheCLIFlags(serveCmd)
+	setupPrefetchCLIFlags(serveCmd)
+	setupInputCacheCLIFlags(serveCmd)
+	setupDockerSandboxCLIFlags(serveCmd)
 }
 
 var serveCmd = &cobra.Command{
@@ -248,6 +716,47 @@ var serveCmd = &cobra.Command{
 			return fmt.Errorf("job-selection-data-locality must be either 'local' or 'anywhere'")
 		}
 
+		if OS.JobSelectionLocalityThreshold < 0 || OS.JobSelectionLocalityThreshold > 1 {
+			return fmt.Errorf("job-selection-locality-threshold must be between 0 and 1")
+		}
+
+		if _, err := model.ParseNetwork(OS.JobSelectionMaxNetwork); err != nil {
+			return fmt.Errorf("job-selection-max-network must be one of 'none', 'allowlist' or 'full'")
+		}
+
+		resultCacheConfig, err := getResultCacheConfig()
+		if err != nil {
+			return err
+		}
+
+		prefetchConfig, err := getPrefetchConfig()
+		if err != nil {
+			return err
+		}
+
+		inputCache, err := getInputCache()
+		if err != nil {
+			return err
+		}
+
+		dockerSandbox, err := getDockerSandboxConfig()
+		if err != nil {
+			return err
+		}
+		dockerImages, err := getDockerImageConfig()
+		if err != nil {
+			return err
+		}
+
+		maxLogSize, err := getMaxLogSize()
+		if err != nil {
+			return err
+		}
+		archiveConfig, err := getArchiveConfig()
+		if err != nil {
+			return err
+		}
+
 		// Establishing p2p connection
 		peers := getPeers()
 		log.Debug().Msgf("libp2p connecting to: %s", peers)
@@ -269,13 +778,33 @@ var serveCmd = &cobra.Command{
 			CleanupManager:       cm,
 			Transport:            transport,
 			FilecoinUnsealedPath: OS.FilecoinUnsealedPath,
+			S3:                   getS3Config(),
+			Git:                  git.Config{AllowedProtocols: OS.GitAllowedProtocols},
+			Archive:              archiveConfig,
+			URLRequireChecksum:   OS.URLRequireChecksum,
+			InputCache:           inputCache,
+			DockerSandbox:        dockerSandbox,
+			DockerImages:         dockerImages,
+			NativeExecutor:       native.Config{CgroupRoot: OS.NativeCgroupRoot},
 			EstuaryAPIKey:        OS.EstuaryAPIKey,
 			HostAddress:          OS.HostAddress,
 			APIPort:              apiPort,
 			MetricsPort:          OS.MetricsPort,
+			AllowPrivateNodeAPIs: OS.AllowPrivateNodeAPIs,
 			ComputeNodeConfig: computenode.ComputeNodeConfig{
-				JobSelectionPolicy:    getJobSelectionConfig(),
-				CapacityManagerConfig: getCapacityManagerConfig(),
+				JobSelectionPolicy:     getJobSelectionConfig(),
+				CapacityManagerConfig:  getCapacityManagerConfig(),
+				Labels:                 OS.Labels,
+				ResultCacheConfig:      resultCacheConfig,
+				PrefetchConfig:         prefetchConfig,
+				APIURL:                 getPublicAPIURL(),
+				MaxJobExecutionTimeout: OS.MaxJobExecutionTimeout,
+				CheckpointConfig: computenode.CheckpointConfig{
+					DefaultInterval: OS.CheckpointInterval,
+					MinInterval:     OS.MinCheckpointInterval,
+				},
+				MaxLogSize:     maxLogSize,
+				SecretsTimeout: OS.SecretsTimeout,
 			},
 			RequesterNodeConfig: requesternode.RequesterNodeConfig{},
 		}
@@ -16,6 +16,7 @@ import (
 	"github.com/filecoin-project/bacalhau/pkg/ipfs"
 	"github.com/filecoin-project/bacalhau/pkg/model"
 	"github.com/filecoin-project/bacalhau/pkg/publicapi"
+	"github.com/filecoin-project/bacalhau/pkg/secrets"
 	"github.com/filecoin-project/bacalhau/pkg/system"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
@@ -189,6 +190,8 @@ func setupDownloadFlags(cmd *cobra.Command, settings *ipfs.IPFSDownloadSettings)
 		settings.OutputDir, "Directory to write the output to.")
 	cmd.Flags().StringVar(&settings.IPFSSwarmAddrs, "ipfs-swarm-addrs",
 		settings.IPFSSwarmAddrs, "Comma-separated list of IPFS nodes to connect to.")
+	cmd.Flags().Int64Var(&settings.MaxLogSize, "max-log-size",
+		settings.MaxLogSize, "The most bytes of stdout and stderr from all the shards to concatenate into the output dir.")
 }
 
 type RunTimeSettings struct {
@@ -198,6 +201,9 @@ type RunTimeSettings struct {
 	IPFSGetTimeOut                   int  // Timeout for IPFS in seconds
 	IsLocal                          bool // Job should be executed locally
 
+	// the values of the job's secrets, which we send to the compute
+	// nodes that run it so we have to wait for it to finish
+	SecretValues map[string]string
 }
 
 func NewRunTimeSettings() *RunTimeSettings {
@@ -261,12 +267,25 @@ func ExecuteJob(ctx context.Context,
 		apiClient = GetAPIClient()
 	}
 
+	if len(jobSpec.Secrets) > 0 {
+		err := secrets.Check(jobSpec.Secrets, runtimeSettings.SecretValues)
+		if err != nil {
+			return err
+		}
+		runtimeSettings.WaitForJobToFinish = true
+	}
+
 	j, err := submitJob(ctx, apiClient, jobSpec, jobDeal)
 	if err != nil {
 		return err
 	}
 
 	cmd.Printf("%s\n", j.ID)
+	if len(jobSpec.Secrets) > 0 {
+		secretsCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go deliverSecrets(secretsCtx, apiClient, j.ID, runtimeSettings.SecretValues)
+	}
 	if runtimeSettings.WaitForJobToFinish || runtimeSettings.WaitForJobToFinishAndPrintOutput {
 		// We have a jobID now, add it to the context baggage
 		ctx = system.AddJobIDToBaggage(ctx, j.ID)
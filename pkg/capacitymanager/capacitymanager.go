@@ -21,6 +21,9 @@ type Config struct {
 	// if a job does not state how much CPU or Memory is used
 	// what values should we assume?
 	ResourceRequirementsDefault model.ResourceUsageConfig
+	// where do we discover the GPU devices on this node?
+	// if this is nil we ask nvidia-container-cli
+	GPUInventory GPUInventory
 }
 
 type CapacityManagerItem struct {
@@ -59,6 +62,9 @@ type CapacityManager struct {
 	resourceRequirementsJobDefault model.ResourceUsageData
 
 	capacityTracker CapacityTracker
+
+	// which GPU devices are assigned to which running shards
+	gpuAllocator *gpuAllocator
 }
 
 func NewCapacityManager( //nolint:funlen,gocyclo
@@ -82,11 +88,24 @@ func NewCapacityManager( //nolint:funlen,gocyclo
 		useConfig.ResourceRequirementsDefault.GPU = DefaultJobGPU
 	}
 
-	resourceLimitsTotal, err := getSystemResources(useConfig.ResourceLimitTotal)
+	if useConfig.GPUInventory == nil {
+		useConfig.GPUInventory = NvidiaGPUInventory{}
+	}
+
+	gpuDevices, err := useConfig.GPUInventory.DeviceIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	resourceLimitsTotal, err := getSystemResources(useConfig.ResourceLimitTotal, uint64(len(gpuDevices)))
 	if err != nil {
 		return nil, err
 	}
 
+	// if we have been configured to use fewer GPUs than the node has
+	// then only hand out the first N devices
+	gpuDevices = gpuDevices[:resourceLimitsTotal.GPU]
+
 	// this is the per job resource limit - i.e. no job can use more than this
 	// if no values are given - then we will use the system available resources
 	resourceLimitsJob := ParseResourceUsageConfig(useConfig.ResourceLimitJob)
@@ -178,6 +197,7 @@ func NewCapacityManager( //nolint:funlen,gocyclo
 		resourceLimitsTotal:            resourceLimitsTotal,
 		resourceLimitsJob:              resourceLimitsJob,
 		resourceRequirementsJobDefault: resourceRequirementsJobDefault,
+		gpuAllocator:                   newGPUAllocator(gpuDevices),
 	}, nil
 }
 
@@ -238,3 +258,19 @@ func (manager *CapacityManager) GetNextItems() []model.JobShard {
 
 	return shards
 }
+
+// reserve specific GPU devices for a shard that is about to run
+// calling this again for the same shard returns the same devices
+func (manager *CapacityManager) AllocateGPUs(shard model.JobShard, count uint64) ([]string, error) {
+	return manager.gpuAllocator.allocate(shard, count)
+}
+
+// return the GPU devices used by a shard back to the pool
+func (manager *CapacityManager) ReleaseGPUs(shard model.JobShard) {
+	manager.gpuAllocator.release(shard)
+}
+
+// the GPU device IDs currently allocated to a shard
+func (manager *CapacityManager) GetGPUs(shard model.JobShard) []string {
+	return manager.gpuAllocator.get(shard)
+}
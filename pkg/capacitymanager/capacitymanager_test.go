@@ -610,3 +610,77 @@ func TestGetFreeSpace(t *testing.T) {
 		t.Errorf("Should be using all GPU, but got %d", res.GPU)
 	}
 }
+
+func TestGPUAllocation(t *testing.T) {
+	capacityTracker := &MockCapacityTracker{}
+
+	m, err := NewCapacityManager(capacityTracker, Config{
+		ResourceLimitTotal: model.ResourceUsageConfig{
+			CPU:    "1",
+			Memory: "1Gi",
+		},
+		GPUInventory: StaticGPUInventory{"0", "1", "2"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), m.resourceLimitsTotal.GPU)
+
+	shard1 := model.JobShard{Job: model.Job{ID: "job1"}, Index: 0}
+	shard2 := model.JobShard{Job: model.Job{ID: "job2"}, Index: 0}
+	shard3 := model.JobShard{Job: model.Job{ID: "job3"}, Index: 0}
+
+	devices, err := m.AllocateGPUs(shard1, 2)
+	require.NoError(t, err)
+	require.Equal(t, []string{"0", "1"}, devices)
+
+	// allocating again for the same shard returns the same devices
+	devices, err = m.AllocateGPUs(shard1, 2)
+	require.NoError(t, err)
+	require.Equal(t, []string{"0", "1"}, devices)
+
+	devices, err = m.AllocateGPUs(shard2, 1)
+	require.NoError(t, err)
+	require.Equal(t, []string{"2"}, devices)
+
+	_, err = m.AllocateGPUs(shard3, 1)
+	require.Error(t, err)
+
+	m.ReleaseGPUs(shard1)
+	require.Empty(t, m.GetGPUs(shard1))
+
+	devices, err = m.AllocateGPUs(shard3, 2)
+	require.NoError(t, err)
+	require.Equal(t, []string{"0", "1"}, devices)
+	require.Equal(t, []string{"2"}, m.GetGPUs(shard2))
+}
+
+func TestGPUInventoryLimitedByConfig(t *testing.T) {
+	capacityTracker := &MockCapacityTracker{}
+
+	m, err := NewCapacityManager(capacityTracker, Config{
+		ResourceLimitTotal: model.ResourceUsageConfig{
+			CPU:    "1",
+			Memory: "1Gi",
+			GPU:    "1",
+		},
+		GPUInventory: StaticGPUInventory{"0", "1"},
+	})
+	require.NoError(t, err)
+
+	shard1 := model.JobShard{Job: model.Job{ID: "job1"}, Index: 0}
+	shard2 := model.JobShard{Job: model.Job{ID: "job2"}, Index: 0}
+
+	devices, err := m.AllocateGPUs(shard1, 1)
+	require.NoError(t, err)
+	require.Equal(t, []string{"0"}, devices)
+
+	_, err = m.AllocateGPUs(shard2, 1)
+	require.Error(t, err)
+
+	_, err = NewCapacityManager(capacityTracker, Config{
+		ResourceLimitTotal: model.ResourceUsageConfig{
+			GPU: "3",
+		},
+		GPUInventory: StaticGPUInventory{"0", "1"},
+	})
+	require.Error(t, err)
+}
@@ -0,0 +1,92 @@
+package capacitymanager
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/filecoin-project/bacalhau/pkg/model"
+)
+
+// GPUInventory lists the GPU devices that are present on this node
+type GPUInventory interface {
+	DeviceIDs() ([]string, error)
+}
+
+// NvidiaGPUInventory discovers GPU devices using nvidia-container-cli
+type NvidiaGPUInventory struct{}
+
+func (NvidiaGPUInventory) DeviceIDs() ([]string, error) {
+	return systemGPUDeviceIDs()
+}
+
+// StaticGPUInventory is a fixed list of device IDs
+// this is mainly used for tests where there are no real GPUs
+type StaticGPUInventory []string
+
+func (inventory StaticGPUInventory) DeviceIDs() ([]string, error) {
+	return inventory, nil
+}
+
+// keeps track of which GPU device is being used by which shard
+type gpuAllocator struct {
+	// the device IDs that we are allowed to hand out
+	devices []string
+	// map of shard ID -> the device IDs allocated to that shard
+	allocations map[string][]string
+	mu          sync.Mutex
+}
+
+func newGPUAllocator(devices []string) *gpuAllocator {
+	return &gpuAllocator{
+		devices:     devices,
+		allocations: map[string][]string{},
+	}
+}
+
+func (a *gpuAllocator) allocate(shard model.JobShard, count uint64) ([]string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if existing, ok := a.allocations[shard.ID()]; ok {
+		return existing, nil
+	}
+
+	used := map[string]bool{}
+	for _, deviceIDs := range a.allocations {
+		for _, deviceID := range deviceIDs {
+			used[deviceID] = true
+		}
+	}
+
+	allocated := []string{}
+	for _, deviceID := range a.devices {
+		if uint64(len(allocated)) == count {
+			break
+		}
+		if !used[deviceID] {
+			allocated = append(allocated, deviceID)
+		}
+	}
+
+	if uint64(len(allocated)) < count {
+		return nil, fmt.Errorf(
+			"shard %s requires %d GPUs but only %d of %d are free",
+			shard, count, len(allocated), len(a.devices),
+		)
+	}
+
+	a.allocations[shard.ID()] = allocated
+	return allocated, nil
+}
+
+func (a *gpuAllocator) release(shard model.JobShard) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.allocations, shard.ID())
+}
+
+func (a *gpuAllocator) get(shard model.JobShard) []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.allocations[shard.ID()]
+}
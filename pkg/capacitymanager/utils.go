@@ -96,15 +96,15 @@ func getFreeDiskSpace(path string) (uint64, error) {
 	return fs.Bfree * uint64(fs.Bsize), nil
 }
 
-// numSystemGPUs wraps nvidia-container-cli to get the number of GPUs
-func numSystemGPUs() (uint64, error) {
+// systemGPUDeviceIDs wraps nvidia-container-cli to get the device IDs of the GPUs
+func systemGPUDeviceIDs() ([]string, error) {
 	nvidiaPath, err := exec.LookPath(NvidiaCLI)
 	if err != nil {
 		// If the NVIDIA CLI is not installed, we can't know the number of GPUs, assume zero
 		if (err.(*exec.Error)).Unwrap() == exec.ErrNotFound {
-			return 0, nil
+			return []string{}, nil
 		}
-		return 0, err
+		return nil, err
 	}
 	args := []string{
 		"info",
@@ -113,13 +113,18 @@ func numSystemGPUs() (uint64, error) {
 	cmd := exec.Command(nvidiaPath, args...)
 	resp, err := cmd.Output()
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
+	return parseNvidiaDeviceIDs(string(resp)), nil
+}
 
-	// Parse output of nvidia-container-cli command
-	lines := strings.Split(string(resp), "\n")
+// parse the output of nvidia-container-cli info --csv
+// the device section starts with a "Device Index" header and
+// each row after it begins with the index of the device
+func parseNvidiaDeviceIDs(output string) []string {
+	lines := strings.Split(output, "\n")
 	deviceInfoFlag := false
-	numDevices := uint64(0)
+	deviceIDs := []string{}
 	for _, line := range lines {
 		if strings.TrimSpace(line) == "" {
 			continue
@@ -129,16 +134,14 @@ func numSystemGPUs() (uint64, error) {
 			continue
 		}
 		if deviceInfoFlag {
-			numDevices += 1
+			deviceIDs = append(deviceIDs, strings.TrimSpace(strings.Split(line, ",")[0]))
 		}
 	}
-
-	fmt.Println(numDevices)
-	return numDevices, nil
+	return deviceIDs
 }
 
 // what resources does this compute node actually have?
-func getSystemResources(limitConfig model.ResourceUsageConfig) (model.ResourceUsageData, error) {
+func getSystemResources(limitConfig model.ResourceUsageConfig, gpus uint64) (model.ResourceUsageData, error) {
 	// this is used mainly for tests to be deterministic
 	allowOverCommit := os.Getenv("BACALHAU_CAPACITY_MANAGER_OVER_COMMIT") != ""
 
@@ -146,10 +149,6 @@ func getSystemResources(limitConfig model.ResourceUsageConfig) (model.ResourceUs
 	if err != nil {
 		return model.ResourceUsageData{}, err
 	}
-	gpus, err := numSystemGPUs()
-	if err != nil {
-		return model.ResourceUsageData{}, err
-	}
 
 	// the actual resources we have
 	physcialResources := model.ResourceUsageData{
@@ -159,7 +159,7 @@ func (suite *ResourceUsageUtilsSuite) TestSystemResources() {
 	for _, test := range tests {
 
 		suite.Run(test.name, func() {
-			resources, err := getSystemResources(test.input)
+			resources, err := getSystemResources(test.input, numSystemGPUsNoError())
 
 			if test.shouldError {
 				require.Error(suite.T(), err, "an error was expected")
@@ -265,9 +265,21 @@ func TestCheckResourceUsage(t *testing.T) {
 }
 
 func numSystemGPUsNoError() uint64 {
-	numGPUs, err := numSystemGPUs()
+	deviceIDs, err := systemGPUDeviceIDs()
 	if err != nil {
 		return 0
 	}
-	return numGPUs
+	return uint64(len(deviceIDs))
+}
+
+func TestParseNvidiaDeviceIDs(t *testing.T) {
+	output := `NVRM version,CUDA version
+470.57.02,11.4
+
+Device Index,Device Minor,Model,Brand,GPU UUID,Bus Location,Architecture
+0,0,Tesla T4,Nvidia,GPU-6d9f6c5b,00000000:00:04.0,7.5
+1,1,Tesla T4,Nvidia,GPU-2a1c8e5d,00000000:00:05.0,7.5
+`
+	require.Equal(t, []string{"0", "1"}, parseNvidiaDeviceIDs(output))
+	require.Equal(t, []string{}, parseNvidiaDeviceIDs(""))
 }
@@ -0,0 +1,220 @@
+package computenode
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/filecoin-project/bacalhau/pkg/executor"
+	jobutils "github.com/filecoin-project/bacalhau/pkg/job"
+	"github.com/filecoin-project/bacalhau/pkg/model"
+)
+
+const (
+	DefaultCheckpointInterval    = 5 * time.Minute
+	DefaultMinCheckpointInterval = 30 * time.Second
+)
+
+// configures how often we snapshot the checkpoint volumes of running shards
+type CheckpointConfig struct {
+	// how often to snapshot when the job doesn't say
+	DefaultInterval time.Duration
+	// jobs that ask to be snapshotted more often than this get this instead
+	// so they can't flood the publisher
+	MinInterval time.Duration
+}
+
+func NewDefaultCheckpointConfig() CheckpointConfig {
+	return CheckpointConfig{
+		DefaultInterval: DefaultCheckpointInterval,
+		MinInterval:     DefaultMinCheckpointInterval,
+	}
+}
+
+// publishes a snapshot of a checkpoint volume that has been copied to snapshotDir
+type checkpointPublisher func(ctx context.Context, snapshotDir string) error
+
+// snapshots the checkpoint volume of a running shard every interval
+// and publishes the snapshot if anything in the volume has changed
+type checkpointer struct {
+	dir      string
+	interval time.Duration
+	publish  checkpointPublisher
+	// what the volume looked like when we last published it
+	lastState string
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+func newCheckpointer(dir string, interval time.Duration, publish checkpointPublisher) (*checkpointer, error) {
+	// a volume we just restored doesn't need publishing again
+	state, err := checkpointState(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &checkpointer{
+		dir:       dir,
+		interval:  interval,
+		publish:   publish,
+		lastState: state,
+	}, nil
+}
+
+func (c *checkpointer) start(ctx context.Context) {
+	ctx, c.cancel = context.WithCancel(ctx)
+	c.done = make(chan struct{})
+	go func() {
+		defer close(c.done)
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := c.snapshot(ctx); err != nil {
+					log.Warn().Msgf("Could not publish checkpoint from %s: %s", c.dir, err)
+				}
+			}
+		}
+	}()
+}
+
+// stop snapshotting and throw the volume away - a shard that failed gets
+// one last snapshot so it can resume from as late as possible
+func (c *checkpointer) stop(ctx context.Context, failed bool) {
+	if c.cancel != nil {
+		c.cancel()
+		<-c.done
+	}
+	if failed {
+		if err := c.snapshot(ctx); err != nil {
+			log.Warn().Msgf("Could not publish checkpoint from %s: %s", c.dir, err)
+		}
+	}
+	if err := os.RemoveAll(c.dir); err != nil {
+		log.Debug().Msgf("Could not remove checkpoint volume %s: %s", c.dir, err)
+	}
+}
+
+func (c *checkpointer) snapshot(ctx context.Context) error {
+	state, err := checkpointState(c.dir)
+	if err != nil {
+		return err
+	}
+	if state == c.lastState {
+		return nil
+	}
+
+	// publish a copy so the job can carry on writing while we do
+	snapshotDir, err := os.MkdirTemp("", "bacalhau-checkpoint-snapshot-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(snapshotDir)
+	err = executor.CopyDir(c.dir, snapshotDir)
+	if err != nil {
+		return err
+	}
+	err = c.publish(ctx, snapshotDir)
+	if err != nil {
+		return err
+	}
+	c.lastState = state
+	return nil
+}
+
+// a summary of the names, sizes and modification times of everything in dir
+// that changes when the job writes to it
+func checkpointState(dir string) (string, error) {
+	h := fnv.New64a()
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00%d\x00%d\n", relPath, info.Mode(), info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// how often the checkpoint volume of a shard of the job is snapshotted on this node
+func (n *ComputeNode) checkpointInterval(spec model.JobSpec) time.Duration {
+	interval := time.Duration(spec.Checkpoint.Interval * float64(time.Second))
+	if interval <= 0 {
+		interval = n.config.CheckpointConfig.DefaultInterval
+	}
+	if interval <= 0 {
+		interval = DefaultCheckpointInterval
+	}
+	if interval < n.config.CheckpointConfig.MinInterval {
+		interval = n.config.CheckpointConfig.MinInterval
+	}
+	return interval
+}
+
+// make the shard's checkpoint volume, put the checkpoint the requester
+// accepted our bid with in it and start snapshotting it
+func (n *ComputeNode) startCheckpoints(
+	ctx context.Context,
+	e executor.Executor,
+	shard model.JobShard,
+) (*checkpointer, error) {
+	restorer, ok := e.(executor.CheckpointRestorer)
+	if !ok {
+		return nil, fmt.Errorf("executor does not support checkpoints: %s", shard.Job.Spec.Engine.String())
+	}
+	events, err := n.controller.GetJobEvents(ctx, shard.Job.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "bacalhau-checkpoint-")
+	if err != nil {
+		return nil, err
+	}
+	if checkpoint := jobutils.AcceptedCheckpoint(shard.Job, events, n.ID, shard.Index); checkpoint != nil {
+		log.Info().Msgf("Compute node %s resuming %s from checkpoint %s", n.ID, shard, checkpoint.Cid)
+		err = restorer.RestoreCheckpoint(ctx, *checkpoint, dir)
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, err
+		}
+	}
+
+	checkpoints, err := newCheckpointer(dir, n.checkpointInterval(shard.Job.Spec), func(ctx context.Context, snapshotDir string) error {
+		return n.publishCheckpoint(ctx, shard, snapshotDir)
+	})
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	checkpoints.start(ctx)
+	return checkpoints, nil
+}
+
+func (n *ComputeNode) publishCheckpoint(ctx context.Context, shard model.JobShard, snapshotDir string) error {
+	publisher, err := n.getPublisher(ctx, shard.Job.Spec.Publisher)
+	if err != nil {
+		return err
+	}
+	checkpoint, err := publisher.PublishShardResult(ctx, shard, n.ID, snapshotDir)
+	if err != nil {
+		return err
+	}
+	log.Debug().Msgf("Compute node %s published checkpoint %s for %s", n.ID, checkpoint.Cid, shard)
+	return n.controller.ShardCheckpointPublished(ctx, shard, checkpoint)
+}
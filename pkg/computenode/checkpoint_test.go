@@ -0,0 +1,114 @@
+package computenode
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	jobutils "github.com/filecoin-project/bacalhau/pkg/job"
+	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/stretchr/testify/require"
+)
+
+// a checkpointer that remembers what was in each snapshot it published
+func newTestCheckpointer(t *testing.T, dir string, interval time.Duration) (*checkpointer, chan string) {
+	published := make(chan string, 10)
+	c, err := newCheckpointer(dir, interval, func(ctx context.Context, snapshotDir string) error {
+		count, err := os.ReadFile(filepath.Join(snapshotDir, "count"))
+		if err != nil {
+			return err
+		}
+		published <- string(count)
+		return nil
+	})
+	require.NoError(t, err)
+	return c, published
+}
+
+func TestCheckpointerOnlyPublishesChanges(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "count"), []byte("1"), 0644))
+	c, published := newTestCheckpointer(t, dir, time.Hour)
+
+	// what we restored doesn't need publishing again
+	require.NoError(t, c.snapshot(context.Background()))
+	require.Empty(t, published)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "count"), []byte("22"), 0644))
+	require.NoError(t, c.snapshot(context.Background()))
+	require.Equal(t, "22", <-published)
+	require.NoError(t, c.snapshot(context.Background()))
+	require.Empty(t, published)
+}
+
+func TestCheckpointerSnapshotsPeriodically(t *testing.T) {
+	dir := t.TempDir()
+	c, published := newTestCheckpointer(t, dir, 10*time.Millisecond)
+	c.start(context.Background())
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "count"), []byte("1"), 0644))
+
+	select {
+	case count := <-published:
+		require.Equal(t, "1", count)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "checkpoint was not published")
+	}
+	c.stop(context.Background(), false)
+	require.NoDirExists(t, dir)
+}
+
+func TestCheckpointerSnapshotsFailedShards(t *testing.T) {
+	dir := t.TempDir()
+	c, published := newTestCheckpointer(t, dir, time.Hour)
+	c.start(context.Background())
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "count"), []byte("3"), 0644))
+
+	c.stop(context.Background(), true)
+	require.Equal(t, "3", <-published)
+	require.NoDirExists(t, dir)
+}
+
+func TestCheckpointInterval(t *testing.T) {
+	n := &ComputeNode{config: ComputeNodeConfig{CheckpointConfig: CheckpointConfig{
+		DefaultInterval: time.Minute,
+		MinInterval:     10 * time.Second,
+	}}}
+	interval := func(seconds float64) time.Duration {
+		return n.checkpointInterval(model.JobSpec{Checkpoint: model.JobSpecCheckpoint{Path: "/checkpoint", Interval: seconds}})
+	}
+	require.Equal(t, time.Minute, interval(0))
+	require.Equal(t, 30*time.Second, interval(30))
+	require.Equal(t, 10*time.Second, interval(1))
+}
+
+func TestCheckpointsNeedAnAcceptedBid(t *testing.T) {
+	ctx := context.Background()
+	job := model.Job{
+		ID:   "checkpoint-job",
+		Spec: model.JobSpec{Checkpoint: model.JobSpecCheckpoint{Path: "/checkpoint"}},
+	}
+	n, ctrl := newAcceptedTestNode(t, NewDefaultComputeNodeConfig(), job)
+	job.RequesterNodeID = ctrl.HostID()
+	checkpoint := model.StorageSpec{Engine: model.StorageSourceIPFS, Cid: "QmCheckpoint"}
+
+	err := ctrl.ShardCheckpointPublished(ctx, model.JobShard{Job: job, Index: 1}, checkpoint)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "has not been accepted")
+	require.NoError(t, ctrl.ShardCheckpointPublished(ctx, model.JobShard{Job: job, Index: 0}, checkpoint))
+
+	// the next time the shard is accepted it resumes from the checkpoint
+	require.Eventually(t, func() bool {
+		events, err := ctrl.GetJobEvents(ctx, job.ID)
+		require.NoError(t, err)
+		return jobutils.LatestCheckpoint(job, events, 0) != nil
+	}, time.Second, time.Millisecond)
+	require.NoError(t, ctrl.AcceptJobBid(ctx, job.ID, n.ID, 0))
+	require.Eventually(t, func() bool {
+		events, err := ctrl.GetJobEvents(ctx, job.ID)
+		require.NoError(t, err)
+		accepted := jobutils.AcceptedCheckpoint(job, events, n.ID, 0)
+		return accepted != nil && accepted.Cid == checkpoint.Cid
+	}, time.Second, time.Millisecond)
+}
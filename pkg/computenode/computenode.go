@@ -16,8 +16,10 @@ import (
 	jobutils "github.com/filecoin-project/bacalhau/pkg/job"
 	"github.com/filecoin-project/bacalhau/pkg/model"
 	"github.com/filecoin-project/bacalhau/pkg/publisher"
+	"github.com/filecoin-project/bacalhau/pkg/resultcache"
 	"github.com/filecoin-project/bacalhau/pkg/system"
 	"github.com/filecoin-project/bacalhau/pkg/verifier"
+	"github.com/filecoin-project/bacalhau/pkg/version"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog/log"
 )
@@ -26,6 +28,7 @@ const DefaultJobCPU = "100m"
 const DefaultJobMemory = "100Mb"
 const ControlLoopIntervalMillis = 100
 const DelayBeforeBidMillisecondRange = 100
+const DefaultNodeInfoPublishInterval = 30 * time.Second
 
 type ComputeNodeConfig struct {
 	// this contains things like data locality and per
@@ -35,6 +38,40 @@ type ComputeNodeConfig struct {
 	// configure the resource capacity we are allowing for
 	// this compute node
 	CapacityManagerConfig capacitymanager.Config
+
+	// operator defined key/value labels for this node
+	// jobs can use node selectors to only run on matching nodes
+	Labels map[string]string
+
+	// how often we broadcast our node info to the network
+	NodeInfoPublishInterval time.Duration
+
+	// where we cache the results of shards so identical
+	// shards can skip execution - an empty Dir disables this
+	ResultCacheConfig resultcache.Config
+
+	// fetch shard inputs while we wait to hear about our bid
+	PrefetchConfig PrefetchConfig
+
+	// the URL other nodes can reach our API on
+	// requester nodes use this to proxy requests for shard logs
+	APIURL string
+
+	// the longest we will let a shard run for - we don't bid on jobs
+	// that ask for longer and jobs with no timeout get this one
+	// zero means no limit
+	MaxJobExecutionTimeout time.Duration
+
+	// how often we snapshot the checkpoint volumes of running shards
+	CheckpointConfig CheckpointConfig
+
+	// how much of each of stdout and stderr is kept in the results
+	// of a shard - zero means the default
+	MaxLogSize int64
+
+	// how long we wait for the client to send the secrets of a shard
+	// we have been accepted for before we give up on it
+	SecretsTimeout time.Duration
 }
 
 type ComputeNode struct {
@@ -53,13 +90,31 @@ type ComputeNode struct {
 	publishers               map[model.PublisherType]publisher.Publisher
 	publishersInstalledCache map[model.PublisherType]bool
 	capacityManager          *capacitymanager.CapacityManager
+	resultCache              *resultcache.ResultCache
+	prefetcher               *prefetcher
 	componentMu              sync.Mutex
 	bidMu                    sync.Mutex
+
+	// a draining node finishes what it has been accepted for
+	// but does not take on any new work
+	draining   bool
+	drainingMu sync.RWMutex
+
+	// the output of shards that are running or recently finished
+	logs   map[string]*shardLogs
+	logsMu sync.Mutex
+
+	// the shards waiting for the client to send their secrets
+	secretsRequests   map[string]chan []byte
+	secretsRequestsMu sync.Mutex
 }
 
 func NewDefaultComputeNodeConfig() ComputeNodeConfig {
 	return ComputeNodeConfig{
-		JobSelectionPolicy: NewDefaultJobSelectionPolicy(),
+		JobSelectionPolicy:      NewDefaultJobSelectionPolicy(),
+		NodeInfoPublishInterval: DefaultNodeInfoPublishInterval,
+		CheckpointConfig:        NewDefaultCheckpointConfig(),
+		SecretsTimeout:          DefaultSecretsTimeout,
 	}
 }
 
@@ -83,6 +138,7 @@ func NewComputeNode(
 
 	computeNode.subscriptionSetup(ctx)
 	go computeNode.controlLoopSetup(ctx, cm)
+	go computeNode.nodeInfoLoopSetup(ctx, cm)
 
 	return computeNode, nil
 }
@@ -111,6 +167,14 @@ func constructComputeNode(
 		return nil, err
 	}
 
+	var resultCache *resultcache.ResultCache
+	if config.ResultCacheConfig.Dir != "" {
+		resultCache, err = resultcache.NewResultCache(config.ResultCacheConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	computeNode := &ComputeNode{
 		ID:                       nodeID,
 		config:                   config,
@@ -123,6 +187,10 @@ func constructComputeNode(
 		publishers:               publishers,
 		publishersInstalledCache: map[model.PublisherType]bool{},
 		capacityManager:          capacityManager,
+		resultCache:              resultCache,
+		prefetcher:               newPrefetcher(config.PrefetchConfig),
+		logs:                     map[string]*shardLogs{},
+		secretsRequests:          map[string]chan []byte{},
 	}
 
 	computeNode.componentMu.EnableTracerWithOpts(sync.Opts{
@@ -133,6 +201,18 @@ func constructComputeNode(
 		Threshold: 10 * time.Millisecond,
 		Id:        "ComputeNode.bidMu",
 	})
+	computeNode.drainingMu.EnableTracerWithOpts(sync.Opts{
+		Threshold: 10 * time.Millisecond,
+		Id:        "ComputeNode.drainingMu",
+	})
+	computeNode.logsMu.EnableTracerWithOpts(sync.Opts{
+		Threshold: 10 * time.Millisecond,
+		Id:        "ComputeNode.logsMu",
+	})
+	computeNode.secretsRequestsMu.EnableTracerWithOpts(sync.Opts{
+		Threshold: 10 * time.Millisecond,
+		Id:        "ComputeNode.secretsRequestsMu",
+	})
 
 	return computeNode, nil
 }
@@ -165,6 +245,73 @@ func (n *ComputeNode) controlLoopSetup(ctx context.Context, cm *system.CleanupMa
 	}
 }
 
+// periodically tell the rest of the network what we support
+// and how much capacity we have free
+func (n *ComputeNode) nodeInfoLoopSetup(ctx context.Context, cm *system.CleanupManager) {
+	interval := n.config.NodeInfoPublishInterval
+	if interval <= 0 {
+		interval = DefaultNodeInfoPublishInterval
+	}
+	ticker := time.NewTicker(interval)
+	ctx, cancelFunction := context.WithCancel(ctx)
+	cm.RegisterCallback(func() error {
+		cancelFunction()
+		return nil
+	})
+
+	// straight away so the node can be scheduled before the first tick
+	n.publishNodeInfo(ctx)
+	for {
+		select {
+		case <-ticker.C:
+			n.publishNodeInfo(ctx)
+		case <-ctx.Done():
+			ticker.Stop()
+			return
+		}
+	}
+}
+
+func (n *ComputeNode) publishNodeInfo(ctx context.Context) {
+	err := n.controller.PublishNodeInfo(ctx, n.GetNodeInfo(ctx))
+	if err != nil {
+		log.Warn().Msgf("Compute node %s could not publish node info: %s", n.ID, err)
+	}
+}
+
+// the information we advertise about this node in heartbeats
+func (n *ComputeNode) GetNodeInfo(ctx context.Context) model.NodeInfo {
+	info := model.NodeInfo{
+		NodeID:       n.ID,
+		Version:      *version.Get(),
+		Labels:       n.config.Labels,
+		Engines:      []model.EngineType{},
+		Verifiers:    []model.VerifierType{},
+		Publishers:   []model.PublisherType{},
+		FreeCapacity: n.capacityManager.GetFreeSpace(),
+		Draining:     n.IsDraining(),
+		APIURL:       n.config.APIURL,
+	}
+
+	for _, typ := range model.EngineTypes() {
+		if _, err := n.getExecutor(ctx, typ); err == nil {
+			info.Engines = append(info.Engines, typ)
+		}
+	}
+	for _, typ := range model.VerifierTypes() {
+		if _, err := n.getVerifier(ctx, typ); err == nil {
+			info.Verifiers = append(info.Verifiers, typ)
+		}
+	}
+	for _, typ := range model.PublisherTypes() {
+		if _, err := n.getPublisher(ctx, typ); err == nil {
+			info.Publishers = append(info.Publishers, typ)
+		}
+	}
+
+	return info
+}
+
 // each control loop we should bid on jobs in our queue
 //   - calculate "remaining resources"
 //   - this is total - running
@@ -176,6 +323,9 @@ func (n *ComputeNode) controlLoopBidOnJobs(ctx context.Context) {
 	// TODO: #557 Should we trace every control loop, even when there is no work to do?
 	n.bidMu.Lock()
 	defer n.bidMu.Unlock()
+	if n.IsDraining() {
+		return
+	}
 	bidShards := n.capacityManager.GetNextItems()
 
 	if len(bidShards) > 0 {
@@ -280,6 +430,8 @@ func (n *ComputeNode) subscriptionSetup(ctx context.Context) {
 				n.subscriptionEventResultsAccepted(ctx, jobEvent, shard)
 			case model.JobEventResultsRejected:
 				n.subscriptionEventResultsRejected(ctx, jobEvent, shard)
+			case model.JobEventSecretsDelivered:
+				n.subscriptionEventSecretsDelivered(ctx, jobEvent, shard)
 			}
 		}
 	})
@@ -427,13 +579,46 @@ func (n *ComputeNode) subscriptionEventBidAccepted(ctx context.Context, jobEvent
 		"client_id":   shard.Job.ClientID,
 	}).Inc()
 
-	if shardState, ok := n.shardStateManager.Get(shard.ID()); ok {
+	// decide under the lock so Drain either sees the shard as accepted and
+	// leaves it to run or we see that we are draining - but only talk to
+	// the fsm once it is released, as a busy fsm would hold up Drain
+	n.drainingMu.RLock()
+	draining := n.draining
+	shardState, ok := n.shardStateManager.Get(shard.ID())
+	if ok && !draining {
+		shardState.markAccepted()
+	}
+	n.drainingMu.RUnlock()
+
+	switch {
+	case ok && shardState.BidCancelled():
+		// the requester accepted our bid before it heard that we cancelled it
+		n.rejectAcceptedShard(ctx, shard)
+	case ok && draining:
+		// withdraw the bid rather than start something new while draining
+		if !shardState.IsWaitingForBid() {
+			log.Debug().Msgf("Ignoring bid accepted for shard %s that is already under way", shard)
+			return
+		}
+		shardState.Cancel(ctx)
+		n.rejectAcceptedShard(ctx, shard)
+	case ok:
 		shardState.Execute(ctx)
-	} else {
+	case draining:
+		n.rejectAcceptedShard(ctx, shard)
+	default:
 		log.Error().Msgf("Received bid accepted for unknown shard %s", shard)
 	}
 }
 
+// tell the requester we are not going to run a shard it accepted our bid for
+func (n *ComputeNode) rejectAcceptedShard(ctx context.Context, shard model.JobShard) {
+	err := n.controller.ShardError(ctx, shard.Job.ID, shard.Index, "compute node is draining")
+	if err != nil {
+		log.Error().Msgf("Compute node %s could not reject accepted shard %s: %s", n.ID, shard, err)
+	}
+}
+
 /*
 subscriptions -> bid rejected
 */
@@ -494,12 +679,43 @@ func (n *ComputeNode) SelectJob(ctx context.Context, data JobSelectionPolicyProb
 
 	requirements := model.ResourceUsageData{}
 
+	if n.IsDraining() {
+		log.Debug().Msgf("Compute node %s skipped bidding on job because it is draining: %s", n.ID, data.JobID)
+		return false, requirements, nil
+	}
+
+	// check that our labels match what the job is asking for
+	labelsMatch, _, err := model.MatchNodeSelectors(data.Spec.NodeSelectors, n.config.Labels)
+	if err != nil {
+		return false, requirements, fmt.Errorf("error matching node selectors: %v", err)
+	}
+	if !labelsMatch {
+		log.Debug().Msgf("Compute node %s skipped bidding on job because labels did not match node selectors: %s",
+			n.ID, data.JobID)
+		return false, requirements, nil
+	}
+
+	maxTimeout := n.config.MaxJobExecutionTimeout
+	if maxTimeout > 0 && jobExecutionTimeout(data.Spec) > maxTimeout {
+		log.Debug().Msgf("Compute node %s skipped bidding on job because its timeout is longer than %s: %s",
+			n.ID, maxTimeout, data.JobID)
+		return false, requirements, nil
+	}
+
 	// check that we have the executor and it's installed
 	e, err := n.getExecutor(ctx, data.Spec.Engine)
 	if err != nil {
 		return false, requirements, fmt.Errorf("getExecutor: %v", err)
 	}
 
+	if data.Spec.Checkpoint.Enabled() {
+		if _, ok := e.(executor.CheckpointRestorer); !ok {
+			log.Debug().Msgf("Compute node %s skipped bidding on job because its executor can't give it checkpoints: %s",
+				n.ID, data.JobID)
+			return false, requirements, nil
+		}
+	}
+
 	// check that we have the verifier and it's installed
 	_, err = n.getVerifier(ctx, data.Spec.Verifier)
 	if err != nil {
@@ -560,7 +776,100 @@ func (n *ComputeNode) SelectJob(ctx context.Context, data JobSelectionPolicyProb
 // in the capacity manager
 func (n *ComputeNode) BidOnJob(ctx context.Context, shard model.JobShard) error {
 	log.Debug().Msgf("Compute node %s bidding on: %s", n.ID, shard)
-	return n.controller.BidJob(ctx, shard)
+	// tell the requester which of our labels matched the job
+	_, matchedLabels, err := model.MatchNodeSelectors(shard.Job.Spec.NodeSelectors, n.config.Labels)
+	if err != nil {
+		return err
+	}
+	// and how much of the input data we already have
+	// so it can prefer nodes that won't need to fetch it
+	e, err := n.getExecutor(ctx, shard.Job.Spec.Engine)
+	if err != nil {
+		return err
+	}
+	localityScore, err := CalculateLocalityScore(ctx, e, shard.Job.Spec)
+	if err != nil {
+		return err
+	}
+	return n.controller.BidJob(ctx, shard, matchedLabels, localityScore)
+}
+
+// start fetching the inputs for a shard we have bid on
+// failing to prefetch is never fatal - the executor will
+// fetch the inputs itself when it runs the shard
+func (n *ComputeNode) startPrefetch(ctx context.Context, shard model.JobShard, requirements model.ResourceUsageData) {
+	e, err := n.getExecutor(ctx, shard.Job.Spec.Engine)
+	if err != nil {
+		return
+	}
+	err = n.prefetcher.start(ctx, e, shard, requirements.Disk)
+	if err != nil {
+		log.Debug().Msgf("Compute node %s not prefetching inputs for %s: %s", n.ID, shard, err)
+	}
+}
+
+/*
+drain mode
+*/
+
+// Drain stops this node from taking on new jobs - any shards we have
+// queued or bid on but not been accepted for are withdrawn and any
+// shards we are running are left to finish and publish their results
+func (n *ComputeNode) Drain(ctx context.Context) model.DrainStatus {
+	n.drainingMu.Lock()
+	n.draining = true
+	n.drainingMu.Unlock()
+
+	// hold the bid lock so the control loop can't bid on
+	// anything in the backlog while we are cancelling it
+	n.bidMu.Lock()
+	defer n.bidMu.Unlock()
+	// the fsm might have moved on by the time it reads the request
+	// so we don't wait for it to be handled - DrainStatus counts the
+	// shards as in progress until their cancellation has finished
+	for _, shardState := range n.shardStateManager.GetEnqueued() {
+		go shardState.Cancel(ctx)
+	}
+	for _, shardState := range n.shardStateManager.GetBidding() {
+		// about to be run, as its bid was accepted before we started draining
+		if shardState.Accepted() {
+			continue
+		}
+		go shardState.Cancel(ctx)
+	}
+
+	log.Info().Msgf("Compute node %s is draining", n.ID)
+	return n.DrainStatus(ctx)
+}
+
+// Undrain lets this node take on new jobs again
+func (n *ComputeNode) Undrain(ctx context.Context) model.DrainStatus {
+	n.drainingMu.Lock()
+	n.draining = false
+	n.drainingMu.Unlock()
+
+	log.Info().Msgf("Compute node %s is no longer draining", n.ID)
+	return n.DrainStatus(ctx)
+}
+
+func (n *ComputeNode) IsDraining() bool {
+	n.drainingMu.RLock()
+	defer n.drainingMu.RUnlock()
+	return n.draining
+}
+
+// DrainStatus reports whether we are draining and if it's safe to shut down
+func (n *ComputeNode) DrainStatus(ctx context.Context) model.DrainStatus {
+	draining := n.IsDraining()
+	// enqueued and bidding shards are only safe once they are cancelled
+	inProgress := len(n.shardStateManager.GetEnqueued()) +
+		len(n.shardStateManager.GetBidding()) +
+		len(n.shardStateManager.GetInProgress())
+	return model.DrainStatus{
+		Draining:         draining,
+		ShardsInProgress: inProgress,
+		SafeToShutdown:   draining && inProgress == 0,
+	}
 }
 
 /*
@@ -573,7 +882,86 @@ func (n *ComputeNode) RunShardExecution(ctx context.Context, shard model.JobShar
 	if err != nil {
 		return err
 	}
-	return e.RunShard(ctx, shard, resultFolder)
+
+	// hand specific GPU devices to this shard for as long as it is running
+	requirements := capacitymanager.ParseResourceUsageConfig(shard.Job.Spec.Resources)
+	if requirements.GPU > 0 {
+		var deviceIDs []string
+		deviceIDs, err = n.capacityManager.AllocateGPUs(shard, requirements.GPU)
+		if err != nil {
+			return err
+		}
+		defer n.capacityManager.ReleaseGPUs(shard)
+		log.Debug().Msgf("Compute node %s allocated GPUs %v to shard %s", n.ID, deviceIDs, shard)
+		ctx = executor.ContextWithGPUDevices(ctx, deviceIDs)
+	}
+
+	// if we started fetching the inputs while we were bidding
+	// then let that finish so the executor can use them
+	n.prefetcher.wait(ctx, shard)
+
+	// the executor kills the shard if it runs for longer than this
+	if timeout := n.executionTimeout(shard.Job.Spec); timeout > 0 {
+		ctx = executor.ContextWithExecutionTimeout(ctx, timeout)
+	}
+
+	// let clients follow the output of the shard while it runs
+	logs := n.startShardLogs(shard)
+	defer logs.finish()
+	ctx = executor.ContextWithLogWriters(ctx, logs.writer(model.LogStreamStdout), logs.writer(model.LogStreamStderr))
+	if n.config.MaxLogSize > 0 {
+		ctx = executor.ContextWithMaxLogSize(ctx, n.config.MaxLogSize)
+	}
+
+	// tell the requester how the shard is getting on, like how far the executor
+	// has got pulling its image - this isn't worth failing the shard over
+	ctx = executor.ContextWithStatusReporter(ctx, func(status string) {
+		if reportErr := n.controller.ShardRunning(ctx, shard.Job.ID, shard.Index, status); reportErr != nil {
+			log.Debug().Msgf("Compute node %s could not report the status of %s: %s", n.ID, shard, reportErr)
+		}
+	})
+
+	// the client only sends the secrets once we ask for them, which is
+	// after our bid has been accepted
+	if len(shard.Job.Spec.Secrets) > 0 {
+		var secretValues map[string]string
+		secretValues, err = n.requestSecrets(ctx, shard)
+		if err != nil {
+			return err
+		}
+		ctx = executor.ContextWithSecrets(ctx, secretValues)
+	}
+
+	// give the shard somewhere to keep its progress that we publish as it runs
+	var checkpoints *checkpointer
+	if shard.Job.Spec.Checkpoint.Enabled() {
+		checkpoints, err = n.startCheckpoints(ctx, e, shard)
+		if err != nil {
+			return err
+		}
+		ctx = executor.ContextWithCheckpointDir(ctx, checkpoints.dir)
+	}
+
+	err = e.RunShard(ctx, shard, resultFolder)
+	if checkpoints != nil {
+		checkpoints.stop(ctx, err != nil)
+	}
+	return err
+}
+
+// the timeout the job asked for - zero if it didn't ask for one
+func jobExecutionTimeout(spec model.JobSpec) time.Duration {
+	return time.Duration(spec.Timeout * float64(time.Second))
+}
+
+// how long a shard of the job can run for on this node - zero means no limit
+func (n *ComputeNode) executionTimeout(spec model.JobSpec) time.Duration {
+	timeout := jobExecutionTimeout(spec)
+	maxTimeout := n.config.MaxJobExecutionTimeout
+	if maxTimeout > 0 && (timeout <= 0 || timeout > maxTimeout) {
+		return maxTimeout
+	}
+	return timeout
 }
 
 func (n *ComputeNode) RunShard(ctx context.Context, shard model.JobShard) ([]byte, error) {
@@ -588,6 +976,26 @@ func (n *ComputeNode) RunShard(ctx context.Context, shard model.JobShard) ([]byt
 		return shardProposal, err
 	}
 
+	// if we have run an identical shard before we can propose those results
+	cacheKey, cacheable := "", false
+	if n.resultCache != nil {
+		cacheKey, cacheable = resultcache.Key(shard)
+	}
+	if cacheable {
+		hit, cacheErr := n.resultCache.Get(cacheKey, resultFolder)
+		if cacheErr != nil {
+			log.Warn().Msgf("Compute node %s could not read cached results for %s: %s", n.ID, shard, cacheErr)
+		} else if hit {
+			log.Info().Msgf("Compute node %s using cached results for %s", n.ID, shard)
+			n.prefetcher.cancel(ctx, shard)
+			resultCacheHits.With(prometheus.Labels{
+				"node_id":   n.ID,
+				"client_id": shard.Job.ClientID,
+			}).Inc()
+			return verifier.GetShardProposal(ctx, shard, resultFolder)
+		}
+	}
+
 	containerRunError := n.RunShardExecution(ctx, shard, resultFolder)
 	if containerRunError != nil {
 		jobsFailed.With(prometheus.Labels{
@@ -609,9 +1017,36 @@ func (n *ComputeNode) RunShard(ctx context.Context, shard model.JobShard) ([]byt
 		shardProposal, containerRunError = verifier.GetShardProposal(ctx, shard, resultFolder)
 	}
 
+	if containerRunError == nil && cacheable {
+		if cacheErr := n.resultCache.Put(cacheKey, resultFolder); cacheErr != nil {
+			log.Warn().Msgf("Compute node %s could not cache results for %s: %s", n.ID, shard, cacheErr)
+		}
+	}
+
 	return shardProposal, containerRunError
 }
 
+// what the executor measured the shard using - nil if it didn't record it
+func (n *ComputeNode) getShardResourceUsage(ctx context.Context, shard model.JobShard) *model.ShardResourceUsage {
+	verifier, err := n.getVerifier(ctx, shard.Job.Spec.Verifier)
+	if err != nil {
+		return nil
+	}
+	resultFolder, err := verifier.GetShardResultPath(ctx, shard)
+	if err != nil {
+		return nil
+	}
+	usage, ok, err := executor.ReadResourceUsage(resultFolder)
+	if err != nil {
+		log.Debug().Msgf("Compute node %s could not read the resource usage of %s: %s", n.ID, shard, err)
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+	return &usage
+}
+
 func (n *ComputeNode) PublishShard(ctx context.Context, shard model.JobShard) error {
 	verifier, err := n.getVerifier(ctx, shard.Job.Spec.Verifier)
 	if err != nil {
@@ -0,0 +1,73 @@
+package computenode
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecutionTimeout(t *testing.T) {
+	testCases := []struct {
+		name       string
+		maxTimeout time.Duration
+		jobTimeout float64
+		expected   time.Duration
+	}{
+		{"no limits", 0, 0, 0},
+		{"job timeout with no node maximum", 0, 90, 90 * time.Second},
+		{"job with no timeout gets the node maximum", time.Hour, 0, time.Hour},
+		{"job timeout under the node maximum", time.Hour, 1.5, 1500 * time.Millisecond},
+		{"job timeout is capped at the node maximum", time.Minute, 3600, time.Minute},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			n := &ComputeNode{config: ComputeNodeConfig{MaxJobExecutionTimeout: test.maxTimeout}}
+			require.Equal(t, test.expected, n.executionTimeout(model.JobSpec{Timeout: test.jobTimeout}))
+		})
+	}
+}
+
+// a shard whose fsm is busy when its bid is accepted mustn't hold up Drain,
+// which leaves the shard to run
+func TestDrainWhileAcceptedShardIsBusy(t *testing.T) {
+	ctx := context.Background()
+	job := model.Job{ID: "drain-job"}
+	n, _ := newAcceptedTestNode(t, NewDefaultComputeNodeConfig(), job)
+
+	// an fsm that isn't reading its requests, for a shard the helper
+	// didn't accept so we only see our own event
+	shard := model.JobShard{Job: job, Index: 1}
+	fsm := n.shardStateManager.newStateMachine(shard, n, model.ResourceUsageData{})
+	fsm.currentState = shardBidding
+	n.shardStateManager.mu.Lock()
+	n.shardStateManager.shardStates[shard.ID()] = fsm
+	n.shardStateManager.shardStatesList = append(n.shardStateManager.shardStatesList, fsm)
+	n.shardStateManager.mu.Unlock()
+
+	go n.subscriptionEventBidAccepted(ctx, model.JobEvent{JobID: job.ID, ShardIndex: 1}, shard)
+	require.Eventually(t, fsm.Accepted, time.Second, time.Millisecond)
+
+	drained := make(chan model.DrainStatus)
+	go func() {
+		drained <- n.Drain(ctx)
+	}()
+	select {
+	case status := <-drained:
+		require.True(t, status.Draining)
+		require.Equal(t, 1, status.ShardsInProgress)
+	case <-time.After(time.Second):
+		require.Fail(t, "Drain was held up by a busy fsm")
+	}
+
+	// the shard is run rather than withdrawn
+	require.Equal(t, actionRun, (<-fsm.req).action)
+	select {
+	case req := <-fsm.req:
+		require.Fail(t, "unexpected request", req.action.String())
+	case <-time.After(100 * time.Millisecond):
+	}
+}
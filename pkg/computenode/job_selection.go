@@ -9,6 +9,7 @@ import (
 	"strings"
 
 	"github.com/filecoin-project/bacalhau/pkg/executor"
+	"github.com/filecoin-project/bacalhau/pkg/executor/language"
 	"github.com/filecoin-project/bacalhau/pkg/model"
 	"github.com/rs/zerolog/log"
 )
@@ -26,9 +27,17 @@ type JobSelectionPolicy struct {
 	// where the data is located - i.e. if the data is "local"
 	// or if the data is "anywhere"
 	Locality JobSelectionDataLocality `json:"locality"`
+	// when the locality is "local" - what fraction of the job's input
+	// bytes must we already have before we take on the job
+	// zero means any amount will do and nil means we must have all of it
+	LocalityThreshold *float64 `json:"locality_threshold,omitempty"`
 	// should we reject jobs that don't specify any data
 	// the default is "accept"
 	RejectStatelessJobs bool `json:"reject_stateless_jobs"`
+	// the most network access we will give a job - jobs that need more are
+	// rejected, even if a probe would accept them
+	// the default is that jobs get no network access
+	MaxNetwork model.Network `json:"max_network,omitempty"`
 	// external hooks that decide if we should take on the job or not
 	// if either of these are given they will override the data locality settings
 	ProbeHTTP string `json:"probe_http,omitempty"`
@@ -125,29 +134,66 @@ func applyJobSelectionPolicySettings(
 		return true, nil
 	}
 
-	// otherwise we are checking that all of the named inputs in the job
-	// are local to us
-	foundInputs := 0
+	// otherwise we are checking that enough of the input data
+	// in the job is local to us
+	threshold := 1.0
+	if policy.LocalityThreshold != nil {
+		threshold = *policy.LocalityThreshold
+	}
+
+	score, err := CalculateLocalityScore(ctx, e, job)
+	if err != nil {
+		log.Error().Msgf("Error checking for storage resource locality: %s", err.Error())
+		return false, err
+	}
+
+	if score >= threshold {
+		log.Trace().Msgf("Found %.2f of input data locally (threshold %.2f) - accepting job", score, threshold)
+		return true, nil
+	} else {
+		log.Trace().Msgf("Found %.2f of input data locally (threshold %.2f) - passing on job", score, threshold)
+		return false, nil
+	}
+}
+
+// CalculateLocalityScore works out what fraction of the job's input bytes
+// the executor already has locally - 1 means we have everything and 0 means
+// we would need to fetch it all
+// if the executor can't tell us the size of any of the inputs then we
+// fall back to the fraction of the inputs we have
+func CalculateLocalityScore(
+	ctx context.Context,
+	e executor.Executor,
+	job model.JobSpec,
+) (float64, error) {
+	if len(job.Inputs) == 0 {
+		return 1, nil
+	}
+
+	var totalBytes, localBytes uint64
+	localInputs := 0
 
 	for _, input := range job.Inputs {
 		// see if the storage engine reports that we have the resource locally
 		hasStorage, err := e.HasStorageLocally(ctx, input)
 		if err != nil {
-			log.Error().Msgf("Error checking for storage resource locality: %s", err.Error())
-			return false, err
+			return 0, err
+		}
+		size, err := e.GetVolumeSize(ctx, input)
+		if err != nil {
+			return 0, err
 		}
+		totalBytes += size
 		if hasStorage {
-			foundInputs++
+			localInputs++
+			localBytes += size
 		}
 	}
 
-	if foundInputs >= len(job.Inputs) {
-		log.Trace().Msgf("Found %d of %d inputs - accepting job", foundInputs, len(job.Inputs))
-		return true, nil
-	} else {
-		log.Trace().Msgf("Found %d of %d inputs - passing on job", foundInputs, len(job.Inputs))
-		return false, nil
+	if totalBytes == 0 {
+		return float64(localInputs) / float64(len(job.Inputs)), nil
 	}
+	return float64(localBytes) / float64(totalBytes), nil
 }
 
 // the compute node "SelectJob" function will call out to this to handle
@@ -159,6 +205,15 @@ func ApplyJobSelectionPolicy(
 	e executor.Executor,
 	data JobSelectionPolicyProbeData,
 ) (bool, error) {
+	if !networkAllowedByPolicy(policy, data.Spec.Network) {
+		log.Trace().Msgf("Job needs %s network access - rejecting job", data.Spec.Network.Type)
+		return false, nil
+	}
+	if installNetwork := language.InstallNetwork(data.Spec); !networkAllowedByPolicy(policy, installNetwork) {
+		log.Trace().Msgf("Installing the job's requirements needs %s network access - rejecting job", installNetwork.Type)
+		return false, nil
+	}
+
 	if policy.ProbeExec != "" {
 		return applyJobSelectionPolicyExecProbe(ctx, policy.ProbeExec, data)
 	} else if policy.ProbeHTTP != "" {
@@ -167,3 +222,12 @@ func ApplyJobSelectionPolicy(
 		return applyJobSelectionPolicySettings(ctx, policy, e, data.Spec)
 	}
 }
+
+// networks are ordered from least to most access
+func networkAllowedByPolicy(policy JobSelectionPolicy, network model.NetworkConfig) bool {
+	maxNetwork := policy.MaxNetwork
+	if !model.IsValidNetwork(maxNetwork) {
+		maxNetwork = model.NetworkNone
+	}
+	return network.Disabled() || network.Type <= maxNetwork
+}
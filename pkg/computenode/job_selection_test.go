@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"github.com/filecoin-project/bacalhau/pkg/computenode/tooling"
+	noop_executor "github.com/filecoin-project/bacalhau/pkg/executor/noop"
 	"github.com/filecoin-project/bacalhau/pkg/model"
 	"github.com/stretchr/testify/require"
 )
@@ -227,3 +228,119 @@ func TestJobSelectionExec(t *testing.T) {
 		})
 	}
 }
+
+func threshold(value float64) *float64 {
+	return &value
+}
+
+func TestJobSelectionLocalityScore(t *testing.T) {
+	// two inputs - we have the small one locally but not the big one
+	localCid := "local-volume"
+	spec := model.JobSpec{
+		Inputs: []model.StorageSpec{
+			{Engine: model.StorageSourceIPFS, Cid: localCid},
+			{Engine: model.StorageSourceIPFS, Cid: "remote-volume"},
+		},
+	}
+	sizes := map[string]uint64{
+		localCid:        25,
+		"remote-volume": 75,
+	}
+
+	testCases := []struct {
+		name           string
+		sizes          map[string]uint64
+		threshold      *float64
+		expectedScore  float64
+		expectedResult bool
+	}{
+		{"default threshold needs all of the data", sizes, nil, 0.25, false},
+		{"zero threshold accepts any amount", sizes, threshold(0), 0.25, true},
+		{"below threshold", sizes, threshold(0.5), 0.25, false},
+		{"above threshold", sizes, threshold(0.2), 0.25, true},
+		{"unknown sizes falls back to counting inputs", map[string]uint64{}, threshold(0.5), 0.5, true},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			suite := tooling.NewTestSuite()
+			sizes := test.sizes
+			executor, err := tooling.NewNoopExecutor(suite.Cm, noop_executor.ExecutorConfig{
+				ExternalHooks: noop_executor.ExecutorConfigExternalHooks{
+					HasStorageLocally: func(ctx context.Context, volume model.StorageSpec) (bool, error) {
+						return volume.Cid == localCid, nil
+					},
+					GetVolumeSize: func(ctx context.Context, volume model.StorageSpec) (uint64, error) {
+						return sizes[volume.Cid], nil
+					},
+				},
+			})
+			require.NoError(t, err)
+
+			score, err := CalculateLocalityScore(context.Background(), executor, spec)
+			require.NoError(t, err)
+			require.InDelta(t, test.expectedScore, score, 0.0001)
+
+			result, err := ApplyJobSelectionPolicy(
+				context.Background(),
+				JobSelectionPolicy{
+					Locality:          Local,
+					LocalityThreshold: test.threshold,
+				},
+				executor,
+				JobSelectionPolicyProbeData{Spec: spec},
+			)
+			require.NoError(t, err)
+			require.Equal(t, test.expectedResult, result)
+		})
+	}
+}
+
+func TestJobSelectionMaxNetwork(t *testing.T) {
+	allowlist := model.NetworkConfig{Type: model.NetworkAllowlist, Domains: []string{"example.com"}}
+	full := model.NetworkConfig{Type: model.NetworkFull}
+
+	// the program has no network but installing its requirements needs PyPI
+	requirements := model.JobSpecLanguage{Language: "python", RequirementsPath: "requirements.txt"}
+
+	testCases := []struct {
+		name           string
+		maxNetwork     model.Network
+		network        model.NetworkConfig
+		language       model.JobSpecLanguage
+		expectedResult bool
+	}{
+		{"no network is always fine", 0, model.NetworkConfig{}, model.JobSpecLanguage{}, true},
+		{"default policy rejects allowlist", 0, allowlist, model.JobSpecLanguage{}, false},
+		{"none rejects allowlist", model.NetworkNone, allowlist, model.JobSpecLanguage{}, false},
+		{"allowlist accepts allowlist", model.NetworkAllowlist, allowlist, model.JobSpecLanguage{}, true},
+		{"allowlist rejects full", model.NetworkAllowlist, full, model.JobSpecLanguage{}, false},
+		{"full accepts full", model.NetworkFull, full, model.JobSpecLanguage{}, true},
+		{"none rejects installing requirements", model.NetworkNone, model.NetworkConfig{}, requirements, false},
+		{"allowlist accepts installing requirements", model.NetworkAllowlist, model.NetworkConfig{}, requirements, true},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			suite := tooling.NewTestSuite()
+			executor, err := tooling.NewNoopExecutor(suite.Cm, noop_executor.ExecutorConfig{})
+			require.NoError(t, err)
+
+			result, err := ApplyJobSelectionPolicy(
+				context.Background(),
+				JobSelectionPolicy{
+					Locality:   Anywhere,
+					MaxNetwork: test.maxNetwork,
+				},
+				executor,
+				JobSelectionPolicyProbeData{Spec: model.JobSpec{
+					Engine:   model.EngineLanguage,
+					Network:  test.network,
+					Language: test.language,
+				}},
+			)
+			require.NoError(t, err)
+			require.Equal(t, test.expectedResult, result)
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package computenode
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	sync "github.com/lukemarsden/golang-mutex-tracer"
+
+	"github.com/filecoin-project/bacalhau/pkg/model"
+)
+
+const (
+	// the most output we keep in memory for a single shard
+	// once we go over this the oldest output is dropped
+	DefaultShardLogBufferSize = 4 * 1024 * 1024
+
+	// how long we keep the output of a finished shard around
+	// so clients can still fetch it
+	shardLogRetention = 10 * time.Minute
+)
+
+// the output of a shard that is running or has recently finished
+type shardLogs struct {
+	chunks []model.LogChunk
+	// the number of chunks that have been dropped from the front of chunks
+	dropped    int
+	size       int
+	maxSize    int
+	finished   bool
+	finishedAt time.Time
+	// closed and replaced every time something changes so followers can wait
+	changed chan struct{}
+	mu      sync.Mutex
+}
+
+func newShardLogs(maxSize int) *shardLogs {
+	logs := &shardLogs{
+		maxSize: maxSize,
+		changed: make(chan struct{}),
+	}
+	logs.mu.EnableTracerWithOpts(sync.Opts{
+		Threshold: 10 * time.Millisecond,
+		Id:        "ComputeNode.shardLogs.mu",
+	})
+	return logs
+}
+
+func (logs *shardLogs) writer(stream string) io.Writer {
+	return &shardLogWriter{logs: logs, stream: stream}
+}
+
+func (logs *shardLogs) append(chunk model.LogChunk) {
+	logs.mu.Lock()
+	defer logs.mu.Unlock()
+	logs.chunks = append(logs.chunks, chunk)
+	logs.size += len(chunk.Data)
+	for logs.size > logs.maxSize && len(logs.chunks) > 1 {
+		logs.size -= len(logs.chunks[0].Data)
+		logs.chunks = logs.chunks[1:]
+		logs.dropped++
+	}
+	close(logs.changed)
+	logs.changed = make(chan struct{})
+}
+
+func (logs *shardLogs) finish() {
+	logs.mu.Lock()
+	defer logs.mu.Unlock()
+	logs.finished = true
+	logs.finishedAt = time.Now()
+	close(logs.changed)
+	logs.changed = make(chan struct{})
+}
+
+// read returns the chunks from position onwards, the position to read from
+// next time, whether the shard has finished and a channel that is closed
+// when there is more to read
+func (logs *shardLogs) read(position int) ([]model.LogChunk, int, bool, <-chan struct{}) {
+	logs.mu.Lock()
+	defer logs.mu.Unlock()
+	start := position - logs.dropped
+	if start < 0 {
+		start = 0
+	}
+	if start > len(logs.chunks) {
+		start = len(logs.chunks)
+	}
+	chunks := make([]model.LogChunk, len(logs.chunks)-start)
+	copy(chunks, logs.chunks[start:])
+	return chunks, logs.dropped + len(logs.chunks), logs.finished, logs.changed
+}
+
+func (logs *shardLogs) expired(now time.Time) bool {
+	logs.mu.Lock()
+	defer logs.mu.Unlock()
+	return logs.finished && now.Sub(logs.finishedAt) > shardLogRetention
+}
+
+type shardLogWriter struct {
+	logs   *shardLogs
+	stream string
+}
+
+func (w *shardLogWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	// the caller is allowed to reuse p so we need our own copy
+	data := make([]byte, len(p))
+	copy(data, p)
+	w.logs.append(model.LogChunk{Stream: w.stream, Data: data})
+	return len(p), nil
+}
+
+func shardLogsKey(jobID string, shardIndex int) string {
+	return fmt.Sprintf("%s:%d", jobID, shardIndex)
+}
+
+// start collecting the output of a shard we are about to run
+// this replaces the output of any earlier attempt at the same shard
+func (n *ComputeNode) startShardLogs(shard model.JobShard) *shardLogs {
+	n.logsMu.Lock()
+	defer n.logsMu.Unlock()
+
+	now := time.Now()
+	for key, logs := range n.logs {
+		if logs.expired(now) {
+			delete(n.logs, key)
+		}
+	}
+
+	logs := newShardLogs(DefaultShardLogBufferSize)
+	n.logs[shardLogsKey(shard.Job.ID, shard.Index)] = logs
+	return logs
+}
+
+// HasShardLogs returns true if this node has output for the given shard
+func (n *ComputeNode) HasShardLogs(jobID string, shardIndex int) bool {
+	n.logsMu.Lock()
+	defer n.logsMu.Unlock()
+	_, ok := n.logs[shardLogsKey(jobID, shardIndex)]
+	return ok
+}
+
+// StreamShardLogs calls fn with the output of a shard that is running or has
+// recently finished on this node - if follow is true this keeps going until
+// the shard finishes or the context is cancelled
+func (n *ComputeNode) StreamShardLogs(
+	ctx context.Context,
+	jobID string,
+	shardIndex int,
+	follow bool,
+	fn func(model.LogChunk) error,
+) error {
+	n.logsMu.Lock()
+	logs, ok := n.logs[shardLogsKey(jobID, shardIndex)]
+	n.logsMu.Unlock()
+	if !ok {
+		return fmt.Errorf("no logs for shard %d of job %s on node %s", shardIndex, jobID, n.ID)
+	}
+
+	position := 0
+	for {
+		chunks, next, finished, changed := logs.read(position)
+		for _, chunk := range chunks {
+			if err := fn(chunk); err != nil {
+				return err
+			}
+		}
+		position = next
+		if !follow || finished {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package computenode
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/stretchr/testify/require"
+)
+
+func readAllShardLogs(logs *shardLogs, position int) (string, int) {
+	chunks, next, _, _ := logs.read(position)
+	var out strings.Builder
+	for _, chunk := range chunks {
+		out.Write(chunk.Data)
+	}
+	return out.String(), next
+}
+
+func TestShardLogsRead(t *testing.T) {
+	logs := newShardLogs(DefaultShardLogBufferSize)
+	_, err := fmt.Fprint(logs.writer(model.LogStreamStdout), "hello ")
+	require.NoError(t, err)
+	_, err = fmt.Fprint(logs.writer(model.LogStreamStderr), "oops ")
+	require.NoError(t, err)
+
+	chunks, next, finished, _ := logs.read(0)
+	require.Len(t, chunks, 2)
+	require.Equal(t, model.LogStreamStdout, chunks[0].Stream)
+	require.Equal(t, model.LogStreamStderr, chunks[1].Stream)
+	require.Equal(t, 2, next)
+	require.False(t, finished)
+
+	// only what was written since we last read
+	_, err = fmt.Fprint(logs.writer(model.LogStreamStdout), "world")
+	require.NoError(t, err)
+	out, _ := readAllShardLogs(logs, next)
+	require.Equal(t, "world", out)
+}
+
+func TestShardLogsDropOldest(t *testing.T) {
+	logs := newShardLogs(10)
+	for _, data := range []string{"aaaa", "bbbb", "cccc"} {
+		_, err := fmt.Fprint(logs.writer(model.LogStreamStdout), data)
+		require.NoError(t, err)
+	}
+	out, next := readAllShardLogs(logs, 0)
+	require.Equal(t, "bbbbcccc", out)
+	require.Equal(t, 3, next)
+}
+
+func TestStreamShardLogsFollow(t *testing.T) {
+	n := &ComputeNode{
+		ID:   "node-id",
+		logs: map[string]*shardLogs{},
+	}
+	shard := model.JobShard{Job: model.Job{ID: "job-id"}, Index: 1}
+	logs := n.startShardLogs(shard)
+	require.True(t, n.HasShardLogs("job-id", 1))
+	require.False(t, n.HasShardLogs("job-id", 0))
+
+	go func() {
+		for i := 0; i < 3; i++ {
+			_, _ = fmt.Fprintf(logs.writer(model.LogStreamStdout), "line %d\n", i)
+			time.Sleep(10 * time.Millisecond)
+		}
+		logs.finish()
+	}()
+
+	var out strings.Builder
+	err := n.StreamShardLogs(context.Background(), "job-id", 1, true, func(chunk model.LogChunk) error {
+		out.Write(chunk.Data)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, "line 0\nline 1\nline 2\n", out.String())
+
+	err = n.StreamShardLogs(context.Background(), "job-id", 0, false, func(chunk model.LogChunk) error {
+		return nil
+	})
+	require.Error(t, err)
+}
@@ -38,4 +38,12 @@ var (
 		},
 		[]string{"node_id", "shard_index", "client_id"},
 	)
+
+	resultCacheHits = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "result_cache_hits",
+			Help: "Number of shards that used cached results instead of being executed.",
+		},
+		[]string{"node_id", "client_id"},
+	)
 )
@@ -0,0 +1,165 @@
+package computenode
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	sync "github.com/lukemarsden/golang-mutex-tracer"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/time/rate"
+
+	"github.com/filecoin-project/bacalhau/pkg/executor"
+	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/filecoin-project/bacalhau/pkg/util/bandwidth"
+)
+
+const DefaultPrefetchMaxConcurrent = 2
+
+// configures fetching shard inputs while we wait for our bid to be accepted
+type PrefetchConfig struct {
+	// start fetching inputs as soon as we bid on a shard
+	Enabled bool
+	// how many shards can be prefetching at the same time
+	MaxConcurrent int
+	// the most bytes a second all prefetches can download between them
+	// this stops them from saturating our network connection - it covers
+	// IPFS, URL and S3 inputs but not git, which clones in its own process
+	// zero means no limit
+	MaxBandwidth uint64
+	// the most disk space that prefetched inputs can use at once
+	// zero means no limit
+	MaxDiskBytes uint64
+}
+
+type prefetchTask struct {
+	executor executor.InputPrefetcher
+	cancel   context.CancelFunc
+	done     chan struct{}
+	size     uint64
+}
+
+// keeps track of the shards we are prefetching inputs for
+type prefetcher struct {
+	config    PrefetchConfig
+	slots     chan struct{}
+	tasks     map[string]*prefetchTask
+	diskInUse uint64
+	// shared by all prefetches, nil if there's no limit
+	bandwidth *rate.Limiter
+	mu        sync.Mutex
+}
+
+func newPrefetcher(config PrefetchConfig) *prefetcher {
+	maxConcurrent := config.MaxConcurrent
+	if maxConcurrent <= 0 {
+		maxConcurrent = DefaultPrefetchMaxConcurrent
+	}
+	p := &prefetcher{
+		config: config,
+		slots:  make(chan struct{}, maxConcurrent),
+		tasks:  map[string]*prefetchTask{},
+	}
+	if config.MaxBandwidth > 0 {
+		p.bandwidth = bandwidth.NewLimiter(config.MaxBandwidth)
+	}
+	p.mu.EnableTracerWithOpts(sync.Opts{
+		Threshold: 10 * time.Millisecond,
+		Id:        "ComputeNode.prefetcher.mu",
+	})
+	return p
+}
+
+// start fetching the inputs for a shard in the background
+// size is how much disk we expect the inputs to use
+func (p *prefetcher) start(ctx context.Context, e executor.Executor, shard model.JobShard, size uint64) error {
+	if !p.config.Enabled {
+		return nil
+	}
+	inputPrefetcher, ok := e.(executor.InputPrefetcher)
+	if !ok {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.tasks[shard.ID()]; ok {
+		return nil
+	}
+	if p.config.MaxDiskBytes > 0 && p.diskInUse+size > p.config.MaxDiskBytes {
+		return fmt.Errorf("prefetching %d bytes would go over the limit of %d bytes", size, p.config.MaxDiskBytes)
+	}
+
+	// the prefetch must outlive the request that started it
+	prefetchCtx, cancel := context.WithCancel(bandwidth.WithLimiter(context.Background(), p.bandwidth))
+	task := &prefetchTask{
+		executor: inputPrefetcher,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+		size:     size,
+	}
+	p.tasks[shard.ID()] = task
+	p.diskInUse += size
+
+	go func() {
+		defer close(task.done)
+		select {
+		case p.slots <- struct{}{}:
+		case <-prefetchCtx.Done():
+			return
+		}
+		defer func() { <-p.slots }()
+
+		err := inputPrefetcher.PrefetchShardInputs(prefetchCtx, shard)
+		if err != nil {
+			// not fatal - RunShard will fetch whatever is missing
+			log.Debug().Msgf("Prefetching inputs for shard %s stopped: %s", shard, err)
+		}
+	}()
+
+	return nil
+}
+
+// wait for the prefetch of a shard to finish so that RunShard can use it
+func (p *prefetcher) wait(ctx context.Context, shard model.JobShard) {
+	task := p.remove(shard)
+	if task == nil {
+		return
+	}
+	select {
+	case <-task.done:
+	case <-ctx.Done():
+		stopPrefetchTask(ctx, task, shard)
+	}
+}
+
+// stop prefetching a shard and throw away what we fetched
+// this is a no-op if the shard has already been handed to RunShard
+func (p *prefetcher) cancel(ctx context.Context, shard model.JobShard) {
+	task := p.remove(shard)
+	if task == nil {
+		return
+	}
+	stopPrefetchTask(ctx, task, shard)
+}
+
+func stopPrefetchTask(ctx context.Context, task *prefetchTask, shard model.JobShard) {
+	task.cancel()
+	<-task.done
+	err := task.executor.CleanupPrefetchedInputs(ctx, shard)
+	if err != nil {
+		log.Warn().Msgf("Could not clean up prefetched inputs for shard %s: %s", shard, err)
+	}
+}
+
+func (p *prefetcher) remove(shard model.JobShard) *prefetchTask {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	task, ok := p.tasks[shard.ID()]
+	if !ok {
+		return nil
+	}
+	delete(p.tasks, shard.ID())
+	p.diskInUse -= task.size
+	return task
+}
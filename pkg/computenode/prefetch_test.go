@@ -0,0 +1,159 @@
+package computenode
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	sync "github.com/lukemarsden/golang-mutex-tracer"
+
+	noop_executor "github.com/filecoin-project/bacalhau/pkg/executor/noop"
+	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/filecoin-project/bacalhau/pkg/util/bandwidth"
+	"github.com/stretchr/testify/require"
+)
+
+// a noop executor that records what it was asked to prefetch
+type fakePrefetchExecutor struct {
+	*noop_executor.Executor
+	release    chan struct{}
+	prefetched map[string]bool
+	cleaned    map[string]bool
+	mu         sync.Mutex
+	// how many bytes each prefetch downloads
+	download int
+}
+
+func newFakePrefetchExecutor(t *testing.T) *fakePrefetchExecutor {
+	e, err := noop_executor.NewExecutor()
+	require.NoError(t, err)
+	return &fakePrefetchExecutor{
+		Executor:   e,
+		release:    make(chan struct{}),
+		prefetched: map[string]bool{},
+		cleaned:    map[string]bool{},
+	}
+}
+
+func (e *fakePrefetchExecutor) PrefetchShardInputs(ctx context.Context, shard model.JobShard) error {
+	select {
+	case <-e.release:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	_, err := io.Copy(io.Discard, bandwidth.Reader(ctx, bytes.NewReader(make([]byte, e.download))))
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.prefetched[shard.ID()] = true
+	return nil
+}
+
+func (e *fakePrefetchExecutor) CleanupPrefetchedInputs(ctx context.Context, shard model.JobShard) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.cleaned[shard.ID()] = true
+	return nil
+}
+
+func getPrefetchShard(index int) model.JobShard {
+	return model.JobShard{
+		Job: model.Job{
+			ID: "job-id",
+		},
+		Index: index,
+	}
+}
+
+func TestPrefetchDisabled(t *testing.T) {
+	ctx := context.Background()
+	e := newFakePrefetchExecutor(t)
+	p := newPrefetcher(PrefetchConfig{})
+
+	shard := getPrefetchShard(0)
+	require.NoError(t, p.start(ctx, e, shard, 0))
+	require.Nil(t, p.remove(shard))
+}
+
+func TestPrefetchWait(t *testing.T) {
+	ctx := context.Background()
+	e := newFakePrefetchExecutor(t)
+	p := newPrefetcher(PrefetchConfig{Enabled: true})
+
+	shard := getPrefetchShard(0)
+	require.NoError(t, p.start(ctx, e, shard, 0))
+	close(e.release)
+	p.wait(ctx, shard)
+
+	require.True(t, e.prefetched[shard.ID()])
+	require.False(t, e.cleaned[shard.ID()], "inputs we waited for belong to RunShard")
+}
+
+func TestPrefetchCancel(t *testing.T) {
+	ctx := context.Background()
+	e := newFakePrefetchExecutor(t)
+	p := newPrefetcher(PrefetchConfig{Enabled: true})
+
+	shard := getPrefetchShard(0)
+	require.NoError(t, p.start(ctx, e, shard, 0))
+	p.cancel(ctx, shard)
+
+	require.False(t, e.prefetched[shard.ID()])
+	require.True(t, e.cleaned[shard.ID()])
+}
+
+func TestPrefetchWaitContextCancelled(t *testing.T) {
+	e := newFakePrefetchExecutor(t)
+	p := newPrefetcher(PrefetchConfig{Enabled: true})
+
+	shard := getPrefetchShard(0)
+	require.NoError(t, p.start(context.Background(), e, shard, 0))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	p.wait(ctx, shard)
+
+	require.True(t, e.cleaned[shard.ID()])
+}
+
+func TestPrefetchDiskLimit(t *testing.T) {
+	ctx := context.Background()
+	e := newFakePrefetchExecutor(t)
+	p := newPrefetcher(PrefetchConfig{Enabled: true, MaxDiskBytes: 100})
+
+	first := getPrefetchShard(0)
+	second := getPrefetchShard(1)
+	require.NoError(t, p.start(ctx, e, first, 60))
+	require.Error(t, p.start(ctx, e, second, 60))
+
+	// the space is given back once the first prefetch is done with
+	p.cancel(ctx, first)
+	require.NoError(t, p.start(ctx, e, second, 60))
+	p.cancel(ctx, second)
+}
+
+func TestPrefetchBandwidthLimit(t *testing.T) {
+	ctx := context.Background()
+	e := newFakePrefetchExecutor(t)
+	e.download = 1000
+	p := newPrefetcher(PrefetchConfig{Enabled: true, MaxBandwidth: 1000})
+
+	// the first second's worth comes straight away and the two
+	// prefetches share the limit for the rest
+	start := time.Now()
+	first := getPrefetchShard(0)
+	second := getPrefetchShard(1)
+	require.NoError(t, p.start(ctx, e, first, 0))
+	require.NoError(t, p.start(ctx, e, second, 0))
+	close(e.release)
+	p.wait(ctx, first)
+	p.wait(ctx, second)
+
+	require.True(t, e.prefetched[first.ID()])
+	require.True(t, e.prefetched[second.ID()])
+	require.GreaterOrEqual(t, time.Since(start), 900*time.Millisecond)
+}
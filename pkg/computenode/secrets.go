@@ -0,0 +1,84 @@
+package computenode
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/filecoin-project/bacalhau/pkg/secrets"
+)
+
+const DefaultSecretsTimeout = 10 * time.Minute
+
+// ask the client for the secrets of the shard and wait for them - the key
+// they are sealed to is only good for this run of the shard
+func (n *ComputeNode) requestSecrets(ctx context.Context, shard model.JobShard) (map[string]string, error) {
+	key, err := secrets.NewKey()
+	if err != nil {
+		return nil, err
+	}
+	defer key.Destroy()
+
+	delivered := make(chan []byte, 1)
+	n.secretsRequestsMu.Lock()
+	n.secretsRequests[shard.ID()] = delivered
+	n.secretsRequestsMu.Unlock()
+	defer func() {
+		n.secretsRequestsMu.Lock()
+		defer n.secretsRequestsMu.Unlock()
+		if n.secretsRequests[shard.ID()] == delivered {
+			delete(n.secretsRequests, shard.ID())
+		}
+	}()
+
+	err = n.controller.RequestShardSecrets(ctx, shard, key.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+	log.Debug().Msgf("Compute node %s waiting for the secrets of %s", n.ID, shard)
+
+	timeout := n.config.SecretsTimeout
+	if timeout <= 0 {
+		timeout = DefaultSecretsTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case sealed := <-delivered:
+			values, err := key.Open(sealed)
+			if err != nil {
+				// probably sent to a key from an earlier run of the shard
+				log.Debug().Msgf("Compute node %s could not open secrets for %s: %s", n.ID, shard, err)
+				continue
+			}
+			err = secrets.Check(shard.Job.Spec.Secrets, values)
+			if err != nil {
+				return nil, err
+			}
+			return values, nil
+		case <-timer.C:
+			return nil, fmt.Errorf("the secrets for %s were not sent within %s", shard, timeout)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (n *ComputeNode) subscriptionEventSecretsDelivered(ctx context.Context, jobEvent model.JobEvent, shard model.JobShard) {
+	n.secretsRequestsMu.Lock()
+	delivered, ok := n.secretsRequests[shard.ID()]
+	n.secretsRequestsMu.Unlock()
+	if !ok {
+		log.Debug().Msgf("Compute node %s was sent secrets for %s which it is not waiting for", n.ID, shard)
+		return
+	}
+	select {
+	case delivered <- jobEvent.Secrets:
+	default:
+		// we are still looking at the last ones we were sent
+	}
+}
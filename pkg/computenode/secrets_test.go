@@ -0,0 +1,132 @@
+package computenode
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/bacalhau/pkg/controller"
+	jobutils "github.com/filecoin-project/bacalhau/pkg/job"
+	"github.com/filecoin-project/bacalhau/pkg/localdb/inmemory"
+	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/filecoin-project/bacalhau/pkg/secrets"
+	"github.com/filecoin-project/bacalhau/pkg/storage"
+	"github.com/filecoin-project/bacalhau/pkg/system"
+	"github.com/filecoin-project/bacalhau/pkg/transport/inprocess"
+	"github.com/stretchr/testify/require"
+)
+
+// a compute node on its own in-process network, with a job in its database
+// that it requested itself and was accepted for shard 0 of
+func newAcceptedTestNode(t *testing.T, config ComputeNodeConfig, job model.Job) (*ComputeNode, *controller.Controller) {
+	ctx := context.Background()
+	cm := system.NewCleanupManager()
+	t.Cleanup(cm.Cleanup)
+	db, err := inmemory.NewInMemoryDatastore()
+	require.NoError(t, err)
+	tx, err := inprocess.NewInprocessTransport()
+	require.NoError(t, err)
+	ctrl, err := controller.NewController(ctx, cm, db, tx, map[model.StorageSourceType]storage.StorageProvider{})
+	require.NoError(t, err)
+	n, err := constructComputeNode(ctx, ctrl, nil, nil, nil, config)
+	require.NoError(t, err)
+	n.subscriptionSetup(ctx)
+	require.NoError(t, ctrl.Start(ctx))
+	job.RequesterNodeID = ctrl.HostID()
+	require.NoError(t, db.AddJob(ctx, job))
+	require.NoError(t, ctrl.AcceptJobBid(ctx, job.ID, n.ID, 0))
+	require.Eventually(t, func() bool {
+		events, err := ctrl.GetJobEvents(ctx, job.ID)
+		require.NoError(t, err)
+		return jobutils.HasAcceptedBid(job, events, n.ID, 0)
+	}, time.Second, time.Millisecond)
+	return n, ctrl
+}
+
+func secretsTestJob() model.Job {
+	return model.Job{
+		ID: "secrets-job",
+		Spec: model.JobSpec{
+			Secrets: []model.JobSpecSecret{{Name: "token", Env: "TOKEN"}},
+		},
+	}
+}
+
+func TestRequestSecrets(t *testing.T) {
+	job := secretsTestJob()
+	n, ctrl := newAcceptedTestNode(t, NewDefaultComputeNodeConfig(), job)
+
+	// play the client: answer the request with a stale key first
+	staleKey, err := secrets.NewKey()
+	require.NoError(t, err)
+	ctrl.Subscribe(func(ctx context.Context, ev model.JobEvent) {
+		if ev.EventName != model.JobEventSecretsRequested {
+			return
+		}
+		stale, err := secrets.Seal(staleKey.PublicKey(), map[string]string{"token": "old"})
+		require.NoError(t, err)
+		require.NoError(t, ctrl.DeliverShardSecrets(ctx, ev.JobID, ev.SourceNodeID, ev.ShardIndex, stale))
+		time.Sleep(10 * time.Millisecond)
+		sealed, err := secrets.Seal(ev.SecretsPublicKey, map[string]string{"token": "hunter2"})
+		require.NoError(t, err)
+		require.NoError(t, ctrl.DeliverShardSecrets(ctx, ev.JobID, ev.SourceNodeID, ev.ShardIndex, sealed))
+	})
+
+	values, err := n.requestSecrets(context.Background(), model.JobShard{Job: job, Index: 0})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"token": "hunter2"}, values)
+
+	// the sealed secrets were never stored
+	events, err := ctrl.GetJobEvents(context.Background(), job.ID)
+	require.NoError(t, err)
+	delivered := 0
+	for _, ev := range events {
+		require.Empty(t, ev.Secrets)
+		if ev.EventName == model.JobEventSecretsDelivered {
+			delivered++
+		}
+	}
+	require.Equal(t, 2, delivered)
+}
+
+func TestRequestSecretsNotAccepted(t *testing.T) {
+	job := secretsTestJob()
+	n, ctrl := newAcceptedTestNode(t, NewDefaultComputeNodeConfig(), job)
+
+	_, err := n.requestSecrets(context.Background(), model.JobShard{Job: job, Index: 1})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "has not been accepted")
+	events, err := ctrl.GetJobEvents(context.Background(), job.ID)
+	require.NoError(t, err)
+	for _, ev := range events {
+		require.NotEqual(t, model.JobEventSecretsRequested, ev.EventName)
+	}
+}
+
+func TestRequestSecretsTimeout(t *testing.T) {
+	job := secretsTestJob()
+	config := NewDefaultComputeNodeConfig()
+	config.SecretsTimeout = 50 * time.Millisecond
+	n, _ := newAcceptedTestNode(t, config, job)
+
+	_, err := n.requestSecrets(context.Background(), model.JobShard{Job: job, Index: 0})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "were not sent")
+}
+
+func TestRequestSecretsMissingValue(t *testing.T) {
+	job := secretsTestJob()
+	n, ctrl := newAcceptedTestNode(t, NewDefaultComputeNodeConfig(), job)
+	ctrl.Subscribe(func(ctx context.Context, ev model.JobEvent) {
+		if ev.EventName != model.JobEventSecretsRequested {
+			return
+		}
+		sealed, err := secrets.Seal(ev.SecretsPublicKey, map[string]string{"other": "value"})
+		require.NoError(t, err)
+		require.NoError(t, ctrl.DeliverShardSecrets(ctx, ev.JobID, ev.SourceNodeID, ev.ShardIndex, sealed))
+	})
+
+	_, err := n.requestSecrets(context.Background(), model.JobShard{Job: job, Index: 0})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "no value for secret token")
+}
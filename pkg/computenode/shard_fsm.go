@@ -30,10 +30,13 @@ const (
 
 	// results were verified, and do publish them
 	actionPublish
+
+	// the node is draining, and do withdraw from the shard if we have not started it
+	actionCancel
 )
 
 func (a shardStateAction) String() string {
-	return [...]string{"ActionBid", "ActionRejected", "ActionFail", "ActionRun"}[a]
+	return [...]string{"ActionBid", "ActionRejected", "ActionFail", "ActionRun", "ActionPublish", "ActionCancel"}[a]
 }
 
 // request to change the state of the fsm
@@ -171,6 +174,35 @@ func (m *shardStateMachineManager) GetActive() []*shardStateMachine {
 	return active
 }
 
+func (m *shardStateMachineManager) GetBidding() []*shardStateMachine {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cleanupCompleted()
+	bidding := []*shardStateMachine{}
+	for _, i := range m.shardStatesList {
+		if i.currentState == shardBidding {
+			bidding = append(bidding, i)
+		}
+	}
+	return bidding
+}
+
+// shards that have been accepted and that we still need to see through
+// to publishing - a draining node is safe to stop once this is empty
+func (m *shardStateMachineManager) GetInProgress() []*shardStateMachine {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cleanupCompleted()
+	inProgress := []*shardStateMachine{}
+	for _, i := range m.shardStatesList {
+		switch i.currentState {
+		case shardRunning, shardPublishingToVerifier, shardVerifyingResults, shardPublishingToRequester:
+			inProgress = append(inProgress, i)
+		}
+	}
+	return inProgress
+}
+
 func (m *shardStateMachineManager) Get(flatID string) (*shardStateMachine, bool) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -209,6 +241,8 @@ type shardStateMachine struct {
 	previousState  shardStateType
 	resultProposal []byte
 	bidSent        bool
+	bidCancelled   bool
+	accepted       bool
 	errorMsg       string
 }
 
@@ -266,6 +300,37 @@ func (m *shardStateMachine) Fail(ctx context.Context, reason string) {
 	m.sendRequest(ctx, shardStateRequest{action: actionFail, failureReason: reason})
 }
 
+func (m *shardStateMachine) Cancel(ctx context.Context) {
+	m.sendRequest(ctx, shardStateRequest{action: actionCancel})
+}
+
+func (m *shardStateMachine) BidCancelled() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.bidCancelled
+}
+
+// the bid was accepted while we were not draining, so the shard is going
+// to run even if the fsm hasn't started it yet
+func (m *shardStateMachine) markAccepted() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.accepted = true
+}
+
+func (m *shardStateMachine) Accepted() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.accepted
+}
+
+// enqueued or bidding, so it can still be cancelled
+func (m *shardStateMachine) IsWaitingForBid() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.currentState == shardEnqueued || m.currentState == shardBidding
+}
+
 // send a request to the state machine by enquing it in the request channel.
 // it is possible due to race condition or duplicate network events that a
 // request is sent after the fsm is completed and no longer a goroutin is
@@ -296,6 +361,9 @@ func (m *shardStateMachine) transitionedTo(ctx context.Context, newState shardSt
 func biddingState(ctx context.Context, m *shardStateMachine) StateFn {
 	m.transitionedTo(ctx, shardBidding)
 
+	// make use of the time waiting to hear about our bid
+	m.node.startPrefetch(ctx, m.Shard, m.capacity.Requirements)
+
 	for {
 		req := <-m.req
 		switch req.action {
@@ -303,6 +371,16 @@ func biddingState(ctx context.Context, m *shardStateMachine) StateFn {
 			return runningState
 		case actionRejected:
 			return completedState
+		case actionCancel:
+			err := m.node.controller.CancelJobBid(ctx, m.Shard)
+			if err != nil {
+				m.errorMsg = err.Error()
+				return errorState
+			}
+			m.mu.Lock()
+			m.bidCancelled = true
+			m.mu.Unlock()
+			return completedState
 		case actionFail:
 			m.errorMsg = req.failureReason
 			return errorState
@@ -336,6 +414,9 @@ func enqueuedState(ctx context.Context, m *shardStateMachine) StateFn {
 			m.bidSent = true
 
 			return biddingState
+		case actionCancel:
+			// we never bid so there is no one to tell
+			return completedState
 		case actionFail:
 			m.errorMsg = req.failureReason
 			return errorState
@@ -377,12 +458,19 @@ func publishingToVerifierState(ctx context.Context, m *shardStateMachine) StateF
 	ctx = system.AddJobIDToBaggage(ctx, m.Shard.Job.ID)
 	system.AddJobIDFromBaggageToSpan(ctx, span)
 
+	status := fmt.Sprintf("Got results proposal of length: %d", len(m.resultProposal))
+	resourceUsage := m.node.getShardResourceUsage(ctx, m.Shard)
+	if resourceUsage != nil {
+		status = fmt.Sprintf("%s (%s)", status, resourceUsage)
+	}
+
 	err := m.node.controller.ShardExecutionFinished(
 		ctx,
 		m.Shard.Job.ID,
 		m.Shard.Index,
-		fmt.Sprintf("Got results proposal of length: %d", len(m.resultProposal)),
+		status,
 		m.resultProposal,
+		resourceUsage,
 	)
 
 	if err != nil {
@@ -464,5 +552,7 @@ func errorState(ctx context.Context, m *shardStateMachine) StateFn {
 // we always reach this state, whether the job completed successfully or due to a failure.
 func completedState(ctx context.Context, m *shardStateMachine) StateFn {
 	m.transitionedTo(ctx, shardCompleted)
+	// throw away any inputs we prefetched but never ran with
+	m.node.prefetcher.cancel(ctx, m.Shard)
 	return nil
 }
@@ -30,6 +30,7 @@ type Controller struct {
 	jobContexts      map[string]context.Context // total job lifecycle
 	jobNodeContexts  map[string]context.Context // per-node job lifecycle
 	subscribeFuncs   []transport.SubscribeFn
+	nodeRegistry     *nodeRegistry
 	contextMutex     sync.RWMutex
 	subscribeMutex   sync.RWMutex
 }
@@ -56,6 +57,7 @@ func NewController(
 		storageProviders: storageProviders,
 		jobContexts:      make(map[string]context.Context),
 		jobNodeContexts:  make(map[string]context.Context),
+		nodeRegistry:     newNodeRegistry(),
 	}
 	ctrl.contextMutex.EnableTracerWithOpts(sync.Opts{
 		Threshold: 10 * time.Millisecond,
@@ -84,6 +86,10 @@ func (ctrl *Controller) Start(ctx context.Context) error {
 		}
 	})
 
+	ctrl.transport.SubscribeNodeInfo(ctx, func(ctx context.Context, info model.NodeInfo) {
+		ctrl.nodeRegistry.add(info)
+	})
+
 	ctrl.cleanupManager.RegisterCallback(func() error {
 		return ctrl.Shutdown(ctx)
 	})
@@ -131,6 +137,11 @@ func (ctrl *Controller) GetJobState(ctx context.Context, id string) (model.JobSt
 	return ctrl.localdb.GetJobState(ctx, id)
 }
 
+// the compute nodes we have heard a heartbeat from recently
+func (ctrl *Controller) GetNodes(ctx context.Context) []model.NodeInfo {
+	return ctrl.nodeRegistry.list()
+}
+
 func (ctrl *Controller) GetJobs(ctx context.Context, query localdb.JobQuery) ([]model.Job, error) {
 	return ctrl.localdb.GetJobs(ctx, query)
 }
@@ -229,6 +240,19 @@ func (ctrl *Controller) AcceptJobBid(
 	// function and so knows which node it is accepting the bid for
 	ev.TargetNodeID = nodeID
 	ev.ShardIndex = shardIndex
+	// the node resumes from the checkpoint we say, rather than
+	// whichever one it heard of last
+	job, err := ctrl.GetJob(ctx, jobID)
+	if err != nil {
+		return err
+	}
+	if job.Spec.Checkpoint.Enabled() {
+		events, err := ctrl.GetJobEvents(ctx, jobID)
+		if err != nil {
+			return err
+		}
+		ev.Checkpoint = jobutils.LatestCheckpoint(job, events, shardIndex)
+	}
 	return ctrl.writeEvent(jobCtx, ev)
 }
 
@@ -338,7 +362,12 @@ func (ctrl *Controller) SelectJob(ctx context.Context, jobID string) error {
 }
 
 // done by compute nodes when they hear about the job
-func (ctrl *Controller) BidJob(ctx context.Context, shard model.JobShard) error {
+func (ctrl *Controller) BidJob(
+	ctx context.Context,
+	shard model.JobShard,
+	matchedNodeLabels map[string]string,
+	localityScore float64,
+) error {
 	jobCtx := ctrl.getJobNodeContext(ctx, shard.Job.ID)
 	err := ctrl.localdb.AddLocalEvent(jobCtx, shard.Job.ID, model.JobLocalEvent{
 		EventName:  model.JobLocalEventBid,
@@ -351,14 +380,25 @@ func (ctrl *Controller) BidJob(ctx context.Context, shard model.JobShard) error
 	ctrl.addJobLifecycleEvent(jobCtx, shard.Job.ID, "write_BidJob")
 	ev := ctrl.constructEvent(shard.Job.ID, model.JobEventBid)
 	ev.ShardIndex = shard.Index
+	ev.MatchedNodeLabels = matchedNodeLabels
+	ev.LocalityScore = localityScore
 	return ctrl.writeEvent(jobCtx, ev)
 }
 
+// called by compute nodes to advertise what they support and
+// how much capacity they have free
+func (ctrl *Controller) PublishNodeInfo(ctx context.Context, info model.NodeInfo) error {
+	info.NodeID = ctrl.id
+	info.EventTime = time.Now()
+	return ctrl.transport.PublishNodeInfo(ctx, info)
+}
+
 // called by a compute node who has already bid
-func (ctrl *Controller) CancelJobBid(ctx context.Context, jobID string) error {
-	jobCtx := ctrl.getJobNodeContext(ctx, jobID)
-	ctrl.addJobLifecycleEvent(jobCtx, jobID, "write_CancelJobBid")
-	ev := ctrl.constructEvent(jobID, model.JobEventBidCancelled)
+func (ctrl *Controller) CancelJobBid(ctx context.Context, shard model.JobShard) error {
+	jobCtx := ctrl.getJobNodeContext(ctx, shard.Job.ID)
+	ctrl.addJobLifecycleEvent(jobCtx, shard.Job.ID, "write_CancelJobBid")
+	ev := ctrl.constructEvent(shard.Job.ID, model.JobEventBidCancelled)
+	ev.ShardIndex = shard.Index
 	return ctrl.writeEvent(jobCtx, ev)
 }
 
@@ -368,6 +408,7 @@ func (ctrl *Controller) ShardExecutionFinished(
 	shardIndex int,
 	status string,
 	proposal []byte,
+	resourceUsage *model.ShardResourceUsage,
 ) error {
 	jobCtx := ctrl.getJobNodeContext(ctx, jobID)
 	ctrl.addJobLifecycleEvent(jobCtx, jobID, "write_ShardExecutionFinished")
@@ -375,6 +416,7 @@ func (ctrl *Controller) ShardExecutionFinished(
 	ev.Status = status
 	ev.VerificationProposal = proposal
 	ev.ShardIndex = shardIndex
+	ev.ResourceUsage = resourceUsage
 	return ctrl.writeEvent(jobCtx, ev)
 }
 
@@ -391,6 +433,82 @@ func (ctrl *Controller) ShardResultsPublished(
 	return ctrl.writeEvent(jobCtx, ev)
 }
 
+// called by a compute node to say how a shard it has been
+// accepted for is getting on, before and while it runs
+func (ctrl *Controller) ShardRunning(
+	ctx context.Context,
+	jobID string,
+	shardIndex int,
+	status string,
+) error {
+	jobCtx := ctrl.getJobNodeContext(ctx, jobID)
+	ctrl.addJobLifecycleEvent(jobCtx, jobID, "write_ShardRunning")
+	ev := ctrl.constructEvent(jobID, model.JobEventRunning)
+	ev.Status = status
+	ev.ShardIndex = shardIndex
+	return ctrl.writeEvent(jobCtx, ev)
+}
+
+// called by a compute node running a shard with a checkpoint volume
+// each time it publishes a snapshot of it
+func (ctrl *Controller) ShardCheckpointPublished(
+	ctx context.Context,
+	shard model.JobShard,
+	checkpoint model.StorageSpec,
+) error {
+	err := ctrl.checkBidAccepted(ctx, shard)
+	if err != nil {
+		return fmt.Errorf("ShardCheckpointPublished: %w", err)
+	}
+	jobCtx := ctrl.getJobNodeContext(ctx, shard.Job.ID)
+	ctrl.addJobLifecycleEvent(jobCtx, shard.Job.ID, "write_ShardCheckpointPublished")
+	ev := ctrl.constructEvent(shard.Job.ID, model.JobEventCheckpointPublished)
+	ev.ShardIndex = shard.Index
+	ev.Checkpoint = &checkpoint
+	return ctrl.writeEvent(jobCtx, ev)
+}
+
+// called by a compute node that has been accepted for a shard of a job with
+// secrets - the client seals the secrets to publicKey
+func (ctrl *Controller) RequestShardSecrets(
+	ctx context.Context,
+	shard model.JobShard,
+	publicKey []byte,
+) error {
+	// clients only answer nodes the requester accepted, so don't
+	// broadcast a request that would never be answered
+	err := ctrl.checkBidAccepted(ctx, shard)
+	if err != nil {
+		return fmt.Errorf("RequestShardSecrets: %w", err)
+	}
+	jobCtx := ctrl.getJobNodeContext(ctx, shard.Job.ID)
+	ctrl.addJobLifecycleEvent(jobCtx, shard.Job.ID, "write_RequestShardSecrets")
+	ev := ctrl.constructEvent(shard.Job.ID, model.JobEventSecretsRequested)
+	ev.ShardIndex = shard.Index
+	ev.SecretsPublicKey = publicKey
+	return ctrl.writeEvent(jobCtx, ev)
+}
+
+// called on behalf of the client to give a compute node the
+// secrets it asked for, sealed to the key it asked with
+func (ctrl *Controller) DeliverShardSecrets(
+	ctx context.Context,
+	jobID, nodeID string,
+	shardIndex int,
+	sealed []byte,
+) error {
+	if nodeID == "" {
+		return fmt.Errorf("DeliverShardSecrets: nodeID cannot be empty")
+	}
+	jobCtx := ctrl.getJobNodeContext(ctx, jobID)
+	ctrl.addJobLifecycleEvent(jobCtx, jobID, "write_DeliverShardSecrets")
+	ev := ctrl.constructEvent(jobID, model.JobEventSecretsDelivered)
+	ev.TargetNodeID = nodeID
+	ev.ShardIndex = shardIndex
+	ev.Secrets = sealed
+	return ctrl.writeEvent(jobCtx, ev)
+}
+
 // can only be called by a compute node who is current assigned to the job
 func (ctrl *Controller) ShardError(
 	ctx context.Context,
@@ -475,13 +593,22 @@ func (ctrl *Controller) writeEvent(ctx context.Context, ev model.JobEvent) error
 func (ctrl *Controller) handleEvent(ctx context.Context, ev model.JobEvent) error {
 	jobCtx := ctrl.getEventJobContext(ctx, ev)
 
+	// sealed secrets are only for the node they were sent to and are
+	// never stored, even though only that node can open them
+	sealedSecrets := ev.Secrets
+	ev.Secrets = nil
+
 	err := ctrl.mutateDatastore(jobCtx, ev)
 	if err != nil {
 		return fmt.Errorf("error mutateDatastore: %s", err)
 	}
 
 	// now trigger our local subscribers with this event
-	ctrl.callLocalSubscribers(jobCtx, ev)
+	subscriberEvent := ev
+	if ev.TargetNodeID == ctrl.id {
+		subscriberEvent.Secrets = sealedSecrets
+	}
+	ctrl.callLocalSubscribers(jobCtx, subscriberEvent)
 
 	log.Trace().Msgf("handleEvent: %+v", ev)
 
@@ -543,6 +670,9 @@ func (ctrl *Controller) mutateDatastore(ctx context.Context, ev model.JobEvent)
 				VerificationProposal: ev.VerificationProposal,
 				VerificationResult:   ev.VerificationResult,
 				PublishedResult:      ev.PublishedResult,
+				MatchedNodeLabels:    ev.MatchedNodeLabels,
+				LocalityScore:        ev.LocalityScore,
+				ResourceUsage:        ev.ResourceUsage,
 			},
 		)
 		if err != nil {
@@ -580,6 +710,22 @@ func (ctrl *Controller) callLocalSubscribers(ctx context.Context, ev model.JobEv
 
 */
 
+// error unless the job's requester has accepted our bid for the shard
+func (ctrl *Controller) checkBidAccepted(ctx context.Context, shard model.JobShard) error {
+	job, err := ctrl.GetJob(ctx, shard.Job.ID)
+	if err != nil {
+		return err
+	}
+	events, err := ctrl.GetJobEvents(ctx, shard.Job.ID)
+	if err != nil {
+		return err
+	}
+	if !jobutils.HasAcceptedBid(job, events, ctrl.id, shard.Index) {
+		return fmt.Errorf("the bid for %s has not been accepted", shard)
+	}
+	return nil
+}
+
 func (ctrl *Controller) constructEvent(jobID string, eventName model.JobEventType) model.JobEvent {
 	return model.JobEvent{
 		SourceNodeID: ctrl.id,
@@ -0,0 +1,58 @@
+package controller
+
+import (
+	"sort"
+	"time"
+
+	sync "github.com/lukemarsden/golang-mutex-tracer"
+
+	"github.com/filecoin-project/bacalhau/pkg/model"
+)
+
+// how long we remember a node for after its last heartbeat
+const NodeInfoTTL = 5 * time.Minute
+
+// the latest node info we have heard from each compute node
+type nodeRegistry struct {
+	nodes map[string]model.NodeInfo
+	mu    sync.RWMutex
+}
+
+func newNodeRegistry() *nodeRegistry {
+	registry := &nodeRegistry{
+		nodes: map[string]model.NodeInfo{},
+	}
+	registry.mu.EnableTracerWithOpts(sync.Opts{
+		Threshold: 10 * time.Millisecond,
+		Id:        "Controller.nodeRegistry.mu",
+	})
+	return registry
+}
+
+func (r *nodeRegistry) add(info model.NodeInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// gossip can deliver heartbeats out of order
+	if existing, ok := r.nodes[info.NodeID]; ok && existing.EventTime.After(info.EventTime) {
+		return
+	}
+	r.nodes[info.NodeID] = info
+}
+
+// list the nodes we have heard from recently sorted by node id
+func (r *nodeRegistry) list() []model.NodeInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	nodes := []model.NodeInfo{}
+	for id, info := range r.nodes {
+		if time.Since(info.EventTime) > NodeInfoTTL {
+			delete(r.nodes, id)
+			continue
+		}
+		nodes = append(nodes, info)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].NodeID < nodes[j].NodeID
+	})
+	return nodes
+}
@@ -0,0 +1,29 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNodeRegistry(t *testing.T) {
+	registry := newNodeRegistry()
+	now := time.Now()
+
+	registry.add(model.NodeInfo{NodeID: "b", EventTime: now})
+	registry.add(model.NodeInfo{NodeID: "a", EventTime: now, Labels: map[string]string{"tier": "new"}})
+
+	// an older heartbeat arriving late must not replace the newer one
+	registry.add(model.NodeInfo{NodeID: "a", EventTime: now.Add(-time.Minute), Labels: map[string]string{"tier": "old"}})
+
+	// a node we have not heard from for a while is dropped
+	registry.add(model.NodeInfo{NodeID: "c", EventTime: now.Add(-2 * NodeInfoTTL)})
+
+	nodes := registry.list()
+	require.Equal(t, 2, len(nodes))
+	require.Equal(t, "a", nodes[0].NodeID)
+	require.Equal(t, "new", nodes[0].Labels["tier"])
+	require.Equal(t, "b", nodes[1].NodeID)
+}
@@ -185,6 +185,10 @@ func NewDevStack(
 		//////////////////////////////////////
 		isBadActor := (options.NumberOfBadActors > 0) && (i >= options.NumberOfNodes-options.NumberOfBadActors)
 
+		// every node in the devstack is reachable on localhost
+		nodeComputeConfig := computeNodeConfig
+		nodeComputeConfig.APIURL = fmt.Sprintf("http://127.0.0.1:%d", apiPort)
+
 		nodeConfig := node.NodeConfig{
 			IPFSClient:           ipfsClient,
 			CleanupManager:       cm,
@@ -195,9 +199,11 @@ func NewDevStack(
 			HostID:               strconv.Itoa(i),
 			APIPort:              apiPort,
 			MetricsPort:          metricsPort,
-			ComputeNodeConfig:    computeNodeConfig,
+			ComputeNodeConfig:    nodeComputeConfig,
 			RequesterNodeConfig:  requesternode.RequesterNodeConfig{},
 			IsBadActor:           isBadActor,
+			// every node in the devstack is on localhost
+			AllowPrivateNodeAPIs: true,
 		}
 
 		var n *node.Node
@@ -0,0 +1,120 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/filecoin-project/bacalhau/pkg/storage"
+	"github.com/rs/zerolog/log"
+)
+
+// CheckpointRestorer is implemented by executors that can give a shard
+// the checkpoint volume its job asked for - they mount the directory from
+// CheckpointDirFromContext at the job's checkpoint path
+type CheckpointRestorer interface {
+	// copy a published checkpoint into the directory that will
+	// be mounted as the shard's checkpoint volume
+	RestoreCheckpoint(ctx context.Context, checkpoint model.StorageSpec, dir string) error
+}
+
+type checkpointDirContextKey struct{}
+
+// ContextWithCheckpointDir records the host directory the executor
+// mounts as the checkpoint volume of the shard that is about to be run.
+func ContextWithCheckpointDir(ctx context.Context, dir string) context.Context {
+	return context.WithValue(ctx, checkpointDirContextKey{}, dir)
+}
+
+// CheckpointDirFromContext returns the host directory to mount as the
+// shard's checkpoint volume, or an empty string if there isn't one.
+func CheckpointDirFromContext(ctx context.Context) string {
+	dir, _ := ctx.Value(checkpointDirContextKey{}).(string)
+	return dir
+}
+
+// CheckpointMountDir returns the host directory to mount at the job's
+// checkpoint path - it is an error for a job that asked for checkpoints
+// to be run without one
+func CheckpointMountDir(ctx context.Context, spec model.JobSpec) (string, bool, error) {
+	if !spec.Checkpoint.Enabled() {
+		return "", false, nil
+	}
+	dir := CheckpointDirFromContext(ctx)
+	if dir == "" {
+		return "", false, fmt.Errorf("job asked for a checkpoint volume at %s but none was given", spec.Checkpoint.Path)
+	}
+	return dir, true, nil
+}
+
+// RestoreCheckpoint fetches a published checkpoint with the storage
+// provider and copies what is in it into dir
+func RestoreCheckpoint(
+	ctx context.Context,
+	provider storage.StorageProvider,
+	checkpoint model.StorageSpec,
+	dir string,
+) error {
+	volume, err := provider.PrepareStorage(ctx, checkpoint)
+	if err != nil {
+		return fmt.Errorf("failed to fetch checkpoint %s: %w", checkpoint.Cid, err)
+	}
+	defer func() {
+		if cleanupErr := provider.CleanupStorage(ctx, checkpoint, volume); cleanupErr != nil {
+			log.Debug().Msgf("Could not clean up checkpoint %s: %s", checkpoint.Cid, cleanupErr)
+		}
+	}()
+	if volume.Type != storage.StorageVolumeConnectorBind {
+		return fmt.Errorf("unknown storage volume type: %s", volume.Type)
+	}
+	return CopyDir(volume.Source, dir)
+}
+
+// CopyDir copies the contents of source into destination, creating it if
+// needed - anything that isn't a file, directory or symlink is skipped
+func CopyDir(source, destination string) error {
+	return filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(destination, relPath)
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0700) //nolint:gomnd
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			return copyFile(path, target, info.Mode().Perm())
+		default:
+			return nil
+		}
+	})
+}
+
+func copyFile(source, destination string, perm os.FileMode) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(destination, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
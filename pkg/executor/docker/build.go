@@ -0,0 +1,246 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	dockerclient "github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/filecoin-project/bacalhau/pkg/docker"
+	"github.com/filecoin-project/bacalhau/pkg/executor"
+	"github.com/google/uuid"
+	sync "github.com/lukemarsden/golang-mutex-tracer"
+	"github.com/rs/zerolog/log"
+)
+
+const buildFilePermissions = 0644
+
+func (e *Executor) HasImage(ctx context.Context, image string) (bool, error) {
+	_, _, err := e.Client.ImageInspectWithRaw(ctx, image)
+	if err == nil {
+		return true, nil
+	}
+	if dockerclient.IsErrNotFound(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+// an image build that other callers for the same image can wait on
+type pendingBuild struct {
+	done chan struct{}
+	err  error
+	// how many callers are waiting for it
+	waiters int
+}
+
+// makes sure only one build runs for each image at a time
+type buildGroup struct {
+	builds map[string]*pendingBuild
+	mu     sync.Mutex
+}
+
+func newBuildGroup() *buildGroup {
+	g := &buildGroup{
+		builds: map[string]*pendingBuild{},
+	}
+	g.mu.EnableTracerWithOpts(sync.Opts{
+		Threshold: 10 * time.Millisecond,
+		Id:        "DockerExecutor.buildGroup.mu",
+	})
+	return g
+}
+
+// run build unless the image is already being built, in which case wait
+// for that build and return its error - the bool is true if we waited
+func (g *buildGroup) do(ctx context.Context, image string, build func() error) (bool, error) {
+	g.mu.Lock()
+	if pending, ok := g.builds[image]; ok {
+		pending.waiters++
+		g.mu.Unlock()
+		log.Debug().Msgf("Waiting for the build of %s that is already running", image)
+		select {
+		case <-pending.done:
+			return true, pending.err
+		case <-ctx.Done():
+			return true, ctx.Err()
+		}
+	}
+	pending := &pendingBuild{done: make(chan struct{})}
+	g.builds[image] = pending
+	g.mu.Unlock()
+
+	pending.err = build()
+
+	g.mu.Lock()
+	delete(g.builds, image)
+	close(pending.done)
+	g.mu.Unlock()
+	return false, pending.err
+}
+
+// BuildImage runs the command in a container started from the base image
+// and commits the container as the new image - shards that ask for the same
+// image while it is being built wait for that build
+func (e *Executor) BuildImage(ctx context.Context, request executor.BuildImageRequest) error {
+	ctx, span := newSpan(ctx, "BuildImage")
+	defer span.End()
+
+	waited, err := e.builds.do(ctx, request.Image, func() error {
+		return e.buildImage(ctx, request)
+	})
+	if waited && err == nil && request.Stdout != nil {
+		fmt.Fprintf(request.Stdout, "Waited for another shard to build %s\n", request.Image)
+	}
+	return err
+}
+
+func (e *Executor) buildImage(ctx context.Context, request executor.BuildImageRequest) error {
+	// a build that finished just before we started has already made it
+	built, err := e.HasImage(ctx, request.Image)
+	if err != nil {
+		return err
+	}
+	if built {
+		return nil
+	}
+
+	acquired, err := e.ensureImage(ctx, request.BaseImage)
+	if err != nil {
+		return err
+	}
+	defer e.images.release(acquired.ID)
+	base, _, err := e.Client.ImageInspectWithRaw(ctx, request.BaseImage)
+	if err != nil {
+		return fmt.Errorf("error inspecting %s: %w", request.BaseImage, err)
+	}
+
+	name := e.buildContainerName(request.Image)
+	labels := map[string]string{"bacalhau-executor": e.ID}
+	buildNetwork, err := e.setupNetwork(ctx, name, labels, request.Network)
+	if err != nil {
+		return err
+	}
+	defer e.cleanupNetwork(ctx, buildNetwork)
+
+	hostConfig := &container.HostConfig{
+		NetworkMode: container.NetworkMode(buildNetwork.mode),
+	}
+	e.sandbox.applyIsolation(hostConfig)
+
+	buildContainer, err := e.Client.ContainerCreate(
+		ctx,
+		&container.Config{
+			Image:           request.BaseImage,
+			Env:             buildNetwork.env,
+			Entrypoint:      request.Command,
+			Labels:          labels,
+			NetworkDisabled: buildNetwork.disabled(),
+		},
+		hostConfig,
+		&network.NetworkingConfig{},
+		nil,
+		name,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create build container: %w", err)
+	}
+	defer func() {
+		if removeErr := docker.RemoveContainer(ctx, e.Client, buildContainer.ID); removeErr != nil {
+			log.Error().Msgf("Docker remove build container error: %s", removeErr.Error())
+		}
+	}()
+
+	if len(request.Files) > 0 {
+		archive, tarErr := tarFiles(request.Files)
+		if tarErr != nil {
+			return tarErr
+		}
+		err = e.Client.CopyToContainer(ctx, buildContainer.ID, "/", archive, dockertypes.CopyToContainerOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to copy files into build container: %w", err)
+		}
+	}
+
+	err = e.Client.ContainerStart(ctx, buildContainer.ID, dockertypes.ContainerStartOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to start build container: %w", err)
+	}
+
+	logsReader, err := e.Client.ContainerLogs(ctx, buildContainer.ID, dockertypes.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get build logs: %w", err)
+	}
+	defer logsReader.Close()
+	_, err = stdcopy.StdCopy(request.Stdout, request.Stderr, logsReader)
+	if err != nil {
+		return fmt.Errorf("failed to get build logs: %w", err)
+	}
+
+	exitCode, err := e.waitForContainer(ctx, buildContainer.ID)
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("building %s failed with exit code %d", request.Image, exitCode)
+	}
+
+	// keep the base image's settings rather than our build command
+	_, err = e.Client.ContainerCommit(ctx, buildContainer.ID, dockertypes.ContainerCommitOptions{
+		Reference: request.Image,
+		Config:    base.Config,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save %s: %w", request.Image, err)
+	}
+	// so it is removed like the images we pull once it hasn't been used for a while
+	image, _, err := e.Client.ImageInspectWithRaw(ctx, request.Image)
+	if err != nil {
+		return fmt.Errorf("error inspecting %s: %w", request.Image, err)
+	}
+	e.images.add(image.ID, uint64(image.Size))
+	log.Debug().Msgf("Built image %s from %s", request.Image, request.BaseImage)
+	return nil
+}
+
+// the name of the container and network for one build of the image
+func (e *Executor) buildContainerName(image string) string {
+	replacer := strings.NewReplacer(":", "-", "/", "-", "@", "-")
+	return fmt.Sprintf("bacalhau-%s-build-%s-%s", e.ID, replacer.Replace(image), uuid.NewString())
+}
+
+func tarFiles(files map[string][]byte) (*bytes.Buffer, error) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for path, contents := range files {
+		err := tw.WriteHeader(&tar.Header{
+			Name: strings.TrimPrefix(path, "/"),
+			Mode: buildFilePermissions,
+			Size: int64(len(contents)),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err = tw.Write(contents); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
+
+// Compile-time interface check:
+var _ executor.ImageBuilder = (*Executor)(nil)
@@ -0,0 +1,72 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildGroup(t *testing.T) {
+	ctx := context.Background()
+	group := newBuildGroup()
+
+	var builds int32
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	build := func() error {
+		if atomic.AddInt32(&builds, 1) == 1 {
+			close(started)
+		}
+		<-finish
+		return fmt.Errorf("build failed")
+	}
+
+	// everyone asking for the image while it is being built gets its result
+	var wg sync.WaitGroup
+	waited := make([]bool, 3)
+	errs := make([]error, 3)
+	for i := range waited {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			waited[i], errs[i] = group.do(ctx, "image", build)
+		}(i)
+		if i == 0 {
+			<-started
+		}
+	}
+	require.Eventually(t, func() bool {
+		group.mu.Lock()
+		defer group.mu.Unlock()
+		return group.builds["image"].waiters == 2
+	}, time.Second, time.Millisecond)
+	// a different image is built on its own
+	otherWaited, err := group.do(ctx, "other", func() error { return nil })
+	require.NoError(t, err)
+	require.False(t, otherWaited)
+
+	close(finish)
+	wg.Wait()
+	require.Equal(t, int32(1), builds)
+	require.Equal(t, []bool{false, true, true}, waited)
+	for _, err := range errs {
+		require.EqualError(t, err, "build failed")
+	}
+
+	// and once it has finished the next caller builds it again
+	waited[0], err = group.do(ctx, "image", func() error { return nil })
+	require.NoError(t, err)
+	require.False(t, waited[0])
+}
+
+func TestBuildContainerNameIsUnique(t *testing.T) {
+	e := &Executor{ID: "executor"}
+	name := e.buildContainerName("bacalhau-requirements:abc")
+	require.Contains(t, name, "bacalhau-executor-build-bacalhau-requirements-abc-")
+	require.NotEqual(t, name, e.buildContainerName("bacalhau-requirements:abc"))
+}
@@ -8,21 +8,23 @@ import (
 	"io/ioutil"
 	"os"
 	"runtime/debug"
+	"time"
 
 	dockertypes "github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/network"
 	dockerclient "github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/filecoin-project/bacalhau/pkg/capacitymanager"
 	"github.com/filecoin-project/bacalhau/pkg/config"
 	"github.com/filecoin-project/bacalhau/pkg/docker"
 	"github.com/filecoin-project/bacalhau/pkg/executor"
-	jobutils "github.com/filecoin-project/bacalhau/pkg/job"
 	"github.com/filecoin-project/bacalhau/pkg/model"
 	"github.com/filecoin-project/bacalhau/pkg/storage"
 	"github.com/filecoin-project/bacalhau/pkg/storage/util"
 	"github.com/filecoin-project/bacalhau/pkg/system"
+	sync "github.com/lukemarsden/golang-mutex-tracer"
 	"github.com/rs/zerolog/log"
 	"go.opentelemetry.io/otel/trace"
 )
@@ -40,6 +42,21 @@ type Executor struct {
 	StorageProviders map[model.StorageSourceType]storage.StorageProvider
 
 	Client *dockerclient.Client
+
+	// how job containers are locked down
+	sandbox *sandbox
+
+	// credentials for private registries keyed by registry host
+	registryAuths map[string]dockertypes.AuthConfig
+	// the images we pulled or built for jobs
+	images *imageCache
+	// the image builds in progress
+	builds *buildGroup
+
+	// input volumes that were prepared while we were bidding
+	// map of shard ID -> prefetch key -> volume
+	prefetched   map[string]map[string]prefetchedVolume
+	prefetchedMu sync.Mutex
 }
 
 func NewExecutor(
@@ -47,7 +64,19 @@ func NewExecutor(
 	cm *system.CleanupManager,
 	id string,
 	storageProviders map[model.StorageSourceType]storage.StorageProvider,
+	sandboxConfig SandboxConfig,
+	imageConfig ImageConfig,
 ) (*Executor, error) {
+	sandbox, err := newSandbox(sandboxConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	registryAuths, err := loadRegistryAuth(imageConfig.RegistryAuthFile)
+	if err != nil {
+		return nil, err
+	}
+
 	dockerClient, err := docker.NewDockerClient()
 	if err != nil {
 		return nil, err
@@ -63,7 +92,16 @@ func NewExecutor(
 		ResultsDir:       dir,
 		StorageProviders: storageProviders,
 		Client:           dockerClient,
+		sandbox:          sandbox,
+		registryAuths:    registryAuths,
+		images:           newImageCache(imageConfig.CacheSize),
+		builds:           newBuildGroup(),
+		prefetched:       map[string]map[string]prefetchedVolume{},
 	}
+	de.prefetchedMu.EnableTracerWithOpts(sync.Opts{
+		Threshold: 10 * time.Millisecond,
+		Id:        "DockerExecutor.prefetchedMu",
+	})
 
 	cm.RegisterCallback(func() error {
 		de.cleanupAll(ctx)
@@ -102,6 +140,14 @@ func (e *Executor) GetVolumeSize(ctx context.Context, volume model.StorageSpec)
 	return storageProvider.GetVolumeSize(ctx, volume)
 }
 
+func (e *Executor) RestoreCheckpoint(ctx context.Context, checkpoint model.StorageSpec, dir string) error {
+	storageProvider, err := e.getStorageProvider(ctx, checkpoint.Engine)
+	if err != nil {
+		return err
+	}
+	return executor.RestoreCheckpoint(ctx, storageProvider, checkpoint, dir)
+}
+
 //nolint:funlen,gocyclo // will clean up
 func (e *Executor) RunShard(
 	ctx context.Context,
@@ -118,23 +164,50 @@ func (e *Executor) RunShard(
 	// these are paths for both input and output data
 	mounts := []mount.Mount{}
 
-	shardStorageSpec, err := jobutils.GetShardStorageSpec(ctx, shard, e.StorageProviders)
+	inputs, err := e.getShardInputs(ctx, shard)
 	if err != nil {
 		return err
 	}
 
+	// anything the compute node fetched for us while it was bidding
+	prefetched := e.takePrefetchedVolumes(shard)
+
+	// volumes we prepared or took over from prefetching and must clean up
+	// once the job has finished - this releases them from the input cache
+	prepared := []prefetchedVolume{}
+	for _, p := range prefetched {
+		prepared = append(prepared, p)
+	}
+	defer func() {
+		for _, p := range prepared {
+			storageProvider, err := e.getStorageProvider(ctx, p.spec.Engine)
+			if err == nil {
+				err = storageProvider.CleanupStorage(ctx, p.spec, p.volume)
+			}
+			if err != nil {
+				log.Warn().Msgf("Could not clean up volume %s for shard %s: %s", p.volume.Source, shard, err)
+			}
+		}
+	}()
+
 	// reusable between the input shards and the input context
 	addInputStorageHandler := func(spec model.StorageSpec) error {
 		var storageProvider storage.StorageProvider
 		var volumeMount storage.StorageVolume
-		storageProvider, err = e.getStorageProvider(ctx, spec.Engine)
-		if err != nil {
-			return err
-		}
 
-		volumeMount, err = storageProvider.PrepareStorage(ctx, spec)
-		if err != nil {
-			return err
+		if p, ok := prefetched[prefetchKey(spec)]; ok {
+			volumeMount = p.volume
+		} else {
+			storageProvider, err = e.getStorageProvider(ctx, spec.Engine)
+			if err != nil {
+				return err
+			}
+
+			volumeMount, err = storageProvider.PrepareStorage(ctx, spec)
+			if err != nil {
+				return err
+			}
+			prepared = append(prepared, prefetchedVolume{spec: spec, volume: volumeMount})
 		}
 
 		if volumeMount.Type == storage.StorageVolumeConnectorBind {
@@ -152,16 +225,8 @@ func (e *Executor) RunShard(
 		return nil
 	}
 
-	// loop over the job contexts and prepare them
-	for _, contextStorage := range shard.Job.Spec.Contexts {
-		err = addInputStorageHandler(contextStorage)
-		if err != nil {
-			return err
-		}
-	}
-
-	// loop over the job storage inputs and prepare them
-	for _, inputStorage := range shardStorageSpec {
+	// loop over the job contexts and storage inputs and prepare them
+	for _, inputStorage := range inputs {
 		err = addInputStorageHandler(inputStorage)
 		if err != nil {
 			return err
@@ -186,6 +251,13 @@ func (e *Executor) RunShard(
 		if err != nil {
 			return err
 		}
+		if e.sandbox.sharedOutputs() {
+			// Mkdir is subject to the umask so set the mode afterwards
+			err = os.Chmod(srcd, util.OS_ALL_RWX)
+			if err != nil {
+				return err
+			}
+		}
 
 		log.Trace().Msgf("Output Volume: %+v", output)
 
@@ -204,25 +276,61 @@ func (e *Executor) RunShard(
 		})
 	}
 
-	if os.Getenv("SKIP_IMAGE_PULL") == "" {
-		// TODO: #283 work out why this does not work in github actions
-		// err = docker.PullImage(e.Client, job.Spec.Vm.Image)
-		var im dockertypes.ImageInspect
-		im, _, err = e.Client.ImageInspectWithRaw(ctx, shard.Job.Spec.Docker.Image)
-		if err == nil {
-			log.Debug().Msgf("Not pulling image %s, already have %s", shard.Job.Spec.Docker.Image, im.ID)
-		} else if dockerclient.IsErrNotFound(err) {
-			stdout, err := system.RunCommandGetResults( //nolint:govet // shadowing ok
-				"docker",
-				[]string{"pull", shard.Job.Spec.Docker.Image},
-			)
+	// the compute node keeps the checkpoint volume outside of the results
+	// so it can snapshot it while the job runs
+	checkpointDir, hasCheckpoint, err := executor.CheckpointMountDir(ctx, shard.Job.Spec)
+	if err != nil {
+		return err
+	}
+	if hasCheckpoint {
+		if e.sandbox.sharedOutputs() {
+			err = os.Chmod(checkpointDir, util.OS_ALL_RWX)
 			if err != nil {
-				return fmt.Errorf("error pulling %s: %s, %s", shard.Job.Spec.Docker.Image, err, stdout)
+				return err
 			}
-			log.Trace().Msgf("Pull image output: %s\n%s", shard.Job.Spec.Docker.Image, stdout)
-		} else {
-			return fmt.Errorf("error checking if we have %s locally: %s", shard.Job.Spec.Docker.Image, err)
 		}
+		mounts = append(mounts, mount.Mount{
+			Type:     "bind",
+			ReadOnly: false,
+			Source:   checkpointDir,
+			Target:   shard.Job.Spec.Checkpoint.Path,
+		})
+	}
+
+	// secrets given in files are mounted read only from a folder we remove
+	// as soon as the container has stopped
+	secretsDir, secretFiles, err := executor.WriteSecretFiles(ctx, shard.Job.Spec)
+	if err != nil {
+		return err
+	}
+	if secretsDir != "" {
+		defer os.RemoveAll(secretsDir)
+	}
+	for _, secretFile := range secretFiles {
+		mounts = append(mounts, mount.Mount{
+			Type:     "bind",
+			ReadOnly: true,
+			Source:   secretFile.Source,
+			Target:   secretFile.Target,
+		})
+	}
+	secretEnv, err := executor.SecretEnv(ctx, shard.Job.Spec)
+	if err != nil {
+		return err
+	}
+
+	image, err := e.ensureImage(ctx, shard.Job.Spec.Docker.Image)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		e.images.release(image.ID)
+		e.evictImages(ctx)
+	}()
+	e.evictImages(ctx)
+	err = writeImageDigest(jobResultsDir, shard.Job.Spec.Docker.Image, image)
+	if err != nil {
+		return err
 	}
 
 	// json the job spec and pass it into all containers
@@ -235,43 +343,70 @@ func (e *Executor) RunShard(
 
 	useEnv := append(shard.Job.Spec.Docker.Env, fmt.Sprintf("BACALHAU_JOB_SPEC=%s", string(jsonJobSpec))) //nolint:gocritic
 
+	// jobs get no network unless they asked for it and the node agreed
+	shardNetwork, err := e.setupShardNetwork(ctx, shard)
+	if err != nil {
+		return err
+	}
+	defer e.cleanupNetwork(ctx, shardNetwork)
+	useEnv = append(useEnv, shardNetwork.env...)
+
 	containerConfig := &container.Config{
 		Image:           shard.Job.Spec.Docker.Image,
 		Tty:             false,
 		Env:             useEnv,
 		Entrypoint:      shard.Job.Spec.Docker.Entrypoint,
 		Labels:          e.jobContainerLabels(shard.Job),
-		NetworkDisabled: true,
+		NetworkDisabled: shardNetwork.disabled(),
 		WorkingDir:      shard.Job.Spec.Docker.WorkingDir,
 	}
 
 	log.Trace().Msgf("Container: %+v %+v", containerConfig, mounts)
+	// added after we log the config so the values never reach the logs
+	containerConfig.Env = append(containerConfig.Env, secretEnv...)
 
 	resourceRequirements := capacitymanager.ParseResourceUsageConfig(shard.Job.Spec.Resources)
 
 	// Create GPU request if the job requests it
 	var deviceRequests []container.DeviceRequest
 	if resourceRequirements.GPU > 0 {
+		// the compute node decides which devices this shard gets
+		// so two shards never share the same GPU
+		deviceIDs := executor.GPUDevicesFromContext(ctx)
+		if uint64(len(deviceIDs)) != resourceRequirements.GPU {
+			return fmt.Errorf(
+				"job requires %d GPUs but %d devices were allocated",
+				resourceRequirements.GPU, len(deviceIDs),
+			)
+		}
 		deviceRequests = append(deviceRequests,
 			container.DeviceRequest{
-				DeviceIDs:    []string{"0"}, // TODO: how do we know which device ID to use?
+				DeviceIDs:    deviceIDs,
 				Capabilities: [][]string{{"gpu"}},
 			},
 		)
-		log.Trace().Msgf("Adding %d GPUs to request", resourceRequirements.GPU)
+		log.Trace().Msgf("Adding GPUs %v to request", deviceIDs)
+	}
+
+	hostConfig := &container.HostConfig{
+		Mounts:      mounts,
+		NetworkMode: container.NetworkMode(shardNetwork.mode),
+		Resources: container.Resources{
+			Memory:         int64(resourceRequirements.Memory),
+			NanoCPUs:       int64(resourceRequirements.CPU * NanoCPUCoefficient),
+			DeviceRequests: deviceRequests,
+		},
+	}
+	err = e.checkUserNamespaces(ctx)
+	if err != nil {
+		return err
 	}
+	e.sandbox.apply(containerConfig, hostConfig)
 
 	jobContainer, err := e.Client.ContainerCreate(
 		ctx,
 		containerConfig,
-		&container.HostConfig{
-			Mounts: mounts,
-			Resources: container.Resources{
-				Memory:         int64(resourceRequirements.Memory),
-				NanoCPUs:       int64(resourceRequirements.CPU * NanoCPUCoefficient),
-				DeviceRequests: deviceRequests,
-			},
-		},
+		hostConfig,
 		&network.NetworkingConfig{},
 		nil,
 		e.jobContainerName(shard),
@@ -280,6 +415,7 @@ func (e *Executor) RunShard(
 		return fmt.Errorf("failed to create container: %w", err)
 	}
 
+	startedAt := time.Now()
 	err = e.Client.ContainerStart(
 		ctx,
 		jobContainer.ID,
@@ -291,24 +427,55 @@ func (e *Executor) RunShard(
 
 	defer e.cleanupJob(ctx, shard)
 
+	// record what the container actually uses while it runs
+	sampler := e.sampleContainerStats(ctx, jobContainer.ID)
+	defer sampler.cancel()
+
+	// follow the output as it is produced so it can be streamed to
+	// clients while we also write it to the results
+	logs, err := executor.NewShardLogs(ctx, jobResultsDir)
+	if err != nil {
+		return fmt.Errorf("failed to create log files: %w", err)
+	}
+	defer logs.Close()
+	logsReader, err := e.Client.ContainerLogs(ctx, jobContainer.ID, dockertypes.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get logs: %w", err)
+	}
+	defer logsReader.Close()
+	logsDone := make(chan error, 1)
+	go func() {
+		_, copyErr := stdcopy.StdCopy(logs.Stdout, logs.Stderr, logsReader)
+		logsDone <- copyErr
+	}()
+
+	// the container is killed if it runs for longer than it is allowed to
+	waitCtx := ctx
+	timeout := executor.ExecutionTimeoutFromContext(ctx)
+	if timeout > 0 {
+		var cancelWait context.CancelFunc
+		waitCtx, cancelWait = context.WithTimeout(ctx, timeout)
+		defer cancelWait()
+	}
+
 	// the idea here is even if the container errors
 	// we want to capture stdout, stderr and feed it back to the user
-	var containerError error
-	var containerExitStatusCode int64
-	statusCh, errCh := e.Client.ContainerWait(
-		ctx,
-		jobContainer.ID,
-		container.WaitConditionNotRunning,
-	)
-	select {
-	case err = <-errCh:
-		containerError = err
-	case exitStatus := <-statusCh:
-		containerExitStatusCode = exitStatus.StatusCode
-		if exitStatus.Error != nil {
-			containerError = errors.New(exitStatus.Error.Message)
+	containerExitStatusCode, containerError := e.waitForContainer(waitCtx, jobContainer.ID)
+	if timeout > 0 && waitCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		log.Info().Msgf("container %s ran for longer than %s, killing it", jobContainer.ID, timeout)
+		err = e.Client.ContainerKill(ctx, jobContainer.ID, "SIGKILL")
+		if err != nil && !dockerclient.IsErrNotFound(err) {
+			return fmt.Errorf("failed to kill container after timeout: %w", err)
 		}
+		containerExitStatusCode, _ = e.waitForContainer(ctx, jobContainer.ID)
+		containerError = executor.NewExecutionTimeoutError(timeout)
 	}
+	resourceUsage := sampler.stop()
+	resourceUsage.WallTime = time.Since(startedAt).Seconds()
 	if containerExitStatusCode != 0 {
 		if containerError == nil {
 			containerError = fmt.Errorf("exit code was not zero: %d", containerExitStatusCode)
@@ -316,16 +483,14 @@ func (e *Executor) RunShard(
 		log.Info().Msgf("container error %s", containerError)
 	}
 
-	stdout, stderr, err := system.RunCommandGetStdoutAndStderr(
-		"docker",
-		[]string{
-			"logs",
-			"-f",
-			jobContainer.ID,
-		},
-	)
-	if err != nil {
-		return fmt.Errorf("failed to get logs: %w", err)
+	// the log stream ends once the container has stopped
+	select {
+	case err = <-logsDone:
+		if err != nil {
+			return fmt.Errorf("failed to get logs: %w", err)
+		}
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 
 	err = os.WriteFile(
@@ -339,24 +504,24 @@ func (e *Executor) RunShard(
 		return errors.New(msg)
 	}
 
-	err = os.WriteFile(
-		fmt.Sprintf("%s/stdout", jobResultsDir),
-		[]byte(stdout),
-		util.OS_ALL_R|util.OS_USER_RW,
-	)
+	err = logs.Close()
 	if err != nil {
-		msg := fmt.Sprintf("could not write results to stdout: %s", err)
+		msg := fmt.Sprintf("could not write results to stdout and stderr: %s", err)
 		log.Error().Msg(msg)
 		return errors.New(msg)
 	}
 
-	err = os.WriteFile(
-		fmt.Sprintf("%s/stderr", jobResultsDir),
-		[]byte(stderr),
-		util.OS_ALL_R|util.OS_USER_RW,
-	)
+	// block IO stats miss anything still in the page cache
+	// so count at least what ended up in the output volumes
+	outputsSize, err := executor.OutputsSize(jobResultsDir, shard.Job.Spec.Outputs)
+	if err != nil {
+		log.Debug().Msgf("Could not measure the outputs of %s: %s", shard, err)
+	} else if outputsSize > resourceUsage.DiskWritten {
+		resourceUsage.DiskWritten = outputsSize
+	}
+	err = executor.WriteResourceUsage(jobResultsDir, resourceUsage)
 	if err != nil {
-		msg := fmt.Sprintf("could not write results to stderr: %s", err)
+		msg := fmt.Sprintf("could not write results to %s: %s", executor.ResourceUsageFilename, err)
 		log.Error().Msg(msg)
 		return errors.New(msg)
 	}
@@ -364,6 +529,24 @@ func (e *Executor) RunShard(
 	return containerError
 }
 
+// wait for the container to stop and return its exit code
+func (e *Executor) waitForContainer(ctx context.Context, containerID string) (int64, error) {
+	statusCh, errCh := e.Client.ContainerWait(
+		ctx,
+		containerID,
+		container.WaitConditionNotRunning,
+	)
+	select {
+	case err := <-errCh:
+		return 0, err
+	case exitStatus := <-statusCh:
+		if exitStatus.Error != nil {
+			return exitStatus.StatusCode, errors.New(exitStatus.Error.Message)
+		}
+		return exitStatus.StatusCode, nil
+	}
+}
+
 func (e *Executor) cleanupJob(ctx context.Context, shard model.JobShard) {
 	if config.ShouldKeepStack() {
 		return
@@ -395,6 +578,7 @@ func (e *Executor) cleanupAll(ctx context.Context) {
 			log.Error().Msgf("Non-critical error cleaning up container: %s", err.Error())
 		}
 	}
+	e.cleanupAllNetworks(ctx)
 }
 
 func (e *Executor) jobContainerName(shard model.JobShard) string {
@@ -414,3 +598,4 @@ func newSpan(ctx context.Context, apiName string) (context.Context, trace.Span)
 
 // Compile-time interface check:
 var _ executor.Executor = (*Executor)(nil)
+var _ executor.CheckpointRestorer = (*Executor)(nil)
@@ -0,0 +1,110 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	dockerclient "github.com/docker/docker/client"
+	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/filecoin-project/bacalhau/pkg/storage"
+	"github.com/filecoin-project/bacalhau/pkg/storage/inputcache"
+	"github.com/stretchr/testify/require"
+)
+
+// a driver that keeps what it prepares in an input cache
+type cachedStorage struct {
+	cache *inputcache.InputCache
+}
+
+func (c *cachedStorage) IsInstalled(ctx context.Context) (bool, error) {
+	return true, nil
+}
+
+func (c *cachedStorage) HasStorageLocally(ctx context.Context, spec model.StorageSpec) (bool, error) {
+	key, _ := inputcache.Key(spec)
+	return c.cache.Has(key), nil
+}
+
+func (c *cachedStorage) GetVolumeSize(ctx context.Context, spec model.StorageSpec) (uint64, error) {
+	return 1, nil
+}
+
+func (c *cachedStorage) PrepareStorage(ctx context.Context, spec model.StorageSpec) (storage.StorageVolume, error) {
+	key, _ := inputcache.Key(spec)
+	source, err := c.cache.Acquire(ctx, key, func(ctx context.Context, path string) error {
+		return os.WriteFile(path, []byte(spec.Cid), 0600)
+	})
+	if err != nil {
+		return storage.StorageVolume{}, err
+	}
+	return storage.StorageVolume{Type: storage.StorageVolumeConnectorBind, Source: source, Target: spec.Path}, nil
+}
+
+func (c *cachedStorage) CleanupStorage(ctx context.Context, spec model.StorageSpec, volume storage.StorageVolume) error {
+	key, _ := inputcache.Key(spec)
+	c.cache.Release(key)
+	return nil
+}
+
+func (c *cachedStorage) Upload(ctx context.Context, localPath string) (model.StorageSpec, error) {
+	return model.StorageSpec{}, fmt.Errorf("not implemented")
+}
+
+func (c *cachedStorage) Explode(ctx context.Context, spec model.StorageSpec) ([]model.StorageSpec, error) {
+	return []model.StorageSpec{spec}, nil
+}
+
+func TestRunShardReleasesInputs(t *testing.T) {
+	ctx := context.Background()
+	cache, err := inputcache.NewInputCache(inputcache.Config{Dir: t.TempDir(), MaxSize: 1024})
+	require.NoError(t, err)
+
+	// there is no docker here so the shard fails once its inputs are ready
+	client, err := dockerclient.NewClientWithOpts(dockerclient.WithHost("unix://" + filepath.Join(t.TempDir(), "docker.sock")))
+	require.NoError(t, err)
+	e := &Executor{
+		StorageProviders: map[model.StorageSourceType]storage.StorageProvider{
+			model.StorageSourceIPFS: &cachedStorage{cache: cache},
+		},
+		Client:     client,
+		images:     newImageCache(0),
+		prefetched: map[string]map[string]prefetchedVolume{},
+	}
+
+	inputs := []model.StorageSpec{
+		{Engine: model.StorageSourceIPFS, Cid: "QmInput", Path: "/inputs"},
+		{Engine: model.StorageSourceIPFS, Cid: "QmContext", Path: "/context"},
+	}
+	shard := model.JobShard{Job: model.Job{
+		ID: "job",
+		Spec: model.JobSpec{
+			Engine:   model.EngineDocker,
+			Docker:   model.JobSpecDocker{Image: "ubuntu"},
+			Inputs:   inputs[:1],
+			Contexts: inputs[1:],
+		},
+	}}
+
+	refs := func() []int {
+		counts := []int{}
+		for _, input := range inputs {
+			key, ok := inputcache.Key(input)
+			require.True(t, ok)
+			require.True(t, cache.Has(key))
+			counts = append(counts, cache.Refs(key))
+		}
+		return counts
+	}
+
+	require.Error(t, e.RunShard(ctx, shard, t.TempDir()))
+	require.Equal(t, []int{0, 0}, refs())
+
+	// the same goes for inputs fetched while the node was bidding
+	require.NoError(t, e.PrefetchShardInputs(ctx, shard))
+	require.Equal(t, []int{1, 1}, refs())
+	require.Error(t, e.RunShard(ctx, shard, t.TempDir()))
+	require.Equal(t, []int{0, 0}, refs())
+}
@@ -0,0 +1,121 @@
+package docker
+
+import (
+	"sort"
+	"time"
+
+	sync "github.com/lukemarsden/golang-mutex-tracer"
+)
+
+type cachedImage struct {
+	id       string
+	size     uint64
+	lastUsed time.Time
+	// how many shards are using the image right now
+	inUse int
+}
+
+// keeps track of the images we pulled for jobs so the ones that
+// haven't been used for longest can be removed to save disk
+// images that were already there when we started are never touched
+type imageCache struct {
+	maxSize uint64
+	images  map[string]*cachedImage
+	mu      sync.Mutex
+	// held for reading while a shard finds and acquires an image and for
+	// writing while images are removed, so one can't go in between
+	removal sync.RWMutex
+}
+
+func newImageCache(maxSize uint64) *imageCache {
+	c := &imageCache{
+		maxSize: maxSize,
+		images:  map[string]*cachedImage{},
+	}
+	c.mu.EnableTracerWithOpts(sync.Opts{
+		Threshold: 10 * time.Millisecond,
+		Id:        "DockerExecutor.imageCache.mu",
+	})
+	c.removal.EnableTracerWithOpts(sync.Opts{
+		Threshold: 10 * time.Millisecond,
+		Id:        "DockerExecutor.imageCache.removal",
+	})
+	return c
+}
+
+// an image we pulled
+func (c *imageCache) add(id string, size uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.images[id]; ok {
+		return
+	}
+	c.images[id] = &cachedImage{id: id, size: size, lastUsed: time.Now()}
+}
+
+// a shard started using the image - it won't be evicted until it is released
+func (c *imageCache) acquire(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if image, ok := c.images[id]; ok {
+		image.inUse++
+		image.lastUsed = time.Now()
+	}
+}
+
+func (c *imageCache) release(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if image, ok := c.images[id]; ok && image.inUse > 0 {
+		image.inUse--
+		image.lastUsed = time.Now()
+	}
+}
+
+// stop tracking the least recently used images that nothing is using until
+// the rest fit, and return them so they can be removed
+func (c *imageCache) evict() []cachedImage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.maxSize == 0 {
+		return nil
+	}
+	var total uint64
+	candidates := []*cachedImage{}
+	for _, image := range c.images {
+		total += image.size
+		if image.inUse == 0 {
+			candidates = append(candidates, image)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].lastUsed.Before(candidates[j].lastUsed)
+	})
+	evicted := []cachedImage{}
+	for _, image := range candidates {
+		if total <= c.maxSize {
+			break
+		}
+		delete(c.images, image.id)
+		total -= image.size
+		evicted = append(evicted, *image)
+	}
+	return evicted
+}
+
+// stop any image being removed until the returned func is called, so a
+// shard can find an image and acquire it without it being removed first
+func (c *imageCache) holdImages() func() {
+	c.removal.RLock()
+	return c.removal.RUnlock
+}
+
+// evict images and call remove for each of them while no shard is
+// between finding an image and acquiring it
+func (c *imageCache) removeEvicted(remove func(image cachedImage)) {
+	c.removal.Lock()
+	defer c.removal.Unlock()
+	for _, image := range c.evict() {
+		remove(image)
+	}
+}
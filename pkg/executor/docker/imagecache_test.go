@@ -0,0 +1,99 @@
+package docker
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func evictedIDs(c *imageCache) []string {
+	ids := []string{}
+	for _, image := range c.evict() {
+		ids = append(ids, image.id)
+	}
+	return ids
+}
+
+func TestImageCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newImageCache(100)
+	c.add("old", 40)
+	c.add("newer", 40)
+	c.add("newest", 40)
+	c.images["old"].lastUsed = time.Now().Add(-time.Hour)
+	c.images["newer"].lastUsed = time.Now().Add(-time.Minute)
+
+	// using an image makes it the most recently used
+	c.acquire("old")
+	c.release("old")
+	require.Equal(t, []string{"newer"}, evictedIDs(c))
+	require.Empty(t, evictedIDs(c))
+}
+
+func TestImageCacheKeepsImagesInUse(t *testing.T) {
+	c := newImageCache(50)
+	c.add("running", 60)
+	c.add("idle", 30)
+	c.acquire("running")
+
+	// we can't get under the limit but we still remove what we can
+	require.Equal(t, []string{"idle"}, evictedIDs(c))
+	require.Empty(t, evictedIDs(c))
+
+	c.release("running")
+	require.Equal(t, []string{"running"}, evictedIDs(c))
+}
+
+func TestImageCacheWithoutLimit(t *testing.T) {
+	c := newImageCache(0)
+	c.add("image", 1000)
+	require.Empty(t, c.evict())
+	// images we didn't pull are never tracked
+	c.acquire("someone-elses")
+	require.NotContains(t, c.images, "someone-elses")
+}
+
+// a shard that finds an image is never racing an eviction to acquire it
+func TestImageCacheAcquireDuringEviction(t *testing.T) {
+	c := newImageCache(1)
+	var mu sync.Mutex
+	removed := map[string]bool{}
+	for i := 0; i < 1000; i++ {
+		id := fmt.Sprintf("image-%d", i)
+		c.add(id, 10)
+		acquired := false
+		var wg sync.WaitGroup
+		wg.Add(2) //nolint:gomnd
+		go func() {
+			defer wg.Done()
+			release := c.holdImages()
+			defer release()
+			// what looking the image up in docker would tell us
+			mu.Lock()
+			found := !removed[id]
+			mu.Unlock()
+			if found {
+				c.acquire(id)
+				acquired = true
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			c.removeEvicted(func(image cachedImage) {
+				mu.Lock()
+				defer mu.Unlock()
+				removed[image.id] = true
+			})
+		}()
+		wg.Wait()
+
+		mu.Lock()
+		require.NotEqual(t, acquired, removed[id], id)
+		mu.Unlock()
+		if acquired {
+			c.release(id)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package docker
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution/reference"
+	dockertypes "github.com/docker/docker/api/types"
+	dockerclient "github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
+	units "github.com/docker/go-units"
+	"github.com/filecoin-project/bacalhau/pkg/executor"
+	"github.com/filecoin-project/bacalhau/pkg/storage/util"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// the file in the shard results with the digest the job's image resolved to
+	ImageDigestFilename = "imageDigest"
+
+	// how often we report how an image pull is getting on
+	pullProgressInterval = 5 * time.Second
+
+	// docker hub is known by a few names
+	dockerHubRegistry = "docker.io"
+)
+
+// ImageConfig configures how the executor gets the images jobs run in
+type ImageConfig struct {
+	// a docker config.json style file with the credentials for private
+	// registries - only the "auths" section is used
+	RegistryAuthFile string
+	// the most disk the images we pulled for jobs can use, the least recently
+	// used are removed once we go over it - zero means no limit
+	CacheSize uint64
+}
+
+type registryAuthFile struct {
+	Auths map[string]dockertypes.AuthConfig `json:"auths"`
+}
+
+// read the credentials for each registry, keyed by the registry's host
+func loadRegistryAuth(path string) (map[string]dockertypes.AuthConfig, error) {
+	auths := map[string]dockertypes.AuthConfig{}
+	if path == "" {
+		return auths, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading registry auth file: %w", err)
+	}
+	var file registryAuthFile
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing registry auth file %s: %w", path, err)
+	}
+	for address, auth := range file.Auths {
+		// "auth" is base64 of username:password like docker login writes it
+		if auth.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				return nil, fmt.Errorf("invalid auth for registry %s: %w", address, err)
+			}
+			username, password, ok := strings.Cut(string(decoded), ":")
+			if !ok {
+				return nil, fmt.Errorf("invalid auth for registry %s: expected username:password", address)
+			}
+			auth.Username = username
+			auth.Password = password
+			auth.Auth = ""
+		}
+		auth.ServerAddress = address
+		auths[registryHost(address)] = auth
+	}
+	return auths, nil
+}
+
+// the host of a registry address, which may be a URL
+func registryHost(address string) string {
+	host := address
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+3:]
+	}
+	host, _, _ = strings.Cut(host, "/")
+	switch host {
+	case "index.docker.io", "registry-1.docker.io":
+		return dockerHubRegistry
+	}
+	return host
+}
+
+// the encoded credentials to pull the image with - empty if we have none
+// for its registry
+func (e *Executor) registryAuth(image string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", fmt.Errorf("invalid image name %s: %w", image, err)
+	}
+	auth, ok := e.registryAuths[reference.Domain(named)]
+	if !ok {
+		return "", nil
+	}
+	encoded, err := json.Marshal(auth)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(encoded), nil
+}
+
+// make sure we have the image, pulling it if we don't, and acquire it so
+// it isn't removed - the caller must release it when it is done with it
+func (e *Executor) ensureImage(ctx context.Context, image string) (dockertypes.ImageInspect, error) {
+	if os.Getenv("SKIP_IMAGE_PULL") != "" {
+		return dockertypes.ImageInspect{}, nil
+	}
+	inspect, found, err := e.acquireImage(ctx, image, false)
+	if err != nil {
+		return inspect, err
+	}
+	if found {
+		log.Debug().Msgf("Not pulling image %s, already have %s", image, inspect.ID)
+		return inspect, nil
+	}
+
+	err = e.pullImage(ctx, image)
+	if err != nil {
+		return inspect, err
+	}
+	inspect, found, err = e.acquireImage(ctx, image, true)
+	if err == nil && !found {
+		err = fmt.Errorf("image %s was removed as soon as it was pulled", image)
+	}
+	return inspect, err
+}
+
+// find the image and acquire it before any image can be removed, tracking
+// it first if we pulled it
+func (e *Executor) acquireImage(ctx context.Context, image string, pulled bool) (dockertypes.ImageInspect, bool, error) {
+	release := e.images.holdImages()
+	defer release()
+	inspect, _, err := e.Client.ImageInspectWithRaw(ctx, image)
+	if dockerclient.IsErrNotFound(err) {
+		return inspect, false, nil
+	}
+	if err != nil {
+		return inspect, false, fmt.Errorf("error checking if we have %s locally: %s", image, err)
+	}
+	if pulled {
+		e.images.add(inspect.ID, uint64(inspect.Size))
+	}
+	e.images.acquire(inspect.ID)
+	return inspect, true, nil
+}
+
+func (e *Executor) pullImage(ctx context.Context, image string) error {
+	auth, err := e.registryAuth(image)
+	if err != nil {
+		return err
+	}
+	stream, err := e.Client.ImagePull(ctx, image, dockertypes.ImagePullOptions{RegistryAuth: auth})
+	if err != nil {
+		return fmt.Errorf("error pulling %s: %w", image, err)
+	}
+	defer stream.Close()
+
+	report := executor.StatusReporterFromContext(ctx)
+	progress := newPullProgress(image)
+	var lastReport time.Time
+	decoder := json.NewDecoder(stream)
+	for {
+		var message jsonmessage.JSONMessage
+		err = decoder.Decode(&message)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error pulling %s: %w", image, err)
+		}
+		if message.Error != nil {
+			return fmt.Errorf("error pulling %s: %s", image, message.Error.Message)
+		}
+		progress.update(message)
+		if time.Since(lastReport) >= pullProgressInterval {
+			report(progress.String())
+			lastReport = time.Now()
+		}
+	}
+	log.Debug().Msgf("Pulled image %s", image)
+	report(fmt.Sprintf("pulled image %s", image))
+	return nil
+}
+
+type layerProgress struct {
+	current int64
+	total   int64
+	done    bool
+}
+
+// follows the messages docker streams as it pulls an image
+type pullProgress struct {
+	image  string
+	layers map[string]*layerProgress
+	// so the layers are always listed in the same order
+	order []string
+}
+
+func newPullProgress(image string) *pullProgress {
+	return &pullProgress{
+		image:  image,
+		layers: map[string]*layerProgress{},
+	}
+}
+
+func (p *pullProgress) update(message jsonmessage.JSONMessage) {
+	// messages without a progress bar are about the image as a whole
+	if message.ID == "" || message.Status == "" {
+		return
+	}
+	layer, ok := p.layers[message.ID]
+	if !ok {
+		// the first message for the image itself has its tag as the ID
+		if !strings.HasPrefix(message.Status, "Pulling fs layer") && !strings.HasPrefix(message.Status, "Already exists") {
+			return
+		}
+		layer = &layerProgress{}
+		p.layers[message.ID] = layer
+		p.order = append(p.order, message.ID)
+	}
+	switch {
+	case message.Status == "Downloading" && message.Progress != nil:
+		layer.current = message.Progress.Current
+		layer.total = message.Progress.Total
+	case message.Status == "Download complete":
+		layer.current = layer.total
+	case message.Status == "Pull complete" || message.Status == "Already exists":
+		layer.current = layer.total
+		layer.done = true
+	}
+}
+
+func (p *pullProgress) String() string {
+	var current, total int64
+	done := 0
+	for _, id := range p.order {
+		layer := p.layers[id]
+		current += layer.current
+		total += layer.total
+		if layer.done {
+			done++
+		}
+	}
+	if len(p.order) == 0 {
+		return fmt.Sprintf("pulling image %s", p.image)
+	}
+	return fmt.Sprintf("pulling image %s: %d of %d layers, %s of %s downloaded",
+		p.image, done, len(p.order), units.HumanSize(float64(current)), units.HumanSize(float64(total)))
+}
+
+// the digest the image was pulled by, which says exactly what the job ran
+// in whatever tag it asked for - empty if the image didn't come from a registry
+func imageDigest(image string, inspect dockertypes.ImageInspect) string {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return ""
+	}
+	for _, repoDigest := range inspect.RepoDigests {
+		digested, err := reference.ParseNormalizedNamed(repoDigest)
+		if err != nil {
+			continue
+		}
+		if _, ok := digested.(reference.Canonical); ok && digested.Name() == named.Name() {
+			return reference.FamiliarString(digested)
+		}
+	}
+	return ""
+}
+
+func writeImageDigest(resultsDir, image string, inspect dockertypes.ImageInspect) error {
+	digest := imageDigest(image, inspect)
+	if digest == "" {
+		return nil
+	}
+	return os.WriteFile(filepath.Join(resultsDir, ImageDigestFilename), []byte(digest), util.OS_ALL_R|util.OS_USER_W)
+}
+
+// remove the least recently used images we pulled until
+// they fit in the cache again
+func (e *Executor) evictImages(ctx context.Context) {
+	e.images.removeEvicted(func(image cachedImage) {
+		_, err := e.Client.ImageRemove(ctx, image.id, dockertypes.ImageRemoveOptions{Force: true, PruneChildren: true})
+		if err != nil {
+			// it might be the base of an image we built so we
+			// try again once the others have had their turn
+			log.Debug().Msgf("Could not remove image %s: %s", image.id, err)
+			e.images.add(image.id, image.size)
+			return
+		}
+		log.Debug().Msgf("Removed image %s to stay under the image cache size", image.id)
+	})
+}
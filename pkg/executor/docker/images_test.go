@@ -0,0 +1,96 @@
+package docker
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryAuth(t *testing.T) {
+	authFile := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(authFile, []byte(`{
+		"auths": {
+			"https://index.docker.io/v1/": {"auth": "`+base64.StdEncoding.EncodeToString([]byte("hubuser:hubpass"))+`"},
+			"registry.example.com:5000": {"username": "user", "password": "pass"}
+		},
+		"credsStore": "desktop"
+	}`), 0600))
+	auths, err := loadRegistryAuth(authFile)
+	require.NoError(t, err)
+	e := &Executor{registryAuths: auths}
+
+	decode := func(encoded string) dockertypes.AuthConfig {
+		data, err := base64.URLEncoding.DecodeString(encoded)
+		require.NoError(t, err)
+		var auth dockertypes.AuthConfig
+		require.NoError(t, json.Unmarshal(data, &auth))
+		return auth
+	}
+
+	encoded, err := e.registryAuth("ubuntu:latest")
+	require.NoError(t, err)
+	auth := decode(encoded)
+	require.Equal(t, "hubuser", auth.Username)
+	require.Equal(t, "hubpass", auth.Password)
+	require.Equal(t, "https://index.docker.io/v1/", auth.ServerAddress)
+
+	encoded, err = e.registryAuth("registry.example.com:5000/team/tool@sha256:" + sha256Hex)
+	require.NoError(t, err)
+	require.Equal(t, "user", decode(encoded).Username)
+
+	// no credentials for this registry
+	encoded, err = e.registryAuth("ghcr.io/org/image")
+	require.NoError(t, err)
+	require.Empty(t, encoded)
+}
+
+func TestRegistryAuthInvalid(t *testing.T) {
+	authFile := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(authFile, []byte(`{"auths": {"example.com": {"auth": "bm9jb2xvbg=="}}}`), 0600))
+	_, err := loadRegistryAuth(authFile)
+	require.Error(t, err)
+
+	auths, err := loadRegistryAuth("")
+	require.NoError(t, err)
+	require.Empty(t, auths)
+}
+
+const sha256Hex = "2d4e459f4ecb5329407ae3e47cbc107a2fbace221354ca75960af4c047b3cb13"
+
+func TestImageDigest(t *testing.T) {
+	inspect := dockertypes.ImageInspect{RepoDigests: []string{
+		"mirror.example.com/library/ubuntu@sha256:" + sha256Hex,
+		"ubuntu@sha256:" + sha256Hex,
+	}}
+	require.Equal(t, "ubuntu@sha256:"+sha256Hex, imageDigest("ubuntu:22.04", inspect))
+	require.Equal(t, "ubuntu@sha256:"+sha256Hex, imageDigest("docker.io/library/ubuntu", inspect))
+	// an image we built has no digest
+	require.Empty(t, imageDigest("bacalhau-python:3.10-abc", inspect))
+	require.Empty(t, imageDigest("ubuntu", dockertypes.ImageInspect{}))
+}
+
+func TestPullProgress(t *testing.T) {
+	progress := newPullProgress("ubuntu:latest")
+	require.Equal(t, "pulling image ubuntu:latest", progress.String())
+
+	for _, message := range []jsonmessage.JSONMessage{
+		{ID: "latest", Status: "Pulling from library/ubuntu"},
+		{ID: "aaa", Status: "Already exists"},
+		{ID: "bbb", Status: "Pulling fs layer"},
+		{ID: "ccc", Status: "Pulling fs layer"},
+		{ID: "bbb", Status: "Downloading", Progress: &jsonmessage.JSONProgress{Current: 1000000, Total: 2000000}},
+		{ID: "ccc", Status: "Downloading", Progress: &jsonmessage.JSONProgress{Current: 500000, Total: 1000000}},
+		{ID: "ccc", Status: "Download complete"},
+		{ID: "ccc", Status: "Pull complete"},
+		{Status: "Digest: sha256:" + sha256Hex},
+	} {
+		progress.update(message)
+	}
+	require.Equal(t, "pulling image ubuntu:latest: 2 of 3 layers, 2MB of 3MB downloaded", progress.String())
+}
@@ -0,0 +1,129 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/filecoin-project/bacalhau/pkg/config"
+	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/rs/zerolog/log"
+)
+
+// how a shard's container is connected to the network
+type shardNetwork struct {
+	// the docker network the container joins - empty means none
+	mode string
+	// extra environment variables for the container
+	env []string
+	// the egress proxy for an allowlist network
+	proxy *egressProxy
+}
+
+func (n *shardNetwork) disabled() bool {
+	return n.mode == ""
+}
+
+// set up the network a shard asked for
+// each shard gets its own bridge network so jobs can't see each other
+func (e *Executor) setupShardNetwork(ctx context.Context, shard model.JobShard) (*shardNetwork, error) {
+	return e.setupNetwork(ctx, e.shardNetworkName(shard), e.jobContainerLabels(shard.Job), shard.Job.Spec.Network)
+}
+
+func (e *Executor) setupNetwork(
+	ctx context.Context,
+	name string,
+	labels map[string]string,
+	spec model.NetworkConfig,
+) (*shardNetwork, error) {
+	if spec.Disabled() {
+		return &shardNetwork{}, nil
+	}
+
+	_, err := e.Client.NetworkCreate(ctx, name, dockertypes.NetworkCreate{
+		CheckDuplicate: true,
+		Driver:         "bridge",
+		// an internal network has no route out of the host
+		// so the only way out is through our proxy
+		Internal: spec.Type == model.NetworkAllowlist,
+		Labels:   labels,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create network %s: %w", name, err)
+	}
+	network := &shardNetwork{mode: name}
+
+	if spec.Type == model.NetworkAllowlist {
+		gateway, err := e.networkGateway(ctx, name)
+		if err != nil {
+			e.cleanupNetwork(ctx, network)
+			return nil, err
+		}
+		proxy, err := newEgressProxy(net.JoinHostPort(gateway, "0"), spec)
+		if err != nil {
+			e.cleanupNetwork(ctx, network)
+			return nil, fmt.Errorf("failed to start egress proxy: %w", err)
+		}
+		log.Debug().Msgf("Egress proxy for %s listening on %s", name, proxy.URL())
+		network.proxy = proxy
+		network.env = []string{
+			"HTTP_PROXY=" + proxy.URL(),
+			"HTTPS_PROXY=" + proxy.URL(),
+			"http_proxy=" + proxy.URL(),
+			"https_proxy=" + proxy.URL(),
+		}
+	}
+
+	return network, nil
+}
+
+// the address the host has on a network, which is where the proxy listens
+func (e *Executor) networkGateway(ctx context.Context, name string) (string, error) {
+	resource, err := e.Client.NetworkInspect(ctx, name, dockertypes.NetworkInspectOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect network %s: %w", name, err)
+	}
+	for _, ipam := range resource.IPAM.Config {
+		if ipam.Gateway != "" {
+			return ipam.Gateway, nil
+		}
+	}
+	return "", fmt.Errorf("network %s has no gateway", name)
+}
+
+func (e *Executor) cleanupNetwork(ctx context.Context, network *shardNetwork) {
+	if network.proxy != nil {
+		if err := network.proxy.Close(ctx); err != nil {
+			log.Debug().Msgf("Error stopping egress proxy: %s", err)
+		}
+	}
+	if network.disabled() || config.ShouldKeepStack() {
+		return
+	}
+	if err := e.Client.NetworkRemove(ctx, network.mode); err != nil {
+		log.Error().Msgf("Docker remove network error: %s", err.Error())
+	}
+}
+
+// remove any networks left behind by this executor
+func (e *Executor) cleanupAllNetworks(ctx context.Context) {
+	networks, err := e.Client.NetworkList(ctx, dockertypes.NetworkListOptions{
+		Filters: filters.NewArgs(filters.Arg("label", "bacalhau-executor="+e.ID)),
+	})
+	if err != nil {
+		log.Error().Msgf("Docker list networks error: %s", err.Error())
+		return
+	}
+	for _, network := range networks {
+		err = e.Client.NetworkRemove(ctx, network.ID)
+		if err != nil {
+			log.Error().Msgf("Non-critical error cleaning up network: %s", err.Error())
+		}
+	}
+}
+
+func (e *Executor) shardNetworkName(shard model.JobShard) string {
+	return e.jobContainerName(shard)
+}
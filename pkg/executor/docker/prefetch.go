@@ -0,0 +1,97 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+
+	jobutils "github.com/filecoin-project/bacalhau/pkg/job"
+	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/filecoin-project/bacalhau/pkg/storage"
+	"github.com/filecoin-project/bacalhau/pkg/system"
+	"github.com/rs/zerolog/log"
+)
+
+// a volume that was prepared before the shard was run
+type prefetchedVolume struct {
+	spec   model.StorageSpec
+	volume storage.StorageVolume
+}
+
+// identify a storage spec so RunShard can find what we prefetched for it
+func prefetchKey(spec model.StorageSpec) string {
+	return fmt.Sprintf("%s|%s|%s|%s", spec.Engine, spec.Cid, spec.URL, spec.Path)
+}
+
+// the inputs of a shard are its context volumes plus its slice of the input volumes
+func (e *Executor) getShardInputs(ctx context.Context, shard model.JobShard) ([]model.StorageSpec, error) {
+	shardStorageSpec, err := jobutils.GetShardStorageSpec(ctx, shard, e.StorageProviders)
+	if err != nil {
+		return nil, err
+	}
+	inputs := []model.StorageSpec{}
+	inputs = append(inputs, shard.Job.Spec.Contexts...)
+	inputs = append(inputs, shardStorageSpec...)
+	return inputs, nil
+}
+
+func (e *Executor) PrefetchShardInputs(ctx context.Context, shard model.JobShard) error {
+	ctx, span := system.GetTracer().Start(ctx, "pkg/executor/docker.PrefetchShardInputs")
+	defer span.End()
+
+	inputs, err := e.getShardInputs(ctx, shard)
+	if err != nil {
+		return err
+	}
+
+	for _, spec := range inputs {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		storageProvider, err := e.getStorageProvider(ctx, spec.Engine)
+		if err != nil {
+			return err
+		}
+		volume, err := storageProvider.PrepareStorage(ctx, spec)
+		if err != nil {
+			return err
+		}
+		e.prefetchedMu.Lock()
+		if e.prefetched[shard.ID()] == nil {
+			e.prefetched[shard.ID()] = map[string]prefetchedVolume{}
+		}
+		e.prefetched[shard.ID()][prefetchKey(spec)] = prefetchedVolume{
+			spec:   spec,
+			volume: volume,
+		}
+		e.prefetchedMu.Unlock()
+		log.Debug().Msgf("Prefetched input %s for shard %s", prefetchKey(spec), shard)
+	}
+
+	return nil
+}
+
+func (e *Executor) CleanupPrefetchedInputs(ctx context.Context, shard model.JobShard) error {
+	ctx, span := system.GetTracer().Start(ctx, "pkg/executor/docker.CleanupPrefetchedInputs")
+	defer span.End()
+
+	var firstErr error
+	for _, prefetched := range e.takePrefetchedVolumes(shard) {
+		storageProvider, err := e.getStorageProvider(ctx, prefetched.spec.Engine)
+		if err == nil {
+			err = storageProvider.CleanupStorage(ctx, prefetched.spec, prefetched.volume)
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// hand over ownership of anything prefetched for the shard
+func (e *Executor) takePrefetchedVolumes(shard model.JobShard) map[string]prefetchedVolume {
+	e.prefetchedMu.Lock()
+	defer e.prefetchedMu.Unlock()
+	volumes := e.prefetched[shard.ID()]
+	delete(e.prefetched, shard.ID())
+	return volumes
+}
@@ -0,0 +1,143 @@
+package docker
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/filecoin-project/bacalhau/pkg/system"
+	"github.com/rs/zerolog/log"
+)
+
+const egressProxyDialTimeout = 30 * time.Second
+
+// egressProxy is an HTTP and CONNECT proxy that only lets a job reach the
+// domains in its allowlist - containers on an allowlist network have no
+// route out so this is the only way they can talk to the outside world
+type egressProxy struct {
+	network  model.NetworkConfig
+	listener net.Listener
+	server   *http.Server
+	dialer   *net.Dialer
+}
+
+// start a proxy listening on the given host (use port 0 to pick any port)
+func newEgressProxy(address string, network model.NetworkConfig) (*egressProxy, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	proxy := &egressProxy{
+		network:  network,
+		listener: listener,
+		dialer: &net.Dialer{
+			Timeout: egressProxyDialTimeout,
+			// an allowed domain can still resolve to the node itself
+			// or something on its network
+			Control: system.RefuseNonPublicAddress,
+		},
+	}
+	proxy.server = &http.Server{
+		Handler:           proxy,
+		ReadHeaderTimeout: egressProxyDialTimeout,
+	}
+	go func() {
+		if serveErr := proxy.server.Serve(listener); serveErr != nil && serveErr != http.ErrServerClosed {
+			log.Warn().Msgf("Egress proxy stopped: %s", serveErr)
+		}
+	}()
+	return proxy, nil
+}
+
+// the URL containers should use as their HTTP(S)_PROXY
+func (proxy *egressProxy) URL() string {
+	return "http://" + proxy.listener.Addr().String()
+}
+
+func (proxy *egressProxy) Close(ctx context.Context) error {
+	return proxy.server.Shutdown(ctx)
+}
+
+func (proxy *egressProxy) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	host := req.Host
+	if req.Method != http.MethodConnect && req.URL.Host != "" {
+		host = req.URL.Host
+	}
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	if !proxy.network.AllowsDomain(hostname) {
+		log.Debug().Msgf("Egress proxy blocked request to %s", hostname)
+		http.Error(res, "domain is not in the job's network allowlist", http.StatusForbidden)
+		return
+	}
+
+	if req.Method == http.MethodConnect {
+		proxy.tunnel(res, req, host)
+	} else {
+		proxy.forward(res, req)
+	}
+}
+
+// pass an HTTPS connection straight through to the target
+func (proxy *egressProxy) tunnel(res http.ResponseWriter, req *http.Request, host string) {
+	hijacker, ok := res.(http.Hijacker)
+	if !ok {
+		http.Error(res, "proxy can't tunnel connections", http.StatusInternalServerError)
+		return
+	}
+	target, err := proxy.dialer.DialContext(req.Context(), "tcp", host)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer target.Close()
+
+	res.WriteHeader(http.StatusOK)
+	client, buffered, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	defer client.Close()
+
+	done := make(chan struct{}, 2) //nolint:gomnd
+	go func() {
+		// anything the client sent after the CONNECT line
+		_, _ = io.Copy(target, buffered)
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(client, target)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// send a plain HTTP request on and copy the response back
+func (proxy *egressProxy) forward(res http.ResponseWriter, req *http.Request) {
+	outReq := req.Clone(req.Context())
+	outReq.RequestURI = ""
+	outReq.Header.Del("Proxy-Connection")
+	outReq.Header.Del("Proxy-Authorization")
+
+	transport := &http.Transport{DialContext: proxy.dialer.DialContext}
+	defer transport.CloseIdleConnections()
+	outRes, err := transport.RoundTrip(outReq)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer outRes.Body.Close()
+
+	for key, values := range outRes.Header {
+		for _, value := range values {
+			res.Header().Add(key, value)
+		}
+	}
+	res.WriteHeader(outRes.StatusCode)
+	_, _ = io.Copy(res, outRes.Body)
+}
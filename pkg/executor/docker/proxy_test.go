@@ -0,0 +1,76 @@
+package docker
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEgressProxyAllowlist(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		_, _ = res.Write([]byte("hello"))
+	}))
+	defer target.Close()
+
+	proxy, err := newEgressProxy("127.0.0.1:0", model.NetworkConfig{
+		Type:    model.NetworkAllowlist,
+		Domains: []string{"127.0.0.1", "localhost", ".example.com"},
+	})
+	require.NoError(t, err)
+	defer proxy.Close(context.Background()) //nolint:errcheck
+
+	proxyURL, err := url.Parse(proxy.URL())
+	require.NoError(t, err)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	// the target is on the allowlist but is on the node itself, so the
+	// proxy refuses to connect to it - whether it is named by its address
+	// or by something that resolves to it
+	_, port, err := net.SplitHostPort(strings.TrimPrefix(target.URL, "http://"))
+	require.NoError(t, err)
+	for _, host := range []string{"127.0.0.1", "localhost"} {
+		res, err := client.Get("http://" + net.JoinHostPort(host, port) + "/")
+		require.NoError(t, err)
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		require.NoError(t, err)
+		require.Equal(t, http.StatusBadGateway, res.StatusCode, host)
+		require.Contains(t, string(body), "non-public address", host)
+		require.NotContains(t, string(body), "hello", host)
+
+		// and the same for an HTTPS tunnel
+		_, err = client.Get("https://" + net.JoinHostPort(host, port) + "/")
+		require.Error(t, err, host)
+	}
+
+	// a domain that isn't is refused without us trying to reach it
+	res, err := client.Get("http://not-allowed.test/")
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	// and so is an HTTPS tunnel to it
+	_, err = client.Get("https://not-allowed.test/")
+	require.Error(t, err)
+}
+
+func TestNetworkConfigAllowsDomain(t *testing.T) {
+	network := model.NetworkConfig{
+		Type:    model.NetworkAllowlist,
+		Domains: []string{"pypi.org", ".githubusercontent.com"},
+	}
+	require.True(t, network.AllowsDomain("pypi.org"))
+	require.True(t, network.AllowsDomain("PyPI.org."))
+	require.False(t, network.AllowsDomain("files.pypi.org"))
+	require.True(t, network.AllowsDomain("githubusercontent.com"))
+	require.True(t, network.AllowsDomain("raw.githubusercontent.com"))
+	require.False(t, network.AllowsDomain("evilgithubusercontent.com"))
+}
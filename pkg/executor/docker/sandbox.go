@@ -0,0 +1,167 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/strslice"
+	units "github.com/docker/go-units"
+)
+
+const (
+	// where containers with a read only root filesystem get somewhere to write
+	sandboxTmpfsPath = "/tmp"
+
+	// the nobody user, which owns nothing in the image
+	sandboxNobodyUser = "65534:65534"
+
+	// the seccomp value that turns filtering off
+	seccompUnconfined = "unconfined"
+)
+
+// SandboxConfig hardens the containers jobs run in.
+// The zero value leaves docker's defaults alone.
+type SandboxConfig struct {
+	// run jobs as this user ("uid" or "uid:gid") rather than the image's user
+	User string
+	// require the docker daemon to run containers in a user namespace
+	// so root in a container is not root on the host (dockerd --userns-remap)
+	UserNamespaces bool
+	// mount the container's root filesystem read only
+	ReadOnlyRootfs bool
+	// the size in bytes of the scratch tmpfs mounted at /tmp - a tmpfs is
+	// always mounted for a read only root filesystem, 0 means docker's default size
+	TmpfsSize uint64
+	// kernel capabilities to remove from and add to containers, e.g. ALL
+	CapDrop []string
+	CapAdd  []string
+	// a seccomp profile json file, or "unconfined" to turn seccomp off
+	SeccompProfile string
+	// an AppArmor profile already loaded on the host
+	AppArmorProfile string
+	// stop processes gaining privileges through setuid binaries
+	NoNewPrivileges bool
+	// the most processes a container can run - 0 means no limit
+	PidsLimit int64
+	// ulimits in the docker format, e.g. nofile=1024:2048
+	Ulimits []string
+	// the OCI runtime that runs containers, e.g. runsc for gVisor
+	Runtime string
+}
+
+// HardenedSandboxConfig is a locked down profile that most jobs still run under
+func HardenedSandboxConfig() SandboxConfig {
+	return SandboxConfig{
+		User:            sandboxNobodyUser,
+		ReadOnlyRootfs:  true,
+		TmpfsSize:       64 * uint64(units.MiB),
+		CapDrop:         []string{"ALL"},
+		NoNewPrivileges: true,
+		PidsLimit:       1024,
+		Ulimits:         []string{"nofile=4096:4096"},
+	}
+}
+
+// the sandbox config checked and turned into docker's settings
+type sandbox struct {
+	config      SandboxConfig
+	securityOpt []string
+	ulimits     []*units.Ulimit
+}
+
+func newSandbox(config SandboxConfig) (*sandbox, error) {
+	s := &sandbox{config: config}
+
+	if config.SeccompProfile != "" {
+		profile := seccompUnconfined
+		if config.SeccompProfile != seccompUnconfined {
+			// the docker API wants the profile itself rather than a path to it
+			contents, err := os.ReadFile(config.SeccompProfile)
+			if err != nil {
+				return nil, fmt.Errorf("could not read seccomp profile: %w", err)
+			}
+			if !json.Valid(contents) {
+				return nil, fmt.Errorf("seccomp profile %s is not valid json", config.SeccompProfile)
+			}
+			profile = string(contents)
+		}
+		s.securityOpt = append(s.securityOpt, "seccomp="+profile)
+	}
+	if config.AppArmorProfile != "" {
+		s.securityOpt = append(s.securityOpt, "apparmor="+config.AppArmorProfile)
+	}
+	if config.NoNewPrivileges {
+		s.securityOpt = append(s.securityOpt, "no-new-privileges")
+	}
+
+	if config.PidsLimit < 0 {
+		return nil, fmt.Errorf("pids limit must not be negative: %d", config.PidsLimit)
+	}
+	for _, value := range config.Ulimits {
+		ulimit, err := units.ParseUlimit(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ulimit %q: %w", value, err)
+		}
+		s.ulimits = append(s.ulimits, ulimit)
+	}
+
+	return s, nil
+}
+
+// a job can't write to its outputs as the node's user so we have to let anyone
+func (s *sandbox) sharedOutputs() bool {
+	return s.config.User != "" || s.config.UserNamespaces
+}
+
+// apply the sandbox to a job's container
+func (s *sandbox) apply(containerConfig *container.Config, hostConfig *container.HostConfig) {
+	s.applyIsolation(hostConfig)
+
+	if s.config.User != "" {
+		containerConfig.User = s.config.User
+	}
+	hostConfig.ReadonlyRootfs = s.config.ReadOnlyRootfs
+	if s.config.ReadOnlyRootfs || s.config.TmpfsSize > 0 {
+		// anyone can write, like the /tmp the image would have had
+		options := []string{"rw", "nosuid", "nodev", "mode=1777"}
+		if s.config.TmpfsSize > 0 {
+			options = append(options, fmt.Sprintf("size=%d", s.config.TmpfsSize))
+		}
+		hostConfig.Tmpfs = map[string]string{sandboxTmpfsPath: strings.Join(options, ",")}
+	}
+	hostConfig.CapDrop = strslice.StrSlice(s.config.CapDrop)
+	hostConfig.CapAdd = strslice.StrSlice(s.config.CapAdd)
+}
+
+// apply the parts of the sandbox that don't stop a container installing
+// software, which is what we use build containers for
+func (s *sandbox) applyIsolation(hostConfig *container.HostConfig) {
+	hostConfig.Runtime = s.config.Runtime
+	hostConfig.SecurityOpt = s.securityOpt
+	if s.config.PidsLimit > 0 {
+		pidsLimit := s.config.PidsLimit
+		hostConfig.Resources.PidsLimit = &pidsLimit
+	}
+	hostConfig.Resources.Ulimits = s.ulimits
+}
+
+// check the docker daemon can give us the user namespaces we were asked for
+func (e *Executor) checkUserNamespaces(ctx context.Context) error {
+	if !e.sandbox.config.UserNamespaces {
+		return nil
+	}
+	info, err := e.Client.Info(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting docker info: %w", err)
+	}
+	for _, option := range info.SecurityOptions {
+		if strings.Contains(option, "name=userns") {
+			return nil
+		}
+	}
+	return fmt.Errorf("user namespaces are required but the docker daemon is not running with --userns-remap")
+}
@@ -0,0 +1,81 @@
+package docker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSandboxDefaultsLeaveDockerAlone(t *testing.T) {
+	s, err := newSandbox(SandboxConfig{})
+	require.NoError(t, err)
+
+	containerConfig := &container.Config{}
+	hostConfig := &container.HostConfig{}
+	s.apply(containerConfig, hostConfig)
+
+	require.Equal(t, &container.Config{}, containerConfig)
+	require.Equal(t, &container.HostConfig{}, hostConfig)
+	require.False(t, s.sharedOutputs())
+}
+
+func TestSandboxHardened(t *testing.T) {
+	config := HardenedSandboxConfig()
+	config.Runtime = "runsc"
+	config.AppArmorProfile = "bacalhau-job"
+	s, err := newSandbox(config)
+	require.NoError(t, err)
+
+	containerConfig := &container.Config{User: "root"}
+	hostConfig := &container.HostConfig{}
+	s.apply(containerConfig, hostConfig)
+
+	require.Equal(t, sandboxNobodyUser, containerConfig.User)
+	require.True(t, hostConfig.ReadonlyRootfs)
+	require.Equal(t, "rw,nosuid,nodev,mode=1777,size=67108864", hostConfig.Tmpfs["/tmp"])
+	require.Equal(t, []string{"ALL"}, []string(hostConfig.CapDrop))
+	require.Equal(t, []string{"apparmor=bacalhau-job", "no-new-privileges"}, hostConfig.SecurityOpt)
+	require.Equal(t, int64(1024), *hostConfig.Resources.PidsLimit)
+	require.Len(t, hostConfig.Resources.Ulimits, 1)
+	require.Equal(t, "nofile", hostConfig.Resources.Ulimits[0].Name)
+	require.Equal(t, int64(4096), hostConfig.Resources.Ulimits[0].Hard)
+	require.Equal(t, "runsc", hostConfig.Runtime)
+	require.True(t, s.sharedOutputs())
+
+	// builds keep root and a writable filesystem so they can install things
+	buildConfig := &container.HostConfig{}
+	s.applyIsolation(buildConfig)
+	require.False(t, buildConfig.ReadonlyRootfs)
+	require.Empty(t, buildConfig.CapDrop)
+	require.Equal(t, "runsc", buildConfig.Runtime)
+}
+
+func TestSandboxSeccompProfile(t *testing.T) {
+	s, err := newSandbox(SandboxConfig{SeccompProfile: "unconfined"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"seccomp=unconfined"}, s.securityOpt)
+
+	profile := filepath.Join(t.TempDir(), "seccomp.json")
+	require.NoError(t, os.WriteFile(profile, []byte(`{"defaultAction":"SCMP_ACT_ERRNO"}`), 0644))
+	s, err = newSandbox(SandboxConfig{SeccompProfile: profile})
+	require.NoError(t, err)
+	require.Equal(t, []string{`seccomp={"defaultAction":"SCMP_ACT_ERRNO"}`}, s.securityOpt)
+
+	require.NoError(t, os.WriteFile(profile, []byte(`not json`), 0644))
+	_, err = newSandbox(SandboxConfig{SeccompProfile: profile})
+	require.Error(t, err)
+
+	_, err = newSandbox(SandboxConfig{SeccompProfile: filepath.Join(t.TempDir(), "missing.json")})
+	require.Error(t, err)
+}
+
+func TestSandboxInvalidLimits(t *testing.T) {
+	_, err := newSandbox(SandboxConfig{Ulimits: []string{"nofile"}})
+	require.Error(t, err)
+
+	_, err = newSandbox(SandboxConfig{PidsLimit: -1})
+	require.Error(t, err)
+}
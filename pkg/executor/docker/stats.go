@@ -0,0 +1,79 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/rs/zerolog/log"
+)
+
+// follows docker stats for a container while it runs and keeps the
+// highest values seen - the counters read as zero once the container
+// has stopped so we can't just take the last sample
+type statsSampler struct {
+	usage  model.ShardResourceUsage
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func (e *Executor) sampleContainerStats(ctx context.Context, containerID string) *statsSampler {
+	ctx, cancel := context.WithCancel(ctx)
+	sampler := &statsSampler{
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go func() {
+		defer close(sampler.done)
+		stats, err := e.Client.ContainerStats(ctx, containerID, true)
+		if err != nil {
+			log.Debug().Msgf("Could not get stats for container %s: %s", containerID, err)
+			return
+		}
+		defer stats.Body.Close()
+		decoder := json.NewDecoder(stats.Body)
+		for {
+			var sample dockertypes.StatsJSON
+			if err := decoder.Decode(&sample); err != nil {
+				return
+			}
+			sampler.record(sample)
+		}
+	}()
+	return sampler
+}
+
+func (sampler *statsSampler) record(sample dockertypes.StatsJSON) {
+	cpuSeconds := float64(sample.CPUStats.CPUUsage.TotalUsage) / NanoCPUCoefficient
+	if cpuSeconds > sampler.usage.CPUSeconds {
+		sampler.usage.CPUSeconds = cpuSeconds
+	}
+
+	// max usage is only reported on cgroups v1
+	memory := sample.MemoryStats.Usage
+	if sample.MemoryStats.MaxUsage > memory {
+		memory = sample.MemoryStats.MaxUsage
+	}
+	if memory > sampler.usage.MemoryPeak {
+		sampler.usage.MemoryPeak = memory
+	}
+
+	var written uint64
+	for _, entry := range sample.BlkioStats.IoServiceBytesRecursive {
+		if strings.EqualFold(entry.Op, "write") {
+			written += entry.Value
+		}
+	}
+	if written > sampler.usage.DiskWritten {
+		sampler.usage.DiskWritten = written
+	}
+}
+
+// stop sampling and return what we saw
+func (sampler *statsSampler) stop() model.ShardResourceUsage {
+	sampler.cancel()
+	<-sampler.done
+	return sampler.usage
+}
@@ -1,34 +1,45 @@
 package language
 
 /*
-The language executor wraps either the python_wasm executor or the generic
-docker executor, depending on whether determinism is required.
+The language executor maps a language and version onto either a wasm executor
+that can run it deterministically or a curated image for the docker executor,
+depending on whether determinism is required.
 */
 
 import (
 	"context"
 	"fmt"
+	"path"
 
 	"github.com/rs/zerolog/log"
 
 	"github.com/filecoin-project/bacalhau/pkg/executor"
 	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/filecoin-project/bacalhau/pkg/storage"
 	"github.com/filecoin-project/bacalhau/pkg/system"
 )
 
+// where the requester mounts the uploaded context (code, requirements)
+const contextPath = "/job"
+
 type Executor struct {
 	Jobs []*model.Job
 
 	executors map[model.EngineType]executor.Executor
+
+	// used to read requirements files out of uploaded contexts
+	storageProviders map[model.StorageSourceType]storage.StorageProvider
 }
 
 func NewExecutor(
 	ctx context.Context,
 	cm *system.CleanupManager,
 	executors map[model.EngineType]executor.Executor,
+	storageProviders map[model.StorageSourceType]storage.StorageProvider,
 ) (*Executor, error) {
 	e := &Executor{
-		executors: executors,
+		executors:        executors,
+		storageProviders: storageProviders,
 	}
 	return e, nil
 }
@@ -50,20 +61,71 @@ func (e *Executor) RunShard(
 	shard model.JobShard,
 	jobResultsDir string,
 ) error {
-	if shard.Job.Spec.Language.Language != "python" && shard.Job.Spec.Language.LanguageVersion != "3.10" {
-		return fmt.Errorf("only python 3.10 is supported")
+	runtime, err := ResolveRuntime(shard.Job.Spec.Language)
+	if err != nil {
+		return err
+	}
+
+	var requirements []byte
+	if shard.Job.Spec.Language.RequirementsPath != "" {
+		requirements, err = e.readRequirements(ctx, shard)
+		if err != nil {
+			return fmt.Errorf("could not read requirements: %w", err)
+		}
 	}
 
+	engine := model.EngineDocker
 	if shard.Job.Spec.Language.Deterministic {
-		log.Debug().Msgf("running deterministic python 3.10")
-		// Instantiate a python_wasm
-		// TODO: mutate job as needed?
-		return e.executors[model.EnginePythonWasm].RunShard(ctx, shard, jobResultsDir)
+		log.Debug().Msgf("running deterministic %s %s", runtime.Language, runtime.Version)
+		engine = runtime.DeterministicEngine
+		if requirements != nil {
+			err = checkPyodideRequirements(requirements, jobResultsDir)
+			if err != nil {
+				return err
+			}
+		}
 	} else {
-		log.Debug().Msgf("running arbitrary python 3.10")
-		// TODO: Instantiate a docker with python:3.10 image
-		return fmt.Errorf("arbitrary python not supported yet")
+		log.Debug().Msgf("running arbitrary %s %s in %s", runtime.Language, runtime.Version, runtime.Image)
+		shard, err = dockerShard(shard, runtime)
+		if err != nil {
+			return err
+		}
+		if requirements != nil {
+			shard.Job.Spec.Docker.Image, err = e.installRequirements(ctx, shard, runtime, requirements, jobResultsDir)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	ex, ok := e.executors[engine]
+	if !ok {
+		return fmt.Errorf("no %s executor to run %s %s", engine, runtime.Language, runtime.Version)
+	}
+	return ex.RunShard(ctx, shard, jobResultsDir)
+}
+
+// turn a language shard into a docker shard that runs the program
+// in the runtime's image with the uploaded context as the working dir
+func dockerShard(shard model.JobShard, runtime Runtime) (model.JobShard, error) {
+	spec := shard.Job.Spec.Language
+	var entrypoint []string
+	switch {
+	case spec.Command != "":
+		entrypoint = append(append([]string{}, runtime.InlineCommand...), spec.Command)
+	case spec.ProgramPath != "":
+		entrypoint = append(append([]string{}, runtime.FileCommand...), path.Join(contextPath, spec.ProgramPath))
+	default:
+		return shard, fmt.Errorf("must specify an inline command or a path to a %s program", runtime.Language)
+	}
+
+	shard.Job.Spec.Engine = model.EngineDocker
+	shard.Job.Spec.Docker.Image = runtime.Image
+	shard.Job.Spec.Docker.Entrypoint = entrypoint
+	if len(shard.Job.Spec.Contexts) > 0 {
+		shard.Job.Spec.Docker.WorkingDir = contextPath
 	}
+	return shard, nil
 }
 
 // Compile-time check that Executor implements the Executor interface.
@@ -0,0 +1,343 @@
+package language
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/filecoin-project/bacalhau/pkg/executor"
+	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/filecoin-project/bacalhau/pkg/storage/util"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// the output of installing requirements, kept apart from the program's output
+	installStdoutFilename = "installStdout"
+	installStderrFilename = "installStderr"
+
+	// the images we build with a job's requirements installed
+	// are tagged with a hash of the base image and the requirements
+	requirementsImageRepository = "bacalhau-requirements"
+
+	// where the requirements file is copied to while it is installed
+	requirementsBuildPath = "/tmp/requirements.txt"
+)
+
+// the hosts pip needs to reach to install from PyPI
+var PyPIDomains = []string{"pypi.org", "files.pythonhosted.org"}
+
+// the packages pyodide was built with and their versions - a deterministic
+// job can't reach the network so these are the only ones it can use
+var pyodideBundledPackages = map[string]string{
+	"attrs": "21.4.0", "beautifulsoup4": "4.11.1", "biopython": "1.79",
+	"cycler": "0.11.0", "fonttools": "4.33.3", "html5lib": "1.1",
+	"jedi": "0.18.1", "jinja2": "3.1.2", "joblib": "1.1.0",
+	"kiwisolver": "1.4.3", "lxml": "4.9.0", "markupsafe": "2.1.1",
+	"matplotlib": "3.5.2", "micropip": "0.1", "mpmath": "1.2.1",
+	"networkx": "2.8.4", "nltk": "3.7", "numpy": "1.22.4",
+	"packaging": "21.3", "pandas": "1.4.2", "parso": "0.8.3",
+	"patsy": "0.5.2", "pillow": "9.1.1", "pyparsing": "3.0.9",
+	"python-dateutil": "2.8.2", "pytz": "2022.1", "pyyaml": "6.0",
+	"regex": "2022.6.2", "scikit-learn": "1.1.1", "scipy": "1.8.1",
+	"setuptools": "62.6.0", "six": "1.16.0", "soupsieve": "2.3.2.post1",
+	"statsmodels": "0.13.2", "sympy": "1.10.1", "threadpoolctl": "3.1.0",
+	"webencodings": "0.5.1",
+}
+
+// InstallNetwork is the network access installing a job's requirements
+// needs - the program itself only gets the network its spec asks for,
+// so compute nodes check both against what they allow
+func InstallNetwork(spec model.JobSpec) model.NetworkConfig {
+	if spec.Engine != model.EngineLanguage || spec.Language.RequirementsPath == "" || spec.Language.Deterministic {
+		return model.NetworkConfig{}
+	}
+	return pypiNetwork()
+}
+
+func pypiNetwork() model.NetworkConfig {
+	return model.NetworkConfig{
+		Type:    model.NetworkAllowlist,
+		Domains: PyPIDomains,
+	}
+}
+
+// read the requirements file out of the job's uploaded context
+func (e *Executor) readRequirements(ctx context.Context, shard model.JobShard) ([]byte, error) {
+	requirementsPath := shard.Job.Spec.Language.RequirementsPath
+	var contextSpec *model.StorageSpec
+	for i := range shard.Job.Spec.Contexts {
+		if shard.Job.Spec.Contexts[i].Path == contextPath {
+			contextSpec = &shard.Job.Spec.Contexts[i]
+		}
+	}
+	if contextSpec == nil {
+		return nil, fmt.Errorf("job has a requirements file %s but no context was uploaded", requirementsPath)
+	}
+
+	provider, err := util.GetStorageProvider(ctx, contextSpec.Engine, e.storageProviders)
+	if err != nil {
+		return nil, err
+	}
+	volume, err := provider.PrepareStorage(ctx, *contextSpec)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cleanupErr := provider.CleanupStorage(ctx, *contextSpec, volume); cleanupErr != nil {
+			log.Debug().Msgf("Could not clean up job context: %s", cleanupErr)
+		}
+	}()
+
+	// don't let the path escape the context
+	cleanPath := filepath.Join("/", requirementsPath)
+	return os.ReadFile(filepath.Join(volume.Source, cleanPath))
+}
+
+// install the requirements into an image based on the runtime's image,
+// reusing the image if we have installed the same requirements before
+func (e *Executor) installRequirements(
+	ctx context.Context,
+	shard model.JobShard,
+	runtime Runtime,
+	requirements []byte,
+	jobResultsDir string,
+) (string, error) {
+	if runtime.Language != "python" {
+		return "", fmt.Errorf("requirements files are only supported for python, not %s", runtime.Language)
+	}
+	builder, ok := e.executors[model.EngineDocker].(executor.ImageBuilder)
+	if !ok {
+		return "", fmt.Errorf("the docker executor can't install requirements")
+	}
+
+	hash := sha256.New()
+	hash.Write([]byte(runtime.Image + "\n"))
+	hash.Write(requirements)
+	image := fmt.Sprintf("%s:%s", requirementsImageRepository, hex.EncodeToString(hash.Sum(nil)))
+
+	var stdout, stderr bytes.Buffer
+	cached, err := builder.HasImage(ctx, image)
+	if err != nil {
+		return "", err
+	}
+	if cached {
+		log.Debug().Msgf("Using cached requirements image %s", image)
+		fmt.Fprintf(&stdout, "Requirements already installed in %s\n", image)
+	} else {
+		err = builder.BuildImage(ctx, executor.BuildImageRequest{
+			BaseImage: runtime.Image,
+			Image:     image,
+			Files:     map[string][]byte{requirementsBuildPath: requirements},
+			Command:   []string{"pip", "install", "--no-cache-dir", "--disable-pip-version-check", "-r", requirementsBuildPath},
+			// only the install can reach PyPI, not the program
+			Network: pypiNetwork(),
+			Stdout:  &stdout,
+			Stderr:  &stderr,
+		})
+	}
+
+	writeErr := writeInstallLogs(jobResultsDir, stdout.Bytes(), stderr.Bytes())
+	if err != nil {
+		return "", fmt.Errorf("failed to install requirements: %w", err)
+	}
+	return image, writeErr
+}
+
+// check a deterministic job only needs packages that come with pyodide,
+// at versions its requirements allow
+func checkPyodideRequirements(requirements []byte, jobResultsDir string) error {
+	missing := []string{}
+	mismatched := []string{}
+	var stdout bytes.Buffer
+	for _, req := range parseRequirements(requirements) {
+		version, ok := pyodideBundledPackages[req.name]
+		switch {
+		case !ok:
+			missing = append(missing, req.name)
+		case !versionAllowed(version, req.specifier):
+			mismatched = append(mismatched, fmt.Sprintf("%s%s (pyodide has %s)", req.name, req.specifier, version))
+		default:
+			fmt.Fprintf(&stdout, "Using %s %s bundled with pyodide\n", req.name, version)
+		}
+	}
+	err := writeInstallLogs(jobResultsDir, stdout.Bytes(), nil)
+	if err != nil {
+		return err
+	}
+	problems := []string{}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		problems = append(problems, "these are not: "+strings.Join(missing, ", "))
+	}
+	if len(mismatched) > 0 {
+		sort.Strings(mismatched)
+		problems = append(problems, "these are at other versions: "+strings.Join(mismatched, ", "))
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("deterministic jobs can only use packages bundled with pyodide, %s",
+			strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// a line of a requirements file
+type requirement struct {
+	// the normalised package name
+	name string
+	// the version specifier with spaces removed, e.g. ">=1.4,<2" - a direct
+	// reference starts with @
+	specifier string
+}
+
+// the packages in a requirements file
+func parseRequirements(requirements []byte) []requirement {
+	reqs := []requirement{}
+	scanner := bufio.NewScanner(bytes.NewReader(requirements))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		// environment markers
+		if i := strings.Index(line, ";"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		// skip blank lines and pip options like -r or --index-url
+		if line == "" || strings.HasPrefix(line, "-") {
+			continue
+		}
+		name, specifier := line, ""
+		if i := strings.IndexAny(line, "=<>!~[ @("); i >= 0 {
+			name, specifier = line[:i], line[i:]
+		}
+		// extras don't change which package it is
+		if strings.HasPrefix(strings.TrimSpace(specifier), "[") {
+			if i := strings.Index(specifier, "]"); i >= 0 {
+				specifier = specifier[i+1:]
+			}
+		}
+		specifier = strings.Trim(strings.Join(strings.Fields(specifier), ""), "()")
+		reqs = append(reqs, requirement{
+			name:      strings.ToLower(strings.NewReplacer("_", "-", ".", "-").Replace(name)),
+			specifier: specifier,
+		})
+	}
+	return reqs
+}
+
+// whether version satisfies every clause of a specifier like ">=1.4,<2"
+// versions are compared part by part, which is enough for the release
+// versions pyodide bundles - anything we don't understand isn't allowed
+func versionAllowed(version, specifier string) bool {
+	if specifier == "" {
+		return true
+	}
+	for _, clause := range strings.Split(specifier, ",") {
+		op, want := splitVersionClause(clause)
+		if want == "" {
+			return false
+		}
+		var ok bool
+		switch op {
+		case "===":
+			ok = version == want
+		case "==", "!=":
+			ok = versionMatches(version, want)
+			if op == "!=" {
+				ok = !ok
+			}
+		case "~=":
+			// at least want but with the same release apart from its last part
+			parts := strings.Split(want, ".")
+			ok = len(parts) > 1 && compareVersions(version, want) >= 0 &&
+				versionMatches(version, strings.Join(parts[:len(parts)-1], ".")+".*")
+		case ">=":
+			ok = compareVersions(version, want) >= 0
+		case "<=":
+			ok = compareVersions(version, want) <= 0
+		case ">":
+			ok = compareVersions(version, want) > 0
+		case "<":
+			ok = compareVersions(version, want) < 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func splitVersionClause(clause string) (string, string) {
+	for _, op := range []string{"===", "==", "!=", "~=", ">=", "<=", ">", "<"} {
+		if strings.HasPrefix(clause, op) {
+			return op, strings.TrimPrefix(clause, op)
+		}
+	}
+	return "", ""
+}
+
+// == matching, where want can end in .* to match any version it starts
+func versionMatches(version, want string) bool {
+	if strings.HasSuffix(want, ".*") {
+		prefix := strings.Split(strings.TrimSuffix(want, ".*"), ".")
+		parts := strings.Split(version, ".")
+		if len(parts) < len(prefix) {
+			return false
+		}
+		return compareVersions(strings.Join(parts[:len(prefix)], "."), strings.Join(prefix, ".")) == 0
+	}
+	return compareVersions(version, want) == 0
+}
+
+// compare dotted versions, padding the shorter one with zeros - numeric
+// parts are compared as numbers and anything else as text
+func compareVersions(a, b string) int {
+	aParts := strings.Split(a, ".")
+	bParts := strings.Split(b, ".")
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		aPart, bPart := "0", "0"
+		if i < len(aParts) {
+			aPart = aParts[i]
+		}
+		if i < len(bParts) {
+			bPart = bParts[i]
+		}
+		aNum, aErr := strconv.Atoi(aPart)
+		bNum, bErr := strconv.Atoi(bPart)
+		switch {
+		case aErr == nil && bErr == nil:
+			if aNum != bNum {
+				if aNum < bNum {
+					return -1
+				}
+				return 1
+			}
+		case aPart != bPart:
+			return strings.Compare(aPart, bPart)
+		}
+	}
+	return 0
+}
+
+func writeInstallLogs(jobResultsDir string, stdout, stderr []byte) error {
+	for name, contents := range map[string][]byte{
+		installStdoutFilename: stdout,
+		installStderrFilename: stderr,
+	} {
+		err := os.WriteFile(filepath.Join(jobResultsDir, name), contents, util.OS_ALL_R|util.OS_USER_RW)
+		if err != nil {
+			return fmt.Errorf("could not write results to %s: %w", name, err)
+		}
+	}
+	return nil
+}
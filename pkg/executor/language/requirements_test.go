@@ -0,0 +1,61 @@
+package language
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRequirements(t *testing.T) {
+	requirements := []byte(`
+# data things
+numpy==1.22.4
+Pandas >= 1.4, < 2 ; python_version > "3.8"
+scikit_learn[alldeps]~=1.1
+-r other-requirements.txt
+--index-url https://example.com/simple
+requests @ https://example.com/requests.whl
+`)
+	require.Equal(t, []requirement{
+		{name: "numpy", specifier: "==1.22.4"},
+		{name: "pandas", specifier: ">=1.4,<2"},
+		{name: "scikit-learn", specifier: "~=1.1"},
+		{name: "requests", specifier: "@https://example.com/requests.whl"},
+	}, parseRequirements(requirements))
+}
+
+func TestVersionAllowed(t *testing.T) {
+	for _, specifier := range []string{
+		"", "==1.22.4", "==1.22.*", "===1.22.4", ">=1.22", "<=1.22.4", ">1.9", "<2",
+		"!=1.21.0", "~=1.22.0", "~=1.20", ">=1.20,<1.23",
+	} {
+		require.True(t, versionAllowed("1.22.4", specifier), specifier)
+	}
+	for _, specifier := range []string{
+		"==1.22.5", "==1.23.*", "===1.22.4.0", ">1.22.4", "<1.22", "!=1.22.4",
+		"~=1.21.0", "~=2", ">=1.20,<1.22", "@https://example.com/numpy.whl", "1.22",
+	} {
+		require.False(t, versionAllowed("1.22.4", specifier), specifier)
+	}
+	require.True(t, versionAllowed("2.3.2.post1", ">=2.3.2"))
+}
+
+func TestCheckPyodideRequirements(t *testing.T) {
+	resultsDir := t.TempDir()
+	require.NoError(t, checkPyodideRequirements([]byte("numpy\npandas==1.4.2\n"), resultsDir))
+	stdout, err := os.ReadFile(filepath.Join(resultsDir, installStdoutFilename))
+	require.NoError(t, err)
+	require.Contains(t, string(stdout), "Using numpy 1.22.4 bundled with pyodide")
+
+	err = checkPyodideRequirements([]byte("numpy\ntorch\nrequests\n"), t.TempDir())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "these are not: requests, torch")
+
+	// a pin the bundled version doesn't satisfy can't be used either
+	err = checkPyodideRequirements([]byte("numpy==1.23.0\npandas>=1.4\n"), t.TempDir())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "these are at other versions: numpy==1.23.0 (pyodide has 1.22.4)")
+	require.NotContains(t, err.Error(), "pandas")
+}
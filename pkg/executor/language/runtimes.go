@@ -0,0 +1,169 @@
+package language
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/filecoin-project/bacalhau/pkg/model"
+)
+
+// Runtime is an interpreter the language executor knows how to run
+// and the docker image it runs in
+type Runtime struct {
+	Language string
+	Version  string
+	// the image the program is run in when it doesn't have to be deterministic
+	Image string
+	// the command that runs a program given as a string, e.g. python -c
+	InlineCommand []string
+	// the command that runs a program file
+	FileCommand []string
+	// the engine that can run this deterministically
+	// the zero value means we can't
+	DeterministicEngine model.EngineType
+}
+
+// the curated interpreters we support, by language then version
+var runtimes = map[string]map[string]Runtime{
+	"python": {
+		"3.8": pythonRuntime("3.8"),
+		"3.9": pythonRuntime("3.9"),
+		// pyodide is built on python 3.10
+		"3.10": withDeterministicEngine(pythonRuntime("3.10"), model.EnginePythonWasm),
+		"3.11": pythonRuntime("3.11"),
+	},
+	"r": {
+		"4.1": rRuntime("4.1.3"),
+		"4.2": rRuntime("4.2.2"),
+	},
+	"node": {
+		"16": nodeRuntime("16"),
+		"18": nodeRuntime("18"),
+	},
+}
+
+// the version used when a job doesn't ask for one
+var defaultVersions = map[string]string{
+	"python": "3.10",
+	"r":      "4.2",
+	"node":   "18",
+}
+
+// other names people use for the languages
+var languageAliases = map[string]string{
+	"python3": "python",
+	"nodejs":  "node",
+	"js":      "node",
+}
+
+func pythonRuntime(version string) Runtime {
+	return Runtime{
+		Language:      "python",
+		Version:       version,
+		Image:         fmt.Sprintf("python:%s-slim", version),
+		InlineCommand: []string{"python", "-c"},
+		FileCommand:   []string{"python"},
+	}
+}
+
+func withDeterministicEngine(runtime Runtime, engine model.EngineType) Runtime {
+	runtime.DeterministicEngine = engine
+	return runtime
+}
+
+func rRuntime(version string) Runtime {
+	return Runtime{
+		Language:      "r",
+		Version:       version[:strings.LastIndex(version, ".")],
+		Image:         fmt.Sprintf("r-base:%s", version),
+		InlineCommand: []string{"Rscript", "-e"},
+		FileCommand:   []string{"Rscript"},
+	}
+}
+
+func nodeRuntime(version string) Runtime {
+	return Runtime{
+		Language:      "node",
+		Version:       version,
+		Image:         fmt.Sprintf("node:%s-slim", version),
+		InlineCommand: []string{"node", "-e"},
+		FileCommand:   []string{"node"},
+	}
+}
+
+// CanRunDeterministically is true if there is a wasm engine for this runtime
+func (r Runtime) CanRunDeterministically() bool {
+	return model.IsValidEngineType(r.DeterministicEngine)
+}
+
+// ResolveRuntime works out which runtime a language job needs
+// or explains why we can't run it
+func ResolveRuntime(spec model.JobSpecLanguage) (Runtime, error) {
+	language := strings.ToLower(strings.TrimSpace(spec.Language))
+	if alias, ok := languageAliases[language]; ok {
+		language = alias
+	}
+	versions, ok := runtimes[language]
+	if !ok {
+		return Runtime{}, fmt.Errorf("language %q is not supported, use one of: %s",
+			spec.Language, strings.Join(SupportedLanguages(), ", "))
+	}
+
+	version := strings.TrimSpace(spec.LanguageVersion)
+	if version == "" {
+		version = defaultVersions[language]
+	}
+	runtime, ok := versions[version]
+	if !ok {
+		return Runtime{}, fmt.Errorf("%s version %q is not supported, use one of: %s",
+			language, version, strings.Join(SupportedVersions(language), ", "))
+	}
+
+	if spec.Deterministic && !runtime.CanRunDeterministically() {
+		deterministic := []string{}
+		for _, lang := range SupportedLanguages() {
+			for _, v := range SupportedVersions(lang) {
+				if runtimes[lang][v].CanRunDeterministically() {
+					deterministic = append(deterministic, lang+" "+v)
+				}
+			}
+		}
+		return Runtime{}, fmt.Errorf("%s %s can't be run deterministically, only %s can - run it without determinism instead",
+			language, version, strings.Join(deterministic, ", "))
+	}
+
+	return runtime, nil
+}
+
+// SupportedLanguages lists the languages the executor can run
+func SupportedLanguages() []string {
+	languages := []string{}
+	for language := range runtimes {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// SupportedVersions lists the versions of a language the executor can run
+func SupportedVersions(language string) []string {
+	versions := []string{}
+	for version := range runtimes[language] {
+		versions = append(versions, version)
+	}
+	// compare each part as a number so 3.9 comes before 3.10
+	sort.Slice(versions, func(i, j int) bool {
+		a, b := strings.Split(versions[i], "."), strings.Split(versions[j], ".")
+		for k := 0; k < len(a) && k < len(b); k++ {
+			x, _ := strconv.Atoi(a[k])
+			y, _ := strconv.Atoi(b[k])
+			if x != y {
+				return x < y
+			}
+		}
+		return len(a) < len(b)
+	})
+	return versions
+}
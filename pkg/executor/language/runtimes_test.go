@@ -0,0 +1,60 @@
+package language
+
+import (
+	"testing"
+
+	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveRuntime(t *testing.T) {
+	testCases := []struct {
+		name          string
+		spec          model.JobSpecLanguage
+		expectedImage string
+		expectedError string
+	}{
+		{"deterministic python 3.10", model.JobSpecLanguage{Language: "python", LanguageVersion: "3.10", Deterministic: true}, "python:3.10-slim", ""},
+		{"python 3.8", model.JobSpecLanguage{Language: "python", LanguageVersion: "3.8"}, "python:3.8-slim", ""},
+		{"default version", model.JobSpecLanguage{Language: "Python3"}, "python:3.10-slim", ""},
+		{"r", model.JobSpecLanguage{Language: "r", LanguageVersion: "4.2"}, "r-base:4.2.2", ""},
+		{"nodejs alias", model.JobSpecLanguage{Language: "nodejs", LanguageVersion: "16"}, "node:16-slim", ""},
+		{"unknown language", model.JobSpecLanguage{Language: "cobol"}, "", "language \"cobol\" is not supported, use one of: node, python, r"},
+		{"unknown version", model.JobSpecLanguage{Language: "python", LanguageVersion: "2.7"}, "", "use one of: 3.8, 3.9, 3.10, 3.11"},
+		{"deterministic needs wasm", model.JobSpecLanguage{Language: "python", LanguageVersion: "3.11", Deterministic: true}, "", "only python 3.10 can"},
+		{"deterministic r", model.JobSpecLanguage{Language: "r", Deterministic: true}, "", "r 4.2 can't be run deterministically"},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			runtime, err := ResolveRuntime(test.spec)
+			if test.expectedError != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), test.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.expectedImage, runtime.Image)
+		})
+	}
+}
+
+func TestDockerShard(t *testing.T) {
+	runtime, err := ResolveRuntime(model.JobSpecLanguage{Language: "python", LanguageVersion: "3.9"})
+	require.NoError(t, err)
+
+	shard := model.JobShard{Job: model.Job{Spec: model.JobSpec{
+		Engine:   model.EngineLanguage,
+		Language: model.JobSpecLanguage{ProgramPath: "main.py"},
+		Contexts: []model.StorageSpec{{Engine: model.StorageSourceIPFS, Cid: "QmContext", Path: "/job"}},
+	}}}
+	shard, err = dockerShard(shard, runtime)
+	require.NoError(t, err)
+	require.Equal(t, model.EngineDocker, shard.Job.Spec.Engine)
+	require.Equal(t, "python:3.9-slim", shard.Job.Spec.Docker.Image)
+	require.Equal(t, []string{"python", "/job/main.py"}, shard.Job.Spec.Docker.Entrypoint)
+	require.Equal(t, "/job", shard.Job.Spec.Docker.WorkingDir)
+
+	_, err = dockerShard(model.JobShard{}, runtime)
+	require.Error(t, err)
+}
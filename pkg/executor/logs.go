@@ -0,0 +1,101 @@
+package executor
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/filecoin-project/bacalhau/pkg/storage/util"
+	"github.com/filecoin-project/bacalhau/pkg/system"
+)
+
+type maxLogSizeContextKey struct{}
+
+// ContextWithMaxLogSize records how much of each of stdout and stderr
+// the executor keeps in the results of the shard.
+func ContextWithMaxLogSize(ctx context.Context, maxSize int64) context.Context {
+	return context.WithValue(ctx, maxLogSizeContextKey{}, maxSize)
+}
+
+// MaxLogSizeFromContext returns how much of each of stdout and stderr
+// to keep - the default limit if nothing was set.
+func MaxLogSizeFromContext(ctx context.Context) int64 {
+	maxSize, _ := ctx.Value(maxLogSizeContextKey{}).(int64)
+	if maxSize <= 0 {
+		return system.DefaultMaxLogSize
+	}
+	return maxSize
+}
+
+// ShardLogs writes the output of a shard straight to the stdout and stderr
+// files in its results folder as it is produced, up to the size limit, and
+// copies all of it to anyone following the shard.
+type ShardLogs struct {
+	Stdout io.Writer
+	Stderr io.Writer
+
+	resultsDir string
+	files      []*os.File
+	limits     []*system.LimitedWriter
+}
+
+func NewShardLogs(ctx context.Context, resultsDir string) (*ShardLogs, error) {
+	logs := &ShardLogs{resultsDir: resultsDir}
+	liveStdout, liveStderr := LogWritersFromContext(ctx)
+	maxSize := MaxLogSizeFromContext(ctx)
+	for _, stream := range []struct {
+		name   string
+		live   io.Writer
+		writer *io.Writer
+	}{
+		{name: model.LogStreamStdout, live: liveStdout, writer: &logs.Stdout},
+		{name: model.LogStreamStderr, live: liveStderr, writer: &logs.Stderr},
+	} {
+		file, err := os.OpenFile(
+			filepath.Join(resultsDir, stream.name),
+			os.O_WRONLY|os.O_CREATE|os.O_TRUNC,
+			util.OS_ALL_R|util.OS_USER_RW,
+		)
+		if err != nil {
+			_ = logs.Close()
+			return nil, err
+		}
+		limited := system.NewLimitedWriter(file, stream.name, maxSize)
+		logs.files = append(logs.files, file)
+		logs.limits = append(logs.limits, limited)
+		*stream.writer = io.MultiWriter(limited, stream.live)
+	}
+	return logs, nil
+}
+
+// Close finishes the log files and records which of them were truncated.
+func (l *ShardLogs) Close() error {
+	var errs []error
+	var truncated []string
+	for i, file := range l.files {
+		errs = append(errs, file.Close())
+		if l.limits[i].Truncated() {
+			truncated = append(truncated, filepath.Base(file.Name()))
+		}
+	}
+	l.files = nil
+	errs = append(errs, system.WriteLogsTruncated(l.resultsDir, truncated))
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Truncated is true if either log hit the size limit.
+func (l *ShardLogs) Truncated() bool {
+	for _, limited := range l.limits {
+		if limited.Truncated() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,156 @@
+package native
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/filecoin-project/bacalhau/pkg/storage/util"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// where cgroup v2 is mounted - cgroup.controllers is only in the root of v2
+	cgroupMount = "/sys/fs/cgroup"
+
+	// cpu.max is a quota of microseconds per period
+	cgroupCPUPeriod = 100000
+
+	// how long we wait for the processes in a cgroup to go after killing them
+	cgroupRemoveTimeout = 5 * time.Second
+)
+
+// the controllers we need jobs' cgroups to have
+var cgroupControllers = []string{"cpu", "memory", "pids", "io"}
+
+func cgroupsAvailable() bool {
+	_, err := os.Stat(filepath.Join(cgroupMount, "cgroup.controllers"))
+	return err == nil
+}
+
+// a cgroup v2 group that one shard runs in
+type cgroup struct {
+	path string
+}
+
+// make a cgroup for a shard under the root with the shard's limits
+func newCgroup(root, name string, limits model.ResourceUsageData) (*cgroup, error) {
+	err := os.MkdirAll(root, util.OS_USER_RWX|util.OS_ALL_R|util.OS_ALL_X)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cgroup %s: %w", root, err)
+	}
+	// a controller has to be enabled in the parent for a child to use it
+	// some may not be delegated to us, in which case setting the limit fails below
+	for _, controller := range cgroupControllers {
+		err = os.WriteFile(filepath.Join(root, "cgroup.subtree_control"), []byte("+"+controller), util.OS_USER_RW)
+		if err != nil {
+			log.Debug().Msgf("Could not enable the %s cgroup controller in %s: %s", controller, root, err)
+		}
+	}
+
+	group := &cgroup{path: filepath.Join(root, name)}
+	err = os.Mkdir(group.path, util.OS_USER_RWX|util.OS_ALL_R|util.OS_ALL_X)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cgroup %s: %w", group.path, err)
+	}
+
+	if limits.Memory > 0 {
+		err = group.write("memory.max", strconv.FormatUint(limits.Memory, 10))
+		if err != nil {
+			group.remove()
+			return nil, err
+		}
+		// swapping would let a job use more memory than it asked for
+		if err = group.write("memory.swap.max", "0"); err != nil {
+			log.Debug().Msgf("Could not turn off swap for %s: %s", group.path, err)
+		}
+	}
+	if limits.CPU > 0 {
+		quota := int64(limits.CPU * cgroupCPUPeriod)
+		err = group.write("cpu.max", fmt.Sprintf("%d %d", quota, cgroupCPUPeriod))
+		if err != nil {
+			group.remove()
+			return nil, err
+		}
+	}
+	return group, nil
+}
+
+func (c *cgroup) write(file, value string) error {
+	err := os.WriteFile(filepath.Join(c.path, file), []byte(value), util.OS_USER_RW)
+	if err != nil {
+		return fmt.Errorf("failed to set %s in cgroup %s: %w", file, c.path, err)
+	}
+	return nil
+}
+
+func (c *cgroup) addProcess(pid int) error {
+	return c.write("cgroup.procs", strconv.Itoa(pid))
+}
+
+// kill everything in the cgroup - this needs linux 5.14 so killing
+// the job's init process, which takes its pid namespace with it, is
+// what we rely on and this is a backstop
+func (c *cgroup) kill() {
+	_ = c.write("cgroup.kill", "1")
+}
+
+// what the processes in the cgroup have used so far
+func (c *cgroup) usage() model.ShardResourceUsage {
+	usage := model.ShardResourceUsage{}
+	c.readKeyedFile("cpu.stat", func(fields []string) {
+		if len(fields) == 2 && fields[0] == "usage_usec" {
+			usec, _ := strconv.ParseUint(fields[1], 10, 64)
+			usage.CPUSeconds = float64(usec) / float64(time.Second/time.Microsecond)
+		}
+	})
+	// memory.peak needs linux 5.19
+	if peak, err := os.ReadFile(filepath.Join(c.path, "memory.peak")); err == nil {
+		usage.MemoryPeak, _ = strconv.ParseUint(strings.TrimSpace(string(peak)), 10, 64)
+	}
+	// one line per device like "8:0 rbytes=1 wbytes=2 rios=3 wios=4"
+	c.readKeyedFile("io.stat", func(fields []string) {
+		for _, field := range fields[1:] {
+			if strings.HasPrefix(field, "wbytes=") {
+				written, _ := strconv.ParseUint(strings.TrimPrefix(field, "wbytes="), 10, 64)
+				usage.DiskWritten += written
+			}
+		}
+	})
+	return usage
+}
+
+func (c *cgroup) readKeyedFile(file string, line func(fields []string)) {
+	f, err := os.Open(filepath.Join(c.path, file))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) > 0 {
+			line(fields)
+		}
+	}
+}
+
+// remove the cgroup, which can only be done once its processes have gone
+func (c *cgroup) remove() {
+	deadline := time.Now().Add(cgroupRemoveTimeout)
+	for {
+		err := os.Remove(c.path)
+		if err == nil || os.IsNotExist(err) {
+			return
+		}
+		if time.Now().After(deadline) {
+			log.Warn().Msgf("Could not remove cgroup %s: %s", c.path, err)
+			return
+		}
+		time.Sleep(10 * time.Millisecond) //nolint:gomnd
+	}
+}
@@ -0,0 +1,34 @@
+package native
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/stretchr/testify/require"
+)
+
+// a directory stands in for the cgroup filesystem
+func TestCgroupLimitsAndUsage(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "bacalhau")
+	group, err := newCgroup(root, "shard", model.ResourceUsageData{CPU: 1.5, Memory: 1024 * 1024})
+	require.NoError(t, err)
+
+	require.Equal(t, "1048576", readFile(t, filepath.Join(group.path, "memory.max")))
+	require.Equal(t, "0", readFile(t, filepath.Join(group.path, "memory.swap.max")))
+	require.Equal(t, "150000 100000", readFile(t, filepath.Join(group.path, "cpu.max")))
+
+	for name, contents := range map[string]string{
+		"cpu.stat":    "usage_usec 2500000\nuser_usec 2000000\nsystem_usec 500000\n",
+		"memory.peak": "4096\n",
+		"io.stat":     "8:0 rbytes=10 wbytes=100 rios=1 wios=2\n8:16 rbytes=0 wbytes=50 rios=0 wios=1\n",
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(group.path, name), []byte(contents), 0644))
+	}
+	require.Equal(t, model.ShardResourceUsage{
+		CPUSeconds:  2.5,
+		MemoryPeak:  4096,
+		DiskWritten: 150,
+	}, group.usage())
+}
@@ -0,0 +1,440 @@
+package native
+
+/*
+The native executor runs jobs on nodes that don't have docker. The job's
+image, an OCI image layout or a plain root filesystem, or a single binary is
+run in its own mount, pid, uts, ipc and network namespaces (and a user
+namespace if the node isn't root) with its volumes bind mounted where a
+docker job would see them. cgroup v2 limits the cpu and memory it can use.
+Jobs have no network access. Before it runs the job gives up all of its
+capabilities, sets no_new_privs and installs a seccomp filter, so a job run
+by a root node is not root on the node.
+
+The namespaces are set up by re-running the bacalhau binary as the job's init
+process, so main has to call reexec.Init before anything else.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/filecoin-project/bacalhau/pkg/capacitymanager"
+	"github.com/filecoin-project/bacalhau/pkg/executor"
+	jobutils "github.com/filecoin-project/bacalhau/pkg/job"
+	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/filecoin-project/bacalhau/pkg/storage"
+	"github.com/filecoin-project/bacalhau/pkg/storage/util"
+	"github.com/filecoin-project/bacalhau/pkg/system"
+)
+
+const (
+	// DefaultCgroupRoot is where shards' cgroups are made unless the node says otherwise
+	DefaultCgroupRoot = "/sys/fs/cgroup/bacalhau"
+
+	// where a job's binary is mounted if its storage spec doesn't say
+	defaultBinaryPath = "/bin/program"
+
+	defaultPath     = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+	defaultHostname = "bacalhau"
+)
+
+type Config struct {
+	// the cgroup v2 directory shards' cgroups are made in, which the
+	// node must be able to write to - defaults to DefaultCgroupRoot
+	CgroupRoot string
+}
+
+type Executor struct {
+	// the storage providers we can implement for a job
+	StorageProviders map[model.StorageSourceType]storage.StorageProvider
+
+	config Config
+
+	// where we unpack images and make root filesystems
+	dir string
+}
+
+func NewExecutor(
+	ctx context.Context,
+	cm *system.CleanupManager,
+	storageProviders map[model.StorageSourceType]storage.StorageProvider,
+	config Config,
+) (*Executor, error) {
+	if config.CgroupRoot == "" {
+		config.CgroupRoot = DefaultCgroupRoot
+	}
+
+	dir, err := ioutil.TempDir("", "bacalhau-native-executor")
+	if err != nil {
+		return nil, err
+	}
+	cm.RegisterCallback(func() error {
+		return os.RemoveAll(dir)
+	})
+
+	return &Executor{
+		StorageProviders: storageProviders,
+		config:           config,
+		dir:              dir,
+	}, nil
+}
+
+func (e *Executor) getStorageProvider(ctx context.Context, engine model.StorageSourceType) (storage.StorageProvider, error) {
+	return util.GetStorageProvider(ctx, engine, e.StorageProviders)
+}
+
+// IsInstalled is true if we can make the namespaces jobs run in
+func (e *Executor) IsInstalled(ctx context.Context) (bool, error) {
+	return namespacesAvailable(), nil
+}
+
+func (e *Executor) HasStorageLocally(ctx context.Context, volume model.StorageSpec) (bool, error) {
+	ctx, span := newSpan(ctx, "HasStorageLocally")
+	defer span.End()
+
+	s, err := e.getStorageProvider(ctx, volume.Engine)
+	if err != nil {
+		return false, err
+	}
+
+	return s.HasStorageLocally(ctx, volume)
+}
+
+func (e *Executor) GetVolumeSize(ctx context.Context, volume model.StorageSpec) (uint64, error) {
+	storageProvider, err := e.getStorageProvider(ctx, volume.Engine)
+	if err != nil {
+		return 0, err
+	}
+	return storageProvider.GetVolumeSize(ctx, volume)
+}
+
+func (e *Executor) RestoreCheckpoint(ctx context.Context, checkpoint model.StorageSpec, dir string) error {
+	storageProvider, err := e.getStorageProvider(ctx, checkpoint.Engine)
+	if err != nil {
+		return err
+	}
+	return executor.RestoreCheckpoint(ctx, storageProvider, checkpoint, dir)
+}
+
+//nolint:funlen,gocyclo // mirrors the steps of the docker executor
+func (e *Executor) RunShard(
+	ctx context.Context,
+	shard model.JobShard,
+	jobResultsDir string,
+) error {
+	ctx, span := system.GetTracer().Start(ctx, "pkg/executor/native.RunShard")
+	defer span.End()
+	system.AddJobIDFromBaggageToSpan(ctx, span)
+	system.AddNodeIDFromBaggageToSpan(ctx, span)
+
+	nativeSpec := shard.Job.Spec.Native
+	if !shard.Job.Spec.Network.Disabled() {
+		return fmt.Errorf("the native executor can't give jobs network access")
+	}
+	resourceRequirements := capacitymanager.ParseResourceUsageConfig(shard.Job.Spec.Resources)
+	if resourceRequirements.GPU > 0 {
+		return fmt.Errorf("the native executor can't give jobs GPUs")
+	}
+
+	// volumes we prepared and must clean up once the job has finished
+	prepared := []preparedVolume{}
+	defer func() {
+		for _, p := range prepared {
+			if err := p.provider.CleanupStorage(ctx, p.spec, p.volume); err != nil {
+				log.Warn().Msgf("Could not clean up volume %s for shard %s: %s", p.volume.Source, shard, err)
+			}
+		}
+	}()
+	prepare := func(spec model.StorageSpec) (storage.StorageVolume, error) {
+		storageProvider, err := e.getStorageProvider(ctx, spec.Engine)
+		if err != nil {
+			return storage.StorageVolume{}, err
+		}
+		volume, err := storageProvider.PrepareStorage(ctx, spec)
+		if err != nil {
+			return storage.StorageVolume{}, err
+		}
+		prepared = append(prepared, preparedVolume{provider: storageProvider, spec: spec, volume: volume})
+		if volume.Type != storage.StorageVolumeConnectorBind {
+			return storage.StorageVolume{}, fmt.Errorf("unknown storage volume type: %s", volume.Type)
+		}
+		return volume, nil
+	}
+
+	mounts := []initMount{}
+	command := nativeSpec.Entrypoint
+	var root *rootfs
+	if model.IsValidStorageSourceType(nativeSpec.Binary.Engine) {
+		binary, err := prepare(nativeSpec.Binary)
+		if err != nil {
+			return fmt.Errorf("failed to prepare binary: %w", err)
+		}
+		dir, err := ioutil.TempDir(e.dir, "rootfs")
+		if err != nil {
+			return err
+		}
+		root = &rootfs{path: dir, temporary: true}
+		binaryPath := nativeSpec.Binary.Path
+		if binaryPath == "" {
+			binaryPath = defaultBinaryPath
+		}
+		mounts = append(mounts, initMount{Source: binary.Source, Target: binaryPath, ReadOnly: true})
+		if len(command) == 0 {
+			command = []string{binaryPath}
+		}
+	} else {
+		image, err := prepare(nativeSpec.Image)
+		if err != nil {
+			return fmt.Errorf("failed to prepare image: %w", err)
+		}
+		root, err = e.prepareImageRootfs(image.Source)
+		if err != nil {
+			return err
+		}
+		if len(command) == 0 {
+			command = append(command, root.config.Entrypoint...)
+			command = append(command, root.config.Cmd...)
+		}
+	}
+	if root.temporary {
+		defer os.RemoveAll(root.path)
+	}
+	if len(command) == 0 {
+		return fmt.Errorf("native job has no command to run")
+	}
+
+	shardStorageSpec, err := jobutils.GetShardStorageSpec(ctx, shard, e.StorageProviders)
+	if err != nil {
+		return err
+	}
+	inputs := []model.StorageSpec{}
+	inputs = append(inputs, shard.Job.Spec.Contexts...)
+	inputs = append(inputs, shardStorageSpec...)
+	for _, input := range inputs {
+		var volume storage.StorageVolume
+		volume, err = prepare(input)
+		if err != nil {
+			return err
+		}
+		log.Trace().Msgf("Input Volume: %+v %+v", input, volume)
+		mounts = append(mounts, initMount{Source: volume.Source, Target: volume.Target, ReadOnly: true})
+	}
+
+	for _, output := range shard.Job.Spec.Outputs {
+		if output.Name == "" {
+			return fmt.Errorf("output volume has no name: %+v", output)
+		}
+
+		if output.Path == "" {
+			return fmt.Errorf("output volume has no path: %+v", output)
+		}
+
+		srcd := filepath.Join(jobResultsDir, output.Name)
+		err = os.Mkdir(srcd, util.OS_ALL_R|util.OS_ALL_X|util.OS_USER_W)
+		if err != nil {
+			return err
+		}
+
+		log.Trace().Msgf("Output Volume: %+v", output)
+		mounts = append(mounts, initMount{Source: srcd, Target: output.Path})
+	}
+
+	checkpointDir, hasCheckpoint, err := executor.CheckpointMountDir(ctx, shard.Job.Spec)
+	if err != nil {
+		return err
+	}
+	if hasCheckpoint {
+		mounts = append(mounts, initMount{Source: checkpointDir, Target: shard.Job.Spec.Checkpoint.Path})
+	}
+
+	secretsDir, secretFiles, err := executor.WriteSecretFiles(ctx, shard.Job.Spec)
+	if err != nil {
+		return err
+	}
+	if secretsDir != "" {
+		defer os.RemoveAll(secretsDir)
+	}
+	for _, secretFile := range secretFiles {
+		mounts = append(mounts, initMount{Source: secretFile.Source, Target: secretFile.Target, ReadOnly: true})
+	}
+	secretEnv, err := executor.SecretEnv(ctx, shard.Job.Spec)
+	if err != nil {
+		return err
+	}
+
+	// everything is mounted at a path we have resolved inside the root
+	// filesystem so a symlink in the image can't point a mount at the host
+	for i := range mounts {
+		var info os.FileInfo
+		info, err = os.Stat(mounts[i].Source)
+		if err != nil {
+			return err
+		}
+		target := mounts[i].Target
+		mounts[i].Target, err = ensureMountpoint(root.path, target, info.IsDir())
+		if err != nil {
+			return fmt.Errorf("failed to make mountpoint for %s: %w", target, err)
+		}
+	}
+	config := initConfig{
+		Rootfs:     root.path,
+		Mounts:     mounts,
+		Hostname:   defaultHostname,
+		Args:       command,
+		WorkingDir: nativeSpec.WorkingDir,
+	}
+	for path, dir := range map[string]*string{"/proc": &config.Proc, "/dev": &config.Dev, "/tmp": &config.Tmp} {
+		*dir, err = ensureMountpoint(root.path, path, true)
+		if err != nil {
+			return fmt.Errorf("failed to make mountpoint for %s: %w", path, err)
+		}
+	}
+	if config.WorkingDir == "" {
+		config.WorkingDir = root.config.WorkingDir
+	}
+
+	jsonJobSpec, err := json.Marshal(shard.Job.Spec)
+	if err != nil {
+		return err
+	}
+	config.Env = append(config.Env, root.config.Env...)
+	config.Env = append(config.Env, nativeSpec.Env...)
+	config.Env = append(config.Env, fmt.Sprintf("BACALHAU_JOB_SPEC=%s", string(jsonJobSpec)))
+	// the config goes to the job's init process over a pipe so these never touch the disk
+	config.Env = append(config.Env, secretEnv...)
+	if !hasEnv(config.Env, "PATH") {
+		config.Env = append(config.Env, "PATH="+defaultPath)
+	}
+
+	var group *cgroup
+	limited := resourceRequirements.CPU > 0 || resourceRequirements.Memory > 0
+	if cgroupsAvailable() {
+		name := fmt.Sprintf("%s-%d-%s", shard.Job.ID, shard.Index, uuid.NewString())
+		group, err = newCgroup(e.config.CgroupRoot, name, resourceRequirements)
+		if err != nil {
+			if limited {
+				return err
+			}
+			// we only lose measuring what the job used
+			log.Debug().Msgf("Running %s without a cgroup: %s", shard, err)
+			group = nil
+		}
+	} else if limited {
+		return fmt.Errorf("the native executor needs cgroup v2 to limit the cpu and memory a job uses")
+	}
+	if group != nil {
+		defer group.remove()
+	}
+
+	// write the output to the results and copy it to
+	// anyone following the shard as it is produced
+	logs, err := executor.NewShardLogs(ctx, jobResultsDir)
+	if err != nil {
+		return fmt.Errorf("failed to create log files: %w", err)
+	}
+	defer logs.Close()
+	result, err := e.runProcess(ctx, config, group, logs.Stdout, logs.Stderr)
+	if err != nil {
+		return err
+	}
+	if result.err != nil {
+		log.Info().Msgf("native job error %s", result.err)
+	}
+
+	err = logs.Close()
+	if err != nil {
+		msg := fmt.Sprintf("could not write results to stdout and stderr: %s", err)
+		log.Error().Msg(msg)
+		return errors.New(msg)
+	}
+	err = os.WriteFile(
+		filepath.Join(jobResultsDir, "exitCode"),
+		[]byte(fmt.Sprintf("%d", result.exitCode)),
+		util.OS_ALL_R|util.OS_USER_RW,
+	)
+	if err != nil {
+		msg := fmt.Sprintf("could not write results to exitCode: %s", err)
+		log.Error().Msg(msg)
+		return errors.New(msg)
+	}
+
+	outputsSize, err := executor.OutputsSize(jobResultsDir, shard.Job.Spec.Outputs)
+	if err != nil {
+		log.Debug().Msgf("Could not measure the outputs of %s: %s", shard, err)
+	}
+	if outputsSize > result.usage.DiskWritten {
+		result.usage.DiskWritten = outputsSize
+	}
+	err = executor.WriteResourceUsage(jobResultsDir, result.usage)
+	if err != nil {
+		msg := fmt.Sprintf("could not write results to %s: %s", executor.ResourceUsageFilename, err)
+		log.Error().Msg(msg)
+		return errors.New(msg)
+	}
+
+	return result.err
+}
+
+// what the job's init process needs to set up the job
+type initConfig struct {
+	// the root filesystem, which is mounted read only
+	Rootfs string
+	// where proc, a minimal /dev and a scratch tmpfs are mounted
+	Proc string
+	Dev  string
+	Tmp  string
+	// volumes to bind mount, in order
+	Mounts     []initMount
+	Hostname   string
+	Args       []string
+	Env        []string
+	WorkingDir string
+}
+
+type initMount struct {
+	Source string
+	// where the volume is mounted on the host, which is inside the root filesystem
+	Target   string
+	ReadOnly bool
+}
+
+// how the job's process finished
+type processResult struct {
+	exitCode int
+	usage    model.ShardResourceUsage
+	// why the job failed, if it did
+	err error
+}
+
+type preparedVolume struct {
+	provider storage.StorageProvider
+	spec     model.StorageSpec
+	volume   storage.StorageVolume
+}
+
+func hasEnv(env []string, key string) bool {
+	for _, value := range env {
+		if strings.HasPrefix(value, key+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+func newSpan(ctx context.Context, apiName string) (context.Context, trace.Span) {
+	return system.Span(ctx, "executor/native", apiName)
+}
+
+// Compile-time interface check:
+var _ executor.Executor = (*Executor)(nil)
+var _ executor.CheckpointRestorer = (*Executor)(nil)
@@ -0,0 +1,278 @@
+package native
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/pkg/reexec"
+	"github.com/filecoin-project/bacalhau/pkg/executor"
+	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/filecoin-project/bacalhau/pkg/storage"
+	noop_storage "github.com/filecoin-project/bacalhau/pkg/storage/noop"
+	"github.com/filecoin-project/bacalhau/pkg/system"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// the test binary is the job's binary too - it is mounted at
+// /bin/program and does what its first argument says
+func TestMain(m *testing.M) {
+	reexec.Register(defaultBinaryPath, testProgram)
+	if reexec.Init() {
+		return
+	}
+	os.Exit(m.Run())
+}
+
+func testProgram() {
+	hostname, _ := os.Hostname()
+	switch os.Args[1] {
+	case "hello":
+		fmt.Printf("hello from %s as pid %d\n", hostname, os.Getpid())
+		input, err := os.ReadFile("/inputs/data")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		err = os.WriteFile("/outputs/copy", input, 0644)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		// the root filesystem is read only
+		if err = os.WriteFile("/newfile", nil, 0644); err == nil {
+			os.Exit(1)
+		}
+	case "checkpoint":
+		// carry on counting from wherever the last run got to
+		count, _ := os.ReadFile("/checkpoint/count")
+		err := os.WriteFile("/checkpoint/count", append(count, 'x'), 0644)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	case "secrets":
+		cert, err := os.ReadFile("/run/secrets/cert")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s %s\n", os.Getenv("TOKEN"), cert)
+	case "privileges":
+		// whoever runs the node, the job can't change it
+		status, err := os.ReadFile("/proc/self/status")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		for _, line := range strings.Split(string(status), "\n") {
+			field, value, _ := strings.Cut(line, ":")
+			switch field {
+			case "CapEff", "CapPrm", "CapBnd", "CapAmb", "NoNewPrivs", "Seccomp":
+				fmt.Printf("%s=%s\n", field, strings.TrimSpace(value))
+			}
+		}
+		fmt.Printf("mount=%v\n", unix.Mount("tmpfs", "/tmp", "tmpfs", 0, ""))
+		fmt.Printf("unshare=%v\n", unix.Unshare(unix.CLONE_NEWUSER))
+		fmt.Printf("sysctl=%v\n", os.WriteFile("/proc/sys/kernel/hostname", []byte("escaped"), 0644) == nil)
+		kcore, _ := os.ReadFile("/proc/kcore")
+		fmt.Printf("kcore=%d\n", len(kcore))
+		interfaces, _ := net.Interfaces()
+		for _, iface := range interfaces {
+			fmt.Printf("interface=%s\n", iface.Name)
+		}
+	case "fail":
+		os.Exit(3)
+	case "sleep":
+		time.Sleep(time.Minute)
+	}
+}
+
+func newTestShard(t *testing.T, args ...string) (*Executor, model.JobShard) {
+	if !namespacesAvailable() {
+		t.Skip("namespaces are not available")
+	}
+	binary, err := os.Executable()
+	require.NoError(t, err)
+	inputDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(inputDir, "data"), []byte("some data"), 0644))
+
+	// each volume's cid is where it is on the host
+	cm := system.NewCleanupManager()
+	t.Cleanup(cm.Cleanup)
+	storageProvider, err := noop_storage.NewStorageProvider(context.Background(), cm, noop_storage.StorageConfig{
+		ExternalHooks: noop_storage.StorageConfigExternalHooks{
+			PrepareStorage: func(ctx context.Context, spec model.StorageSpec) (storage.StorageVolume, error) {
+				return storage.StorageVolume{
+					Type:   storage.StorageVolumeConnectorBind,
+					Source: spec.Cid,
+					Target: spec.Path,
+				}, nil
+			},
+		},
+	})
+	require.NoError(t, err)
+	e, err := NewExecutor(context.Background(), cm, map[model.StorageSourceType]storage.StorageProvider{
+		model.StorageSourceIPFS: storageProvider,
+	}, Config{CgroupRoot: filepath.Join(DefaultCgroupRoot, "test")})
+	require.NoError(t, err)
+
+	contexts := []model.StorageSpec{{Engine: model.StorageSourceIPFS, Cid: inputDir, Path: "/inputs"}}
+	// the test binary may be dynamically linked so give it the host's libraries
+	for _, dir := range []string{"/lib", "/lib64", "/usr/lib", "/usr/lib64"} {
+		if _, err = os.Stat(dir); err == nil {
+			contexts = append(contexts, model.StorageSpec{Engine: model.StorageSourceIPFS, Cid: dir, Path: dir})
+		}
+	}
+
+	shard := model.JobShard{
+		Job: model.Job{
+			ID: "job-id",
+			Spec: model.JobSpec{
+				Engine: model.EngineNative,
+				Native: model.JobSpecNative{
+					Binary:     model.StorageSpec{Engine: model.StorageSourceIPFS, Cid: binary},
+					Entrypoint: append([]string{defaultBinaryPath}, args...),
+				},
+				Contexts: contexts,
+				Outputs:  []model.StorageSpec{{Name: "outputs", Path: "/outputs"}},
+			},
+			ExecutionPlan: model.JobExecutionPlan{TotalShards: 1},
+		},
+	}
+	return e, shard
+}
+
+func runTestShard(ctx context.Context, t *testing.T, args ...string) (string, error) {
+	e, shard := newTestShard(t, args...)
+	resultsDir := t.TempDir()
+	err := e.RunShard(ctx, shard, resultsDir)
+	return resultsDir, err
+}
+
+func TestRunShard(t *testing.T) {
+	resultsDir, err := runTestShard(context.Background(), t, "hello")
+	require.NoError(t, err, readFile(t, filepath.Join(resultsDir, "stderr")))
+	require.Equal(t, "hello from bacalhau as pid 1\n", readFile(t, filepath.Join(resultsDir, "stdout")))
+	require.Equal(t, "0", readFile(t, filepath.Join(resultsDir, "exitCode")))
+	require.Equal(t, "some data", readFile(t, filepath.Join(resultsDir, "outputs", "copy")))
+
+	usage, ok, err := executor.ReadResourceUsage(resultsDir)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Greater(t, usage.WallTime, float64(0))
+}
+
+func TestRunShardPrivileges(t *testing.T) {
+	resultsDir, err := runTestShard(context.Background(), t, "privileges")
+	require.NoError(t, err, readFile(t, filepath.Join(resultsDir, "stderr")))
+	stdout := readFile(t, filepath.Join(resultsDir, "stdout"))
+	for _, line := range []string{
+		"CapEff=0000000000000000",
+		"CapPrm=0000000000000000",
+		"CapBnd=0000000000000000",
+		"CapAmb=0000000000000000",
+		"NoNewPrivs=1",
+		"Seccomp=2",
+		"mount=operation not permitted",
+		"unshare=operation not permitted",
+		"sysctl=false",
+		"kcore=0",
+		// its own network namespace only has loopback
+		"interface=lo",
+	} {
+		require.Contains(t, stdout, line+"\n")
+	}
+	require.Equal(t, 1, strings.Count(stdout, "interface="), stdout)
+}
+
+func TestRunShardNetwork(t *testing.T) {
+	e, shard := newTestShard(t, "hello")
+	shard.Job.Spec.Network = model.NetworkConfig{Type: model.NetworkFull}
+	err := e.RunShard(context.Background(), shard, t.TempDir())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "can't give jobs network access")
+}
+
+func TestRunShardExitCode(t *testing.T) {
+	resultsDir, err := runTestShard(context.Background(), t, "fail")
+	require.Error(t, err)
+	require.Equal(t, "3", readFile(t, filepath.Join(resultsDir, "exitCode")))
+}
+
+func TestRunShardTimeout(t *testing.T) {
+	ctx := executor.ContextWithExecutionTimeout(context.Background(), 500*time.Millisecond)
+	resultsDir, err := runTestShard(ctx, t, "sleep")
+	require.ErrorIs(t, err, executor.ErrExecutionTimeout)
+	require.Equal(t, "137", readFile(t, filepath.Join(resultsDir, "exitCode")))
+}
+
+func TestRunShardCheckpoint(t *testing.T) {
+	e, shard := newTestShard(t, "checkpoint")
+	shard.Job.Spec.Checkpoint = model.JobSpecCheckpoint{Path: "/checkpoint"}
+
+	// without a checkpoint volume the shard can't run
+	err := e.RunShard(context.Background(), shard, t.TempDir())
+	require.Error(t, err)
+
+	published := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(published, "count"), []byte("x"), 0644))
+	dir := t.TempDir()
+	err = e.RestoreCheckpoint(context.Background(), model.StorageSpec{Engine: model.StorageSourceIPFS, Cid: published}, dir)
+	require.NoError(t, err)
+
+	resultsDir := t.TempDir()
+	err = e.RunShard(executor.ContextWithCheckpointDir(context.Background(), dir), shard, resultsDir)
+	require.NoError(t, err, readFile(t, filepath.Join(resultsDir, "stderr")))
+	require.Equal(t, "xx", readFile(t, filepath.Join(dir, "count")))
+	require.Equal(t, "x", readFile(t, filepath.Join(published, "count")))
+}
+
+func TestRunShardSecrets(t *testing.T) {
+	e, shard := newTestShard(t, "secrets")
+	shard.Job.Spec.Secrets = []model.JobSpecSecret{
+		{Name: "token", Env: "TOKEN"},
+		{Name: "cert", Path: "/run/secrets/cert"},
+	}
+
+	// the shard can't run without the values
+	err := e.RunShard(context.Background(), shard, t.TempDir())
+	require.Error(t, err)
+
+	ctx := executor.ContextWithSecrets(context.Background(), map[string]string{"token": "hunter2", "cert": "PEM"})
+	resultsDir := t.TempDir()
+	err = e.RunShard(ctx, shard, resultsDir)
+	require.NoError(t, err, readFile(t, filepath.Join(resultsDir, "stderr")))
+	require.Equal(t, "hunter2 PEM\n", readFile(t, filepath.Join(resultsDir, "stdout")))
+}
+
+func TestRunShardMissingCommand(t *testing.T) {
+	if !namespacesAvailable() {
+		t.Skip("namespaces are not available")
+	}
+	root := t.TempDir()
+	e := &Executor{dir: t.TempDir()}
+	result, err := e.runProcess(context.Background(), initConfig{
+		Rootfs: root,
+		Proc:   mustMountpoint(t, root, "/proc"),
+		Dev:    mustMountpoint(t, root, "/dev"),
+		Tmp:    mustMountpoint(t, root, "/tmp"),
+		Args:   []string{"missing"},
+	}, nil, io.Discard, io.Discard)
+	require.NoError(t, err)
+	require.Equal(t, initFailedExitCode, result.exitCode)
+	require.Error(t, result.err)
+}
+
+func mustMountpoint(t *testing.T, root, path string) string {
+	mountpoint, err := ensureMountpoint(root, path, true)
+	require.NoError(t, err)
+	return mountpoint
+}
@@ -0,0 +1,363 @@
+package native
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/filecoin-project/bacalhau/pkg/storage/util"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// docker's names for the same things as the OCI media types
+	mediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+
+	// a whiteout file deletes what it names from the layers below,
+	// an opaque whiteout deletes everything in its directory
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// a root filesystem ready for a job and the image settings that go with it
+type rootfs struct {
+	path   string
+	config ocispec.ImageConfig
+	// true if the root filesystem belongs to this shard and
+	// should be removed once it has finished
+	temporary bool
+}
+
+// get a root filesystem for an image, which is either an OCI image layout
+// or a directory that already is a root filesystem
+func (e *Executor) prepareImageRootfs(source string) (*rootfs, error) {
+	if _, err := os.Stat(filepath.Join(source, ocispec.ImageLayoutFile)); err != nil {
+		log.Debug().Msgf("%s is not an OCI image layout so using it as the root filesystem", source)
+		dir, err := ioutil.TempDir(e.dir, "rootfs")
+		if err != nil {
+			return nil, err
+		}
+		err = copyTree(source, dir)
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, fmt.Errorf("failed to copy root filesystem: %w", err)
+		}
+		return &rootfs{path: dir, temporary: true}, nil
+	}
+
+	manifestDigest, manifest, err := readImageManifest(source)
+	if err != nil {
+		return nil, err
+	}
+	var image ocispec.Image
+	err = readBlobJSON(source, manifest.Config, &image)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image config: %w", err)
+	}
+
+	// images are unpacked once and shared by the shards that use them
+	path := filepath.Join(e.dir, "images", manifestDigest.Encoded())
+	if _, err = os.Stat(path); err == nil {
+		return &rootfs{path: path, config: image.Config}, nil
+	}
+	err = os.MkdirAll(filepath.Dir(path), util.OS_USER_RWX)
+	if err != nil {
+		return nil, err
+	}
+	unpackDir, err := ioutil.TempDir(filepath.Dir(path), ".unpack")
+	if err != nil {
+		return nil, err
+	}
+	err = unpackLayers(source, manifest.Layers, unpackDir)
+	if err != nil {
+		os.RemoveAll(unpackDir)
+		return nil, fmt.Errorf("failed to unpack image: %w", err)
+	}
+	// another shard may have unpacked the same image while we were
+	if err = os.Rename(unpackDir, path); err != nil {
+		os.RemoveAll(unpackDir)
+		if _, statErr := os.Stat(path); statErr != nil {
+			return nil, err
+		}
+	}
+	log.Debug().Msgf("Unpacked image %s to %s", manifestDigest, path)
+	return &rootfs{path: path, config: image.Config}, nil
+}
+
+// find the manifest for our platform in an OCI image layout
+func readImageManifest(layout string) (digest.Digest, ocispec.Manifest, error) {
+	var index ocispec.Index
+	data, err := os.ReadFile(filepath.Join(layout, "index.json"))
+	if err != nil {
+		return "", ocispec.Manifest{}, err
+	}
+	err = json.Unmarshal(data, &index)
+	if err != nil {
+		return "", ocispec.Manifest{}, fmt.Errorf("invalid image index: %w", err)
+	}
+
+	for {
+		descriptor, err := selectManifest(index.Manifests)
+		if err != nil {
+			return "", ocispec.Manifest{}, err
+		}
+		switch descriptor.MediaType {
+		case ocispec.MediaTypeImageIndex, mediaTypeDockerManifestList:
+			index = ocispec.Index{}
+			err = readBlobJSON(layout, descriptor, &index)
+			if err != nil {
+				return "", ocispec.Manifest{}, fmt.Errorf("failed to read image index: %w", err)
+			}
+		default:
+			var manifest ocispec.Manifest
+			err = readBlobJSON(layout, descriptor, &manifest)
+			if err != nil {
+				return "", ocispec.Manifest{}, fmt.Errorf("failed to read image manifest: %w", err)
+			}
+			return descriptor.Digest, manifest, nil
+		}
+	}
+}
+
+// pick the manifest for the platform we are running on
+// if the manifests don't say which platform they are for use the first one
+func selectManifest(manifests []ocispec.Descriptor) (ocispec.Descriptor, error) {
+	if len(manifests) == 0 {
+		return ocispec.Descriptor{}, fmt.Errorf("image has no manifests")
+	}
+	for _, descriptor := range manifests {
+		if descriptor.Platform == nil {
+			return descriptor, nil
+		}
+		if descriptor.Platform.OS == runtime.GOOS && descriptor.Platform.Architecture == runtime.GOARCH {
+			return descriptor, nil
+		}
+	}
+	return ocispec.Descriptor{}, fmt.Errorf("image has no manifest for %s/%s", runtime.GOOS, runtime.GOARCH)
+}
+
+// open a blob in an image layout and check it is what the descriptor says
+func openBlob(layout string, descriptor ocispec.Descriptor) (io.ReadCloser, digest.Verifier, error) {
+	if err := descriptor.Digest.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("invalid digest %q: %w", descriptor.Digest, err)
+	}
+	file, err := os.Open(filepath.Join(layout, "blobs", descriptor.Digest.Algorithm().String(), descriptor.Digest.Encoded()))
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, descriptor.Digest.Verifier(), nil
+}
+
+func readBlobJSON(layout string, descriptor ocispec.Descriptor, value interface{}) error {
+	blob, verifier, err := openBlob(layout, descriptor)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+	data, err := io.ReadAll(io.TeeReader(blob, verifier))
+	if err != nil {
+		return err
+	}
+	if !verifier.Verified() {
+		return fmt.Errorf("blob %s does not match its digest", descriptor.Digest)
+	}
+	return json.Unmarshal(data, value)
+}
+
+// apply the layers of an image in order to make its root filesystem
+func unpackLayers(layout string, layers []ocispec.Descriptor, root string) error {
+	// directories are made writable while we unpack into them
+	// and given their real permissions at the end
+	dirModes := map[string]os.FileMode{}
+	for _, layer := range layers {
+		err := unpackLayerBlob(layout, layer, root, dirModes)
+		if err != nil {
+			return fmt.Errorf("layer %s: %w", layer.Digest, err)
+		}
+	}
+
+	dirs := []string{}
+	for dir := range dirModes {
+		dirs = append(dirs, dir)
+	}
+	// deepest first so we can still get to the ones below
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	for _, dir := range dirs {
+		if err := os.Chmod(dir, dirModes[dir]); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func unpackLayerBlob(layout string, layer ocispec.Descriptor, root string, dirModes map[string]os.FileMode) error {
+	blob, verifier, err := openBlob(layout, layer)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	reader := bufio.NewReader(io.TeeReader(blob, verifier))
+	magic, _ := reader.Peek(len(zstdMagic))
+	var layerReader io.Reader = reader
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return err
+		}
+		defer gzipReader.Close()
+		layerReader = gzipReader
+	case bytes.HasPrefix(magic, zstdMagic):
+		return fmt.Errorf("zstd compressed layers are not supported")
+	}
+
+	err = unpackLayer(tar.NewReader(layerReader), root, dirModes)
+	if err != nil {
+		return err
+	}
+	// read whatever is after the end of the archive so the whole blob is verified
+	if _, err = io.Copy(ioutil.Discard, reader); err != nil {
+		return err
+	}
+	if !verifier.Verified() {
+		return fmt.Errorf("layer does not match its digest")
+	}
+	return nil
+}
+
+//nolint:gocyclo // one case per kind of tar entry
+func unpackLayer(tarReader *tar.Reader, root string, dirModes map[string]os.FileMode) error {
+	// whiteouts only apply to the layers below so we must not
+	// remove anything this layer has already added
+	added := map[string]bool{}
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := filepath.Clean("/" + header.Name)
+		if name == "/" {
+			continue
+		}
+		parent, err := securePath(root, filepath.Dir(name))
+		if err != nil {
+			return err
+		}
+		base := filepath.Base(name)
+		err = os.MkdirAll(parent, util.OS_ALL_R|util.OS_ALL_X|util.OS_USER_W)
+		if err != nil {
+			return err
+		}
+
+		if base == whiteoutOpaque {
+			entries, err := os.ReadDir(parent)
+			if err != nil {
+				return err
+			}
+			for _, entry := range entries {
+				path := filepath.Join(parent, entry.Name())
+				if !added[path] {
+					if err = os.RemoveAll(path); err != nil {
+						return err
+					}
+				}
+			}
+			continue
+		}
+		if strings.HasPrefix(base, whiteoutPrefix) {
+			// .wh.. and .wh... would remove the directory itself or its parent
+			hidden := strings.TrimPrefix(base, whiteoutPrefix)
+			if hidden == "" || hidden == "." || hidden == ".." {
+				return fmt.Errorf("invalid whiteout %q", header.Name)
+			}
+			err = os.RemoveAll(filepath.Join(parent, hidden))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		path := filepath.Join(parent, base)
+		added[path] = true
+		// an entry replaces what was there before, apart from a
+		// directory over a directory which merges their contents
+		if info, err := os.Lstat(path); err == nil && !(info.IsDir() && header.Typeflag == tar.TypeDir) {
+			if err = os.RemoveAll(path); err != nil {
+				return err
+			}
+		}
+
+		mode := header.FileInfo().Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(path, util.OS_USER_RWX)
+			if err == nil {
+				err = os.Chmod(path, mode|util.OS_USER_RWX)
+			}
+			dirModes[path] = mode
+		case tar.TypeReg, tar.TypeRegA: //nolint:staticcheck // old archives still use TypeRegA
+			err = writeLayerFile(path, tarReader, mode)
+		case tar.TypeSymlink:
+			err = os.Symlink(header.Linkname, path)
+		case tar.TypeLink:
+			var target string
+			target, err = securePath(root, filepath.Dir(filepath.Clean("/"+header.Linkname)))
+			if err == nil {
+				err = os.Link(filepath.Join(target, filepath.Base(header.Linkname)), path)
+			}
+		default:
+			// devices and pipes can't be made without privileges
+			// and jobs get their own /dev
+			log.Trace().Msgf("Skipping %s in image layer", header.Name)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if os.Geteuid() == 0 {
+			_ = os.Lchown(path, header.Uid, header.Gid)
+			// chown clears setuid bits so put them back
+			if header.Typeflag != tar.TypeSymlink && header.Typeflag != tar.TypeDir {
+				_ = os.Chmod(path, mode)
+			}
+		}
+	}
+}
+
+func writeLayerFile(path string, reader io.Reader, mode os.FileMode) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, util.OS_USER_RW)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, reader)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Chmod(path, mode)
+}
@@ -0,0 +1,210 @@
+package native
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+type tarEntry struct {
+	name     string
+	typeflag byte
+	contents string
+	linkname string
+}
+
+func makeLayer(t *testing.T, compress bool, entries ...tarEntry) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		header := &tar.Header{
+			Name:     entry.name,
+			Typeflag: entry.typeflag,
+			Linkname: entry.linkname,
+			Mode:     0755,
+			Size:     int64(len(entry.contents)),
+		}
+		require.NoError(t, tw.WriteHeader(header))
+		_, err := tw.Write([]byte(entry.contents))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	if !compress {
+		return buf.Bytes()
+	}
+	var gzipped bytes.Buffer
+	gw := gzip.NewWriter(&gzipped)
+	_, err := gw.Write(buf.Bytes())
+	require.NoError(t, err)
+	require.NoError(t, gw.Close())
+	return gzipped.Bytes()
+}
+
+func writeBlob(t *testing.T, layout, mediaType string, data []byte) ocispec.Descriptor {
+	d := digest.FromBytes(data)
+	dir := filepath.Join(layout, "blobs", d.Algorithm().String())
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, d.Encoded()), data, 0644))
+	return ocispec.Descriptor{MediaType: mediaType, Digest: d, Size: int64(len(data))}
+}
+
+func writeJSONBlob(t *testing.T, layout, mediaType string, value interface{}) ocispec.Descriptor {
+	data, err := json.Marshal(value)
+	require.NoError(t, err)
+	return writeBlob(t, layout, mediaType, data)
+}
+
+// an image layout with two layers that exercise whiteouts and symlinks
+func makeImageLayout(t *testing.T) string {
+	layout := t.TempDir()
+	lower := makeLayer(t, true,
+		tarEntry{name: "etc/", typeflag: tar.TypeDir},
+		tarEntry{name: "etc/hello", typeflag: tar.TypeReg, contents: "hello"},
+		tarEntry{name: "etc/old", typeflag: tar.TypeReg, contents: "old"},
+		tarEntry{name: "cache/", typeflag: tar.TypeDir},
+		tarEntry{name: "cache/stale", typeflag: tar.TypeReg, contents: "stale"},
+		tarEntry{name: "usr/bin/", typeflag: tar.TypeDir},
+		tarEntry{name: "bin", typeflag: tar.TypeSymlink, linkname: "usr/bin"},
+		tarEntry{name: "escape", typeflag: tar.TypeSymlink, linkname: "/../../../../outside"},
+	)
+	upper := makeLayer(t, false,
+		tarEntry{name: "etc/.wh.old", typeflag: tar.TypeReg},
+		tarEntry{name: "cache/fresh", typeflag: tar.TypeReg, contents: "fresh"},
+		tarEntry{name: "cache/.wh..wh..opq", typeflag: tar.TypeReg},
+		tarEntry{name: "bin/tool", typeflag: tar.TypeReg, contents: "tool"},
+		tarEntry{name: "escape/file", typeflag: tar.TypeReg, contents: "contained"},
+		tarEntry{name: "etc/hello-link", typeflag: tar.TypeLink, linkname: "etc/hello"},
+	)
+
+	config := writeJSONBlob(t, layout, ocispec.MediaTypeImageConfig, ocispec.Image{
+		Config: ocispec.ImageConfig{
+			Entrypoint: []string{"/bin/tool"},
+			Cmd:        []string{"--flag"},
+			Env:        []string{"PATH=/bin"},
+		},
+	})
+	manifest := writeJSONBlob(t, layout, ocispec.MediaTypeImageManifest, ocispec.Manifest{
+		Config: config,
+		Layers: []ocispec.Descriptor{
+			writeBlob(t, layout, ocispec.MediaTypeImageLayerGzip, lower),
+			writeBlob(t, layout, ocispec.MediaTypeImageLayer, upper),
+		},
+	})
+	index, err := json.Marshal(ocispec.Index{Manifests: []ocispec.Descriptor{manifest}})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(layout, "index.json"), index, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(layout, ocispec.ImageLayoutFile), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0644))
+	return layout
+}
+
+func readFile(t *testing.T, path string) string {
+	contents, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return string(contents)
+}
+
+func TestPrepareImageRootfs(t *testing.T) {
+	e := &Executor{dir: t.TempDir()}
+	layout := makeImageLayout(t)
+
+	root, err := e.prepareImageRootfs(layout)
+	require.NoError(t, err)
+	require.False(t, root.temporary)
+	require.Equal(t, []string{"/bin/tool"}, root.config.Entrypoint)
+	require.Equal(t, []string{"--flag"}, root.config.Cmd)
+
+	require.Equal(t, "hello", readFile(t, filepath.Join(root.path, "etc/hello")))
+	require.Equal(t, "hello", readFile(t, filepath.Join(root.path, "etc/hello-link")))
+	require.NoFileExists(t, filepath.Join(root.path, "etc/old"))
+	// the opaque whiteout hides the lower layer but not what the same layer added
+	require.NoFileExists(t, filepath.Join(root.path, "cache/stale"))
+	require.Equal(t, "fresh", readFile(t, filepath.Join(root.path, "cache/fresh")))
+	// writing through a symlink stays inside the root filesystem
+	require.Equal(t, "tool", readFile(t, filepath.Join(root.path, "usr/bin/tool")))
+	require.Equal(t, "contained", readFile(t, filepath.Join(root.path, "outside/file")))
+
+	// the image is only unpacked once
+	again, err := e.prepareImageRootfs(layout)
+	require.NoError(t, err)
+	require.Equal(t, root.path, again.path)
+}
+
+func TestPrepareImageRootfsChecksDigests(t *testing.T) {
+	e := &Executor{dir: t.TempDir()}
+	layout := makeImageLayout(t)
+
+	_, manifest, err := readImageManifest(layout)
+	require.NoError(t, err)
+	layer := manifest.Layers[1]
+	blob := filepath.Join(layout, "blobs", layer.Digest.Algorithm().String(), layer.Digest.Encoded())
+	require.NoError(t, os.WriteFile(blob, makeLayer(t, false, tarEntry{name: "evil", typeflag: tar.TypeReg}), 0644))
+
+	_, err = e.prepareImageRootfs(layout)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "does not match its digest")
+}
+
+func TestUnpackLayerRejectsInvalidWhiteouts(t *testing.T) {
+	for _, name := range []string{"usr/.wh.", "usr/.wh..", "usr/.wh...", ".wh.."} {
+		t.Run(name, func(t *testing.T) {
+			root := t.TempDir()
+			require.NoError(t, os.MkdirAll(filepath.Join(root, "usr/bin"), 0755))
+			layer := makeLayer(t, false, tarEntry{name: name, typeflag: tar.TypeReg})
+			err := unpackLayer(tar.NewReader(bytes.NewReader(layer)), root, map[string]os.FileMode{})
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "invalid whiteout")
+			require.DirExists(t, filepath.Join(root, "usr/bin"))
+		})
+	}
+}
+
+func TestPrepareImageRootfsPlainDirectory(t *testing.T) {
+	e := &Executor{dir: t.TempDir()}
+	source := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(source, "usr/bin"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(source, "usr/bin/tool"), []byte("tool"), 0755))
+	require.NoError(t, os.Symlink("usr/bin", filepath.Join(source, "bin")))
+
+	root, err := e.prepareImageRootfs(source)
+	require.NoError(t, err)
+	require.True(t, root.temporary)
+	require.Equal(t, "tool", readFile(t, filepath.Join(root.path, "bin/tool")))
+
+	// adding mountpoints leaves the original alone
+	_, err = ensureMountpoint(root.path, "/inputs", true)
+	require.NoError(t, err)
+	require.NoDirExists(t, filepath.Join(source, "inputs"))
+}
+
+func TestSecurePath(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "usr/bin"), 0755))
+	require.NoError(t, os.Symlink("/usr/bin", filepath.Join(root, "bin")))
+	require.NoError(t, os.Symlink("../../../../..", filepath.Join(root, "up")))
+	require.NoError(t, os.Symlink("loop2", filepath.Join(root, "loop1")))
+	require.NoError(t, os.Symlink("loop1", filepath.Join(root, "loop2")))
+
+	for unsafePath, expected := range map[string]string{
+		"/bin/tool":        "usr/bin/tool",
+		"bin/../etc":       "usr/etc",
+		"/up/etc/passwd":   "etc/passwd",
+		"/../../etc":       "etc",
+		"/missing/file":    "missing/file",
+		"/usr/bin/../lib/": "usr/lib",
+	} {
+		path, err := securePath(root, unsafePath)
+		require.NoError(t, err)
+		require.Equal(t, filepath.Join(root, expected), path, unsafePath)
+	}
+
+	_, err := securePath(root, "/loop1/file")
+	require.Error(t, err)
+}
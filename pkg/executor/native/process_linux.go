@@ -0,0 +1,464 @@
+package native
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/docker/docker/pkg/reexec"
+	"github.com/filecoin-project/bacalhau/pkg/executor"
+	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// the name we re-run ourselves with to become a job's init process
+	initCommandName = "bacalhau-native-init"
+
+	// the exit code when we could not start the job, like docker's
+	initFailedExitCode = 125
+
+	// the init process reads its config from this file descriptor
+	initConfigFd = 3
+
+	// the largest /dev can get, it only holds device mountpoints and links
+	devTmpfsSize = 65536
+)
+
+// the host devices every job gets
+var devices = []string{"null", "zero", "full", "random", "urandom", "tty"}
+
+// like docker, the parts of /proc that change the whole node are read only
+// and the ones that tell the job about the node are hidden
+var (
+	readOnlyProcPaths = []string{"bus", "fs", "irq", "sys", "sysrq-trigger"}
+	maskedProcPaths   = []string{
+		"acpi", "asound", "kcore", "keys", "latency_stats",
+		"sched_debug", "scsi", "timer_list", "timer_stats",
+	}
+)
+
+func init() { //nolint:gochecknoinits // reexec needs the init process registered before main runs
+	reexec.Register(initCommandName, runInit)
+}
+
+func namespacesAvailable() bool {
+	if _, err := os.Stat("/proc/self/ns/pid"); err != nil {
+		return false
+	}
+	if os.Geteuid() == 0 {
+		return true
+	}
+	// without root we need to be allowed user namespaces
+	maxNamespaces, err := os.ReadFile("/proc/sys/user/max_user_namespaces")
+	return err == nil && strings.TrimSpace(string(maxNamespaces)) != "0"
+}
+
+// run the job's process in its namespaces and wait for it to finish
+//
+//nolint:funlen // the steps have to happen in this order
+func (e *Executor) runProcess(
+	ctx context.Context,
+	config initConfig,
+	group *cgroup,
+	stdout, stderr io.Writer,
+) (processResult, error) {
+	configReader, configWriter, err := os.Pipe()
+	if err != nil {
+		return processResult{}, err
+	}
+	defer configReader.Close()
+	defer configWriter.Close()
+
+	// the job never shares the node's network namespace, and as root it
+	// gives up its privileges in setupAndExec before it runs
+	cloneflags := unix.CLONE_NEWNS | unix.CLONE_NEWPID | unix.CLONE_NEWUTS | unix.CLONE_NEWIPC | unix.CLONE_NEWNET
+	attr := &syscall.SysProcAttr{
+		Cloneflags: uintptr(cloneflags),
+		Pdeathsig:  syscall.SIGKILL,
+	}
+	if os.Geteuid() != 0 {
+		// we are root in the job's user namespace, which is us outside of it
+		attr.Cloneflags |= unix.CLONE_NEWUSER
+		attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Geteuid(), Size: 1}}
+		attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getegid(), Size: 1}}
+		attr.GidMappingsEnableSetgroups = false
+	}
+
+	cmd := reexec.Command(initCommandName)
+	cmd.SysProcAttr = attr
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.ExtraFiles = []*os.File{configReader}
+
+	startedAt := time.Now()
+	err = cmd.Start()
+	if err != nil {
+		return processResult{}, fmt.Errorf("failed to start job: %w", err)
+	}
+	configReader.Close()
+
+	// the init process waits for its config so it is in
+	// the cgroup before it can start anything else
+	if group != nil {
+		if err = group.addProcess(cmd.Process.Pid); err != nil {
+			_ = cmd.Process.Kill()
+			_ = cmd.Wait()
+			return processResult{}, err
+		}
+	}
+	err = json.NewEncoder(configWriter).Encode(config)
+	configWriter.Close()
+	if err != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return processResult{}, fmt.Errorf("failed to start job: %w", err)
+	}
+
+	waitErr := make(chan error, 1)
+	go func() {
+		waitErr <- cmd.Wait()
+	}()
+	var timedOut <-chan time.Time
+	timeout := executor.ExecutionTimeoutFromContext(ctx)
+	if timeout > 0 {
+		timer := time.NewTimer(timeout - time.Since(startedAt))
+		defer timer.Stop()
+		timedOut = timer.C
+	}
+	// killing the init process kills everything in its pid namespace
+	kill := func() {
+		_ = cmd.Process.Kill()
+		if group != nil {
+			group.kill()
+		}
+	}
+
+	result := processResult{}
+	select {
+	case err = <-waitErr:
+	case <-timedOut:
+		kill()
+		err = <-waitErr
+		result.err = executor.NewExecutionTimeoutError(timeout)
+	case <-ctx.Done():
+		kill()
+		err = <-waitErr
+		result.err = ctx.Err()
+	}
+	wallTime := time.Since(startedAt).Seconds()
+	if _, ok := err.(*exec.ExitError); !ok && err != nil {
+		return processResult{}, fmt.Errorf("failed to run job: %w", err)
+	}
+
+	status, _ := cmd.ProcessState.Sys().(syscall.WaitStatus)
+	result.exitCode = cmd.ProcessState.ExitCode()
+	if status.Signaled() {
+		result.exitCode = 128 + int(status.Signal()) //nolint:gomnd // like a shell reports it
+	}
+	if result.err == nil && result.exitCode != 0 {
+		result.err = fmt.Errorf("exit code was not zero: %d", result.exitCode)
+	}
+
+	if group != nil {
+		result.usage = group.usage()
+	} else if rusage, ok := cmd.ProcessState.SysUsage().(*syscall.Rusage); ok {
+		// the init process becomes the job so this covers it and everything it waited for
+		result.usage = model.ShardResourceUsage{
+			CPUSeconds: time.Duration(rusage.Utime.Nano() + rusage.Stime.Nano()).Seconds(),
+			MemoryPeak: uint64(rusage.Maxrss) * 1024, //nolint:gomnd // maxrss is in KiB
+		}
+	}
+	result.usage.WallTime = wallTime
+	log.Debug().Msgf("Native job finished with exit code %d after %.1fs", result.exitCode, wallTime)
+	return result, nil
+}
+
+// the init process: set up the job's root filesystem in our new namespaces
+// then become the job
+func runInit() {
+	err := setupAndExec()
+	fmt.Fprintf(os.Stderr, "bacalhau: failed to start job: %s\n", err)
+	os.Exit(initFailedExitCode)
+}
+
+func setupAndExec() error {
+	// what we give up is given up by this thread, so it has to be
+	// the one that becomes the job
+	runtime.LockOSThread()
+
+	var config initConfig
+	configFile := os.NewFile(initConfigFd, "config")
+	err := json.NewDecoder(configFile).Decode(&config)
+	configFile.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+
+	// nothing we mount can be seen from the host
+	err = unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, "")
+	if err != nil {
+		return fmt.Errorf("failed to make mounts private: %w", err)
+	}
+	err = unix.Mount(config.Rootfs, config.Rootfs, "", unix.MS_BIND|unix.MS_REC, "")
+	if err != nil {
+		return fmt.Errorf("failed to mount root filesystem: %w", err)
+	}
+
+	err = unix.Mount("proc", config.Proc, "proc", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, "")
+	if err != nil {
+		return fmt.Errorf("failed to mount /proc: %w", err)
+	}
+	err = restrictProc(config.Proc)
+	if err != nil {
+		return err
+	}
+	err = setupDev(config.Dev)
+	if err != nil {
+		return err
+	}
+	err = unix.Mount("tmpfs", config.Tmp, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "mode=1777")
+	if err != nil {
+		return fmt.Errorf("failed to mount /tmp: %w", err)
+	}
+
+	for _, mount := range config.Mounts {
+		err = bindMount(mount.Source, mount.Target, mount.ReadOnly)
+		if err != nil {
+			return err
+		}
+	}
+	err = remountReadOnly(config.Rootfs)
+	if err != nil {
+		return err
+	}
+
+	// swap the root filesystem in for the host's and let go of the host's,
+	// pivoting onto the same directory means we don't need to write anywhere
+	err = unix.Chdir(config.Rootfs)
+	if err != nil {
+		return err
+	}
+	err = unix.PivotRoot(".", ".")
+	if err != nil {
+		return fmt.Errorf("failed to pivot root: %w", err)
+	}
+	err = unix.Unmount(".", unix.MNT_DETACH)
+	if err != nil {
+		return fmt.Errorf("failed to unmount host filesystem: %w", err)
+	}
+
+	err = unix.Sethostname([]byte(config.Hostname))
+	if err != nil {
+		return fmt.Errorf("failed to set hostname: %w", err)
+	}
+	workingDir := config.WorkingDir
+	if workingDir == "" {
+		workingDir = "/"
+	}
+	err = unix.Chdir(workingDir)
+	if err != nil {
+		return fmt.Errorf("failed to change to working directory: %w", err)
+	}
+
+	path, err := lookPath(config.Args[0], config.Env)
+	if err != nil {
+		return err
+	}
+	err = dropPrivileges()
+	if err != nil {
+		return err
+	}
+	return unix.Exec(path, config.Args, config.Env)
+}
+
+func restrictProc(proc string) error {
+	for _, name := range readOnlyProcPaths {
+		path := filepath.Join(proc, name)
+		if _, err := os.Lstat(path); err != nil {
+			continue
+		}
+		err := bindMount(path, path, true)
+		if err != nil {
+			return err
+		}
+	}
+	for _, name := range maskedProcPaths {
+		path := filepath.Join(proc, name)
+		info, err := os.Lstat(path)
+		if err != nil {
+			continue
+		}
+		if info.IsDir() {
+			err = unix.Mount("tmpfs", path, "tmpfs", unix.MS_RDONLY, "")
+		} else {
+			err = unix.Mount("/dev/null", path, "", unix.MS_BIND, "")
+		}
+		if err != nil {
+			return fmt.Errorf("failed to hide %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// the job keeps its uid but none of the capabilities that go with it, can't
+// get any back by running something setuid and can't use the syscalls that
+// would let it out of its namespaces - so a job run by a root node is not
+// root on the node
+func dropPrivileges() error {
+	// until the kernel says there are no more
+	for capability := 0; ; capability++ {
+		err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(capability), 0, 0, 0)
+		if err == unix.EINVAL {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to drop capability %d: %w", capability, err)
+		}
+	}
+	err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0)
+	if err != nil && err != unix.EINVAL {
+		return fmt.Errorf("failed to clear ambient capabilities: %w", err)
+	}
+	// with nothing inheritable and an empty bounding set exec leaves us none
+	header := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	data := [2]unix.CapUserData{}
+	err = unix.Capget(&header, &data[0])
+	if err != nil {
+		return fmt.Errorf("failed to get capabilities: %w", err)
+	}
+	data[0].Inheritable = 0
+	data[1].Inheritable = 0
+	err = unix.Capset(&header, &data[0])
+	if err != nil {
+		return fmt.Errorf("failed to clear inheritable capabilities: %w", err)
+	}
+
+	err = unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0)
+	if err != nil {
+		return fmt.Errorf("failed to set no_new_privs: %w", err)
+	}
+	filter, err := seccompFilter()
+	if err != nil {
+		return err
+	}
+	program := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	err = unix.Prctl(unix.PR_SET_SECCOMP, unix.SECCOMP_MODE_FILTER, uintptr(unsafe.Pointer(&program)), 0, 0)
+	if err != nil {
+		return fmt.Errorf("failed to apply seccomp filter: %w", err)
+	}
+	return nil
+}
+
+// a tmpfs /dev with the host's harmless devices bound into it
+func setupDev(dev string) error {
+	err := unix.Mount("tmpfs", dev, "tmpfs", unix.MS_NOSUID|unix.MS_NOEXEC, fmt.Sprintf("mode=755,size=%d", devTmpfsSize))
+	if err != nil {
+		return fmt.Errorf("failed to mount /dev: %w", err)
+	}
+	for _, device := range devices {
+		if _, err = os.Stat(filepath.Join("/dev", device)); err != nil {
+			continue
+		}
+		err = bindMount(filepath.Join("/dev", device), filepath.Join(dev, device), false)
+		if err != nil {
+			return err
+		}
+	}
+	for link, target := range map[string]string{
+		"fd":     "/proc/self/fd",
+		"stdin":  "/proc/self/fd/0",
+		"stdout": "/proc/self/fd/1",
+		"stderr": "/proc/self/fd/2",
+	} {
+		err = os.Symlink(target, filepath.Join(dev, link))
+		if err != nil {
+			return err
+		}
+	}
+	return os.Mkdir(filepath.Join(dev, "shm"), os.ModeSticky|os.ModePerm)
+}
+
+func bindMount(source, target string, readOnly bool) error {
+	// mountpoints on a tmpfs we mounted won't have been made yet
+	if _, err := os.Lstat(target); os.IsNotExist(err) {
+		info, err := os.Stat(source)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			err = os.MkdirAll(target, os.ModePerm)
+		} else {
+			err = os.WriteFile(target, nil, os.ModePerm)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	err := unix.Mount(source, target, "", unix.MS_BIND|unix.MS_REC, "")
+	if err != nil {
+		return fmt.Errorf("failed to mount %s: %w", target, err)
+	}
+	if readOnly {
+		return remountReadOnly(target)
+	}
+	return nil
+}
+
+// make a bind mount read only - the flags of the mount it came from have to
+// be kept because a user namespace isn't allowed to clear them
+func remountReadOnly(target string) error {
+	var stat unix.Statfs_t
+	err := unix.Statfs(target, &stat)
+	if err != nil {
+		return err
+	}
+	flags := uintptr(unix.MS_REMOUNT | unix.MS_BIND | unix.MS_RDONLY)
+	for statFlag, mountFlag := range map[int64]uintptr{
+		unix.ST_NOSUID:     unix.MS_NOSUID,
+		unix.ST_NODEV:      unix.MS_NODEV,
+		unix.ST_NOEXEC:     unix.MS_NOEXEC,
+		unix.ST_NOATIME:    unix.MS_NOATIME,
+		unix.ST_NODIRATIME: unix.MS_NODIRATIME,
+		unix.ST_RELATIME:   unix.MS_RELATIME,
+	} {
+		if int64(stat.Flags)&statFlag != 0 {
+			flags |= mountFlag
+		}
+	}
+	err = unix.Mount("", target, "", flags, "")
+	if err != nil {
+		return fmt.Errorf("failed to make %s read only: %w", target, err)
+	}
+	return nil
+}
+
+// find a command on the job's PATH
+func lookPath(command string, env []string) (string, error) {
+	if strings.Contains(command, "/") {
+		return command, nil
+	}
+	path := defaultPath
+	for _, value := range env {
+		if strings.HasPrefix(value, "PATH=") {
+			path = strings.TrimPrefix(value, "PATH=")
+		}
+	}
+	for _, dir := range filepath.SplitList(path) {
+		candidate := filepath.Join(dir, command)
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() && info.Mode()&0111 != 0 {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("%s not found on PATH %s", command, path)
+}
@@ -0,0 +1,23 @@
+//go:build !linux
+
+package native
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// namespaces and cgroups only exist on linux
+func namespacesAvailable() bool {
+	return false
+}
+
+func (e *Executor) runProcess(
+	ctx context.Context,
+	config initConfig,
+	group *cgroup,
+	stdout, stderr io.Writer,
+) (processResult, error) {
+	return processResult{}, fmt.Errorf("the native executor only runs on linux")
+}
@@ -0,0 +1,140 @@
+package native
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/filecoin-project/bacalhau/pkg/storage/util"
+)
+
+// the most symlinks we follow resolving one path, like the kernel's limit
+const maxSymlinks = 255
+
+// securePath resolves a path inside root the way it would be resolved if
+// root were /, so symlinks in the root filesystem can't lead outside of it
+// the path doesn't have to exist
+func securePath(root, unsafePath string) (string, error) {
+	resolved := "/"
+	remaining := unsafePath
+	links := 0
+	for remaining != "" {
+		var component string
+		component, remaining, _ = strings.Cut(strings.TrimPrefix(remaining, "/"), "/")
+		switch component {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		next := filepath.Join(resolved, component)
+		info, err := os.Lstat(filepath.Join(root, next))
+		if os.IsNotExist(err) {
+			resolved = next
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return "", fmt.Errorf("too many symlinks resolving %s", unsafePath)
+		}
+		target, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		// an absolute link starts again from the root filesystem's root
+		if filepath.IsAbs(target) {
+			resolved = "/"
+		}
+		remaining = target + "/" + remaining
+	}
+	return filepath.Join(root, resolved), nil
+}
+
+// make somewhere in the root filesystem to mount a file or directory
+// and return where it is on the host
+func ensureMountpoint(root, target string, isDir bool) (string, error) {
+	path, err := securePath(root, target)
+	if err != nil {
+		return "", err
+	}
+	if isDir {
+		return path, os.MkdirAll(path, util.OS_ALL_R|util.OS_ALL_X|util.OS_USER_W)
+	}
+	err = os.MkdirAll(filepath.Dir(path), util.OS_ALL_R|util.OS_ALL_X|util.OS_USER_W)
+	if err != nil {
+		return "", err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, util.OS_ALL_R|util.OS_USER_W)
+	if err != nil {
+		return "", err
+	}
+	return path, file.Close()
+}
+
+// copy a root filesystem so we can add mountpoints to it without changing
+// the original - files are hard linked when they can be as we never write to them
+func copyTree(source, destination string) error {
+	return filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relative, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(destination, relative)
+
+		switch {
+		case info.IsDir():
+			err = os.MkdirAll(target, util.OS_USER_RWX)
+			if err != nil {
+				return err
+			}
+			return os.Chmod(target, info.Mode().Perm()|util.OS_USER_RWX)
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			if err := os.Link(path, target); err == nil {
+				return nil
+			}
+			return copyFile(path, target, info.Mode())
+		default:
+			// devices and pipes can't be made without privileges
+			// and jobs get their own /dev
+			return nil
+		}
+	})
+}
+
+func copyFile(source, destination string, mode os.FileMode) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(destination, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,118 @@
+//go:build linux && (amd64 || arm64)
+
+package native
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+const (
+	seccompRetAllow = 0x7fff0000
+	seccompRetErrno = 0x00050000
+
+	// offsets into struct seccomp_data
+	seccompDataNr   = 0
+	seccompDataArch = 4
+	// the low half of the first argument, both architectures are little endian
+	seccompDataArg0 = 16
+
+	// x32 syscalls on amd64 have this bit set in their number
+	x32SyscallBit = 0x40000000
+
+	// clone flags that would give the job new namespaces
+	namespaceCloneFlags = unix.CLONE_NEWNS | unix.CLONE_NEWUTS | unix.CLONE_NEWIPC |
+		unix.CLONE_NEWUSER | unix.CLONE_NEWPID | unix.CLONE_NEWNET | unix.CLONE_NEWCGROUP
+)
+
+// syscalls that could let a job out of its namespaces or change the rest of
+// the node - like docker's default profile, but a list of what is denied so
+// jobs keep working with syscalls newer than this list
+var commonDeniedSyscalls = []uint32{
+	unix.SYS_ACCT,
+	unix.SYS_ADD_KEY,
+	unix.SYS_ADJTIMEX,
+	unix.SYS_BPF,
+	unix.SYS_CLOCK_ADJTIME,
+	unix.SYS_CLOCK_SETTIME,
+	unix.SYS_DELETE_MODULE,
+	unix.SYS_FINIT_MODULE,
+	unix.SYS_FSCONFIG,
+	unix.SYS_FSMOUNT,
+	unix.SYS_FSOPEN,
+	unix.SYS_FSPICK,
+	unix.SYS_INIT_MODULE,
+	unix.SYS_KCMP,
+	unix.SYS_KEXEC_FILE_LOAD,
+	unix.SYS_KEXEC_LOAD,
+	unix.SYS_KEYCTL,
+	unix.SYS_LOOKUP_DCOOKIE,
+	unix.SYS_MOUNT,
+	unix.SYS_MOUNT_SETATTR,
+	unix.SYS_MOVE_MOUNT,
+	unix.SYS_NAME_TO_HANDLE_AT,
+	unix.SYS_OPEN_BY_HANDLE_AT,
+	unix.SYS_OPEN_TREE,
+	unix.SYS_PERF_EVENT_OPEN,
+	unix.SYS_PIVOT_ROOT,
+	unix.SYS_PROCESS_VM_READV,
+	unix.SYS_PROCESS_VM_WRITEV,
+	unix.SYS_PTRACE,
+	unix.SYS_QUOTACTL,
+	unix.SYS_REBOOT,
+	unix.SYS_REQUEST_KEY,
+	unix.SYS_SETNS,
+	unix.SYS_SETTIMEOFDAY,
+	unix.SYS_SWAPOFF,
+	unix.SYS_SWAPON,
+	unix.SYS_SYSLOG,
+	unix.SYS_UMOUNT2,
+	unix.SYS_UNSHARE,
+	unix.SYS_USERFAULTFD,
+	unix.SYS_VHANGUP,
+}
+
+func bpfStmt(code uint16, k uint32) unix.SockFilter {
+	return unix.SockFilter{Code: code, K: k}
+}
+
+func bpfJump(code uint16, k uint32, jt, jf uint8) unix.SockFilter {
+	return unix.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+}
+
+func seccompDeny(errno unix.Errno) unix.SockFilter {
+	return bpfStmt(unix.BPF_RET|unix.BPF_K, seccompRetErrno|uint32(errno))
+}
+
+// the filter the job runs under
+func seccompFilter() ([]unix.SockFilter, error) {
+	load := func(offset uint32) unix.SockFilter {
+		return bpfStmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, offset)
+	}
+	equals := func(k uint32, jt, jf uint8) unix.SockFilter {
+		return bpfJump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, k, jt, jf)
+	}
+
+	filter := []unix.SockFilter{
+		// syscalls from another architecture have other numbers
+		load(seccompDataArch),
+		equals(seccompArch, 1, 0),
+		seccompDeny(unix.EPERM),
+		load(seccompDataNr),
+		bpfJump(unix.BPF_JMP|unix.BPF_JGE|unix.BPF_K, x32SyscallBit, 0, 1),
+		seccompDeny(unix.EPERM),
+	}
+	for _, nr := range deniedSyscalls {
+		filter = append(filter, equals(nr, 0, 1), seccompDeny(unix.EPERM))
+	}
+	filter = append(filter,
+		// we can't see clone3's flags but libc falls back to clone if it isn't there
+		equals(unix.SYS_CLONE3, 0, 1),
+		seccompDeny(unix.ENOSYS),
+		equals(unix.SYS_CLONE, 0, 3), //nolint:gomnd // skips the flags check
+		load(seccompDataArg0),
+		bpfJump(unix.BPF_JMP|unix.BPF_JSET|unix.BPF_K, namespaceCloneFlags, 0, 1),
+		seccompDeny(unix.EPERM),
+		bpfStmt(unix.BPF_RET|unix.BPF_K, seccompRetAllow),
+	)
+	return filter, nil
+}
@@ -0,0 +1,13 @@
+package native
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+const seccompArch = unix.AUDIT_ARCH_X86_64
+
+var deniedSyscalls = append([]uint32{
+	unix.SYS_IOPERM,
+	unix.SYS_IOPL,
+	unix.SYS_USELIB,
+}, commonDeniedSyscalls...)
@@ -0,0 +1,9 @@
+package native
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+const seccompArch = unix.AUDIT_ARCH_AARCH64
+
+var deniedSyscalls = commonDeniedSyscalls
@@ -0,0 +1,15 @@
+//go:build linux && !amd64 && !arm64
+
+package native
+
+import (
+	"fmt"
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+// we only know the syscall numbers to filter on amd64 and arm64
+func seccompFilter() ([]unix.SockFilter, error) {
+	return nil, fmt.Errorf("the native executor has no seccomp filter for %s", runtime.GOARCH)
+}
@@ -0,0 +1,64 @@
+package executor
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/filecoin-project/bacalhau/pkg/model"
+)
+
+// ResourceUsageFilename is the file in the results folder that records
+// what the shard actually used - it differs between runs so verifiers
+// must leave it out when comparing results
+const ResourceUsageFilename = "resourceUsage.json"
+
+const resourceUsagePermissions = 0644
+
+// WriteResourceUsage records what a shard used in its results folder
+func WriteResourceUsage(resultsDir string, usage model.ShardResourceUsage) error {
+	data, err := json.Marshal(usage)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(resultsDir, ResourceUsageFilename), data, resourceUsagePermissions)
+}
+
+// ReadResourceUsage returns what a shard used from its results folder
+// the second return value is false if the executor did not record it
+func ReadResourceUsage(resultsDir string) (model.ShardResourceUsage, bool, error) {
+	usage := model.ShardResourceUsage{}
+	data, err := os.ReadFile(filepath.Join(resultsDir, ResourceUsageFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		return usage, false, nil
+	}
+	if err != nil {
+		return usage, false, err
+	}
+	err = json.Unmarshal(data, &usage)
+	if err != nil {
+		return usage, false, err
+	}
+	return usage, true, nil
+}
+
+// OutputsSize is the number of bytes the shard wrote to its output volumes
+func OutputsSize(resultsDir string, outputs []model.StorageSpec) (uint64, error) {
+	var size uint64
+	for _, output := range outputs {
+		err := filepath.Walk(filepath.Join(resultsDir, output.Name), func(_ string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.Mode().IsRegular() {
+				size += uint64(info.Size())
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return 0, err
+		}
+	}
+	return size, nil
+}
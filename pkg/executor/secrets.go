@@ -0,0 +1,114 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/filecoin-project/bacalhau/pkg/model"
+)
+
+type secretsContextKey struct{}
+
+// ContextWithSecrets gives the executor the values of the job's secrets
+// for the shard that is about to be run.
+func ContextWithSecrets(ctx context.Context, values map[string]string) context.Context {
+	return context.WithValue(ctx, secretsContextKey{}, values)
+}
+
+// SecretsFromContext returns the values of the job's secrets by name.
+func SecretsFromContext(ctx context.Context) map[string]string {
+	values, _ := ctx.Value(secretsContextKey{}).(map[string]string)
+	return values
+}
+
+func secretValue(ctx context.Context, secret model.JobSpecSecret) (string, error) {
+	value, ok := SecretsFromContext(ctx)[secret.Name]
+	if !ok {
+		return "", fmt.Errorf("no value was given for secret %s", secret.Name)
+	}
+	return value, nil
+}
+
+// SecretEnv returns the secrets the job is given in env vars in KEY=VALUE form
+func SecretEnv(ctx context.Context, spec model.JobSpec) ([]string, error) {
+	env := []string{}
+	for _, secret := range spec.Secrets {
+		if secret.Env == "" {
+			continue
+		}
+		value, err := secretValue(ctx, secret)
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, fmt.Sprintf("%s=%s", secret.Env, value))
+	}
+	return env, nil
+}
+
+// SecretFile is a file holding a secret on the host that
+// the executor mounts read only at Target
+type SecretFile struct {
+	Source string
+	Target string
+}
+
+// somewhere in memory if we can so the secrets never reach the disk
+func secretsTempDir() string {
+	if info, err := os.Stat("/dev/shm"); err == nil && info.IsDir() {
+		return "/dev/shm"
+	}
+	return ""
+}
+
+// WriteSecretFiles writes the secrets the job is given in files to a new
+// directory that only we can get into, which the caller removes once the
+// shard has run. The files that go in the same directory in the job are
+// put in the same directory under their own names, so an executor that
+// can only mount directories can mount that.
+func WriteSecretFiles(ctx context.Context, spec model.JobSpec) (string, []SecretFile, error) {
+	files := []SecretFile{}
+	hasFiles := false
+	for _, secret := range spec.Secrets {
+		hasFiles = hasFiles || secret.Path != ""
+	}
+	if !hasFiles {
+		return "", files, nil
+	}
+	dir, err := os.MkdirTemp(secretsTempDir(), "bacalhau-secrets-")
+	if err != nil {
+		return "", nil, err
+	}
+	hostDirs := map[string]string{}
+	for _, secret := range spec.Secrets {
+		if secret.Path == "" {
+			continue
+		}
+		value, err := secretValue(ctx, secret)
+		if err != nil {
+			os.RemoveAll(dir)
+			return "", nil, err
+		}
+		guestDir := filepath.Dir(secret.Path)
+		hostDir, ok := hostDirs[guestDir]
+		if !ok {
+			hostDir = filepath.Join(dir, fmt.Sprintf("%d", len(hostDirs)))
+			err = os.Mkdir(hostDir, 0755) //nolint:gomnd
+			if err != nil {
+				os.RemoveAll(dir)
+				return "", nil, err
+			}
+			hostDirs[guestDir] = hostDir
+		}
+		// the job may not run as us so the file itself has to be readable
+		source := filepath.Join(hostDir, filepath.Base(secret.Path))
+		err = os.WriteFile(source, []byte(value), 0444) //nolint:gomnd
+		if err != nil {
+			os.RemoveAll(dir)
+			return "", nil, err
+		}
+		files = append(files, SecretFile{Source: source, Target: secret.Path})
+	}
+	return dir, files, nil
+}
@@ -2,6 +2,10 @@ package executor
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
 
 	"github.com/filecoin-project/bacalhau/pkg/model"
 )
@@ -29,3 +33,128 @@ type Executor interface {
 		resultsDir string,
 	) error
 }
+
+// InputPrefetcher is implemented by executors that can start fetching
+// the inputs for a shard before they are asked to run it - this lets the
+// compute node download data while it waits to hear if its bid was accepted
+type InputPrefetcher interface {
+	// prepare the storage for the shard inputs so that a later
+	// RunShard for the same shard can use it straight away
+	PrefetchShardInputs(ctx context.Context, shard model.JobShard) error
+
+	// throw away anything that was prefetched for the shard
+	// and not used by RunShard
+	CleanupPrefetchedInputs(ctx context.Context, shard model.JobShard) error
+}
+
+// ImageBuilder is implemented by executors that can make a new image by
+// running a setup command on top of an existing one - the language
+// executor uses it to install a job's requirements once and reuse them
+type ImageBuilder interface {
+	// HasImage returns true if the image is available locally
+	HasImage(ctx context.Context, image string) (bool, error)
+	// BuildImage runs the request's command in its base image and
+	// saves the result as a new image
+	BuildImage(ctx context.Context, request BuildImageRequest) error
+}
+
+type BuildImageRequest struct {
+	// the image to start from
+	BaseImage string
+	// the name to save the result as
+	Image string
+	// files to copy into the container before the command runs
+	// keyed by their absolute path in the container
+	Files map[string][]byte
+	// the command to run
+	Command []string
+	// the network access the command has
+	Network model.NetworkConfig
+	// where the output of the command goes
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+type gpuDevicesContextKey struct{}
+
+// ContextWithGPUDevices records the GPU device IDs that the compute node
+// has allocated to the shard that is about to be run.
+func ContextWithGPUDevices(ctx context.Context, deviceIDs []string) context.Context {
+	return context.WithValue(ctx, gpuDevicesContextKey{}, deviceIDs)
+}
+
+// GPUDevicesFromContext returns the GPU device IDs allocated to the shard
+// being run, or nil if none were allocated.
+func GPUDevicesFromContext(ctx context.Context) []string {
+	deviceIDs, _ := ctx.Value(gpuDevicesContextKey{}).([]string)
+	return deviceIDs
+}
+
+type logWritersContextKey struct{}
+
+type logWriters struct {
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// ContextWithLogWriters gives the executor somewhere to copy the output
+// of the shard to as it is produced, so it can be followed while it runs.
+func ContextWithLogWriters(ctx context.Context, stdout, stderr io.Writer) context.Context {
+	return context.WithValue(ctx, logWritersContextKey{}, logWriters{stdout: stdout, stderr: stderr})
+}
+
+// LogWritersFromContext returns where the executor should copy the live
+// output of the shard to - these discard everything if nothing was set.
+func LogWritersFromContext(ctx context.Context) (stdout, stderr io.Writer) {
+	writers, ok := ctx.Value(logWritersContextKey{}).(logWriters)
+	if !ok {
+		return io.Discard, io.Discard
+	}
+	return writers.stdout, writers.stderr
+}
+
+// StatusReporter is given a short description of what the executor is
+// doing with a shard before it is running, like how far an image pull got
+type StatusReporter func(status string)
+
+type statusReporterContextKey struct{}
+
+// ContextWithStatusReporter gives the executor somewhere to report the
+// progress of the shard that is about to be run.
+func ContextWithStatusReporter(ctx context.Context, reporter StatusReporter) context.Context {
+	return context.WithValue(ctx, statusReporterContextKey{}, reporter)
+}
+
+// StatusReporterFromContext returns where the executor should report the
+// progress of the shard - this does nothing if nothing was set.
+func StatusReporterFromContext(ctx context.Context) StatusReporter {
+	reporter, ok := ctx.Value(statusReporterContextKey{}).(StatusReporter)
+	if !ok {
+		return func(string) {}
+	}
+	return reporter
+}
+
+// ErrExecutionTimeout is wrapped by the error an executor returns
+// when a shard runs for longer than it was allowed to
+var ErrExecutionTimeout = errors.New("execution timed out")
+
+// NewExecutionTimeoutError is the error for a shard that was killed after timeout
+func NewExecutionTimeoutError(timeout time.Duration) error {
+	return fmt.Errorf("%w after %s", ErrExecutionTimeout, timeout)
+}
+
+type executionTimeoutContextKey struct{}
+
+// ContextWithExecutionTimeout records how long the shard can run for
+// the executor kills the workload once this has passed
+func ContextWithExecutionTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, executionTimeoutContextKey{}, timeout)
+}
+
+// ExecutionTimeoutFromContext returns how long the shard can run for
+// or zero if there is no limit
+func ExecutionTimeoutFromContext(ctx context.Context) time.Duration {
+	timeout, _ := ctx.Value(executionTimeoutContextKey{}).(time.Duration)
+	return timeout
+}
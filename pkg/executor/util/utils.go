@@ -6,14 +6,20 @@ import (
 	"github.com/filecoin-project/bacalhau/pkg/executor"
 	"github.com/filecoin-project/bacalhau/pkg/executor/docker"
 	"github.com/filecoin-project/bacalhau/pkg/executor/language"
+	"github.com/filecoin-project/bacalhau/pkg/executor/native"
 	noop_executor "github.com/filecoin-project/bacalhau/pkg/executor/noop"
 	pythonwasm "github.com/filecoin-project/bacalhau/pkg/executor/python_wasm"
+	"github.com/filecoin-project/bacalhau/pkg/executor/wasm"
 	"github.com/filecoin-project/bacalhau/pkg/model"
 	"github.com/filecoin-project/bacalhau/pkg/storage"
+	"github.com/filecoin-project/bacalhau/pkg/storage/archive"
 	"github.com/filecoin-project/bacalhau/pkg/storage/combo"
 	filecoinunsealed "github.com/filecoin-project/bacalhau/pkg/storage/filecoin_unsealed"
+	"github.com/filecoin-project/bacalhau/pkg/storage/git"
+	"github.com/filecoin-project/bacalhau/pkg/storage/inputcache"
 	apicopy "github.com/filecoin-project/bacalhau/pkg/storage/ipfs_apicopy"
 	noop_storage "github.com/filecoin-project/bacalhau/pkg/storage/noop"
+	"github.com/filecoin-project/bacalhau/pkg/storage/s3"
 	"github.com/filecoin-project/bacalhau/pkg/storage/url/urldownload"
 	"github.com/filecoin-project/bacalhau/pkg/system"
 )
@@ -21,12 +27,21 @@ import (
 type StandardStorageProviderOptions struct {
 	IPFSMultiaddress     string
 	FilecoinUnsealedPath string
+	S3                   s3.Config
+	Git                  git.Config
+	Archive              archive.Config
+	URLRequireChecksum   bool
+	// shared by the storage providers that download inputs - can be nil
+	InputCache *inputcache.InputCache
 }
 
 type StandardExecutorOptions struct {
-	DockerID   string
-	IsBadActor bool
-	Storage    StandardStorageProviderOptions
+	DockerID      string
+	DockerSandbox docker.SandboxConfig
+	DockerImages  docker.ImageConfig
+	Native        native.Config
+	IsBadActor    bool
+	Storage       StandardStorageProviderOptions
 }
 
 func NewStandardStorageProviders(
@@ -38,17 +53,31 @@ func NewStandardStorageProviders(
 	if err != nil {
 		return nil, err
 	}
+	ipfsAPICopyStorage.InputCache = options.InputCache
 
 	urlDownloadStorage, err := urldownload.NewStorageProvider(cm)
 	if err != nil {
 		return nil, err
 	}
+	urlDownloadStorage.InputCache = options.InputCache
+	urlDownloadStorage.RequireChecksum = options.URLRequireChecksum
 
 	filecoinUnsealedStorage, err := filecoinunsealed.NewStorageProvider(cm, options.FilecoinUnsealedPath)
 	if err != nil {
 		return nil, err
 	}
 
+	s3Storage, err := s3.NewStorageProvider(cm, options.S3)
+	if err != nil {
+		return nil, err
+	}
+
+	gitStorage, err := git.NewStorageProvider(cm, options.Git)
+	if err != nil {
+		return nil, err
+	}
+	gitStorage.InputCache = options.InputCache
+
 	var useIPFSDriver storage.StorageProvider = ipfsAPICopyStorage
 
 	// if we are using a FilecoinUnsealedPath then construct a combo
@@ -86,11 +115,14 @@ func NewStandardStorageProviders(
 		useIPFSDriver = comboDriver
 	}
 
-	return map[model.StorageSourceType]storage.StorageProvider{
+	// any of them can be asked to extract an archive
+	return archive.WrapStorageProviders(cm, map[model.StorageSourceType]storage.StorageProvider{
 		model.StorageSourceIPFS:             useIPFSDriver,
 		model.StorageSourceURLDownload:      urlDownloadStorage,
 		model.StorageSourceFilecoinUnsealed: filecoinUnsealedStorage,
-	}, nil
+		model.StorageSourceS3:               s3Storage,
+		model.StorageSourceGit:              gitStorage,
+	}, options.Archive, options.InputCache)
 }
 
 func NewNoopStorageProviders(
@@ -118,19 +150,38 @@ func NewStandardExecutors(
 		return nil, err
 	}
 
-	dockerExecutor, err := docker.NewExecutor(ctx, cm, executorOptions.DockerID, storageProviders)
+	dockerExecutor, err := docker.NewExecutor(
+		ctx,
+		cm,
+		executorOptions.DockerID,
+		storageProviders,
+		executorOptions.DockerSandbox,
+		executorOptions.DockerImages,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	wasmExecutor, err := wasm.NewExecutor(ctx, cm, storageProviders)
+	if err != nil {
+		return nil, err
+	}
 
+	nativeExecutor, err := native.NewExecutor(ctx, cm, storageProviders, executorOptions.Native)
 	if err != nil {
 		return nil, err
 	}
 
 	executors := map[model.EngineType]executor.Executor{
 		model.EngineDocker: dockerExecutor,
+		model.EngineWasm:   wasmExecutor,
+		model.EngineNative: nativeExecutor,
 	}
 
 	// language executors wrap other executors, so pass them a reference to all
 	// the executors so they can look up the ones they need
-	exLang, err := language.NewExecutor(ctx, cm, executors)
+	exLang, err := language.NewExecutor(ctx, cm, executors, storageProviders)
 	executors[model.EngineLanguage] = exLang
 	if err != nil {
 		return nil, err
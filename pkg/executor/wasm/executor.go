@@ -0,0 +1,444 @@
+package wasm
+
+/*
+The wasm executor runs WASI modules in process using wazero so jobs can be run
+on nodes that don't have docker. Input and output volumes are mapped to WASI
+preopened directories at the same paths a docker job would see them.
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/experimental"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/filecoin-project/bacalhau/pkg/capacitymanager"
+	"github.com/filecoin-project/bacalhau/pkg/executor"
+	jobutils "github.com/filecoin-project/bacalhau/pkg/job"
+	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/filecoin-project/bacalhau/pkg/storage"
+	"github.com/filecoin-project/bacalhau/pkg/storage/util"
+	"github.com/filecoin-project/bacalhau/pkg/system"
+)
+
+const (
+	DefaultEntryPoint = "_start"
+
+	// wasm memory is allocated in 64KiB pages and a module can address at most 4GiB
+	wasmPageSize     = 65536
+	wasmMaxPageCount = 65536
+)
+
+type Executor struct {
+	// the storage providers we can implement for a job
+	StorageProviders map[model.StorageSourceType]storage.StorageProvider
+}
+
+func NewExecutor(
+	ctx context.Context,
+	cm *system.CleanupManager,
+	storageProviders map[model.StorageSourceType]storage.StorageProvider,
+) (*Executor, error) {
+	return &Executor{
+		StorageProviders: storageProviders,
+	}, nil
+}
+
+func (e *Executor) getStorageProvider(ctx context.Context, engine model.StorageSourceType) (storage.StorageProvider, error) {
+	return util.GetStorageProvider(ctx, engine, e.StorageProviders)
+}
+
+// IsInstalled is always true as the runtime is compiled in
+func (e *Executor) IsInstalled(ctx context.Context) (bool, error) {
+	return true, nil
+}
+
+func (e *Executor) HasStorageLocally(ctx context.Context, volume model.StorageSpec) (bool, error) {
+	ctx, span := newSpan(ctx, "HasStorageLocally")
+	defer span.End()
+
+	s, err := e.getStorageProvider(ctx, volume.Engine)
+	if err != nil {
+		return false, err
+	}
+
+	return s.HasStorageLocally(ctx, volume)
+}
+
+func (e *Executor) GetVolumeSize(ctx context.Context, volume model.StorageSpec) (uint64, error) {
+	storageProvider, err := e.getStorageProvider(ctx, volume.Engine)
+	if err != nil {
+		return 0, err
+	}
+	return storageProvider.GetVolumeSize(ctx, volume)
+}
+
+//nolint:funlen // mirrors the steps of the docker executor
+func (e *Executor) RunShard(
+	ctx context.Context,
+	shard model.JobShard,
+	jobResultsDir string,
+) error {
+	ctx, span := system.GetTracer().Start(ctx, "pkg/executor/wasm.RunShard")
+	defer span.End()
+	system.AddJobIDFromBaggageToSpan(ctx, span)
+	system.AddNodeIDFromBaggageToSpan(ctx, span)
+
+	wasmSpec := shard.Job.Spec.Wasm
+	entryPoint := wasmSpec.EntryPoint
+	if entryPoint == "" {
+		entryPoint = DefaultEntryPoint
+	}
+
+	// volumes we prepared and must clean up once the module has finished
+	prepared := []preparedVolume{}
+	defer func() {
+		for _, p := range prepared {
+			if err := p.provider.CleanupStorage(ctx, p.spec, p.volume); err != nil {
+				log.Warn().Msgf("Could not clean up volume %s for shard %s: %s", p.volume.Source, shard, err)
+			}
+		}
+	}()
+	prepare := func(spec model.StorageSpec) (storage.StorageVolume, error) {
+		storageProvider, err := e.getStorageProvider(ctx, spec.Engine)
+		if err != nil {
+			return storage.StorageVolume{}, err
+		}
+		volume, err := storageProvider.PrepareStorage(ctx, spec)
+		if err != nil {
+			return storage.StorageVolume{}, err
+		}
+		prepared = append(prepared, preparedVolume{provider: storageProvider, spec: spec, volume: volume})
+		if volume.Type != storage.StorageVolumeConnectorBind {
+			return storage.StorageVolume{}, fmt.Errorf("unknown storage volume type: %s", volume.Type)
+		}
+		return volume, nil
+	}
+
+	moduleVolume, err := prepare(wasmSpec.EntryModule)
+	if err != nil {
+		return fmt.Errorf("failed to prepare entry module: %w", err)
+	}
+	moduleBytes, err := os.ReadFile(moduleVolume.Source)
+	if err != nil {
+		return fmt.Errorf("failed to read entry module (it must be a single .wasm file): %w", err)
+	}
+
+	shardStorageSpec, err := jobutils.GetShardStorageSpec(ctx, shard, e.StorageProviders)
+	if err != nil {
+		return err
+	}
+	inputs := []model.StorageSpec{}
+	inputs = append(inputs, shard.Job.Spec.Contexts...)
+	inputs = append(inputs, shardStorageSpec...)
+
+	// WASI can only preopen directories so single file inputs are linked
+	// into a folder that is mounted where the file's parent would be
+	stagingDir, err := ioutil.TempDir("", "bacalhau-wasm-inputs")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(stagingDir)
+	fileMounts := map[string]string{}
+
+	fsConfig := wazero.NewFSConfig()
+	for _, input := range inputs {
+		var volume storage.StorageVolume
+		volume, err = prepare(input)
+		if err != nil {
+			return err
+		}
+		log.Trace().Msgf("Input Volume: %+v %+v", input, volume)
+
+		var info os.FileInfo
+		info, err = os.Stat(volume.Source)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			fsConfig = fsConfig.WithReadOnlyDirMount(volume.Source, volume.Target)
+			continue
+		}
+
+		guestDir := filepath.Dir(volume.Target)
+		hostDir, ok := fileMounts[guestDir]
+		if !ok {
+			hostDir = filepath.Join(stagingDir, fmt.Sprintf("%d", len(fileMounts)))
+			err = os.Mkdir(hostDir, util.OS_USER_RWX)
+			if err != nil {
+				return err
+			}
+			fileMounts[guestDir] = hostDir
+			fsConfig = fsConfig.WithReadOnlyDirMount(hostDir, guestDir)
+		}
+		err = linkOrCopy(volume.Source, filepath.Join(hostDir, filepath.Base(volume.Target)))
+		if err != nil {
+			return err
+		}
+	}
+
+	// wasm modules can only be given directories so we mount the
+	// directories the secret files are in on their own
+	secretsDir, secretFiles, err := executor.WriteSecretFiles(ctx, shard.Job.Spec)
+	if err != nil {
+		return err
+	}
+	if secretsDir != "" {
+		defer os.RemoveAll(secretsDir)
+	}
+	secretMounts := map[string]bool{}
+	for _, secretFile := range secretFiles {
+		guestDir := filepath.Dir(secretFile.Target)
+		if _, ok := fileMounts[guestDir]; ok {
+			return fmt.Errorf("secret %s is in the same directory as an input file", secretFile.Target)
+		}
+		if !secretMounts[guestDir] {
+			secretMounts[guestDir] = true
+			fsConfig = fsConfig.WithReadOnlyDirMount(filepath.Dir(secretFile.Source), guestDir)
+		}
+	}
+	secretEnv, err := executor.SecretEnv(ctx, shard.Job.Spec)
+	if err != nil {
+		return err
+	}
+
+	for _, output := range shard.Job.Spec.Outputs {
+		if output.Name == "" {
+			return fmt.Errorf("output volume has no name: %+v", output)
+		}
+
+		if output.Path == "" {
+			return fmt.Errorf("output volume has no path: %+v", output)
+		}
+
+		srcd := filepath.Join(jobResultsDir, output.Name)
+		err = os.Mkdir(srcd, util.OS_ALL_R|util.OS_ALL_X|util.OS_USER_W)
+		if err != nil {
+			return err
+		}
+
+		log.Trace().Msgf("Output Volume: %+v", output)
+		fsConfig = fsConfig.WithDirMount(srcd, output.Path)
+	}
+
+	// the module is stopped by cancelling this context, either because it
+	// has run out of fuel, has run for too long or the shard was cancelled
+	timeout := executor.ExecutionTimeoutFromContext(ctx)
+	var runCtx context.Context
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		runCtx, cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		runCtx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
+	runtimeConfig := wazero.NewRuntimeConfig().
+		WithCloseOnContextDone(true)
+	resourceRequirements := capacitymanager.ParseResourceUsageConfig(shard.Job.Spec.Resources)
+	if resourceRequirements.Memory > 0 {
+		runtimeConfig = runtimeConfig.WithMemoryLimitPages(memoryLimitPages(resourceRequirements.Memory))
+	}
+
+	var fuelExhausted int32
+	if wasmSpec.Fuel > 0 {
+		runCtx = context.WithValue(runCtx, experimental.FunctionListenerFactoryKey{}, newFuelListenerFactory(wasmSpec.Fuel, func() {
+			atomic.StoreInt32(&fuelExhausted, 1)
+			cancel()
+		}))
+	}
+
+	runtime := wazero.NewRuntimeWithConfig(runCtx, runtimeConfig)
+	defer runtime.Close(ctx)
+
+	_, err = wasi_snapshot_preview1.Instantiate(runCtx, runtime)
+	if err != nil {
+		return fmt.Errorf("failed to set up WASI: %w", err)
+	}
+
+	compiled, err := runtime.CompileModule(runCtx, moduleBytes)
+	if err != nil {
+		return fmt.Errorf("failed to compile entry module: %w", err)
+	}
+
+	// write the output to the results and copy it to
+	// anyone following the shard as it is produced
+	logs, err := executor.NewShardLogs(ctx, jobResultsDir)
+	if err != nil {
+		return fmt.Errorf("failed to create log files: %w", err)
+	}
+	defer logs.Close()
+	moduleConfig := wazero.NewModuleConfig().
+		WithName("").
+		WithArgs(append([]string{"main.wasm"}, wasmSpec.Parameters...)...).
+		WithStdout(logs.Stdout).
+		WithStderr(logs.Stderr).
+		WithFSConfig(fsConfig).
+		WithSysWalltime().
+		WithSysNanotime().
+		// we call the entry point ourselves so that we can see the exit code
+		WithStartFunctions()
+	for _, env := range append(wasmSpec.Env, secretEnv...) {
+		key, value, _ := strings.Cut(env, "=")
+		moduleConfig = moduleConfig.WithEnv(key, value)
+	}
+
+	var exitCode uint32
+	var runError error
+	var memory api.Memory
+	startedAt := time.Now()
+	module, err := runtime.InstantiateModule(runCtx, compiled, moduleConfig)
+	if err != nil {
+		runError = fmt.Errorf("failed to instantiate entry module: %w", err)
+	} else {
+		memory = module.Memory()
+		entry := module.ExportedFunction(entryPoint)
+		if entry == nil {
+			runError = fmt.Errorf("entry module does not export %s", entryPoint)
+		} else {
+			_, runError = entry.Call(runCtx)
+		}
+	}
+
+	// the module is single threaded and runs on this goroutine so the
+	// time it spent on the cpu is close to the time it took
+	wallTime := time.Since(startedAt).Seconds()
+	resourceUsage := model.ShardResourceUsage{
+		CPUSeconds: wallTime,
+		WallTime:   wallTime,
+	}
+	if memory != nil {
+		// wasm memory can only grow so its final size is its peak
+		resourceUsage.MemoryPeak = uint64(memory.Size())
+	}
+
+	var exitError *sys.ExitError
+	if errors.As(runError, &exitError) {
+		exitCode = exitError.ExitCode()
+		runError = nil
+		switch {
+		case atomic.LoadInt32(&fuelExhausted) == 1:
+			runError = fmt.Errorf("module ran out of fuel after %d function calls", wasmSpec.Fuel)
+		case exitCode != 0:
+			runError = fmt.Errorf("exit code was not zero: %d", exitCode)
+		}
+	} else if runError != nil {
+		// the module trapped or we could not start it
+		exitCode = 1
+		_, _ = io.WriteString(logs.Stderr, runError.Error()+"\n")
+	}
+	if timeout > 0 && runCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		runError = executor.NewExecutionTimeoutError(timeout)
+	}
+	if runError != nil {
+		log.Info().Msgf("wasm module error %s", runError)
+	}
+
+	err = logs.Close()
+	if err != nil {
+		msg := fmt.Sprintf("could not write results to stdout and stderr: %s", err)
+		log.Error().Msg(msg)
+		return errors.New(msg)
+	}
+	err = os.WriteFile(
+		filepath.Join(jobResultsDir, "exitCode"),
+		[]byte(fmt.Sprintf("%d", exitCode)),
+		util.OS_ALL_R|util.OS_USER_RW,
+	)
+	if err != nil {
+		msg := fmt.Sprintf("could not write results to exitCode: %s", err)
+		log.Error().Msg(msg)
+		return errors.New(msg)
+	}
+
+	resourceUsage.DiskWritten, err = executor.OutputsSize(jobResultsDir, shard.Job.Spec.Outputs)
+	if err != nil {
+		log.Debug().Msgf("Could not measure the outputs of %s: %s", shard, err)
+	}
+	err = executor.WriteResourceUsage(jobResultsDir, resourceUsage)
+	if err != nil {
+		msg := fmt.Sprintf("could not write results to %s: %s", executor.ResourceUsageFilename, err)
+		log.Error().Msg(msg)
+		return errors.New(msg)
+	}
+
+	return runError
+}
+
+type preparedVolume struct {
+	provider storage.StorageProvider
+	spec     model.StorageSpec
+	volume   storage.StorageVolume
+}
+
+// turn a memory limit in bytes into wasm pages, rounding down
+func memoryLimitPages(memory uint64) uint32 {
+	pages := memory / wasmPageSize
+	if pages < 1 {
+		pages = 1
+	}
+	if pages > wasmMaxPageCount {
+		pages = wasmMaxPageCount
+	}
+	return uint32(pages)
+}
+
+// hard link a file if we can because it's free, otherwise copy it
+func linkOrCopy(source, destination string) error {
+	if err := os.Link(source, destination); err == nil {
+		return nil
+	}
+	data, err := os.ReadFile(source)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(destination, data, util.OS_ALL_R|util.OS_USER_RW)
+}
+
+// wazero doesn't meter instructions so fuel is spent one unit per
+// function call - when it runs out the module is stopped
+type fuelListener struct {
+	remaining int64
+	exhausted func()
+}
+
+func newFuelListenerFactory(fuel uint64, exhausted func()) experimental.FunctionListenerFactory {
+	listener := &fuelListener{
+		remaining: int64(fuel),
+		exhausted: exhausted,
+	}
+	return experimental.FunctionListenerFactoryFunc(func(api.FunctionDefinition) experimental.FunctionListener {
+		return listener
+	})
+}
+
+func (l *fuelListener) Before(context.Context, api.Module, api.FunctionDefinition, []uint64, experimental.StackIterator) {
+	if atomic.AddInt64(&l.remaining, -1) == -1 {
+		l.exhausted()
+	}
+}
+
+func (l *fuelListener) After(context.Context, api.Module, api.FunctionDefinition, []uint64) {}
+
+func (l *fuelListener) Abort(context.Context, api.Module, api.FunctionDefinition, error) {}
+
+func newSpan(ctx context.Context, apiName string) (context.Context, trace.Span) {
+	return system.Span(ctx, "executor/wasm", apiName)
+}
+
+// Compile-time interface check:
+var _ executor.Executor = (*Executor)(nil)
@@ -0,0 +1,191 @@
+package wasm
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/bacalhau/pkg/executor"
+	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/filecoin-project/bacalhau/pkg/storage"
+	noop_storage "github.com/filecoin-project/bacalhau/pkg/storage/noop"
+	"github.com/filecoin-project/bacalhau/pkg/system"
+	"github.com/stretchr/testify/require"
+)
+
+// a WASI module with four exports:
+//   - _start writes "hello\n" to stdout and returns
+//   - fail calls proc_exit(3)
+//   - spin writes "hello\n" to stdout then calls an empty function in a
+//     loop forever
+//   - copy copies in.txt from the first preopened directory to out.txt in
+//     the second, calling proc_exit with the errno if anything fails
+var testModule = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00, 0x01, 0x1d, 0x04, 0x60, 0x04, 0x7f, 0x7f, 0x7f,
+	0x7f, 0x01, 0x7f, 0x60, 0x01, 0x7f, 0x00, 0x60, 0x00, 0x00, 0x60, 0x09, 0x7f, 0x7f, 0x7f, 0x7f,
+	0x7f, 0x7e, 0x7e, 0x7f, 0x7f, 0x01, 0x7f, 0x02, 0x8a, 0x01, 0x04, 0x16, 0x77, 0x61, 0x73, 0x69,
+	0x5f, 0x73, 0x6e, 0x61, 0x70, 0x73, 0x68, 0x6f, 0x74, 0x5f, 0x70, 0x72, 0x65, 0x76, 0x69, 0x65,
+	0x77, 0x31, 0x08, 0x66, 0x64, 0x5f, 0x77, 0x72, 0x69, 0x74, 0x65, 0x00, 0x00, 0x16, 0x77, 0x61,
+	0x73, 0x69, 0x5f, 0x73, 0x6e, 0x61, 0x70, 0x73, 0x68, 0x6f, 0x74, 0x5f, 0x70, 0x72, 0x65, 0x76,
+	0x69, 0x65, 0x77, 0x31, 0x09, 0x70, 0x72, 0x6f, 0x63, 0x5f, 0x65, 0x78, 0x69, 0x74, 0x00, 0x01,
+	0x16, 0x77, 0x61, 0x73, 0x69, 0x5f, 0x73, 0x6e, 0x61, 0x70, 0x73, 0x68, 0x6f, 0x74, 0x5f, 0x70,
+	0x72, 0x65, 0x76, 0x69, 0x65, 0x77, 0x31, 0x07, 0x66, 0x64, 0x5f, 0x72, 0x65, 0x61, 0x64, 0x00,
+	0x00, 0x16, 0x77, 0x61, 0x73, 0x69, 0x5f, 0x73, 0x6e, 0x61, 0x70, 0x73, 0x68, 0x6f, 0x74, 0x5f,
+	0x70, 0x72, 0x65, 0x76, 0x69, 0x65, 0x77, 0x31, 0x09, 0x70, 0x61, 0x74, 0x68, 0x5f, 0x6f, 0x70,
+	0x65, 0x6e, 0x00, 0x03, 0x03, 0x06, 0x05, 0x02, 0x02, 0x02, 0x02, 0x02, 0x05, 0x03, 0x01, 0x00,
+	0x01, 0x07, 0x28, 0x05, 0x06, 0x6d, 0x65, 0x6d, 0x6f, 0x72, 0x79, 0x02, 0x00, 0x06, 0x5f, 0x73,
+	0x74, 0x61, 0x72, 0x74, 0x00, 0x04, 0x04, 0x66, 0x61, 0x69, 0x6c, 0x00, 0x05, 0x04, 0x73, 0x70,
+	0x69, 0x6e, 0x00, 0x07, 0x04, 0x63, 0x6f, 0x70, 0x79, 0x00, 0x08, 0x0a, 0xc2, 0x01, 0x05, 0x1b,
+	0x00, 0x41, 0x00, 0x41, 0x10, 0x36, 0x02, 0x00, 0x41, 0x04, 0x41, 0x06, 0x36, 0x02, 0x00, 0x41,
+	0x01, 0x41, 0x00, 0x41, 0x01, 0x41, 0x08, 0x10, 0x00, 0x1a, 0x0b, 0x06, 0x00, 0x41, 0x03, 0x10,
+	0x01, 0x0b, 0x02, 0x00, 0x0b, 0x0b, 0x00, 0x10, 0x04, 0x03, 0x40, 0x10, 0x06, 0x0c, 0x00, 0x0b,
+	0x0b, 0x8d, 0x01, 0x01, 0x01, 0x7f, 0x41, 0x03, 0x41, 0x00, 0x41, 0x20, 0x41, 0x06, 0x41, 0x00,
+	0x42, 0x02, 0x42, 0x00, 0x41, 0x00, 0x41, 0x0c, 0x10, 0x03, 0x21, 0x00, 0x20, 0x00, 0x04, 0x40,
+	0x20, 0x00, 0x10, 0x01, 0x0b, 0x41, 0x00, 0x41, 0x80, 0x08, 0x36, 0x02, 0x00, 0x41, 0x04, 0x41,
+	0x80, 0x08, 0x36, 0x02, 0x00, 0x41, 0x0c, 0x28, 0x02, 0x00, 0x41, 0x00, 0x41, 0x01, 0x41, 0x08,
+	0x10, 0x02, 0x21, 0x00, 0x20, 0x00, 0x04, 0x40, 0x20, 0x00, 0x10, 0x01, 0x0b, 0x41, 0x04, 0x41,
+	0x08, 0x28, 0x02, 0x00, 0x36, 0x02, 0x00, 0x41, 0x04, 0x41, 0x00, 0x41, 0x30, 0x41, 0x07, 0x41,
+	0x01, 0x42, 0xc0, 0x00, 0x42, 0x00, 0x41, 0x00, 0x41, 0x0c, 0x10, 0x03, 0x21, 0x00, 0x20, 0x00,
+	0x04, 0x40, 0x20, 0x00, 0x10, 0x01, 0x0b, 0x41, 0x0c, 0x28, 0x02, 0x00, 0x41, 0x00, 0x41, 0x01,
+	0x41, 0x08, 0x10, 0x00, 0x21, 0x00, 0x20, 0x00, 0x04, 0x40, 0x20, 0x00, 0x10, 0x01, 0x0b, 0x0b,
+	0x0b, 0x23, 0x03, 0x00, 0x41, 0x10, 0x0b, 0x06, 0x68, 0x65, 0x6c, 0x6c, 0x6f, 0x0a, 0x00, 0x41,
+	0x20, 0x0b, 0x06, 0x69, 0x6e, 0x2e, 0x74, 0x78, 0x74, 0x00, 0x41, 0x30, 0x0b, 0x07, 0x6f, 0x75,
+	0x74, 0x2e, 0x74, 0x78, 0x74,
+}
+
+// an executor whose IPFS inputs are the test module, or the contents of
+// files for any other CID
+func newTestExecutor(t *testing.T, files map[string]string) *Executor {
+	dir := t.TempDir()
+	modulePath := filepath.Join(dir, "main.wasm")
+	require.NoError(t, os.WriteFile(modulePath, testModule, 0600))
+	for cid, contents := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, cid), []byte(contents), 0600))
+	}
+
+	cm := system.NewCleanupManager()
+	t.Cleanup(cm.Cleanup)
+	storageProvider, err := noop_storage.NewStorageProvider(context.Background(), cm, noop_storage.StorageConfig{
+		ExternalHooks: noop_storage.StorageConfigExternalHooks{
+			PrepareStorage: func(ctx context.Context, spec model.StorageSpec) (storage.StorageVolume, error) {
+				source := modulePath
+				if _, ok := files[spec.Cid]; ok {
+					source = filepath.Join(dir, spec.Cid)
+				}
+				return storage.StorageVolume{
+					Type:   storage.StorageVolumeConnectorBind,
+					Source: source,
+					Target: spec.Path,
+				}, nil
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	e, err := NewExecutor(context.Background(), cm, map[model.StorageSourceType]storage.StorageProvider{
+		model.StorageSourceIPFS: storageProvider,
+	})
+	require.NoError(t, err)
+	return e
+}
+
+func runTestShard(t *testing.T, wasmSpec model.JobSpecWasm) (string, error) {
+	return runTestShardWithContext(context.Background(), t, wasmSpec)
+}
+
+func runTestShardWithContext(ctx context.Context, t *testing.T, wasmSpec model.JobSpecWasm) (string, error) {
+	return runTestJob(ctx, t, model.JobSpec{Wasm: wasmSpec}, nil)
+}
+
+func runTestJob(ctx context.Context, t *testing.T, spec model.JobSpec, files map[string]string) (string, error) {
+	spec.Engine = model.EngineWasm
+	spec.Wasm.EntryModule = model.StorageSpec{Engine: model.StorageSourceIPFS, Cid: "QmModule"}
+	shard := model.JobShard{
+		Job: model.Job{
+			ID:            "job-id",
+			Spec:          spec,
+			ExecutionPlan: model.JobExecutionPlan{TotalShards: 1},
+		},
+	}
+	resultsDir := t.TempDir()
+	err := newTestExecutor(t, files).RunShard(ctx, shard, resultsDir)
+	return resultsDir, err
+}
+
+func readResult(t *testing.T, resultsDir, name string) string {
+	contents, err := os.ReadFile(filepath.Join(resultsDir, name))
+	require.NoError(t, err)
+	return string(contents)
+}
+
+func TestRunShard(t *testing.T) {
+	resultsDir, err := runTestShard(t, model.JobSpecWasm{})
+	require.NoError(t, err)
+	require.Equal(t, "hello\n", readResult(t, resultsDir, "stdout"))
+	require.Equal(t, "", readResult(t, resultsDir, "stderr"))
+	require.Equal(t, "0", readResult(t, resultsDir, "exitCode"))
+
+	usage, ok, err := executor.ReadResourceUsage(resultsDir)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Greater(t, usage.MemoryPeak, uint64(0))
+	require.Greater(t, usage.WallTime, float64(0))
+}
+
+func TestRunShardMountsVolumes(t *testing.T) {
+	// a single file input is preopened as its folder and outputs are
+	// preopened from their folder in the results
+	resultsDir, err := runTestJob(context.Background(), t, model.JobSpec{
+		Wasm:    model.JobSpecWasm{EntryPoint: "copy"},
+		Inputs:  []model.StorageSpec{{Engine: model.StorageSourceIPFS, Cid: "QmInput", Path: "/inputs/in.txt"}},
+		Outputs: []model.StorageSpec{{Name: "outputs", Path: "/outputs"}},
+	}, map[string]string{"QmInput": "some data"})
+	require.NoError(t, err)
+	require.Equal(t, "0", readResult(t, resultsDir, "exitCode"))
+	require.Equal(t, "some data", readResult(t, resultsDir, filepath.Join("outputs", "out.txt")))
+}
+
+func TestRunShardExitCode(t *testing.T) {
+	resultsDir, err := runTestShard(t, model.JobSpecWasm{EntryPoint: "fail"})
+	require.Error(t, err)
+	require.Equal(t, "3", readResult(t, resultsDir, "exitCode"))
+}
+
+func TestRunShardMissingEntryPoint(t *testing.T) {
+	resultsDir, err := runTestShard(t, model.JobSpecWasm{EntryPoint: "missing"})
+	require.Error(t, err)
+	require.Equal(t, "1", readResult(t, resultsDir, "exitCode"))
+	require.Contains(t, readResult(t, resultsDir, "stderr"), "does not export missing")
+}
+
+func TestRunShardOutOfFuel(t *testing.T) {
+	_, err := runTestShard(t, model.JobSpecWasm{EntryPoint: "spin", Fuel: 1000})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "ran out of fuel")
+}
+
+func TestRunShardTimeout(t *testing.T) {
+	ctx := executor.ContextWithExecutionTimeout(context.Background(), 100*time.Millisecond)
+	resultsDir, err := runTestShardWithContext(ctx, t, model.JobSpecWasm{EntryPoint: "spin"})
+	require.ErrorIs(t, err, executor.ErrExecutionTimeout)
+	// what it wrote before it was stopped is kept
+	require.Equal(t, "hello\n", readResult(t, resultsDir, "stdout"))
+}
+
+func TestRunShardTruncatesLogs(t *testing.T) {
+	ctx := executor.ContextWithMaxLogSize(context.Background(), 3)
+	resultsDir, err := runTestShardWithContext(ctx, t, model.JobSpecWasm{})
+	require.NoError(t, err)
+	require.Equal(t, "hel\n[stdout truncated, it was longer than the limit]\n", readResult(t, resultsDir, "stdout"))
+	truncated, err := system.ReadLogsTruncated(resultsDir)
+	require.NoError(t, err)
+	require.Equal(t, []string{"stdout"}, truncated)
+}
+
+func TestMemoryLimitPages(t *testing.T) {
+	require.Equal(t, uint32(1), memoryLimitPages(1))
+	require.Equal(t, uint32(16), memoryLimitPages(1024*1024))
+	require.Equal(t, uint32(wasmMaxPageCount), memoryLimitPages(64*1024*1024*1024))
+}
@@ -3,11 +3,13 @@ package ipfs
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"time"
 
 	"github.com/filecoin-project/bacalhau/pkg/system"
+	"github.com/filecoin-project/bacalhau/pkg/util/bandwidth"
 	files "github.com/ipfs/go-ipfs-files"
 	httpapi "github.com/ipfs/go-ipfs-http-client"
 	ipld "github.com/ipfs/go-ipld-format"
@@ -132,7 +134,7 @@ func (cl *Client) Get(ctx context.Context, cid, outputPath string) error {
 
 	baseDir := filepath.Dir(outputPath)
 	tmpPath := filepath.Join(baseDir, system.GetRandomString(10)) //nolint:gomnd // magic number ok for string
-	if err := files.WriteTo(node, tmpPath); err != nil {
+	if err := files.WriteTo(limitNode(ctx, node), tmpPath); err != nil {
 		return fmt.Errorf("failed to write to '%s': %w", tmpPath, err)
 	}
 	defer os.RemoveAll(tmpPath)
@@ -144,6 +146,48 @@ func (cl *Client) Get(ctx context.Context, cid, outputPath string) error {
 	return nil
 }
 
+// the node with its files read as fast as the context's bandwidth limit
+// allows - symlinks are files too but have nothing to read
+func limitNode(ctx context.Context, node files.Node) files.Node {
+	switch node := node.(type) {
+	case *files.Symlink:
+		return node
+	case files.File:
+		return &limitedFile{File: node, reader: bandwidth.Reader(ctx, node)}
+	case files.Directory:
+		return &limitedDirectory{Directory: node, ctx: ctx}
+	default:
+		return node
+	}
+}
+
+type limitedFile struct {
+	files.File
+	reader io.Reader
+}
+
+func (f *limitedFile) Read(p []byte) (int, error) {
+	return f.reader.Read(p)
+}
+
+type limitedDirectory struct {
+	files.Directory
+	ctx context.Context
+}
+
+func (d *limitedDirectory) Entries() files.DirIterator {
+	return &limitedDirIterator{DirIterator: d.Directory.Entries(), ctx: d.ctx}
+}
+
+type limitedDirIterator struct {
+	files.DirIterator
+	ctx context.Context
+}
+
+func (it *limitedDirIterator) Node() files.Node {
+	return limitNode(it.ctx, it.DirIterator.Node())
+}
+
 // Put uploads and pins a file or directory to the ipfs network. Timeouts and
 // cancellation should be handled by passing an appropriate context value.
 func (cl *Client) Put(ctx context.Context, inputPath string) (string, error) {
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -19,6 +20,9 @@ type IPFSDownloadSettings struct {
 	TimeoutSecs    int
 	OutputDir      string
 	IPFSSwarmAddrs string
+	// how much of the stdout and stderr of all the shards is
+	// concatenated into the output dir - zero means the default
+	MaxLogSize int64
 }
 
 func NewIPFSDownloadSettings() *IPFSDownloadSettings {
@@ -26,6 +30,7 @@ func NewIPFSDownloadSettings() *IPFSDownloadSettings {
 		TimeoutSecs:    10,
 		OutputDir:      ".",
 		IPFSSwarmAddrs: "",
+		MaxLogSize:     system.DefaultMaxLogSize,
 	}
 }
 
@@ -114,6 +119,16 @@ func loopOverResults(ctx context.Context,
 	// and then merge each outout volume into the global results
 	log.Info().Msgf("Found %d result shards, downloading to temporary folder.", len(results))
 
+	err = os.MkdirAll(finalOutputDirAbs, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	logs, err := openCombinedLogs(finalOutputDirAbs, settings.MaxLogSize)
+	if err != nil {
+		return err
+	}
+	defer logs.close()
+
 	// we move all the contents of the output volume to the global results dir
 	// for this output volume
 	// find $SOURCE_DIR -name '*' -type f -exec mv -f {} $TARGET_DIR \;
@@ -127,12 +142,12 @@ func loopOverResults(ctx context.Context,
 			return err
 		}
 
-		err = moveResults(ctx, job, shardDownloadDir, finalOutputDirAbs, result)
+		err = moveResults(ctx, job, shardDownloadDir, finalOutputDirAbs, result, logs)
 		if err != nil {
 			return err
 		}
 	}
-	return nil
+	return logs.close()
 }
 
 func spinUpIPFSNode(ctx context.Context,
@@ -181,7 +196,8 @@ func moveResults(ctx context.Context,
 	job model.Job,
 	shardDownloadDir string,
 	finalOutputDirAbs string,
-	result model.StorageSpec) error {
+	result model.StorageSpec,
+	logs *combinedLogs) error {
 	ctx, span := system.GetTracer().Start(ctx, "pkg/ipfs.movingResults")
 	defer span.End()
 
@@ -203,7 +219,7 @@ func moveResults(ctx context.Context,
 		}
 	}
 
-	err := catStdFiles(ctx, shardDownloadDir, finalOutputDirAbs)
+	err := catStdFiles(ctx, shardDownloadDir, logs)
 	if err != nil {
 		return err
 	}
@@ -218,20 +234,75 @@ func moveResults(ctx context.Context,
 	return nil
 }
 
+// the stdout and stderr of every shard concatenated in the output dir,
+// which stop growing once they reach the size limit
+type combinedLogs struct {
+	dir    string
+	files  []*os.File
+	limits map[string]*system.LimitedWriter
+}
+
+func openCombinedLogs(dir string, maxSize int64) (*combinedLogs, error) {
+	if maxSize <= 0 {
+		maxSize = system.DefaultMaxLogSize
+	}
+	logs := &combinedLogs{
+		dir:    dir,
+		limits: map[string]*system.LimitedWriter{},
+	}
+	for _, filename := range stdFilenames {
+		file, err := os.OpenFile(filepath.Join(dir, filename), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644) //nolint:gomnd
+		if err != nil {
+			_ = logs.close()
+			return nil, err
+		}
+		logs.files = append(logs.files, file)
+		logs.limits[filename] = system.NewLimitedWriter(file, filename, maxSize)
+	}
+	return logs, nil
+}
+
+// close the logs and record which of them were truncated
+func (l *combinedLogs) close() error {
+	if l.files == nil {
+		return nil
+	}
+	var closeErr error
+	for _, file := range l.files {
+		if err := file.Close(); err != nil && closeErr == nil {
+			closeErr = err
+		}
+	}
+	l.files = nil
+	if closeErr != nil {
+		return closeErr
+	}
+	truncated := []string{}
+	for _, filename := range stdFilenames {
+		if limited, ok := l.limits[filename]; ok && limited.Truncated() {
+			truncated = append(truncated, filename)
+		}
+	}
+	return system.WriteLogsTruncated(l.dir, truncated)
+}
+
+var stdFilenames = []string{
+	"stdout",
+	"stderr",
+}
+
 func catStdFiles(ctx context.Context,
-	shardDownloadDir, finalOutputDirAbs string) error {
-	for _, filename := range []string{
-		"stdout",
-		"stderr",
-	} {
-		err := system.RunCommand("bash", []string{
-			"-c",
-			fmt.Sprintf(
-				"cat %s >> %s",
-				filepath.Join(shardDownloadDir, filename),
-				filepath.Join(finalOutputDirAbs, filename),
-			),
-		})
+	shardDownloadDir string, logs *combinedLogs) error {
+	for _, filename := range stdFilenames {
+		err := func() error {
+			file, err := os.Open(filepath.Join(shardDownloadDir, filename))
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			_, err = io.Copy(logs.limits[filename], file)
+			return err
+		}()
 		if err != nil {
 			return err
 		}
@@ -250,7 +321,12 @@ func moveStdFiles(ctx context.Context,
 		"stdout",
 		"stderr",
 		"exitCode",
+		system.LogsTruncatedFilename,
 	} {
+		_, err = os.Stat(filepath.Join(shardDownloadDir, filename))
+		if filename == system.LogsTruncatedFilename && os.IsNotExist(err) {
+			continue
+		}
 		err = system.RunCommand("mv", []string{
 			filepath.Join(shardDownloadDir, filename),
 			shardOutputDir,
@@ -117,7 +117,7 @@ func ExplodeShardedVolumes(
 			return allVolumes, fmt.Errorf("storage provider not found for engine %s", volume.Engine)
 		}
 		explodedVolumes, err := storageProvider.Explode(ctx, volume)
-		if !ok {
+		if err != nil {
 			return allVolumes, err
 		}
 		allVolumes = append(allVolumes, explodedVolumes...)
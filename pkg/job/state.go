@@ -372,7 +372,7 @@ func HasShardReachedCapacity(ctx context.Context, j model.Job, jobState model.Jo
 	for _, shardState := range shardStates { //nolint:gocritic
 		if shardState.State == model.JobStateBidding {
 			bidsSeen++
-		} else if shardState.State == model.JobStateWaiting {
+		} else if shardState.State == model.JobStateWaiting || shardState.State == model.JobStateRunning {
 			acceptedBidsSeen++
 		}
 	}
@@ -388,6 +388,54 @@ func HasShardReachedCapacity(ctx context.Context, j model.Job, jobState model.Jo
 	return false
 }
 
+// whether the job's requester has accepted the bid nodeID made for the shard
+func HasAcceptedBid(j model.Job, events []model.JobEvent, nodeID string, shardIndex int) bool {
+	for _, event := range events { //nolint:gocritic
+		if event.EventName == model.JobEventBidAccepted &&
+			event.SourceNodeID == j.RequesterNodeID &&
+			event.TargetNodeID == nodeID &&
+			event.ShardIndex == shardIndex {
+			return true
+		}
+	}
+	return false
+}
+
+// the last checkpoint for the shard, in the order events reached this node,
+// from a node whose bid for the shard had been accepted - or nil if there
+// isn't one. Any node can publish a checkpoint event and choose its time,
+// so the requester uses this to pick the checkpoint it accepts bids with.
+// It relies on the transport only delivering events from their source.
+func LatestCheckpoint(j model.Job, events []model.JobEvent, shardIndex int) *model.StorageSpec {
+	var latest *model.StorageSpec
+	for i := range events {
+		event := &events[i]
+		if event.EventName != model.JobEventCheckpointPublished || event.ShardIndex != shardIndex || event.Checkpoint == nil {
+			continue
+		}
+		if !HasAcceptedBid(j, events[:i], event.SourceNodeID, shardIndex) {
+			continue
+		}
+		latest = event.Checkpoint
+	}
+	return latest
+}
+
+// the checkpoint the requester accepted nodeID's latest bid for the shard
+// with, which is nil if there was nothing to resume from
+func AcceptedCheckpoint(j model.Job, events []model.JobEvent, nodeID string, shardIndex int) *model.StorageSpec {
+	var checkpoint *model.StorageSpec
+	for _, event := range events { //nolint:gocritic
+		if event.EventName == model.JobEventBidAccepted &&
+			event.SourceNodeID == j.RequesterNodeID &&
+			event.TargetNodeID == nodeID &&
+			event.ShardIndex == shardIndex {
+			checkpoint = event.Checkpoint
+		}
+	}
+	return checkpoint
+}
+
 // group states by shard index so we can easily iterate over a whole set of them
 func GroupShardStates(flatShards []model.JobShardState) map[int][]model.JobShardState {
 	ret := map[int][]model.JobShardState{}
@@ -0,0 +1,95 @@
+package job
+
+import (
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatestCheckpoint(t *testing.T) {
+	j := model.Job{ID: "job", RequesterNodeID: "requester"}
+	now := time.Now()
+	checkpoint := func(node string, shardIndex int, cid string, at time.Time) model.JobEvent {
+		return model.JobEvent{
+			EventName:    model.JobEventCheckpointPublished,
+			SourceNodeID: node,
+			ShardIndex:   shardIndex,
+			Checkpoint:   &model.StorageSpec{Engine: model.StorageSourceIPFS, Cid: cid},
+			EventTime:    at,
+		}
+	}
+	accepted := func(node string, shardIndex int) model.JobEvent {
+		return model.JobEvent{
+			EventName:    model.JobEventBidAccepted,
+			SourceNodeID: "requester",
+			TargetNodeID: node,
+			ShardIndex:   shardIndex,
+		}
+	}
+
+	require.Nil(t, LatestCheckpoint(j, nil, 0))
+
+	events := []model.JobEvent{
+		accepted("node", 0),
+		checkpoint("node", 0, "first", now),
+		// from a node that was never accepted for the shard
+		checkpoint("impostor", 0, "planted", now.Add(time.Hour)),
+		accepted("other", 1),
+		checkpoint("other", 0, "wrong-shard", now.Add(time.Hour)),
+		checkpoint("node", 0, "second", now.Add(time.Minute)),
+		// the sender's clock doesn't matter, only the order we heard them in
+		checkpoint("node", 0, "third", now.Add(-time.Minute)),
+		checkpoint("other", 1, "other-shard", now.Add(time.Hour)),
+		{EventName: model.JobEventError, ShardIndex: 0, EventTime: now.Add(time.Hour)},
+		// published before the node was accepted
+		checkpoint("late", 2, "early", now),
+		accepted("late", 2),
+	}
+	latest := LatestCheckpoint(j, events, 0)
+	require.NotNil(t, latest)
+	require.Equal(t, "third", latest.Cid)
+	require.Equal(t, "other-shard", LatestCheckpoint(j, events, 1).Cid)
+	require.Nil(t, LatestCheckpoint(j, events, 2))
+}
+
+func TestAcceptedCheckpoint(t *testing.T) {
+	j := model.Job{ID: "job", RequesterNodeID: "requester"}
+	spec := func(cid string) *model.StorageSpec {
+		return &model.StorageSpec{Engine: model.StorageSourceIPFS, Cid: cid}
+	}
+	events := []model.JobEvent{
+		{EventName: model.JobEventBidAccepted, SourceNodeID: "requester", TargetNodeID: "node", ShardIndex: 0},
+		{EventName: model.JobEventCheckpointPublished, SourceNodeID: "node", ShardIndex: 0, Checkpoint: spec("published")},
+		// a rerun of the shard on the same node
+		{EventName: model.JobEventBidAccepted, SourceNodeID: "requester", TargetNodeID: "node", ShardIndex: 0, Checkpoint: spec("resume")},
+		{EventName: model.JobEventBidAccepted, SourceNodeID: "impostor", TargetNodeID: "node", ShardIndex: 0, Checkpoint: spec("planted")},
+		{EventName: model.JobEventBidAccepted, SourceNodeID: "requester", TargetNodeID: "other", ShardIndex: 0},
+	}
+	require.Equal(t, "resume", AcceptedCheckpoint(j, events, "node", 0).Cid)
+	require.Nil(t, AcceptedCheckpoint(j, events, "other", 0))
+	require.Nil(t, AcceptedCheckpoint(j, events, "node", 1))
+}
+
+func TestHasAcceptedBid(t *testing.T) {
+	j := model.Job{ID: "job", RequesterNodeID: "requester"}
+	accepted := func(source, target string, shardIndex int) model.JobEvent {
+		return model.JobEvent{
+			EventName:    model.JobEventBidAccepted,
+			SourceNodeID: source,
+			TargetNodeID: target,
+			ShardIndex:   shardIndex,
+		}
+	}
+	events := []model.JobEvent{
+		accepted("requester", "node", 0),
+		// only the job's requester can accept bids
+		accepted("impostor", "node", 1),
+		{EventName: model.JobEventBid, SourceNodeID: "other", ShardIndex: 0},
+	}
+	require.True(t, HasAcceptedBid(j, events, "node", 0))
+	require.False(t, HasAcceptedBid(j, events, "node", 1))
+	require.False(t, HasAcceptedBid(j, events, "other", 0))
+	require.False(t, HasAcceptedBid(j, nil, "node", 0))
+}
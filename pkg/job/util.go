@@ -8,8 +8,11 @@ import (
 	"strings"
 
 	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/filecoin-project/bacalhau/pkg/storage/git"
+	"github.com/filecoin-project/bacalhau/pkg/storage/s3"
 	"github.com/filecoin-project/bacalhau/pkg/storage/url/urldownload"
 	"github.com/rs/zerolog/log"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 const RegexString = "A-Za-z0-9._~!:@,;+-"
@@ -51,16 +54,20 @@ func buildJobInputs(inputVolumes, inputUrls []string) ([]model.StorageSpec, erro
 		lastInd := strings.LastIndex(inputURL, ":")
 		rawURL := inputURL[:lastInd]
 		path := inputURL[lastInd+1:]
+		if s3.IsS3URL(rawURL) {
+			spec, err := s3.NewStorageSpec(rawURL, path)
+			if err != nil {
+				return []model.StorageSpec{}, err
+			}
+			jobInputs = append(jobInputs, spec)
+			continue
+		}
 		// should loop through all available storage providers?
-		_, err := urldownload.IsURLSupported(rawURL)
+		spec, err := urldownload.NewStorageSpec(rawURL, path)
 		if err != nil {
 			return []model.StorageSpec{}, err
 		}
-		jobInputs = append(jobInputs, model.StorageSpec{
-			Engine: model.StorageSourceURLDownload,
-			URL:    rawURL,
-			Path:   path,
-		})
+		jobInputs = append(jobInputs, spec)
 	}
 
 	for _, inputVolume := range inputVolumes {
@@ -116,3 +123,41 @@ func buildJobOutputs(outputVolumes []string) ([]model.StorageSpec, error) {
 
 	return returnOutputVolumes, nil
 }
+
+// turn a 'REPO#COMMIT:PATH' string into a git storage spec
+// e.g. "https://github.com/org/repo.git#<full commit hash>:/src"
+func ParseGitVolume(volume string) (model.StorageSpec, error) {
+	i := strings.LastIndex(volume, "#")
+	if i < 0 {
+		return model.StorageSpec{}, fmt.Errorf("invalid git volume %q: expected REPO#COMMIT:PATH", volume)
+	}
+	commit, path, ok := strings.Cut(volume[i+1:], ":")
+	if !ok || path == "" {
+		return model.StorageSpec{}, fmt.Errorf("invalid git volume %q: expected REPO#COMMIT:PATH", volume)
+	}
+	return git.NewStorageSpec(volume[:i], commit, path)
+}
+
+// turn a kubernetes style selector string into node selector requirements
+// e.g. "region=eu,tier in (trusted,gold),!legacy"
+func ParseNodeSelector(nodeSelector string) ([]model.LabelSelectorRequirement, error) {
+	requirements := []model.LabelSelectorRequirement{}
+	if strings.TrimSpace(nodeSelector) == "" {
+		return requirements, nil
+	}
+
+	selector, err := labels.Parse(nodeSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid node selector %q: %w", nodeSelector, err)
+	}
+
+	parsed, _ := selector.Requirements()
+	for _, requirement := range parsed {
+		requirements = append(requirements, model.LabelSelectorRequirement{
+			Key:      requirement.Key(),
+			Operator: model.SelectorOperator(requirement.Operator()),
+			Values:   requirement.Values().List(),
+		})
+	}
+	return requirements, nil
+}
@@ -0,0 +1,82 @@
+package job
+
+import (
+	"testing"
+
+	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNodeSelector(t *testing.T) {
+	selectors, err := ParseNodeSelector("")
+	require.NoError(t, err)
+	require.Empty(t, selectors)
+
+	selectors, err = ParseNodeSelector("region=eu,tier in (gold, trusted),!legacy")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []model.LabelSelectorRequirement{
+		{Key: "region", Operator: model.SelectorOpEquals, Values: []string{"eu"}},
+		{Key: "tier", Operator: model.SelectorOpIn, Values: []string{"gold", "trusted"}},
+		{Key: "legacy", Operator: model.SelectorOpDoesNotExist, Values: []string{}},
+	}, selectors)
+
+	_, err = ParseNodeSelector("region in eu")
+	require.Error(t, err)
+}
+
+func TestParseGitVolume(t *testing.T) {
+	commit := "96023805cee3e77fde5bf276b8e17f40526a9df6"
+	spec, err := ParseGitVolume("git@github.com:org/repo.git#" + commit + ":/src")
+	require.NoError(t, err)
+	require.Equal(t, model.StorageSpec{
+		Engine: model.StorageSourceGit,
+		URL:    "git@github.com:org/repo.git",
+		Cid:    commit,
+		Path:   "/src",
+	}, spec)
+
+	for _, volume := range []string{
+		"https://example.com/repo.git:/src",
+		"https://example.com/repo.git#" + commit,
+		"https://example.com/repo.git#main:/src",
+	} {
+		_, err = ParseGitVolume(volume)
+		require.Error(t, err, volume)
+	}
+}
+
+func TestMatchNodeSelectors(t *testing.T) {
+	labels := map[string]string{
+		"region": "eu",
+		"tier":   "trusted",
+		"cores":  "16",
+	}
+
+	testCases := []struct {
+		selector        string
+		expectedMatch   bool
+		expectedMatched map[string]string
+	}{
+		{"", true, map[string]string{}},
+		{"region=eu", true, map[string]string{"region": "eu"}},
+		{"region=eu,tier in (trusted)", true, map[string]string{"region": "eu", "tier": "trusted"}},
+		{"region notin (us)", true, map[string]string{"region": "eu"}},
+		{"cores>8", true, map[string]string{"cores": "16"}},
+		{"cores<8", false, nil},
+		{"region=us", false, nil},
+		{"gpu", false, nil},
+		{"!gpu", true, map[string]string{}},
+	}
+
+	for _, testCase := range testCases {
+		selectors, err := ParseNodeSelector(testCase.selector)
+		require.NoError(t, err, testCase.selector)
+		for _, selector := range selectors {
+			require.NoError(t, selector.Validate(), testCase.selector)
+		}
+		ok, matched, err := model.MatchNodeSelectors(selectors, labels)
+		require.NoError(t, err, testCase.selector)
+		require.Equal(t, testCase.expectedMatch, ok, testCase.selector)
+		require.Equal(t, testCase.expectedMatched, matched, testCase.selector)
+	}
+}
@@ -2,8 +2,10 @@ package job
 
 import (
 	"fmt"
+	"path/filepath"
 	"reflect"
 
+	"github.com/filecoin-project/bacalhau/pkg/executor/language"
 	"github.com/filecoin-project/bacalhau/pkg/model"
 )
 
@@ -38,5 +40,73 @@ func VerifyJob(spec model.JobSpec, deal model.JobDeal) error {
 		}
 	}
 
+	if spec.Engine == model.EngineWasm && !model.IsValidStorageSourceType(spec.Wasm.EntryModule.Engine) {
+		return fmt.Errorf("invalid wasm entry module type: %s", spec.Wasm.EntryModule.Engine.String())
+	}
+
+	if spec.Engine == model.EngineNative {
+		hasImage := model.IsValidStorageSourceType(spec.Native.Image.Engine)
+		hasBinary := model.IsValidStorageSourceType(spec.Native.Binary.Engine)
+		if hasImage == hasBinary {
+			return fmt.Errorf("a native job must have either an image or a binary")
+		}
+	}
+
+	if spec.Engine == model.EngineLanguage {
+		if _, err := language.ResolveRuntime(spec.Language); err != nil {
+			return err
+		}
+	}
+
+	if spec.Network.Type != 0 && !model.IsValidNetwork(spec.Network.Type) {
+		return fmt.Errorf("invalid network type: %s", spec.Network.Type.String())
+	}
+
+	if spec.Network.Type == model.NetworkAllowlist && len(spec.Network.Domains) == 0 {
+		return fmt.Errorf("a job with an allowlist network must list the domains it needs")
+	}
+
+	if spec.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative: %f", spec.Timeout)
+	}
+
+	if spec.Checkpoint.Enabled() && !filepath.IsAbs(spec.Checkpoint.Path) {
+		return fmt.Errorf("checkpoint path must be absolute: %s", spec.Checkpoint.Path)
+	}
+
+	if spec.Checkpoint.Interval < 0 {
+		return fmt.Errorf("checkpoint interval must not be negative: %f", spec.Checkpoint.Interval)
+	}
+
+	for _, selector := range spec.NodeSelectors {
+		if err := selector.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if err := validateSecrets(spec.Secrets); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func validateSecrets(secrets []model.JobSpecSecret) error {
+	names := map[string]bool{}
+	for _, secret := range secrets {
+		if secret.Name == "" {
+			return fmt.Errorf("secrets must have a name")
+		}
+		if names[secret.Name] {
+			return fmt.Errorf("secret %s is given more than once", secret.Name)
+		}
+		names[secret.Name] = true
+		if (secret.Env == "") == (secret.Path == "") {
+			return fmt.Errorf("secret %s must be given to the job in either an env var or a file", secret.Name)
+		}
+		if secret.Path != "" && !filepath.IsAbs(secret.Path) {
+			return fmt.Errorf("secret %s path must be absolute: %s", secret.Name, secret.Path)
+		}
+	}
 	return nil
 }
@@ -261,6 +261,18 @@ func (d *InMemoryDatastore) UpdateShardState(
 		shardSate.PublishedResult = update.PublishedResult
 	}
 
+	if len(update.MatchedNodeLabels) != 0 {
+		shardSate.MatchedNodeLabels = update.MatchedNodeLabels
+	}
+
+	if update.LocalityScore != 0 {
+		shardSate.LocalityScore = update.LocalityScore
+	}
+
+	if update.ResourceUsage != nil {
+		shardSate.ResourceUsage = update.ResourceUsage
+	}
+
 	nodeState.Shards[shardIndex] = shardSate
 	jobState.Nodes[nodeID] = nodeState
 	d.states[jobID] = jobState
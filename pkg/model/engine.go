@@ -11,9 +11,10 @@ const (
 	engineUnknown EngineType = iota // must be first
 	EngineNoop
 	EngineDocker
-	EngineWasm       // raw wasm executor not implemented yet
+	EngineWasm       // runs WASI modules natively
 	EngineLanguage   // wraps python_wasm
 	EnginePythonWasm // wraps docker
+	EngineNative     // runs jobs in linux namespaces without docker
 	engineDone       // must be last
 )
 
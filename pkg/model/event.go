@@ -53,6 +53,17 @@ const (
 	// the compute node will publish them and issue this event
 	JobEventResultsPublished
 
+	// a compute node published a snapshot of a running shard's
+	// checkpoint volume that the shard can resume from
+	JobEventCheckpointPublished
+
+	// a compute node that has been accepted for a shard of a job
+	// with secrets is waiting for the client to send them
+	JobEventSecretsRequested
+
+	// the client sent the secrets a compute node asked for
+	JobEventSecretsDelivered
+
 	jobEventDone // must be last
 )
 
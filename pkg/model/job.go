@@ -106,6 +106,12 @@ type JobShardState struct {
 	VerificationProposal []byte             `json:"verification_proposal"`
 	VerificationResult   VerificationResult `json:"verification_result"`
 	PublishedResult      StorageSpec        `json:"published_results"`
+	// the labels of the node that matched the job's node selectors
+	MatchedNodeLabels map[string]string `json:"matched_node_labels,omitempty"`
+	// the fraction of the job's input data the node had locally when it bid
+	LocalityScore float64 `json:"locality_score,omitempty"`
+	// what the shard actually used when it ran on the node
+	ResourceUsage *ShardResourceUsage `json:"resource_usage,omitempty"`
 }
 
 // The deal the client has made with the bacalhau network.
@@ -147,10 +153,27 @@ type JobSpec struct {
 	// executor specific data
 	Docker   JobSpecDocker   `json:"job_spec_docker,omitempty" yaml:"job_spec_docker,omitempty"`
 	Language JobSpecLanguage `json:"job_spec_language,omitempty" yaml:"job_spec_language,omitempty"`
+	Wasm     JobSpecWasm     `json:"job_spec_wasm,omitempty" yaml:"job_spec_wasm,omitempty"`
+	Native   JobSpecNative   `json:"job_spec_native,omitempty" yaml:"job_spec_native,omitempty"`
 
 	// the compute (cpy, ram) resources this job requires
 	Resources ResourceUsageConfig `json:"resources" yaml:"resources"`
 
+	// the network access the job needs - none by default
+	Network NetworkConfig `json:"network,omitempty" yaml:"network,omitempty"`
+
+	// how long each shard may run for in seconds before it is killed
+	// zero means the compute node's maximum applies
+	Timeout float64 `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+
+	// a writable volume the job keeps its progress in so that
+	// a shard that has to run again can resume from where it got to
+	Checkpoint JobSpecCheckpoint `json:"checkpoint,omitempty" yaml:"checkpoint,omitempty"`
+
+	// the secrets the job is given - only their names are in the spec, the
+	// client sends the values to each compute node once its bid is accepted
+	Secrets []JobSpecSecret `json:"secrets,omitempty" yaml:"secrets,omitempty"`
+
 	// the data volumes we will read in the job
 	// for example "read this ipfs cid"
 	Inputs []StorageSpec `json:"inputs" yaml:"inputs"`
@@ -173,6 +196,14 @@ type JobSpec struct {
 
 	// Do not track specified by the client
 	DoNotTrack bool `json:"donottrack" yaml:"donottrack"`
+
+	// never use cached results from an identical earlier shard
+	// and never cache the results of this job
+	DoNotCache bool `json:"do_not_cache,omitempty" yaml:"do_not_cache,omitempty"`
+
+	// only compute nodes whose labels match all of these
+	// selectors will bid on the job
+	NodeSelectors []LabelSelectorRequirement `json:"node_selectors,omitempty" yaml:"node_selectors,omitempty"`
 }
 
 // for VM style executors
@@ -203,6 +234,64 @@ type JobSpecLanguage struct {
 	RequirementsPath string `json:"requirements_path" yaml:"requirements_path"`
 }
 
+// for the native wasm executor
+type JobSpecWasm struct {
+	// the compiled WASI module to run
+	EntryModule StorageSpec `json:"entry_module" yaml:"entry_module"`
+	// the exported function to call - defaults to _start
+	EntryPoint string `json:"entry_point" yaml:"entry_point"`
+	// arguments passed to the module after the module name
+	Parameters []string `json:"parameters" yaml:"parameters"`
+	// environment variables for the module in KEY=VALUE form
+	Env []string `json:"env" yaml:"env"`
+	// the most function calls the module can make before it is stopped
+	// zero means no limit
+	Fuel uint64 `json:"fuel" yaml:"fuel"`
+}
+
+// for the native executor, which runs jobs without docker
+type JobSpecNative struct {
+	// an OCI image layout or an unpacked root filesystem to run the job in
+	Image StorageSpec `json:"image" yaml:"image"`
+	// a single executable to run instead of an image, mounted at its path
+	// (or /bin/program) in an otherwise empty root filesystem
+	Binary StorageSpec `json:"binary" yaml:"binary"`
+	// the command to run - defaults to the image's entrypoint and command
+	// or to running the binary with no arguments
+	Entrypoint []string `json:"entrypoint" yaml:"entrypoint"`
+	// environment variables in KEY=VALUE form, added to the image's
+	Env []string `json:"env" yaml:"env"`
+	// working directory - defaults to the image's or /
+	WorkingDir string `json:"workdir" yaml:"workdir"`
+}
+
+// an opt-in checkpoint volume - the compute node snapshots it every interval
+// and publishes the snapshot, and the latest snapshot for a shard is put
+// back in the volume whenever that shard starts running again
+type JobSpecCheckpoint struct {
+	// where the checkpoint volume is mounted - empty means no checkpoints
+	Path string `json:"path,omitempty" yaml:"path,omitempty"`
+	// how often in seconds the volume is snapshotted
+	// zero means the compute node's default
+	Interval float64 `json:"interval,omitempty" yaml:"interval,omitempty"`
+}
+
+// Enabled returns true if the job asked for a checkpoint volume
+func (c JobSpecCheckpoint) Enabled() bool {
+	return c.Path != ""
+}
+
+// a secret the job needs - its value is never part of the spec, it is
+// encrypted to a key the compute node makes for the shard once its bid is
+// accepted and it is only kept in memory until the shard has run
+type JobSpecSecret struct {
+	Name string `json:"name" yaml:"name"`
+	// the environment variable the job is given the secret in
+	Env string `json:"env,omitempty" yaml:"env,omitempty"`
+	// or the absolute path of the file the job can read it from
+	Path string `json:"path,omitempty" yaml:"path,omitempty"`
+}
+
 // gives us a way to keep local data against a job
 // so our compute node and requester node control loops
 // can keep state against a job without broadcasting it
@@ -238,6 +327,25 @@ type JobEvent struct {
 	VerificationProposal []byte             `json:"verification_proposal"`
 	VerificationResult   VerificationResult `json:"verification_result"`
 	PublishedResult      StorageSpec        `json:"published_results"`
+	// this is only defined in "bid" events
+	// the labels of the bidding node that matched the job's node selectors
+	MatchedNodeLabels map[string]string `json:"matched_node_labels,omitempty"`
+	// the fraction of the job's input data the bidding node has locally
+	LocalityScore float64 `json:"locality_score,omitempty"`
+	// this is only defined in "results proposed" events
+	// what the shard actually used while it ran
+	ResourceUsage *ShardResourceUsage `json:"resource_usage,omitempty"`
+	// this is only defined in "checkpoint published" events
+	// where the snapshot of the shard's checkpoint volume was published to,
+	// and in "bid accepted" events the checkpoint the shard resumes from
+	Checkpoint *StorageSpec `json:"checkpoint,omitempty"`
+	// this is only defined in "secrets requested" events
+	// the key the client encrypts the shard's secrets to
+	SecretsPublicKey []byte `json:"secrets_public_key,omitempty"`
+	// this is only defined in "secrets delivered" events
+	// the shard's secrets sealed to the key the compute node asked for them with
+	// these are never stored, only the target node is given them
+	Secrets []byte `json:"secrets,omitempty"`
 
 	EventTime       time.Time `json:"event_time"`
 	SenderPublicKey []byte    `json:"public_key"`
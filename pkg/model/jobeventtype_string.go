@@ -21,12 +21,15 @@ func _() {
 	_ = x[JobEventResultsAccepted-10]
 	_ = x[JobEventResultsRejected-11]
 	_ = x[JobEventResultsPublished-12]
-	_ = x[jobEventDone-13]
+	_ = x[JobEventCheckpointPublished-13]
+	_ = x[JobEventSecretsRequested-14]
+	_ = x[JobEventSecretsDelivered-15]
+	_ = x[jobEventDone-16]
 }
 
-const _JobEventType_name = "jobEventUnknownCreatedDealUpdatedBidBidAcceptedBidRejectedBidCancelledRunningErrorResultsProposedResultsAcceptedResultsRejectedResultsPublishedjobEventDone"
+const _JobEventType_name = "jobEventUnknownCreatedDealUpdatedBidBidAcceptedBidRejectedBidCancelledRunningErrorResultsProposedResultsAcceptedResultsRejectedResultsPublishedCheckpointPublishedSecretsRequestedSecretsDeliveredjobEventDone"
 
-var _JobEventType_index = [...]uint8{0, 15, 22, 33, 36, 47, 58, 70, 77, 82, 97, 112, 127, 143, 155}
+var _JobEventType_index = [...]uint8{0, 15, 22, 33, 36, 47, 58, 70, 77, 82, 97, 112, 127, 143, 162, 178, 194, 206}
 
 func (i JobEventType) String() string {
 	if i < 0 || i >= JobEventType(len(_JobEventType_index)-1) {
@@ -0,0 +1,159 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// the operators a node selector can use to compare a label
+// these mirror the kubernetes label selector syntax
+type SelectorOperator string
+
+const (
+	SelectorOpEquals       SelectorOperator = "="
+	SelectorOpDoubleEquals SelectorOperator = "=="
+	SelectorOpNotEquals    SelectorOperator = "!="
+	SelectorOpIn           SelectorOperator = "in"
+	SelectorOpNotIn        SelectorOperator = "notin"
+	SelectorOpExists       SelectorOperator = "exists"
+	SelectorOpDoesNotExist SelectorOperator = "!"
+	SelectorOpGreaterThan  SelectorOperator = "gt"
+	SelectorOpLessThan     SelectorOperator = "lt"
+)
+
+// a single match expression against the labels of a compute node
+// e.g. region in (eu, us) or tier=trusted
+type LabelSelectorRequirement struct {
+	Key      string           `json:"key" yaml:"key"`
+	Operator SelectorOperator `json:"operator" yaml:"operator"`
+	Values   []string         `json:"values,omitempty" yaml:"values,omitempty"`
+}
+
+func (r LabelSelectorRequirement) String() string {
+	switch r.Operator {
+	case SelectorOpExists:
+		return r.Key
+	case SelectorOpDoesNotExist:
+		return fmt.Sprintf("!%s", r.Key)
+	case SelectorOpIn, SelectorOpNotIn:
+		return fmt.Sprintf("%s %s %v", r.Key, r.Operator, r.Values)
+	case SelectorOpGreaterThan:
+		return fmt.Sprintf("%s>%v", r.Key, r.Values)
+	case SelectorOpLessThan:
+		return fmt.Sprintf("%s<%v", r.Key, r.Values)
+	default:
+		if len(r.Values) == 1 {
+			return fmt.Sprintf("%s%s%s", r.Key, r.Operator, r.Values[0])
+		}
+		return fmt.Sprintf("%s%s%v", r.Key, r.Operator, r.Values)
+	}
+}
+
+// check the operator is known and has the right number of values
+func (r LabelSelectorRequirement) Validate() error {
+	if r.Key == "" {
+		return fmt.Errorf("node selector has no key: %s", r)
+	}
+	switch r.Operator {
+	case SelectorOpEquals, SelectorOpDoubleEquals, SelectorOpNotEquals:
+		if len(r.Values) != 1 {
+			return fmt.Errorf("node selector %s needs exactly one value", r)
+		}
+	case SelectorOpIn, SelectorOpNotIn:
+		if len(r.Values) == 0 {
+			return fmt.Errorf("node selector %s needs at least one value", r)
+		}
+	case SelectorOpExists, SelectorOpDoesNotExist:
+		if len(r.Values) != 0 {
+			return fmt.Errorf("node selector %s cannot have values", r)
+		}
+	case SelectorOpGreaterThan, SelectorOpLessThan:
+		if len(r.Values) != 1 {
+			return fmt.Errorf("node selector %s needs exactly one value", r)
+		}
+		if _, err := strconv.ParseInt(r.Values[0], 10, 64); err != nil {
+			return fmt.Errorf("node selector %s has a non-integer value: %w", r, err)
+		}
+	default:
+		return fmt.Errorf("unknown node selector operator: %s", r.Operator)
+	}
+	return nil
+}
+
+// does the given set of node labels satisfy this requirement?
+func (r LabelSelectorRequirement) Matches(labels map[string]string) (bool, error) {
+	value, hasLabel := labels[r.Key]
+	switch r.Operator {
+	case SelectorOpEquals, SelectorOpDoubleEquals, SelectorOpIn:
+		return hasLabel && containsString(r.Values, value), nil
+	case SelectorOpNotEquals, SelectorOpNotIn:
+		return !hasLabel || !containsString(r.Values, value), nil
+	case SelectorOpExists:
+		return hasLabel, nil
+	case SelectorOpDoesNotExist:
+		return !hasLabel, nil
+	case SelectorOpGreaterThan, SelectorOpLessThan:
+		if !hasLabel {
+			return false, nil
+		}
+		if err := r.Validate(); err != nil {
+			return false, err
+		}
+		want, _ := strconv.ParseInt(r.Values[0], 10, 64)
+		have, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			// a label that is not a number can never be compared
+			return false, nil //nolint:nilerr
+		}
+		if r.Operator == SelectorOpGreaterThan {
+			return have > want, nil
+		}
+		return have < want, nil
+	default:
+		return false, fmt.Errorf("unknown node selector operator: %s", r.Operator)
+	}
+}
+
+// check the node labels against all of the node selectors for a job
+// the node must satisfy every requirement - if it does we return
+// the node labels that the selectors referenced so they can be
+// reported back alongside the bid
+func MatchNodeSelectors(
+	selectors []LabelSelectorRequirement,
+	labels map[string]string,
+) (bool, map[string]string, error) {
+	matched := map[string]string{}
+	for _, selector := range selectors {
+		ok, err := selector.Matches(labels)
+		if err != nil {
+			return false, nil, err
+		}
+		if !ok {
+			return false, nil, nil
+		}
+		if value, hasLabel := labels[selector.Key]; hasLabel {
+			matched[selector.Key] = value
+		}
+	}
+	return true, matched, nil
+}
+
+// turn a map of labels into a stable list of key=value strings
+func LabelsToStrings(labels map[string]string) []string {
+	ret := []string{}
+	for key, value := range labels {
+		ret = append(ret, fmt.Sprintf("%s=%s", key, value))
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,15 @@
+package model
+
+const (
+	LogStreamStdout = "stdout"
+	LogStreamStderr = "stderr"
+)
+
+// LogChunk is a piece of the output of a shard
+// these are streamed to clients while the shard is running
+type LogChunk struct {
+	// either stdout or stderr
+	Stream string `json:"stream"`
+	// the raw bytes that were written
+	Data []byte `json:"data"`
+}
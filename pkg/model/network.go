@@ -0,0 +1,71 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+//go:generate stringer -type=Network --trimprefix=Network
+type Network int
+
+const (
+	networkUnknown   Network = iota // must be first
+	NetworkNone                     // no network access at all
+	NetworkAllowlist                // only HTTP(S) to the listed domains
+	NetworkFull                     // unrestricted network access
+	networkDone                     // must be last
+)
+
+func IsValidNetwork(network Network) bool {
+	return network > networkUnknown && network < networkDone
+}
+
+func ParseNetwork(str string) (Network, error) {
+	for typ := networkUnknown + 1; typ < networkDone; typ++ {
+		if equal(typ.String(), str) {
+			return typ, nil
+		}
+	}
+
+	return networkUnknown, fmt.Errorf(
+		"executor: unknown network type '%s'", str)
+}
+
+func Networks() []Network {
+	var res []Network
+	for typ := networkUnknown + 1; typ < networkDone; typ++ {
+		res = append(res, typ)
+	}
+
+	return res
+}
+
+// NetworkConfig says what network access the job needs
+// the zero value is no network access
+type NetworkConfig struct {
+	Type Network `json:"type,omitempty" yaml:"type,omitempty"`
+	// the domains an Allowlist job can reach
+	// an entry starting with a dot also matches any subdomain
+	Domains []string `json:"domains,omitempty" yaml:"domains,omitempty"`
+}
+
+// Disabled is true if the job should have no network access
+func (n NetworkConfig) Disabled() bool {
+	return n.Type == networkUnknown || n.Type == NetworkNone
+}
+
+// AllowsDomain returns true if an Allowlist job may connect to host
+func (n NetworkConfig) AllowsDomain(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, domain := range n.Domains {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if strings.HasPrefix(domain, ".") {
+			if host == domain[1:] || strings.HasSuffix(host, domain) {
+				return true
+			}
+		} else if host == domain {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+package model
+
+import "time"
+
+// NodeInfo is periodically broadcast by compute nodes so that other
+// nodes and clients know which compute nodes exist, what they
+// support and how much capacity they have free.
+type NodeInfo struct {
+	// the node that is advertising itself
+	NodeID string `json:"node_id"`
+	// the version of bacalhau the node is running
+	Version VersionInfo `json:"version"`
+	// the operator defined labels for this node
+	Labels map[string]string `json:"labels,omitempty"`
+	// the components that are installed on this node
+	Engines    []EngineType    `json:"engines"`
+	Verifiers  []VerifierType  `json:"verifiers"`
+	Publishers []PublisherType `json:"publishers"`
+	// how much capacity the node has left for new jobs
+	FreeCapacity ResourceUsageData `json:"free_capacity"`
+	// the node is not taking on new jobs
+	Draining bool `json:"draining,omitempty"`
+	// where the node's API can be reached, if it has said
+	APIURL string `json:"api_url,omitempty"`
+	// when the node sent this info
+	EventTime time.Time `json:"event_time"`
+	// the public key the node signed the info with
+	// this is filled in by the transport on receipt
+	SenderPublicKey []byte `json:"public_key,omitempty"`
+}
+
+// DrainStatus reports how far a compute node has got with draining
+type DrainStatus struct {
+	// the node is not selecting or bidding on new jobs
+	Draining bool `json:"draining"`
+	// the number of shards the node has been accepted for
+	// and is still running or publishing
+	ShardsInProgress int `json:"shards_in_progress"`
+	// the node is draining and has nothing left in progress
+	SafeToShutdown bool `json:"safe_to_shutdown"`
+}
@@ -1,5 +1,11 @@
 package model
 
+import (
+	"fmt"
+
+	"github.com/c2h5oh/datasize"
+)
+
 // a record for the "amount" of compute resources an entity has / can consume / is using
 
 type ResourceUsageConfig struct {
@@ -33,3 +39,24 @@ type ResourceUsageProfile struct {
 	// what is the total amount of resources available to the system
 	SystemTotal ResourceUsageData `json:"system_total"`
 }
+
+// what a shard actually used while it ran, as measured by the executor
+type ShardResourceUsage struct {
+	// cpu time in seconds summed across all cores
+	CPUSeconds float64 `json:"cpu_seconds"`
+	// the most memory the shard was using at once in bytes
+	MemoryPeak uint64 `json:"memory_peak"`
+	// bytes written to disk
+	DiskWritten uint64 `json:"disk_written"`
+	// how long the shard ran for in seconds
+	WallTime float64 `json:"wall_time"`
+}
+
+func (usage ShardResourceUsage) String() string {
+	return fmt.Sprintf("cpu: %.2fs, memory peak: %s, disk written: %s, wall time: %.2fs",
+		usage.CPUSeconds,
+		datasize.ByteSize(usage.MemoryPeak).HR(),
+		datasize.ByteSize(usage.DiskWritten).HR(),
+		usage.WallTime,
+	)
+}
@@ -19,6 +19,8 @@ const (
 	StorageSourceFilecoinUnsealed
 	StorageSourceFilecoin
 	StorageSourceEstuary
+	StorageSourceS3
+	StorageSourceGit
 	storageSourceDone // must be last
 )
 
@@ -14,12 +14,14 @@ func _() {
 	_ = x[StorageSourceFilecoinUnsealed-3]
 	_ = x[StorageSourceFilecoin-4]
 	_ = x[StorageSourceEstuary-5]
-	_ = x[storageSourceDone-6]
+	_ = x[StorageSourceS3-6]
+	_ = x[StorageSourceGit-7]
+	_ = x[storageSourceDone-8]
 }
 
-const _StorageSourceType_name = "storageSourceUnknownIPFSURLDownloadFilecoinUnsealedFilecoinEstuarystorageSourceDone"
+const _StorageSourceType_name = "storageSourceUnknownIPFSURLDownloadFilecoinUnsealedFilecoinEstuaryS3GitstorageSourceDone"
 
-var _StorageSourceType_index = [...]uint8{0, 20, 24, 35, 51, 59, 66, 83}
+var _StorageSourceType_index = [...]uint8{0, 20, 24, 35, 51, 59, 66, 68, 71, 88}
 
 func (i StorageSourceType) String() string {
 	if i < 0 || i >= StorageSourceType(len(_StorageSourceType_index)-1) {
@@ -87,6 +87,11 @@ func (f *StandardStorageProvidersFactory) Get(
 		executor_util.StandardStorageProviderOptions{
 			IPFSMultiaddress:     nodeConfig.IPFSClient.APIAddress(),
 			FilecoinUnsealedPath: nodeConfig.FilecoinUnsealedPath,
+			S3:                   nodeConfig.S3,
+			Git:                  nodeConfig.Git,
+			Archive:              nodeConfig.Archive,
+			URLRequireChecksum:   nodeConfig.URLRequireChecksum,
+			InputCache:           nodeConfig.InputCache,
 		},
 	)
 }
@@ -104,11 +109,19 @@ func (f *StandardExecutorsFactory) Get(
 		ctx,
 		nodeConfig.CleanupManager,
 		executor_util.StandardExecutorOptions{
-			DockerID:   fmt.Sprintf("bacalhau-%s", nodeConfig.HostID),
-			IsBadActor: nodeConfig.IsBadActor,
+			DockerID:      fmt.Sprintf("bacalhau-%s", nodeConfig.HostID),
+			DockerSandbox: nodeConfig.DockerSandbox,
+			DockerImages:  nodeConfig.DockerImages,
+			Native:        nodeConfig.NativeExecutor,
+			IsBadActor:    nodeConfig.IsBadActor,
 			Storage: executor_util.StandardStorageProviderOptions{
 				IPFSMultiaddress:     nodeConfig.IPFSClient.APIAddress(),
 				FilecoinUnsealedPath: nodeConfig.FilecoinUnsealedPath,
+				S3:                   nodeConfig.S3,
+				Git:                  nodeConfig.Git,
+				Archive:              nodeConfig.Archive,
+				URLRequireChecksum:   nodeConfig.URLRequireChecksum,
+				InputCache:           nodeConfig.InputCache,
 			},
 		},
 	)
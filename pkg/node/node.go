@@ -6,11 +6,17 @@ import (
 	computenode "github.com/filecoin-project/bacalhau/pkg/computenode"
 	"github.com/filecoin-project/bacalhau/pkg/controller"
 	"github.com/filecoin-project/bacalhau/pkg/executor"
+	"github.com/filecoin-project/bacalhau/pkg/executor/docker"
+	"github.com/filecoin-project/bacalhau/pkg/executor/native"
 	"github.com/filecoin-project/bacalhau/pkg/ipfs"
 	"github.com/filecoin-project/bacalhau/pkg/localdb/inmemory"
 	"github.com/filecoin-project/bacalhau/pkg/model"
 	"github.com/filecoin-project/bacalhau/pkg/publicapi"
 	"github.com/filecoin-project/bacalhau/pkg/requesternode"
+	"github.com/filecoin-project/bacalhau/pkg/storage/archive"
+	"github.com/filecoin-project/bacalhau/pkg/storage/git"
+	"github.com/filecoin-project/bacalhau/pkg/storage/inputcache"
+	"github.com/filecoin-project/bacalhau/pkg/storage/s3"
 	"github.com/filecoin-project/bacalhau/pkg/system"
 	"github.com/filecoin-project/bacalhau/pkg/transport"
 	"github.com/rs/zerolog/log"
@@ -22,12 +28,21 @@ type NodeConfig struct {
 	CleanupManager       *system.CleanupManager
 	Transport            transport.Transport
 	FilecoinUnsealedPath string
+	S3                   s3.Config
+	Git                  git.Config
+	Archive              archive.Config
+	URLRequireChecksum   bool
+	InputCache           *inputcache.InputCache
+	DockerSandbox        docker.SandboxConfig
+	DockerImages         docker.ImageConfig
+	NativeExecutor       native.Config
 	EstuaryAPIKey        string
 	HostAddress          string
 	HostID               string
 	APIPort              int
 	MetricsPort          int
 	IsBadActor           bool
+	AllowPrivateNodeAPIs bool
 	ComputeNodeConfig    computenode.ComputeNodeConfig
 	RequesterNodeConfig  requesternode.RequesterNodeConfig
 }
@@ -172,6 +187,8 @@ func NewNode(
 		controller,
 		publishers,
 	)
+	apiServer.ComputeNode = computeNode
+	apiServer.AllowPrivateNodeAPIs = config.AllowPrivateNodeAPIs
 
 	node := &Node{
 		CleanupManager: config.CleanupManager,
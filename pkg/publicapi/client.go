@@ -13,6 +13,7 @@ import (
 
 	"github.com/filecoin-project/bacalhau/pkg/job"
 	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/filecoin-project/bacalhau/pkg/secrets"
 	"github.com/filecoin-project/bacalhau/pkg/system"
 	"github.com/rs/zerolog/log"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
@@ -251,6 +252,50 @@ func (apiClient *APIClient) Submit(
 	return res.Job, nil
 }
 
+// DeliverSecrets answers a compute node asking for the values of the job's
+// secrets so it can run a shard. The values are sealed to the key in the
+// request so only that node can read them.
+func (apiClient *APIClient) DeliverSecrets(
+	ctx context.Context,
+	request model.JobEvent,
+	values map[string]string,
+) error {
+	ctx, span := system.GetTracer().Start(ctx, "pkg/publicapi.DeliverSecrets")
+	defer span.End()
+
+	sealed, err := secrets.Seal(request.SecretsPublicKey, values)
+	if err != nil {
+		return err
+	}
+
+	data := secretsPayload{
+		ClientID:   system.GetClientID(),
+		JobID:      request.JobID,
+		NodeID:     request.SourceNodeID,
+		ShardIndex: request.ShardIndex,
+		Secrets:    sealed,
+	}
+
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	signature, err := system.SignForClient(jsonData)
+	if err != nil {
+		return err
+	}
+
+	var res secretsResponse
+	req := secretsRequest{
+		Data:            data,
+		ClientSignature: signature,
+		ClientPublicKey: system.GetClientPublicKey(),
+	}
+
+	return apiClient.post(ctx, "secrets", req, &res)
+}
+
 // Submit submits a new job to the node's transport.
 func (apiClient *APIClient) Version(ctx context.Context) (*model.VersionInfo, error) {
 	ctx, span := system.GetTracer().Start(ctx, "pkg/publicapi.Version")
@@ -268,6 +313,118 @@ func (apiClient *APIClient) Version(ctx context.Context) (*model.VersionInfo, er
 	return res.VersionInfo, nil
 }
 
+// Nodes returns the compute nodes the server has heard a heartbeat from recently.
+func (apiClient *APIClient) Nodes(ctx context.Context) ([]model.NodeInfo, error) {
+	ctx, span := system.GetTracer().Start(ctx, "pkg/publicapi.Nodes")
+	defer span.End()
+
+	req := nodesRequest{
+		ClientID: system.GetClientID(),
+	}
+
+	var res nodesResponse
+	if err := apiClient.post(ctx, "nodes", req, &res); err != nil {
+		return nil, err
+	}
+
+	return res.Nodes, nil
+}
+
+// Drain stops the compute node from taking on new jobs.
+func (apiClient *APIClient) Drain(ctx context.Context) (model.DrainStatus, error) {
+	ctx, span := system.GetTracer().Start(ctx, "pkg/publicapi.Drain")
+	defer span.End()
+	return apiClient.drainRequest(ctx, "admin/drain")
+}
+
+// Undrain lets the compute node take on new jobs again.
+func (apiClient *APIClient) Undrain(ctx context.Context) (model.DrainStatus, error) {
+	ctx, span := system.GetTracer().Start(ctx, "pkg/publicapi.Undrain")
+	defer span.End()
+	return apiClient.drainRequest(ctx, "admin/undrain")
+}
+
+// DrainStatus reports whether the compute node is safe to shut down.
+func (apiClient *APIClient) DrainStatus(ctx context.Context) (model.DrainStatus, error) {
+	ctx, span := system.GetTracer().Start(ctx, "pkg/publicapi.DrainStatus")
+	defer span.End()
+	return apiClient.drainRequest(ctx, "admin/drain_status")
+}
+
+func (apiClient *APIClient) drainRequest(ctx context.Context, api string) (model.DrainStatus, error) {
+	req := drainRequest{
+		ClientID: system.GetClientID(),
+	}
+
+	var res drainResponse
+	if err := apiClient.post(ctx, api, req, &res); err != nil {
+		return model.DrainStatus{}, err
+	}
+
+	return res.Status, nil
+}
+
+// Logs calls fn with each piece of output from a shard. If follow is true
+// this keeps going until the shard finishes or ctx is cancelled.
+func (apiClient *APIClient) Logs(
+	ctx context.Context,
+	jobID string,
+	shardIndex int,
+	follow bool,
+	fn func(model.LogChunk) error,
+) error {
+	ctx, span := system.GetTracer().Start(ctx, "pkg/publicapi.Logs")
+	defer span.End()
+
+	var body bytes.Buffer
+	err := json.NewEncoder(&body).Encode(logsRequest{
+		ClientID:   system.GetClientID(),
+		JobID:      jobID,
+		ShardIndex: shardIndex,
+		Follow:     follow,
+	})
+	if err != nil {
+		return fmt.Errorf("publicapi: error encoding request body: %v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/logs", apiClient.BaseURI), &body)
+	if err != nil {
+		return fmt.Errorf("publicapi: error creating post request: %v", err)
+	}
+	req.Header.Set("Content-type", "application/json")
+
+	// following logs can take as long as the shard does so
+	// don't use the client timeout
+	streamClient := &http.Client{Transport: apiClient.client.Transport}
+	res, err := streamClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		resBody, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("publicapi: received non-200 status: %d %s", res.StatusCode, strings.TrimSpace(string(resBody)))
+	}
+
+	decoder := json.NewDecoder(res.Body)
+	for {
+		var chunk model.LogChunk
+		err = decoder.Decode(&chunk)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("publicapi: error decoding logs: %v", err)
+		}
+		if err = fn(chunk); err != nil {
+			return err
+		}
+	}
+}
+
 func (apiClient *APIClient) post(ctx context.Context, api string, reqData, resData interface{}) error {
 	ctx, span := system.GetTracer().Start(ctx, "pkg/publicapi.post")
 	defer span.End()
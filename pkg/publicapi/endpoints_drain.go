@@ -0,0 +1,85 @@
+package publicapi
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+
+	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/filecoin-project/bacalhau/pkg/system"
+)
+
+type drainRequest struct {
+	ClientID string `json:"client_id"`
+}
+
+type drainResponse struct {
+	Status model.DrainStatus `json:"status"`
+}
+
+func (apiServer *APIServer) drain(res http.ResponseWriter, req *http.Request) {
+	ctx, span := system.GetSpanFromRequest(req, "apiServer/drain")
+	defer span.End()
+
+	if !apiServer.checkAdminRequest(res, req) {
+		return
+	}
+	apiServer.writeDrainStatus(res, apiServer.ComputeNode.Drain(ctx))
+}
+
+func (apiServer *APIServer) undrain(res http.ResponseWriter, req *http.Request) {
+	ctx, span := system.GetSpanFromRequest(req, "apiServer/undrain")
+	defer span.End()
+
+	if !apiServer.checkAdminRequest(res, req) {
+		return
+	}
+	apiServer.writeDrainStatus(res, apiServer.ComputeNode.Undrain(ctx))
+}
+
+func (apiServer *APIServer) drainStatus(res http.ResponseWriter, req *http.Request) {
+	ctx, span := system.GetSpanFromRequest(req, "apiServer/drainStatus")
+	defer span.End()
+
+	if !apiServer.checkAdminRequest(res, req) {
+		return
+	}
+	apiServer.writeDrainStatus(res, apiServer.ComputeNode.DrainStatus(ctx))
+}
+
+// admin endpoints change how the node behaves so we only
+// accept them from the machine the node is running on
+// this also decodes the request body
+func (apiServer *APIServer) checkAdminRequest(res http.ResponseWriter, req *http.Request) bool {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		http.Error(res, "admin endpoints can only be called from localhost", http.StatusForbidden)
+		return false
+	}
+	if apiServer.ComputeNode == nil {
+		http.Error(res, "this node is not running a compute node", http.StatusBadRequest)
+		return false
+	}
+
+	var drainReq drainRequest
+	if err := json.NewDecoder(req.Body).Decode(&drainReq); err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func (apiServer *APIServer) writeDrainStatus(res http.ResponseWriter, status model.DrainStatus) {
+	res.WriteHeader(http.StatusOK)
+	err := json.NewEncoder(res).Encode(drainResponse{
+		Status: status,
+	})
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,214 @@
+package publicapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/filecoin-project/bacalhau/pkg/system"
+	"github.com/rs/zerolog/log"
+)
+
+const nodeAPIDialTimeout = 30 * time.Second
+
+type logsRequest struct {
+	ClientID   string `json:"client_id"`
+	JobID      string `json:"job_id"`
+	ShardIndex int    `json:"shard_index"`
+	// keep streaming until the shard finishes
+	Follow bool `json:"follow"`
+	// only look at this node's compute node - set when a
+	// requester proxies the request so we never loop
+	LocalOnly bool `json:"local_only"`
+}
+
+// logs streams the output of a shard as newline delimited LogChunk JSON
+// if the shard is not running here we proxy to the node that is running it
+func (apiServer *APIServer) logs(res http.ResponseWriter, req *http.Request) {
+	ctx, span := system.GetSpanFromRequest(req, "apiServer/logs")
+	defer span.End()
+
+	var logsReq logsRequest
+	if err := json.NewDecoder(req.Body).Decode(&logsReq); err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	computeNode := apiServer.ComputeNode
+	if computeNode != nil && computeNode.HasShardLogs(logsReq.JobID, logsReq.ShardIndex) {
+		apiServer.streamLocalLogs(ctx, res, logsReq)
+		return
+	}
+	if logsReq.LocalOnly {
+		http.Error(res, "no logs for this shard on this node", http.StatusNotFound)
+		return
+	}
+
+	apiURL, err := apiServer.findShardLogsNode(ctx, logsReq)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusNotFound)
+		return
+	}
+	apiServer.proxyLogs(ctx, res, apiURL, logsReq)
+}
+
+func (apiServer *APIServer) streamLocalLogs(ctx context.Context, res http.ResponseWriter, logsReq logsRequest) {
+	res.Header().Set("Content-type", "application/x-ndjson")
+	res.WriteHeader(http.StatusOK)
+	flusher, _ := res.(http.Flusher)
+	encoder := json.NewEncoder(res)
+
+	err := apiServer.ComputeNode.StreamShardLogs(ctx, logsReq.JobID, logsReq.ShardIndex, logsReq.Follow,
+		func(chunk model.LogChunk) error {
+			if err := encoder.Encode(chunk); err != nil {
+				return err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+			return nil
+		})
+	if err != nil {
+		// too late to change the status code - the client will see the stream end
+		log.Debug().Msgf("Stopped streaming logs for %s shard %d: %s", logsReq.JobID, logsReq.ShardIndex, err)
+	}
+}
+
+// work out which compute node has been running the shard and where its API is
+func (apiServer *APIServer) findShardLogsNode(ctx context.Context, logsReq logsRequest) (string, error) {
+	jobState, err := apiServer.Controller.GetJobState(ctx, logsReq.JobID)
+	if err != nil {
+		return "", err
+	}
+
+	nodeID := ""
+	for id, nodeState := range jobState.Nodes {
+		shardState, ok := nodeState.Shards[logsReq.ShardIndex]
+		if !ok {
+			continue
+		}
+		switch shardState.State {
+		case model.JobStateRunning:
+			// this is the one to follow
+			nodeID = id
+		case model.JobStateVerifying, model.JobStateCompleted, model.JobStateError:
+			if nodeID == "" {
+				nodeID = id
+			}
+		}
+	}
+	if nodeID == "" {
+		return "", fmt.Errorf("shard %d of job %s has not started running", logsReq.ShardIndex, logsReq.JobID)
+	}
+
+	for _, info := range apiServer.Controller.GetNodes(ctx) {
+		if info.NodeID != nodeID {
+			continue
+		}
+		if info.APIURL == "" {
+			return "", fmt.Errorf("node %s running shard %d has not advertised its API", nodeID, logsReq.ShardIndex)
+		}
+		return info.APIURL, nil
+	}
+	return "", fmt.Errorf("node %s running shard %d is not known to this node", nodeID, logsReq.ShardIndex)
+}
+
+func (apiServer *APIServer) proxyLogs(ctx context.Context, res http.ResponseWriter, apiURL string, logsReq logsRequest) {
+	logsURL, err := nodeLogsURL(apiURL)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadGateway)
+		return
+	}
+	logsReq.LocalOnly = true
+	var body bytes.Buffer
+	if err = json.NewEncoder(&body).Encode(logsReq); err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	proxyReq, err := http.NewRequestWithContext(ctx, http.MethodPost, logsURL, &body)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	proxyReq.Header.Set("Content-type", "application/json")
+
+	proxyRes, err := newNodeAPIClient(apiServer.AllowPrivateNodeAPIs).Do(proxyReq)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer proxyRes.Body.Close()
+
+	// the URL comes from the node itself, so only pass on what a
+	// bacalhau node answers with rather than whatever is there
+	if proxyRes.StatusCode != http.StatusOK || proxyRes.Header.Get("Content-type") != "application/x-ndjson" {
+		http.Error(res, fmt.Sprintf("node API at %s answered %s", apiURL, proxyRes.Status), http.StatusBadGateway)
+		return
+	}
+	res.Header().Set("Content-type", "application/x-ndjson")
+	res.WriteHeader(http.StatusOK)
+	flusher, _ := res.(http.Flusher)
+	buf := make([]byte, 32*1024) //nolint:gomnd
+	for {
+		n, readErr := proxyRes.Body.Read(buf)
+		if n > 0 {
+			if _, err = res.Write(buf[:n]); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if readErr == io.EOF {
+			return
+		}
+		if readErr != nil {
+			log.Debug().Msgf("Stopped proxying logs from %s: %s", apiURL, readErr)
+			return
+		}
+	}
+}
+
+// the logs endpoint of a node's advertised API URL, which has to be http(s)
+func nodeLogsURL(apiURL string) (string, error) {
+	parsed, err := url.Parse(apiURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid node API URL %q: %w", apiURL, err)
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" || parsed.User != nil {
+		return "", fmt.Errorf("invalid node API URL %q: must be http(s)://host[:port]", apiURL)
+	}
+	parsed.Path = strings.TrimSuffix(parsed.Path, "/") + "/logs"
+	parsed.RawQuery = ""
+	parsed.Fragment = ""
+	return parsed.String(), nil
+}
+
+// a client for calling other nodes' APIs - they advertise their own URLs,
+// so unless we trust every node it only connects to public addresses and
+// never follows redirects
+// there is no timeout as following logs can take as long as the shard
+func newNodeAPIClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: nodeAPIDialTimeout}
+	if !allowPrivate {
+		dialer.Control = system.RefuseNonPublicAddress
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			// no proxy from the environment, it would be the address we check
+			DialContext:       dialer.DialContext,
+			DisableKeepAlives: true,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
@@ -0,0 +1,37 @@
+package publicapi
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/filecoin-project/bacalhau/pkg/system"
+)
+
+type nodesRequest struct {
+	ClientID string `json:"client_id"`
+}
+
+type nodesResponse struct {
+	Nodes []model.NodeInfo `json:"nodes"`
+}
+
+func (apiServer *APIServer) nodes(res http.ResponseWriter, req *http.Request) {
+	ctx, span := system.GetSpanFromRequest(req, "apiServer/nodes")
+	defer span.End()
+
+	var nodesReq nodesRequest
+	if err := json.NewDecoder(req.Body).Decode(&nodesReq); err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	res.WriteHeader(http.StatusOK)
+	err := json.NewEncoder(res).Encode(nodesResponse{
+		Nodes: apiServer.Controller.GetNodes(ctx),
+	})
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
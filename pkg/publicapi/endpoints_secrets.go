@@ -0,0 +1,91 @@
+package publicapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+type secretsPayload struct {
+	// the id of the client that submitted the job
+	ClientID   string `json:"client_id"`
+	JobID      string `json:"job_id"`
+	NodeID     string `json:"node_id"`
+	ShardIndex int    `json:"shard_index"`
+
+	// the values of the job's secrets sealed to the
+	// key the compute node asked for them with
+	Secrets []byte `json:"secrets"`
+}
+
+type secretsRequest struct {
+	Data secretsPayload `json:"data"`
+
+	// A base64-encoded signature of the data, signed by the client:
+	ClientSignature string `json:"signature"`
+
+	// The base64-encoded public key of the client:
+	ClientPublicKey string `json:"client_public_key"`
+}
+
+type secretsResponse struct{}
+
+func (apiServer *APIServer) secrets(res http.ResponseWriter, req *http.Request) {
+	var secretsReq secretsRequest
+	if err := json.NewDecoder(req.Body).Decode(&secretsReq); err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := verifySecretsRequest(&secretsReq); err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	j, err := apiServer.Controller.GetJob(req.Context(), secretsReq.Data.JobID)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusNotFound)
+		return
+	}
+	// only whoever submitted the job can give it secrets
+	if j.ClientID != secretsReq.Data.ClientID {
+		http.Error(res, "job was not submitted by this client", http.StatusForbidden)
+		return
+	}
+	if len(j.Spec.Secrets) == 0 {
+		http.Error(res, "job has no secrets", http.StatusBadRequest)
+		return
+	}
+
+	err = apiServer.Controller.DeliverShardSecrets(
+		req.Context(),
+		secretsReq.Data.JobID,
+		secretsReq.Data.NodeID,
+		secretsReq.Data.ShardIndex,
+		secretsReq.Data.Secrets,
+	)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(res).Encode(secretsResponse{})
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func verifySecretsRequest(req *secretsRequest) error {
+	if req.Data.ClientID == "" {
+		return errors.New("secrets must say which client is sending them")
+	}
+	if req.Data.NodeID == "" {
+		return errors.New("secrets must say which node they are for")
+	}
+	if len(req.Data.Secrets) == 0 {
+		return errors.New("no secrets were sent")
+	}
+	return verifyClientSignature(req.Data.ClientID, req.Data, req.ClientSignature, req.ClientPublicKey)
+}
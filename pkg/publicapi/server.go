@@ -12,6 +12,7 @@ import (
 
 	"github.com/didip/tollbooth"
 	"github.com/didip/tollbooth/limiter"
+	"github.com/filecoin-project/bacalhau/pkg/computenode"
 	"github.com/filecoin-project/bacalhau/pkg/controller"
 	"github.com/filecoin-project/bacalhau/pkg/model"
 	"github.com/filecoin-project/bacalhau/pkg/publisher"
@@ -23,11 +24,17 @@ import (
 
 // APIServer configures a node's public REST API.
 type APIServer struct {
-	Controller  *controller.Controller
+	Controller *controller.Controller
+	// the compute node running alongside this server, if any
+	// this is used by the admin endpoints
+	ComputeNode *computenode.ComputeNode
 	Publishers  map[model.PublisherType]publisher.Publisher
 	Host        string
 	Port        int
 	componentMu sync.Mutex
+	// let the logs proxy call node APIs on loopback and private
+	// addresses - only for clusters where every node is trusted
+	AllowPrivateNodeAPIs bool
 }
 
 func init() { //nolint:gochecknoinits
@@ -87,7 +94,13 @@ func (apiServer *APIServer) ListenAndServe(ctx context.Context, cm *system.Clean
 	sm.Handle("/local_events", throttle(instrument("local_events", apiServer.localEvents)))
 	sm.Handle("/id", throttle(instrument("id", apiServer.id)))
 	sm.Handle("/peers", throttle(instrument("peers", apiServer.peers)))
+	sm.Handle("/nodes", throttle(instrument("nodes", apiServer.nodes)))
+	sm.Handle("/logs", throttle(instrument("logs", apiServer.logs)))
+	sm.Handle("/admin/drain", throttle(instrument("admin/drain", apiServer.drain)))
+	sm.Handle("/admin/undrain", throttle(instrument("admin/undrain", apiServer.undrain)))
+	sm.Handle("/admin/drain_status", throttle(instrument("admin/drain_status", apiServer.drainStatus)))
 	sm.Handle("/submit", throttle(instrument("submit", apiServer.submit)))
+	sm.Handle("/secrets", throttle(instrument("secrets", apiServer.secrets)))
 	sm.Handle("/version", throttle(instrument("version", apiServer.version)))
 	sm.Handle("/healthz", throttle(instrument("healthz", apiServer.healthz)))
 	sm.Handle("/logz", throttle(instrument("logz", apiServer.logz)))
@@ -147,15 +160,20 @@ func verifySubmitRequest(req *submitRequest) error {
 	if req.Data.ClientID == "" {
 		return errors.New("job deal must contain a client ID")
 	}
-	if req.ClientSignature == "" {
+	return verifyClientSignature(req.Data.ClientID, req.Data, req.ClientSignature, req.ClientPublicKey)
+}
+
+// check that the data was signed by the client with the given ID
+func verifyClientSignature(clientID string, data interface{}, signature, publicKey string) error {
+	if signature == "" {
 		return errors.New("client's signature is required")
 	}
-	if req.ClientPublicKey == "" {
+	if publicKey == "" {
 		return errors.New("client's public key is required")
 	}
 
 	// Check that the client's public key matches the client ID:
-	ok, err := system.PublicKeyMatchesID(req.ClientPublicKey, req.Data.ClientID)
+	ok, err := system.PublicKeyMatchesID(publicKey, clientID)
 	if err != nil {
 		return fmt.Errorf("error verifying client ID: %w", err)
 	}
@@ -164,12 +182,12 @@ func verifySubmitRequest(req *submitRequest) error {
 	}
 
 	// Check that the signature is valid:
-	jsonData, err := json.Marshal(req.Data)
+	jsonData, err := json.Marshal(data)
 	if err != nil {
 		return fmt.Errorf("error marshaling job data: %w", err)
 	}
 
-	err = system.Verify(jsonData, req.ClientSignature, req.ClientPublicKey)
+	err = system.Verify(jsonData, signature, publicKey)
 	if err != nil {
 		return fmt.Errorf("client's signature is invalid: %w", err)
 	}
@@ -3,6 +3,7 @@ package requesternode
 import (
 	"context"
 	"math/rand"
+	"sort"
 
 	"github.com/filecoin-project/bacalhau/pkg/controller"
 	"github.com/filecoin-project/bacalhau/pkg/model"
@@ -51,6 +52,7 @@ func getLocalShardEvents(
 }
 
 // these are the bids we have heard about
+// minus any that the compute node has since cancelled
 func getGlobalShardBidEvents(
 	ctx context.Context,
 	controller *controller.Controller,
@@ -61,9 +63,16 @@ func getGlobalShardBidEvents(
 	if err != nil {
 		return nil, err
 	}
+	cancelledNodes := map[string]bool{}
+	for _, globalEvent := range globalEvents { //nolint:gocritic
+		if globalEvent.EventName == model.JobEventBidCancelled && globalEvent.ShardIndex == shardIndex {
+			cancelledNodes[globalEvent.SourceNodeID] = true
+		}
+	}
 	shardGlobalEvents := []model.JobEvent{}
 	for _, globalEvent := range globalEvents { //nolint:gocritic
-		if globalEvent.EventName == model.JobEventBid && globalEvent.ShardIndex == shardIndex {
+		if globalEvent.EventName == model.JobEventBid && globalEvent.ShardIndex == shardIndex &&
+			!cancelledNodes[globalEvent.SourceNodeID] {
 			shardGlobalEvents = append(shardGlobalEvents, globalEvent)
 		}
 	}
@@ -104,6 +113,12 @@ func getCandidateBids(
 		candidateBids[i], candidateBids[j] = candidateBids[j], candidateBids[i]
 	})
 
+	// then prefer nodes that already hold more of the input data
+	// nodes with the same score stay in random order
+	sort.SliceStable(candidateBids, func(i, j int) bool {
+		return candidateBids[i].LocalityScore > candidateBids[j].LocalityScore
+	})
+
 	return candidateBids
 }
 
@@ -144,8 +159,8 @@ func processIncomingBid(
 		return results, nil
 	} else if len(bidsHeard) == minBids {
 		// we've reached our threshold of when we can start accepting bids
-		// first let's randomize the list of bids
-		// then pick the first concurrency number of them to accept and reject the rest
+		// the list of bids is randomized and then ordered by locality score
+		// so we pick the first concurrency number of them to accept and reject the rest
 		// if min bids < concurrency then we accept them all
 		bidsToAcceptCount := len(candidateBids)
 		if bidsToAcceptCount > concurrency {
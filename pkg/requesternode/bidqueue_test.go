@@ -0,0 +1,27 @@
+package requesternode
+
+import (
+	"context"
+	"testing"
+
+	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCandidateBidsPreferLocalData(t *testing.T) {
+	bids := []model.JobEvent{
+		{SourceNodeID: "far", LocalityScore: 0},
+		{SourceNodeID: "near", LocalityScore: 1},
+		{SourceNodeID: "responded", LocalityScore: 1},
+		{SourceNodeID: "middle", LocalityScore: 0.5},
+	}
+	accepted := []model.JobLocalEvent{
+		{EventName: model.JobLocalEventBidAccepted, TargetNodeID: "responded"},
+	}
+
+	candidates := getCandidateBids(context.Background(), bids, accepted, []model.JobLocalEvent{})
+	require.Equal(t, 3, len(candidates))
+	require.Equal(t, "near", candidates[0].SourceNodeID)
+	require.Equal(t, "middle", candidates[1].SourceNodeID)
+	require.Equal(t, "far", candidates[2].SourceNodeID)
+}
@@ -0,0 +1,336 @@
+package resultcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	sync "github.com/lukemarsden/golang-mutex-tracer"
+	"github.com/rs/zerolog/log"
+
+	"github.com/filecoin-project/bacalhau/pkg/model"
+)
+
+// configures where we keep cached shard results and how big the cache can get
+type Config struct {
+	// the folder the cached results live in
+	// if this is empty then result caching is disabled
+	Dir string
+	// the total number of bytes all cached results can use
+	// once we go over this the least recently used results are evicted
+	MaxSize uint64
+	// results bigger than this are never cached
+	// zero means any result that fits in MaxSize can be cached
+	MaxEntrySize uint64
+}
+
+type cacheEntry struct {
+	size     uint64
+	lastUsed time.Time
+}
+
+// ResultCache is a content addressed store of shard results
+// keyed on a hash of everything that can affect the output of a shard
+// if we see the same shard again we can propose the cached results
+// rather than running it again
+type ResultCache struct {
+	config    Config
+	entries   map[string]*cacheEntry
+	totalSize uint64
+	mu        sync.Mutex
+}
+
+func NewResultCache(config Config) (*ResultCache, error) {
+	if config.Dir == "" {
+		return nil, fmt.Errorf("result cache needs a directory")
+	}
+	if config.MaxSize == 0 {
+		return nil, fmt.Errorf("result cache needs a max size")
+	}
+	err := os.MkdirAll(config.Dir, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	cache := &ResultCache{
+		config:  config,
+		entries: map[string]*cacheEntry{},
+	}
+	cache.mu.EnableTracerWithOpts(sync.Opts{
+		Threshold: 10 * time.Millisecond,
+		Id:        "ResultCache.mu",
+	})
+
+	// pick up any results we cached before we were restarted
+	items, err := os.ReadDir(config.Dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		itemPath := filepath.Join(config.Dir, item.Name())
+		// a leftover from a put that never finished
+		if strings.HasPrefix(item.Name(), ".") || !item.IsDir() {
+			_ = os.RemoveAll(itemPath)
+			continue
+		}
+		info, err := item.Info()
+		if err != nil {
+			return nil, err
+		}
+		size, err := dirSize(itemPath)
+		if err != nil {
+			return nil, err
+		}
+		cache.entries[item.Name()] = &cacheEntry{
+			size:     size,
+			lastUsed: info.ModTime(),
+		}
+		cache.totalSize += size
+	}
+	cache.evict(0)
+
+	return cache, nil
+}
+
+// Key works out the cache key for a shard
+// the second return value is false if the shard can't be cached, either
+// because the job opted out or because we can't be sure that running it
+// again would give the same results (e.g. a docker image that is not
+// pinned to a digest or an input that is not content addressed)
+func Key(shard model.JobShard) (string, bool) {
+	spec := shard.Job.Spec
+	if spec.DoNotCache {
+		return "", false
+	}
+	// we can't tell if the secrets were the same as last time
+	if len(spec.Secrets) > 0 {
+		return "", false
+	}
+	// anything on the network could change between runs
+	if !spec.Network.Disabled() {
+		return "", false
+	}
+	if spec.Engine == model.EngineDocker && !strings.Contains(spec.Docker.Image, "@sha256:") {
+		return "", false
+	}
+	volumes := []model.StorageSpec{}
+	volumes = append(volumes, spec.Inputs...)
+	volumes = append(volumes, spec.Contexts...)
+	if spec.Engine == model.EngineLanguage && spec.Language.Context.URL != "" {
+		volumes = append(volumes, spec.Language.Context)
+	}
+	if spec.Engine == model.EngineWasm {
+		volumes = append(volumes, spec.Wasm.EntryModule)
+	}
+	if spec.Engine == model.EngineNative {
+		if model.IsValidStorageSourceType(spec.Native.Binary.Engine) {
+			volumes = append(volumes, spec.Native.Binary)
+		} else {
+			volumes = append(volumes, spec.Native.Image)
+		}
+	}
+	for _, volume := range volumes {
+		if volume.Cid == "" {
+			return "", false
+		}
+	}
+
+	// only the fields that can change the output of the shard
+	// json.Marshal sorts map keys so this is stable
+	keyData := struct {
+		Engine      model.EngineType        `json:"engine"`
+		Verifier    model.VerifierType      `json:"verifier"`
+		Docker      model.JobSpecDocker     `json:"docker"`
+		Language    model.JobSpecLanguage   `json:"language"`
+		Wasm        model.JobSpecWasm       `json:"wasm"`
+		Native      model.JobSpecNative     `json:"native"`
+		Inputs      []model.StorageSpec     `json:"inputs"`
+		Contexts    []model.StorageSpec     `json:"contexts"`
+		Outputs     []model.StorageSpec     `json:"outputs"`
+		Sharding    model.JobShardingConfig `json:"sharding"`
+		TotalShards int                     `json:"total_shards"`
+		ShardIndex  int                     `json:"shard_index"`
+	}{
+		Engine:      spec.Engine,
+		Verifier:    spec.Verifier,
+		Docker:      spec.Docker,
+		Language:    spec.Language,
+		Wasm:        spec.Wasm,
+		Native:      spec.Native,
+		Inputs:      spec.Inputs,
+		Contexts:    spec.Contexts,
+		Outputs:     spec.Outputs,
+		Sharding:    spec.Sharding,
+		TotalShards: shard.Job.ExecutionPlan.TotalShards,
+		ShardIndex:  shard.Index,
+	}
+	data, err := json.Marshal(keyData)
+	if err != nil {
+		return "", false
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), true
+}
+
+// Get copies the cached results for key into resultsDir
+// returns false if there is nothing cached for the key
+func (cache *ResultCache) Get(key, resultsDir string) (bool, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	entry, ok := cache.entries[key]
+	if !ok {
+		return false, nil
+	}
+	err := copyDir(filepath.Join(cache.config.Dir, key), resultsDir)
+	if err != nil {
+		return false, err
+	}
+	entry.lastUsed = time.Now()
+	return true, nil
+}
+
+// Put copies the results in resultsDir into the cache under key
+// results that are too big to cache are silently skipped
+func (cache *ResultCache) Put(key, resultsDir string) error {
+	size, err := dirSize(resultsDir)
+	if err != nil {
+		return err
+	}
+	if size > cache.config.MaxSize || (cache.config.MaxEntrySize > 0 && size > cache.config.MaxEntrySize) {
+		log.Debug().Msgf("Not caching results for %s: %d bytes is too big", key, size)
+		return nil
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if entry, ok := cache.entries[key]; ok {
+		entry.lastUsed = time.Now()
+		return nil
+	}
+
+	// copy to a temporary folder first so a half written
+	// entry is never picked up
+	tmpDir, err := os.MkdirTemp(cache.config.Dir, ".put-")
+	if err != nil {
+		return err
+	}
+	err = copyDir(resultsDir, tmpDir)
+	if err != nil {
+		_ = os.RemoveAll(tmpDir)
+		return err
+	}
+
+	cache.evict(size)
+
+	err = os.Rename(tmpDir, filepath.Join(cache.config.Dir, key))
+	if err != nil {
+		_ = os.RemoveAll(tmpDir)
+		return err
+	}
+	cache.entries[key] = &cacheEntry{
+		size:     size,
+		lastUsed: time.Now(),
+	}
+	cache.totalSize += size
+	return nil
+}
+
+// Size is the number of bytes currently used by cached results
+func (cache *ResultCache) Size() uint64 {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	return cache.totalSize
+}
+
+// remove the least recently used entries until there is room
+// for another entry of the given size - must be called with the lock held
+func (cache *ResultCache) evict(incoming uint64) {
+	if cache.totalSize+incoming <= cache.config.MaxSize {
+		return
+	}
+	keys := []string{}
+	for key := range cache.entries {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return cache.entries[keys[i]].lastUsed.Before(cache.entries[keys[j]].lastUsed)
+	})
+	for _, key := range keys {
+		if cache.totalSize+incoming <= cache.config.MaxSize {
+			return
+		}
+		err := os.RemoveAll(filepath.Join(cache.config.Dir, key))
+		if err != nil {
+			log.Warn().Msgf("Could not evict cached results %s: %s", key, err)
+			continue
+		}
+		cache.totalSize -= cache.entries[key].size
+		delete(cache.entries, key)
+	}
+}
+
+func dirSize(dir string) (uint64, error) {
+	var size uint64
+	err := filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += uint64(info.Size())
+		}
+		return nil
+	})
+	return size, err
+}
+
+// copy the contents of source into destination, creating it if needed
+func copyDir(source, destination string) error {
+	return filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(destination, relPath)
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0700) //nolint:gomnd
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		default:
+			return copyFile(path, target, info.Mode().Perm())
+		}
+	})
+}
+
+func copyFile(source, destination string, perm os.FileMode) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(destination, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,155 @@
+package resultcache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/stretchr/testify/require"
+)
+
+func writeResults(t *testing.T, contents string) string {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "stdout"), []byte(contents), 0600))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "outputs"), 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "outputs", "result.txt"), []byte(contents), 0600))
+	return dir
+}
+
+func cacheableShard() model.JobShard {
+	return model.JobShard{
+		Job: model.Job{
+			ID: "job-a",
+			Spec: model.JobSpec{
+				Engine:   model.EngineDocker,
+				Verifier: model.VerifierNoop,
+				Docker: model.JobSpecDocker{
+					Image:      "ubuntu@sha256:0123456789abcdef",
+					Entrypoint: []string{"echo", "hello"},
+				},
+				Inputs: []model.StorageSpec{
+					{Engine: model.StorageSourceIPFS, Cid: "QmInput", Path: "/inputs"},
+				},
+			},
+			ExecutionPlan: model.JobExecutionPlan{TotalShards: 1},
+		},
+	}
+}
+
+func TestKey(t *testing.T) {
+	shard := cacheableShard()
+	key, ok := Key(shard)
+	require.True(t, ok)
+
+	// the job id and the things that don't affect the output are not part of the key
+	other := cacheableShard()
+	other.Job.ID = "job-b"
+	other.Job.Spec.Annotations = []string{"something"}
+	other.Job.Spec.Publisher = model.PublisherEstuary
+	otherKey, ok := Key(other)
+	require.True(t, ok)
+	require.Equal(t, key, otherKey)
+
+	// a different entrypoint is a different key
+	other.Job.Spec.Docker.Entrypoint = []string{"echo", "world"}
+	otherKey, ok = Key(other)
+	require.True(t, ok)
+	require.NotEqual(t, key, otherKey)
+
+	// a different shard is a different key
+	other = cacheableShard()
+	other.Index = 1
+	otherKey, ok = Key(other)
+	require.True(t, ok)
+	require.NotEqual(t, key, otherKey)
+
+	// the secrets could be different each time
+	other = cacheableShard()
+	other.Job.Spec.Secrets = []model.JobSpecSecret{{Name: "token", Env: "TOKEN"}}
+	_, ok = Key(other)
+	require.False(t, ok)
+
+	// opted out
+	other = cacheableShard()
+	other.Job.Spec.DoNotCache = true
+	_, ok = Key(other)
+	require.False(t, ok)
+
+	// image tags can move
+	other = cacheableShard()
+	other.Job.Spec.Docker.Image = "ubuntu:latest"
+	_, ok = Key(other)
+	require.False(t, ok)
+
+	// url inputs are not content addressed
+	other = cacheableShard()
+	other.Job.Spec.Inputs = []model.StorageSpec{
+		{Engine: model.StorageSourceURLDownload, URL: "https://example.com/data.csv", Path: "/inputs"},
+	}
+	_, ok = Key(other)
+	require.False(t, ok)
+}
+
+func TestGetAndPut(t *testing.T) {
+	cache, err := NewResultCache(Config{Dir: t.TempDir(), MaxSize: 1024})
+	require.NoError(t, err)
+
+	hit, err := cache.Get("a", t.TempDir())
+	require.NoError(t, err)
+	require.False(t, hit)
+
+	require.NoError(t, cache.Put("a", writeResults(t, "hello")))
+	require.Equal(t, uint64(10), cache.Size())
+
+	resultsDir := t.TempDir()
+	hit, err = cache.Get("a", resultsDir)
+	require.NoError(t, err)
+	require.True(t, hit)
+	contents, err := os.ReadFile(filepath.Join(resultsDir, "outputs", "result.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(contents))
+}
+
+func TestEviction(t *testing.T) {
+	cache, err := NewResultCache(Config{Dir: t.TempDir(), MaxSize: 25, MaxEntrySize: 20})
+	require.NoError(t, err)
+
+	require.NoError(t, cache.Put("a", writeResults(t, "aaaaa")))
+	require.NoError(t, cache.Put("b", writeResults(t, "bbbbb")))
+
+	// use a so that b is the least recently used
+	hit, err := cache.Get("a", t.TempDir())
+	require.NoError(t, err)
+	require.True(t, hit)
+
+	require.NoError(t, cache.Put("c", writeResults(t, "ccccc")))
+	require.Equal(t, uint64(20), cache.Size())
+
+	hit, err = cache.Get("b", t.TempDir())
+	require.NoError(t, err)
+	require.False(t, hit)
+	hit, err = cache.Get("a", t.TempDir())
+	require.NoError(t, err)
+	require.True(t, hit)
+
+	// too big for a single entry
+	require.NoError(t, cache.Put("d", writeResults(t, "dddddddddddddddddddd")))
+	hit, err = cache.Get("d", t.TempDir())
+	require.NoError(t, err)
+	require.False(t, hit)
+}
+
+func TestReloadFromDisk(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := NewResultCache(Config{Dir: dir, MaxSize: 1024})
+	require.NoError(t, err)
+	require.NoError(t, cache.Put("a", writeResults(t, "hello")))
+
+	reloaded, err := NewResultCache(Config{Dir: dir, MaxSize: 1024})
+	require.NoError(t, err)
+	require.Equal(t, uint64(10), reloaded.Size())
+	hit, err := reloaded.Get("a", t.TempDir())
+	require.NoError(t, err)
+	require.True(t, hit)
+}
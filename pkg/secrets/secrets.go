@@ -0,0 +1,94 @@
+// Package secrets seals the values of a job's secrets so that only the
+// compute node running a shard of the job can read them.
+//
+// A compute node that is accepted for a shard of a job with secrets makes
+// a key pair just for that shard and asks for the secrets with the public
+// half. The client seals the values to it and the node opens them with the
+// private half, which it throws away once it has - so whatever relays the
+// sealed values can't read them and they can't be opened again later.
+package secrets
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/nacl/box"
+
+	"github.com/filecoin-project/bacalhau/pkg/model"
+)
+
+const keySize = 32
+
+// Key is the key pair a compute node asks for the secrets of a shard with.
+type Key struct {
+	publicKey  *[keySize]byte
+	privateKey *[keySize]byte
+}
+
+func NewKey() (*Key, error) {
+	publicKey, privateKey, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &Key{publicKey: publicKey, privateKey: privateKey}, nil
+}
+
+// PublicKey is what the client seals the secrets to.
+func (k *Key) PublicKey() []byte {
+	return k.publicKey[:]
+}
+
+// Open returns the values sealed to the key.
+func (k *Key) Open(sealed []byte) (map[string]string, error) {
+	if k.privateKey == nil {
+		return nil, errors.New("secrets key has been destroyed")
+	}
+	data, ok := box.OpenAnonymous(nil, sealed, k.publicKey, k.privateKey)
+	if !ok {
+		return nil, errors.New("secrets were not sealed to this key")
+	}
+	values := map[string]string{}
+	err := json.Unmarshal(data, &values)
+	if err != nil {
+		return nil, fmt.Errorf("invalid secrets: %w", err)
+	}
+	return values, nil
+}
+
+// Destroy forgets the private key so nothing sealed to the key can be opened.
+func (k *Key) Destroy() {
+	if k.privateKey == nil {
+		return
+	}
+	for i := range k.privateKey {
+		k.privateKey[i] = 0
+	}
+	k.privateKey = nil
+}
+
+// Seal encrypts the values so only the holder of the
+// private half of publicKey can read them.
+func Seal(publicKey []byte, values map[string]string) ([]byte, error) {
+	if len(publicKey) != keySize {
+		return nil, fmt.Errorf("invalid secrets public key: expected %d bytes, got %d", keySize, len(publicKey))
+	}
+	var recipient [keySize]byte
+	copy(recipient[:], publicKey)
+	data, err := json.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	return box.SealAnonymous(nil, data, &recipient, rand.Reader)
+}
+
+// Check returns an error unless there is a value for every secret the job has.
+func Check(secrets []model.JobSpecSecret, values map[string]string) error {
+	for _, secret := range secrets {
+		if _, ok := values[secret.Name]; !ok {
+			return fmt.Errorf("no value for secret %s", secret.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package secrets
+
+import (
+	"testing"
+
+	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSealAndOpen(t *testing.T) {
+	key, err := NewKey()
+	require.NoError(t, err)
+
+	sealed, err := Seal(key.PublicKey(), map[string]string{"token": "hunter2"})
+	require.NoError(t, err)
+	require.NotContains(t, string(sealed), "hunter2")
+
+	values, err := key.Open(sealed)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"token": "hunter2"}, values)
+
+	// once the shard has them nothing else can be opened
+	key.Destroy()
+	_, err = key.Open(sealed)
+	require.Error(t, err)
+}
+
+func TestOpenWithWrongKey(t *testing.T) {
+	key, err := NewKey()
+	require.NoError(t, err)
+	otherKey, err := NewKey()
+	require.NoError(t, err)
+
+	sealed, err := Seal(otherKey.PublicKey(), map[string]string{"token": "hunter2"})
+	require.NoError(t, err)
+	_, err = key.Open(sealed)
+	require.Error(t, err)
+
+	_, err = Seal([]byte("too short"), map[string]string{})
+	require.Error(t, err)
+}
+
+func TestCheck(t *testing.T) {
+	spec := []model.JobSpecSecret{{Name: "token", Env: "TOKEN"}, {Name: "cert", Path: "/certs/client.pem"}}
+	require.NoError(t, Check(spec, map[string]string{"token": "a", "cert": "b"}))
+	require.Error(t, Check(spec, map[string]string{"token": "a"}))
+}
@@ -0,0 +1,261 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// FormatAuto works the format out from the start of the file
+	FormatAuto  = "auto"
+	FormatTar   = "tar"
+	FormatTarGz = "tar.gz"
+	FormatZip   = "zip"
+)
+
+// Formats are the values the extract option can have
+var Formats = []string{FormatAuto, FormatTar, FormatTarGz, FormatZip}
+
+// ValidateFormat checks the extract option is one we understand
+func ValidateFormat(format string) error {
+	for _, f := range Formats {
+		if format == f {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid archive format %q: must be one of %s", format, strings.Join(Formats, ", "))
+}
+
+// the format of the archive at archivePath, looking at it if we were
+// asked to work it out
+func detectFormat(archivePath, format string) (string, error) {
+	if format != FormatAuto {
+		return format, ValidateFormat(format)
+	}
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	header := make([]byte, 512) //nolint:gomnd // the size of a tar header
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	header = header[:n]
+	switch {
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		return FormatTarGz, nil
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")) || bytes.HasPrefix(header, []byte("PK\x05\x06")):
+		return FormatZip, nil
+	case len(header) > 262 && string(header[257:262]) == "ustar":
+		return FormatTar, nil
+	default:
+		return "", fmt.Errorf("%s is not a tar, tar.gz or zip archive", filepath.Base(archivePath))
+	}
+}
+
+// a file or folder in an archive
+type entry struct {
+	name string
+	mode os.FileMode
+	// what the archive says it extracts to
+	size uint64
+	// only valid until the walk moves on to the next entry
+	open func() (io.ReadCloser, error)
+}
+
+// where an entry goes relative to the folder it is extracted to - names
+// come from whoever made the archive so they can't point outside it
+func cleanName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// call fn for each entry in the archive, in the order they are stored
+func walk(archivePath, format string, fn func(entry) error) error {
+	format, err := detectFormat(archivePath, format)
+	if err != nil {
+		return err
+	}
+	if format == FormatZip {
+		return walkZip(archivePath, fn)
+	}
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var reader io.Reader = file
+	if format == FormatTarGz {
+		gzipReader, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading %s: %w", filepath.Base(archivePath), err)
+		}
+		err = fn(entry{
+			name: header.Name,
+			mode: header.FileInfo().Mode(),
+			size: uint64(header.Size),
+			open: func() (io.ReadCloser, error) {
+				return io.NopCloser(tarReader), nil
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func walkZip(archivePath string, fn func(entry) error) error {
+	zipReader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", filepath.Base(archivePath), err)
+	}
+	defer zipReader.Close()
+	for _, file := range zipReader.File {
+		err = fn(entry{
+			name: file.Name,
+			mode: file.Mode(),
+			size: file.UncompressedSize64,
+			open: file.Open,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Entries lists the files in an archive, relative to its root
+func Entries(archivePath, format string) ([]string, error) {
+	names := []string{}
+	seen := map[string]bool{}
+	err := walk(archivePath, format, func(e entry) error {
+		name := cleanName(e.name)
+		if !e.mode.IsRegular() || name == "" || seen[name] {
+			return nil
+		}
+		seen[name] = true
+		names = append(names, name)
+		return nil
+	})
+	return names, err
+}
+
+// Size is how big the files in an archive - or just the file called only if
+// it isn't empty - say they are, which is what Extract writes unless the
+// archive is lying
+func Size(archivePath, format, only string) (uint64, error) {
+	var size uint64
+	seen := map[string]bool{}
+	err := walk(archivePath, format, func(e entry) error {
+		name := cleanName(e.name)
+		if !e.mode.IsRegular() || name == "" || seen[name] || (only != "" && name != only) {
+			return nil
+		}
+		seen[name] = true
+		size += e.size
+		return nil
+	})
+	return size, err
+}
+
+// Extract the archive into dir - or just the file called only if it isn't
+// empty. Links and special files are left out so nothing in the archive can
+// make us write, or the job read, outside dir. It stops with an error once it
+// has written more than maxSize bytes, so a small archive can't fill the disk.
+func Extract(archivePath, format, dir, only string, maxSize uint64) error {
+	found := false
+	var written uint64
+	err := walk(archivePath, format, func(e entry) error {
+		name := cleanName(e.name)
+		if name == "" || (only != "" && name != only) {
+			return nil
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		switch {
+		case e.mode.IsDir():
+			return os.MkdirAll(target, os.ModePerm)
+		case e.mode.IsRegular():
+			found = true
+			n, err := extractFile(e, target, maxSize-written)
+			written += n
+			if err == errTooBig {
+				return fmt.Errorf("%s extracts to more than %d bytes", filepath.Base(archivePath), maxSize)
+			}
+			return err
+		default:
+			log.Debug().Msgf("Not extracting %s from %s: it is not a file or folder", e.name, filepath.Base(archivePath))
+			return nil
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if only != "" && !found {
+		return fmt.Errorf("%s not found in %s", only, filepath.Base(archivePath))
+	}
+	return nil
+}
+
+var errTooBig = errors.New("too big")
+
+// write the entry to target, returning how much was written - or errTooBig
+// if that is more than maxSize
+func extractFile(e entry, target string, maxSize uint64) (uint64, error) {
+	err := os.MkdirAll(filepath.Dir(target), os.ModePerm)
+	if err != nil {
+		return 0, err
+	}
+	// keep whether it can be run but nothing else
+	var perm os.FileMode = 0644
+	if e.mode&0111 != 0 {
+		perm = 0755
+	}
+	reader, err := e.open()
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return 0, err
+	}
+	// one byte more than we allow, so we can tell it was too big
+	limit := int64(math.MaxInt64)
+	if maxSize < math.MaxInt64 {
+		limit = int64(maxSize) + 1
+	}
+	n, err := io.Copy(file, io.LimitReader(reader, limit))
+	closeErr := file.Close()
+	if err == nil && uint64(n) > maxSize {
+		err = errTooBig
+	}
+	if err != nil {
+		return uint64(n), err
+	}
+	return uint64(n), closeErr
+}
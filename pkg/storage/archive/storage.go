@@ -0,0 +1,337 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/filecoin-project/bacalhau/pkg/config"
+	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/filecoin-project/bacalhau/pkg/storage"
+	"github.com/filecoin-project/bacalhau/pkg/storage/inputcache"
+	"github.com/filecoin-project/bacalhau/pkg/system"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// ExtractMetadataKey is the key in a storage spec's metadata that makes
+	// the job get the contents of the archive it names instead of the
+	// archive itself - the value is one of Formats
+	ExtractMetadataKey = "extract"
+	// EntryMetadataKey is the key in a storage spec's metadata for the one
+	// file the job gets from the archive, which Explode sets
+	EntryMetadataKey = "entry"
+
+	// DefaultMaxExtractedSize is the most an archive can extract to unless
+	// the node says otherwise
+	DefaultMaxExtractedSize = 10 * 1024 * 1024 * 1024
+)
+
+type Config struct {
+	// the most bytes one archive can extract to, whatever its headers say -
+	// DefaultMaxExtractedSize if it is zero
+	MaxExtractedSize uint64
+}
+
+// a storage driver that sits in front of another one and extracts the
+// archive it prepares into a folder of its own when the spec asks for it,
+// so jobs don't have to unpack their inputs themselves (which they can't
+// do in place as inputs are read only). Archives explode into their files
+// so jobs can be sharded over what is in them. Specs that don't ask for
+// extraction are passed straight through. If there is an InputCache and the
+// inner driver's spec can be cached the whole archive is extracted into it
+// once, and every shard of it mounts its file from there.
+type StorageProvider struct {
+	LocalDir   string
+	Inner      storage.StorageProvider
+	InputCache *inputcache.InputCache
+
+	config Config
+}
+
+// WrapStorageProviders puts an archive driver in front of each of the
+// providers, sharing the one output dir
+func WrapStorageProviders(
+	cm *system.CleanupManager,
+	providers map[model.StorageSourceType]storage.StorageProvider,
+	archiveConfig Config,
+	inputCache *inputcache.InputCache,
+) (map[model.StorageSourceType]storage.StorageProvider, error) {
+	dir, err := ioutil.TempDir(config.GetStoragePath(), "bacalhau-archive")
+	if err != nil {
+		return nil, err
+	}
+	wrapped := map[model.StorageSourceType]storage.StorageProvider{}
+	for sourceType, provider := range providers {
+		wrapped[sourceType] = &StorageProvider{
+			LocalDir:   dir,
+			Inner:      provider,
+			InputCache: inputCache,
+			config:     archiveConfig,
+		}
+	}
+	return wrapped, nil
+}
+
+func (sp *StorageProvider) maxExtractedSize() uint64 {
+	if sp.config.MaxExtractedSize == 0 {
+		return DefaultMaxExtractedSize
+	}
+	return sp.config.MaxExtractedSize
+}
+
+// the input cache key for the whole of the archive extracted, if we can
+// cache it - the format is part of it as tar and tar.gz could both read
+// the same file differently
+func (sp *StorageProvider) extractedKey(spec model.StorageSpec) (string, bool) {
+	if sp.InputCache == nil {
+		return "", false
+	}
+	key, ok := inputcache.Key(archiveSpec(spec))
+	if !ok {
+		return "", false
+	}
+	return "extracted-" + spec.Metadata[ExtractMetadataKey] + "-" + key, true
+}
+
+// the spec for the archive itself, which is what the inner driver knows about
+func archiveSpec(spec model.StorageSpec) model.StorageSpec {
+	var metadata map[string]string
+	for key, value := range spec.Metadata {
+		if key == ExtractMetadataKey || key == EntryMetadataKey {
+			continue
+		}
+		if metadata == nil {
+			metadata = map[string]string{}
+		}
+		metadata[key] = value
+	}
+	spec.Metadata = metadata
+	return spec
+}
+
+func (sp *StorageProvider) IsInstalled(ctx context.Context) (bool, error) {
+	return sp.Inner.IsInstalled(ctx)
+}
+
+func (sp *StorageProvider) HasStorageLocally(ctx context.Context, volume model.StorageSpec) (bool, error) {
+	if key, ok := sp.extractedKey(volume); ok && volume.Metadata[ExtractMetadataKey] != "" && sp.InputCache.Has(key) {
+		return true, nil
+	}
+	return sp.Inner.HasStorageLocally(ctx, archiveSpec(volume))
+}
+
+// what the archive's headers say it extracts to, which means fetching it -
+// the compressed size would let a small archive past the disk check
+func (sp *StorageProvider) GetVolumeSize(ctx context.Context, volume model.StorageSpec) (uint64, error) {
+	format := volume.Metadata[ExtractMetadataKey]
+	if format == "" {
+		return sp.Inner.GetVolumeSize(ctx, volume)
+	}
+	ctx, span := newSpan(ctx, "GetVolumeSize")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, config.GetVolumeSizeRequestTimeout())
+	defer cancel()
+
+	err := ValidateFormat(format)
+	if err != nil {
+		return 0, err
+	}
+	var size uint64
+	err = sp.withArchive(ctx, volume, func(archivePath string) error {
+		size, err = Size(archivePath, format, cleanName(volume.Metadata[EntryMetadataKey]))
+		return err
+	})
+	return size, err
+}
+
+func (sp *StorageProvider) PrepareStorage(ctx context.Context, storageSpec model.StorageSpec) (storage.StorageVolume, error) {
+	format := storageSpec.Metadata[ExtractMetadataKey]
+	if format == "" {
+		return sp.Inner.PrepareStorage(ctx, storageSpec)
+	}
+	ctx, span := newSpan(ctx, "PrepareStorage")
+	defer span.End()
+
+	err := ValidateFormat(format)
+	if err != nil {
+		return storage.StorageVolume{}, err
+	}
+	if key, ok := sp.extractedKey(storageSpec); ok {
+		return sp.prepareCached(ctx, storageSpec, key)
+	}
+	outputPath, err := ioutil.TempDir(sp.LocalDir, "*")
+	if err != nil {
+		return storage.StorageVolume{}, err
+	}
+	dataPath := filepath.Join(outputPath, "data")
+	entry := storageSpec.Metadata[EntryMetadataKey]
+	err = sp.withArchive(ctx, storageSpec, func(archivePath string) error {
+		return Extract(archivePath, format, dataPath, cleanName(entry), sp.maxExtractedSize())
+	})
+	if err != nil {
+		os.RemoveAll(outputPath)
+		return storage.StorageVolume{}, err
+	}
+
+	source := dataPath
+	if entry != "" {
+		source = filepath.Join(dataPath, filepath.FromSlash(cleanName(entry)))
+	} else {
+		// an archive with nothing in it is still a folder
+		err = os.MkdirAll(dataPath, os.ModePerm)
+		if err != nil {
+			os.RemoveAll(outputPath)
+			return storage.StorageVolume{}, err
+		}
+	}
+	return storage.StorageVolume{
+		Type:   storage.StorageVolumeConnectorBind,
+		Source: source,
+		Target: storageSpec.Path,
+	}, nil
+}
+
+// the whole archive extracted in the input cache, which every shard of it
+// shares - the volume is the file the spec's entry names if it has one
+func (sp *StorageProvider) prepareCached(
+	ctx context.Context,
+	spec model.StorageSpec,
+	key string,
+) (storage.StorageVolume, error) {
+	format := spec.Metadata[ExtractMetadataKey]
+	dataPath, err := sp.InputCache.Acquire(ctx, key, func(ctx context.Context, path string) error {
+		err := os.MkdirAll(path, os.ModePerm)
+		if err != nil {
+			return err
+		}
+		return sp.withArchive(ctx, spec, func(archivePath string) error {
+			return Extract(archivePath, format, path, "", sp.maxExtractedSize())
+		})
+	})
+	if err != nil {
+		return storage.StorageVolume{}, err
+	}
+	source := dataPath
+	if entry := cleanName(spec.Metadata[EntryMetadataKey]); entry != "" {
+		source = filepath.Join(dataPath, filepath.FromSlash(entry))
+		// only files are extracted, so this can't lead out of the folder
+		info, err := os.Lstat(source)
+		if err != nil || !info.Mode().IsRegular() {
+			sp.InputCache.Release(key)
+			return storage.StorageVolume{}, fmt.Errorf("%s not found in %s", entry, spec.URL)
+		}
+	}
+	return storage.StorageVolume{
+		Type:   storage.StorageVolumeConnectorBind,
+		Source: source,
+		Target: spec.Path,
+	}, nil
+}
+
+// prepare the archive with the inner driver for as long as fn runs
+func (sp *StorageProvider) withArchive(ctx context.Context, spec model.StorageSpec, fn func(archivePath string) error) error {
+	innerSpec := archiveSpec(spec)
+	volume, err := sp.Inner.PrepareStorage(ctx, innerSpec)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cleanupErr := sp.Inner.CleanupStorage(ctx, innerSpec, volume); cleanupErr != nil {
+			log.Warn().Msgf("Error cleaning up archive %s: %s", volume.Source, cleanupErr)
+		}
+	}()
+	if volume.Type != storage.StorageVolumeConnectorBind {
+		return fmt.Errorf("can't extract %s: it isn't on disk", spec.Path)
+	}
+	info, err := os.Stat(volume.Source)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("can't extract %s: it is a folder rather than an archive", spec.Path)
+	}
+	return fn(volume.Source)
+}
+
+func (sp *StorageProvider) CleanupStorage(
+	ctx context.Context,
+	storageSpec model.StorageSpec,
+	volume storage.StorageVolume,
+) error {
+	if storageSpec.Metadata[ExtractMetadataKey] == "" {
+		return sp.Inner.CleanupStorage(ctx, storageSpec, volume)
+	}
+	_, span := newSpan(ctx, "CleanupStorage")
+	defer span.End()
+
+	if key, ok := sp.extractedKey(storageSpec); ok {
+		sp.InputCache.Release(key)
+		return nil
+	}
+	// the folder PrepareStorage made, however deep in it the source is
+	rel, err := filepath.Rel(sp.LocalDir, volume.Source)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return fmt.Errorf("%s was not extracted by this driver", volume.Source)
+	}
+	pathToCleanup := filepath.Join(sp.LocalDir, strings.Split(rel, string(filepath.Separator))[0])
+	log.Debug().Msgf("Cleaning up: %s", pathToCleanup)
+	return os.RemoveAll(pathToCleanup)
+}
+
+func (sp *StorageProvider) Upload(ctx context.Context, localPath string) (model.StorageSpec, error) {
+	return sp.Inner.Upload(ctx, localPath)
+}
+
+// an archive explodes into each file in it, mounted at its path in the
+// archive under the spec's path
+func (sp *StorageProvider) Explode(ctx context.Context, spec model.StorageSpec) ([]model.StorageSpec, error) {
+	format := spec.Metadata[ExtractMetadataKey]
+	if format == "" {
+		return sp.Inner.Explode(ctx, spec)
+	}
+	if spec.Metadata[EntryMetadataKey] != "" {
+		return []model.StorageSpec{spec}, nil
+	}
+	ctx, span := newSpan(ctx, "Explode")
+	defer span.End()
+
+	err := ValidateFormat(format)
+	if err != nil {
+		return nil, err
+	}
+	var entries []string
+	err = sp.withArchive(ctx, spec, func(archivePath string) error {
+		entries, err = Entries(archivePath, format)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	basePath := strings.TrimSuffix(spec.Path, "/")
+	specs := []model.StorageSpec{}
+	for _, entry := range entries {
+		metadata := map[string]string{}
+		for key, value := range spec.Metadata {
+			metadata[key] = value
+		}
+		metadata[EntryMetadataKey] = entry
+		exploded := spec
+		exploded.Path = basePath + "/" + entry
+		exploded.Metadata = metadata
+		specs = append(specs, exploded)
+	}
+	return specs, nil
+}
+
+func newSpan(ctx context.Context, apiName string) (context.Context, trace.Span) {
+	return system.Span(ctx, "storage/archive", apiName)
+}
+
+// Compile time interface check:
+var _ storage.StorageProvider = (*StorageProvider)(nil)
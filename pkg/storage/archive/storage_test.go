@@ -0,0 +1,330 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/filecoin-project/bacalhau/pkg/logger"
+	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/filecoin-project/bacalhau/pkg/storage"
+	"github.com/filecoin-project/bacalhau/pkg/storage/inputcache"
+	"github.com/stretchr/testify/require"
+)
+
+// a driver whose specs' URLs are files on disk, counting what it has
+// prepared and not cleaned up and how many times it has been asked to
+type fileStorage struct {
+	prepared int
+	fetches  int
+}
+
+func (f *fileStorage) IsInstalled(ctx context.Context) (bool, error) {
+	return true, nil
+}
+
+func (f *fileStorage) HasStorageLocally(ctx context.Context, spec model.StorageSpec) (bool, error) {
+	return true, nil
+}
+
+func (f *fileStorage) GetVolumeSize(ctx context.Context, spec model.StorageSpec) (uint64, error) {
+	info, err := os.Stat(spec.URL)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(info.Size()), nil
+}
+
+func (f *fileStorage) PrepareStorage(ctx context.Context, spec model.StorageSpec) (storage.StorageVolume, error) {
+	if spec.Metadata[ExtractMetadataKey] != "" || spec.Metadata[EntryMetadataKey] != "" {
+		return storage.StorageVolume{}, fmt.Errorf("archive options passed on")
+	}
+	f.prepared++
+	f.fetches++
+	return storage.StorageVolume{Type: storage.StorageVolumeConnectorBind, Source: spec.URL, Target: spec.Path}, nil
+}
+
+func (f *fileStorage) CleanupStorage(ctx context.Context, spec model.StorageSpec, volume storage.StorageVolume) error {
+	f.prepared--
+	return nil
+}
+
+func (f *fileStorage) Upload(ctx context.Context, localPath string) (model.StorageSpec, error) {
+	return model.StorageSpec{}, fmt.Errorf("not implemented")
+}
+
+func (f *fileStorage) Explode(ctx context.Context, spec model.StorageSpec) ([]model.StorageSpec, error) {
+	return []model.StorageSpec{spec}, nil
+}
+
+type testFile struct {
+	name    string
+	content string
+	link    string
+}
+
+var testFiles = []testFile{
+	{name: "data/"},
+	{name: "data/a.csv", content: "a"},
+	{name: "data/nested/b.csv", content: "bb"},
+	{name: "../../escape.txt", content: "contained"},
+	{name: "link", link: "/etc/passwd"},
+}
+
+func writeTar(t *testing.T, path string, compress bool) {
+	var buf bytes.Buffer
+	tarWriter := tar.NewWriter(&buf)
+	for _, file := range testFiles {
+		header := &tar.Header{Name: file.name, Mode: 0644, Size: int64(len(file.content)), Typeflag: tar.TypeReg}
+		switch {
+		case file.link != "":
+			header.Typeflag = tar.TypeSymlink
+			header.Linkname = file.link
+		case file.name[len(file.name)-1] == '/':
+			header.Typeflag = tar.TypeDir
+			header.Mode = 0755
+		}
+		require.NoError(t, tarWriter.WriteHeader(header))
+		_, err := tarWriter.Write([]byte(file.content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tarWriter.Close())
+	data := buf.Bytes()
+	if compress {
+		var gzipped bytes.Buffer
+		gzipWriter := gzip.NewWriter(&gzipped)
+		_, err := gzipWriter.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, gzipWriter.Close())
+		data = gzipped.Bytes()
+	}
+	require.NoError(t, os.WriteFile(path, data, 0644)) //nolint:gosec
+}
+
+func writeZip(t *testing.T, path string) {
+	var buf bytes.Buffer
+	zipWriter := zip.NewWriter(&buf)
+	for _, file := range testFiles {
+		if file.link != "" {
+			header := &zip.FileHeader{Name: file.name}
+			header.SetMode(os.ModeSymlink | 0777)
+			writer, err := zipWriter.CreateHeader(header)
+			require.NoError(t, err)
+			_, err = writer.Write([]byte(file.link))
+			require.NoError(t, err)
+			continue
+		}
+		writer, err := zipWriter.Create(file.name)
+		require.NoError(t, err)
+		_, err = writer.Write([]byte(file.content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zipWriter.Close())
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0644)) //nolint:gosec
+}
+
+func newTestProvider(t *testing.T) (*StorageProvider, *fileStorage, map[string]string) {
+	dir := t.TempDir()
+	archives := map[string]string{
+		FormatTar:   filepath.Join(dir, "data.tar"),
+		FormatTarGz: filepath.Join(dir, "data.tar.gz"),
+		FormatZip:   filepath.Join(dir, "data.zip"),
+	}
+	writeTar(t, archives[FormatTar], false)
+	writeTar(t, archives[FormatTarGz], true)
+	writeZip(t, archives[FormatZip])
+	inner := &fileStorage{}
+	return &StorageProvider{LocalDir: t.TempDir(), Inner: inner}, inner, archives
+}
+
+func readFile(t *testing.T, path string) string {
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return string(content)
+}
+
+func TestPrepareStorage(t *testing.T) {
+	sp, inner, archives := newTestProvider(t)
+	ctx := context.Background()
+
+	for format, path := range archives {
+		for _, extract := range []string{format, FormatAuto} {
+			spec := model.StorageSpec{URL: path, Path: "/inputs", Metadata: map[string]string{ExtractMetadataKey: extract}}
+			volume, err := sp.PrepareStorage(ctx, spec)
+			require.NoError(t, err, path)
+			require.Equal(t, "/inputs", volume.Target)
+			require.Equal(t, "a", readFile(t, filepath.Join(volume.Source, "data", "a.csv")))
+			require.Equal(t, "bb", readFile(t, filepath.Join(volume.Source, "data", "nested", "b.csv")))
+			require.Equal(t, "contained", readFile(t, filepath.Join(volume.Source, "escape.txt")))
+			require.NoFileExists(t, filepath.Join(volume.Source, "link"))
+			require.Equal(t, 0, inner.prepared)
+
+			require.NoError(t, sp.CleanupStorage(ctx, spec, volume))
+			entries, err := os.ReadDir(sp.LocalDir)
+			require.NoError(t, err)
+			require.Empty(t, entries)
+		}
+	}
+
+	// the wrong format or not an archive at all
+	for _, spec := range []model.StorageSpec{
+		{URL: archives[FormatZip], Metadata: map[string]string{ExtractMetadataKey: FormatTarGz}},
+		{URL: archives[FormatTarGz], Metadata: map[string]string{ExtractMetadataKey: "rar"}},
+		{URL: filepath.Dir(archives[FormatTar]), Metadata: map[string]string{ExtractMetadataKey: FormatAuto}},
+	} {
+		_, err := sp.PrepareStorage(ctx, spec)
+		require.Error(t, err, spec.URL)
+	}
+	entries, err := os.ReadDir(sp.LocalDir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+	require.Equal(t, 0, inner.prepared)
+
+	// no extract option is passed straight through
+	volume, err := sp.PrepareStorage(ctx, model.StorageSpec{URL: archives[FormatZip]})
+	require.NoError(t, err)
+	require.Equal(t, archives[FormatZip], volume.Source)
+}
+
+func TestExplode(t *testing.T) {
+	sp, inner, archives := newTestProvider(t)
+	ctx := context.Background()
+
+	for _, path := range archives {
+		spec := model.StorageSpec{
+			Engine:   model.StorageSourceURLDownload,
+			URL:      path,
+			Path:     "/inputs/",
+			Metadata: map[string]string{ExtractMetadataKey: FormatAuto, "checksum": "kept"},
+		}
+		specs, err := sp.Explode(ctx, spec)
+		require.NoError(t, err)
+		paths := []string{}
+		for _, exploded := range specs {
+			paths = append(paths, exploded.Path)
+			require.Equal(t, "kept", exploded.Metadata["checksum"])
+		}
+		require.Equal(t, []string{"/inputs/data/a.csv", "/inputs/data/nested/b.csv", "/inputs/escape.txt"}, paths)
+
+		// each one is just its own file
+		volume, err := sp.PrepareStorage(ctx, specs[1])
+		require.NoError(t, err)
+		require.Equal(t, "/inputs/data/nested/b.csv", volume.Target)
+		require.Equal(t, "bb", readFile(t, volume.Source))
+		require.NoError(t, sp.CleanupStorage(ctx, specs[1], volume))
+
+		specs, err = sp.Explode(ctx, specs[0])
+		require.NoError(t, err)
+		require.Len(t, specs, 1)
+
+		specs[0].Metadata[EntryMetadataKey] = "missing.csv"
+		_, err = sp.PrepareStorage(ctx, specs[0])
+		require.Error(t, err)
+	}
+	entries, err := os.ReadDir(sp.LocalDir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+	require.Equal(t, 0, inner.prepared)
+}
+
+func TestGetVolumeSize(t *testing.T) {
+	sp, inner, archives := newTestProvider(t)
+	ctx := context.Background()
+
+	for format, path := range archives {
+		// what the files extract to, not the size of the archive
+		spec := model.StorageSpec{URL: path, Metadata: map[string]string{ExtractMetadataKey: FormatAuto}}
+		size, err := sp.GetVolumeSize(ctx, spec)
+		require.NoError(t, err, format)
+		require.Equal(t, uint64(len("a")+len("bb")+len("contained")), size, format)
+
+		spec.Metadata[EntryMetadataKey] = "data/nested/b.csv"
+		size, err = sp.GetVolumeSize(ctx, spec)
+		require.NoError(t, err, format)
+		require.Equal(t, uint64(len("bb")), size, format)
+	}
+	require.Equal(t, 0, inner.prepared)
+}
+
+func TestMaxExtractedSize(t *testing.T) {
+	sp, inner, archives := newTestProvider(t)
+	sp.config.MaxExtractedSize = 5
+	ctx := context.Background()
+
+	for format, path := range archives {
+		spec := model.StorageSpec{URL: path, Metadata: map[string]string{ExtractMetadataKey: format}}
+		_, err := sp.PrepareStorage(ctx, spec)
+		require.ErrorContains(t, err, "extracts to more than 5 bytes", format)
+
+		// one file on its own fits
+		spec.Metadata[EntryMetadataKey] = "data/nested/b.csv"
+		volume, err := sp.PrepareStorage(ctx, spec)
+		require.NoError(t, err, format)
+		require.NoError(t, sp.CleanupStorage(ctx, spec, volume))
+	}
+	entries, err := os.ReadDir(sp.LocalDir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+	require.Equal(t, 0, inner.prepared)
+}
+
+func TestExtractOnce(t *testing.T) {
+	sp, inner, archives := newTestProvider(t)
+	cache, err := inputcache.NewInputCache(inputcache.Config{Dir: t.TempDir(), MaxSize: 1 << 30})
+	require.NoError(t, err)
+	sp.InputCache = cache
+	ctx := context.Background()
+
+	spec := model.StorageSpec{
+		Engine:   model.StorageSourceURLDownload,
+		URL:      archives[FormatTarGz],
+		Path:     "/inputs/",
+		Metadata: map[string]string{ExtractMetadataKey: FormatAuto, "checksum": "sha256:kept"},
+	}
+	specs, err := sp.Explode(ctx, spec)
+	require.NoError(t, err)
+	require.Len(t, specs, 3)
+	fetches := inner.fetches
+
+	// every shard shares the one extraction
+	volumes := []storage.StorageVolume{}
+	for _, exploded := range specs {
+		volume, err := sp.PrepareStorage(ctx, exploded)
+		require.NoError(t, err)
+		volumes = append(volumes, volume)
+	}
+	require.Equal(t, fetches+1, inner.fetches)
+	require.Equal(t, "bb", readFile(t, volumes[1].Source))
+	key, ok := sp.extractedKey(specs[0])
+	require.True(t, ok)
+	require.Equal(t, 3, cache.Refs(key))
+	hasStorage, err := sp.HasStorageLocally(ctx, specs[2])
+	require.NoError(t, err)
+	require.True(t, hasStorage)
+
+	missing := specs[0]
+	missing.Metadata = map[string]string{ExtractMetadataKey: FormatAuto, EntryMetadataKey: "missing.csv", "checksum": "sha256:kept"}
+	_, err = sp.PrepareStorage(ctx, missing)
+	require.Error(t, err)
+
+	for i, exploded := range specs {
+		require.NoError(t, sp.CleanupStorage(ctx, exploded, volumes[i]))
+	}
+	require.Equal(t, 0, cache.Refs(key))
+	require.Equal(t, 0, inner.prepared)
+
+	// an extraction that is too big isn't cached
+	sp.config.MaxExtractedSize = 5
+	spec.Metadata[ExtractMetadataKey] = FormatTarGz
+	_, err = sp.PrepareStorage(ctx, spec)
+	require.ErrorContains(t, err, "extracts to more than 5 bytes")
+	key, ok = sp.extractedKey(spec)
+	require.True(t, ok)
+	require.False(t, cache.Has(key))
+}
@@ -0,0 +1,367 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/filecoin-project/bacalhau/pkg/config"
+	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/filecoin-project/bacalhau/pkg/storage"
+	"github.com/filecoin-project/bacalhau/pkg/storage/inputcache"
+	"github.com/filecoin-project/bacalhau/pkg/system"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SubpathMetadataKey is the key in a storage spec's metadata for the
+// file or folder in the repository the job gets instead of all of it
+const SubpathMetadataKey = "subpath"
+
+// the protocols repositories can be cloned over unless the node says
+// otherwise. Not file, so jobs can't read repositories on the node, and not
+// ssh, which would clone with the node's keys and config - nor the
+// unauthenticated http and git protocols.
+var DefaultAllowedProtocols = []string{"https"}
+
+// a full sha1 or sha256 commit hash
+var commitRegex = regexp.MustCompile(`^([0-9a-f]{40}|[0-9a-f]{64})$`)
+
+type Config struct {
+	// the git transports repositories can be cloned over, e.g. https
+	AllowedProtocols []string
+}
+
+// a storage driver that checks out a repository at a commit for the job.
+// The spec's URL is the repository and its Cid the full hash of the commit,
+// which is checked so the job gets exactly what it asked for. A checkout is
+// kept in the InputCache if there is one and shared by every shard that
+// uses the commit, otherwise it is removed once the shard is done.
+type StorageProvider struct {
+	LocalDir   string
+	InputCache *inputcache.InputCache
+
+	config Config
+}
+
+func NewStorageProvider(cm *system.CleanupManager, gitConfig Config) (*StorageProvider, error) {
+	if len(gitConfig.AllowedProtocols) == 0 {
+		gitConfig.AllowedProtocols = DefaultAllowedProtocols
+	}
+	dir, err := ioutil.TempDir(config.GetStoragePath(), "bacalhau-git")
+	if err != nil {
+		return nil, err
+	}
+	storageHandler := &StorageProvider{
+		LocalDir: dir,
+		config:   gitConfig,
+	}
+	log.Debug().Msgf("Git driver created with output dir: %s", dir)
+	return storageHandler, nil
+}
+
+// NewStorageSpec makes the spec for a repository at a commit
+func NewStorageSpec(repoURL, commit, path string) (model.StorageSpec, error) {
+	spec := model.StorageSpec{
+		Engine: model.StorageSourceGit,
+		URL:    repoURL,
+		Cid:    commit,
+		Path:   path,
+	}
+	return spec, validateSpec(spec)
+}
+
+func validateSpec(spec model.StorageSpec) error {
+	if spec.URL == "" {
+		return fmt.Errorf("git storage needs a repository URL")
+	}
+	if !commitRegex.MatchString(spec.Cid) {
+		return fmt.Errorf("invalid commit %q for %s: must be a full lowercase commit hash", spec.Cid, spec.URL)
+	}
+	return nil
+}
+
+func (sp *StorageProvider) IsInstalled(ctx context.Context) (bool, error) {
+	_, err := exec.LookPath("git")
+	return err == nil, nil
+}
+
+func (sp *StorageProvider) HasStorageLocally(ctx context.Context, volume model.StorageSpec) (bool, error) {
+	if sp.InputCache != nil {
+		if key, ok := inputcache.Key(volume); ok {
+			return sp.InputCache.Has(key), nil
+		}
+	}
+	return false, nil
+}
+
+// servers can't tell us how big a commit is so we check it out and measure
+// it - with an InputCache the checkout is kept for PrepareStorage to use
+func (sp *StorageProvider) GetVolumeSize(ctx context.Context, volume model.StorageSpec) (uint64, error) {
+	ctx, span := newSpan(ctx, "GetVolumeSize")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, config.GetVolumeSizeRequestTimeout())
+	defer cancel()
+
+	checkout, err := sp.acquire(ctx, volume)
+	if err != nil {
+		return 0, err
+	}
+	defer sp.release(volume, checkout) //nolint:errcheck
+	source, err := subpathSource(checkout, volume.Metadata[SubpathMetadataKey])
+	if err != nil {
+		return 0, err
+	}
+	return dirSize(source)
+}
+
+func (sp *StorageProvider) PrepareStorage(ctx context.Context, storageSpec model.StorageSpec) (storage.StorageVolume, error) {
+	ctx, span := newSpan(ctx, "PrepareStorage")
+	defer span.End()
+
+	checkout, err := sp.acquire(ctx, storageSpec)
+	if err != nil {
+		return storage.StorageVolume{}, err
+	}
+	source, err := subpathSource(checkout, storageSpec.Metadata[SubpathMetadataKey])
+	if err != nil {
+		_ = sp.release(storageSpec, checkout)
+		return storage.StorageVolume{}, err
+	}
+	return storage.StorageVolume{
+		Type:   storage.StorageVolumeConnectorBind,
+		Source: source,
+		Target: storageSpec.Path,
+	}, nil
+}
+
+func (sp *StorageProvider) CleanupStorage(
+	ctx context.Context,
+	storageSpec model.StorageSpec,
+	volume storage.StorageVolume,
+) error {
+	_, span := newSpan(ctx, "CleanupStorage")
+	defer span.End()
+
+	checkout := volume.Source
+	if subpath := storageSpec.Metadata[SubpathMetadataKey]; subpath != "" {
+		checkout = strings.TrimSuffix(volume.Source, filepath.Clean("/"+subpath))
+	}
+	return sp.release(storageSpec, checkout)
+}
+
+// we only read from repositories
+func (sp *StorageProvider) Upload(ctx context.Context, localPath string) (model.StorageSpec, error) {
+	return model.StorageSpec{}, fmt.Errorf("not implemented")
+}
+
+// explode into each file in the commit - or in the subpath of it if the
+// spec has one - mounted at its path relative to that. Symlinks and
+// submodules are left out as they don't point at anything in the commit.
+func (sp *StorageProvider) Explode(ctx context.Context, spec model.StorageSpec) ([]model.StorageSpec, error) {
+	ctx, span := newSpan(ctx, "Explode")
+	defer span.End()
+
+	checkout, err := sp.acquire(ctx, spec)
+	if err != nil {
+		return nil, err
+	}
+	defer sp.release(spec, checkout) //nolint:errcheck
+
+	subpath := strings.Trim(filepath.ToSlash(filepath.Clean("/"+spec.Metadata[SubpathMetadataKey])), "/")
+	args := []string{"ls-tree", "-r", "-z", "--full-tree", spec.Cid}
+	if subpath != "" {
+		args = append(args, "--", subpath)
+	}
+	out, err := sp.git(ctx, checkout, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	basePath := strings.TrimSuffix(spec.Path, "/")
+	specs := []model.StorageSpec{}
+	for _, entry := range strings.Split(out, "\x00") {
+		// <mode> SP <type> SP <object> TAB <file>
+		info, file, ok := strings.Cut(entry, "\t")
+		if !ok {
+			continue
+		}
+		mode, _, _ := strings.Cut(info, " ")
+		if mode != "100644" && mode != "100755" {
+			continue
+		}
+		rel := strings.TrimPrefix(file, subpath+"/")
+		if file == subpath {
+			// the subpath is this file so the spec is already as small as it gets
+			return []model.StorageSpec{spec}, nil
+		}
+		specs = append(specs, model.StorageSpec{
+			Engine:   model.StorageSourceGit,
+			Name:     spec.Name,
+			URL:      spec.URL,
+			Cid:      spec.Cid,
+			Path:     basePath + "/" + rel,
+			Metadata: map[string]string{SubpathMetadataKey: file},
+		})
+	}
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("no files found in %s at %s", spec.URL, spec.Cid)
+	}
+	return specs, nil
+}
+
+// a checkout of the spec's commit, from the cache if we can
+func (sp *StorageProvider) acquire(ctx context.Context, spec model.StorageSpec) (string, error) {
+	err := validateSpec(spec)
+	if err != nil {
+		return "", err
+	}
+	if sp.InputCache != nil {
+		if key, ok := inputcache.Key(spec); ok {
+			return sp.InputCache.Acquire(ctx, key, func(ctx context.Context, path string) error {
+				return sp.checkout(ctx, spec.URL, spec.Cid, path)
+			})
+		}
+	}
+	dir, err := ioutil.TempDir(sp.LocalDir, "*")
+	if err != nil {
+		return "", err
+	}
+	checkout := filepath.Join(dir, "repo")
+	err = sp.checkout(ctx, spec.URL, spec.Cid, checkout)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return checkout, nil
+}
+
+func (sp *StorageProvider) release(spec model.StorageSpec, checkout string) error {
+	if sp.InputCache != nil {
+		if key, ok := inputcache.Key(spec); ok {
+			sp.InputCache.Release(key)
+			return nil
+		}
+	}
+	pathToCleanup := filepath.Dir(checkout)
+	log.Debug().Msgf("Cleaning up: %s", pathToCleanup)
+	return os.RemoveAll(pathToCleanup)
+}
+
+// check out the commit of the repository into dir, which must not exist yet
+func (sp *StorageProvider) checkout(ctx context.Context, repoURL, commit, dir string) error {
+	_, err := sp.git(ctx, "", "init", "-q", dir)
+	if err != nil {
+		return err
+	}
+	// most servers let us fetch just the commit we want
+	_, err = sp.git(ctx, dir, "fetch", "-q", "--depth", "1", "--", repoURL, commit)
+	if err != nil {
+		log.Debug().Msgf("Could not fetch %s from %s by itself, fetching everything: %s", commit, repoURL, err)
+		_, err = sp.git(ctx, dir, "fetch", "-q", "--", repoURL,
+			"+refs/heads/*:refs/remotes/origin/*", "+refs/tags/*:refs/tags/*")
+		if err != nil {
+			return fmt.Errorf("error fetching %s: %w", repoURL, err)
+		}
+	}
+	_, err = sp.git(ctx, dir, "-c", "advice.detachedHead=false", "checkout", "-q", "--detach", commit)
+	if err != nil {
+		return fmt.Errorf("commit %s not found in %s: %w", commit, repoURL, err)
+	}
+	head, err := sp.git(ctx, dir, "rev-parse", "HEAD")
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(head) != commit {
+		return fmt.Errorf("checked out %s from %s but expected %s", strings.TrimSpace(head), repoURL, commit)
+	}
+	return nil
+}
+
+// git runs without the node's git config or GIT_ environment, which could
+// give a job's clone the operator's credentials (credential helpers, url
+// rewrites, ssh commands) for a private repository it then publishes
+func (sp *StorageProvider) git(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-c", "credential.helper="}, args...)...)
+	cmd.Dir = dir
+	cmd.Env = append(gitEnviron(),
+		"GIT_CONFIG_NOSYSTEM=1",
+		"GIT_CONFIG_GLOBAL="+os.DevNull,
+		// older gits don't know GIT_CONFIG_GLOBAL and look in these
+		"HOME="+os.DevNull,
+		"XDG_CONFIG_HOME="+os.DevNull,
+		"GIT_ALLOW_PROTOCOL="+strings.Join(sp.config.AllowedProtocols, ":"),
+		// fail rather than wait for someone to type a password
+		"GIT_TERMINAL_PROMPT=0",
+	)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// the node's environment without anything that tells git where to find
+// config or credentials
+func gitEnviron() []string {
+	env := []string{}
+	for _, v := range os.Environ() {
+		name, _, _ := strings.Cut(v, "=")
+		if strings.HasPrefix(name, "GIT_") || name == "HOME" || name == "XDG_CONFIG_HOME" || name == "SSH_ASKPASS" {
+			continue
+		}
+		env = append(env, v)
+	}
+	return env
+}
+
+// the path of the subpath in the checkout - the repository decides what its
+// symlinks point at so make sure we don't follow one out of the checkout
+func subpathSource(checkout, subpath string) (string, error) {
+	if subpath == "" {
+		return checkout, nil
+	}
+	source := filepath.Join(checkout, filepath.Clean("/"+subpath))
+	resolved, err := filepath.EvalSymlinks(source)
+	if err != nil {
+		return "", fmt.Errorf("%s not found in the repository: %w", subpath, err)
+	}
+	root, err := filepath.EvalSymlinks(checkout)
+	if err != nil {
+		return "", err
+	}
+	if resolved != root && !strings.HasPrefix(resolved, root+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside the repository", subpath)
+	}
+	return source, nil
+}
+
+func dirSize(path string) (uint64, error) {
+	var size uint64
+	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			size += uint64(info.Size())
+		}
+		return err
+	})
+	return size, err
+}
+
+func newSpan(ctx context.Context, apiName string) (context.Context, trace.Span) {
+	return system.Span(ctx, "storage/git", apiName)
+}
+
+// Compile time interface check:
+var _ storage.StorageProvider = (*StorageProvider)(nil)
@@ -0,0 +1,294 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "github.com/filecoin-project/bacalhau/pkg/logger"
+	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/filecoin-project/bacalhau/pkg/storage/inputcache"
+	"github.com/stretchr/testify/require"
+)
+
+func runGit(t *testing.T, dir string, args ...string) string {
+	args = append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+	return strings.TrimSpace(string(out))
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for path, content := range files {
+		path = filepath.Join(dir, path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), os.ModePerm))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644)) //nolint:gosec
+	}
+}
+
+// a bare repository with two commits, returning its URL and the commits
+func newTestRepo(t *testing.T) (string, string, string) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	work := t.TempDir()
+	runGit(t, work, "init", "-q", ".")
+	writeFiles(t, work, map[string]string{"README.md": "first"})
+	runGit(t, work, "add", ".")
+	runGit(t, work, "commit", "-q", "-m", "first")
+	first := runGit(t, work, "rev-parse", "HEAD")
+
+	writeFiles(t, work, map[string]string{
+		"README.md":          "second",
+		"data/a.txt":         "a",
+		"data/b.txt":         "b",
+		"data/nested/c.txt":  "c",
+		"database/other.txt": "other",
+	})
+	require.NoError(t, os.Symlink("/etc/passwd", filepath.Join(work, "link")))
+	runGit(t, work, "add", ".")
+	runGit(t, work, "commit", "-q", "-m", "second")
+	second := runGit(t, work, "rev-parse", "HEAD")
+
+	bare := filepath.Join(t.TempDir(), "repo.git")
+	runGit(t, work, "clone", "-q", "--bare", work, bare)
+	return "file://" + bare, first, second
+}
+
+func newTestProvider(t *testing.T) *StorageProvider {
+	return &StorageProvider{
+		LocalDir: t.TempDir(),
+		config:   Config{AllowedProtocols: []string{"file"}},
+	}
+}
+
+func readSource(t *testing.T, source, path string) string {
+	content, err := os.ReadFile(filepath.Join(source, path))
+	require.NoError(t, err)
+	return string(content)
+}
+
+func TestPrepareStorage(t *testing.T) {
+	repo, first, second := newTestRepo(t)
+	sp := newTestProvider(t)
+	ctx := context.Background()
+
+	spec, err := NewStorageSpec(repo, second, "/repo")
+	require.NoError(t, err)
+	volume, err := sp.PrepareStorage(ctx, spec)
+	require.NoError(t, err)
+	require.Equal(t, "/repo", volume.Target)
+	require.Equal(t, "second", readSource(t, volume.Source, "README.md"))
+	require.Equal(t, "c", readSource(t, volume.Source, "data/nested/c.txt"))
+	require.NoError(t, sp.CleanupStorage(ctx, spec, volume))
+	require.NoDirExists(t, volume.Source)
+
+	spec.Cid = first
+	volume, err = sp.PrepareStorage(ctx, spec)
+	require.NoError(t, err)
+	require.Equal(t, "first", readSource(t, volume.Source, "README.md"))
+	require.NoFileExists(t, filepath.Join(volume.Source, "data", "a.txt"))
+}
+
+func TestGetVolumeSize(t *testing.T) {
+	repo, first, second := newTestRepo(t)
+	sp := newTestProvider(t)
+	ctx := context.Background()
+
+	spec, err := NewStorageSpec(repo, second, "/repo")
+	require.NoError(t, err)
+	size, err := sp.GetVolumeSize(ctx, spec)
+	require.NoError(t, err)
+	spec.Cid = first
+	firstSize, err := sp.GetVolumeSize(ctx, spec)
+	require.NoError(t, err)
+	// the checkout includes the .git folder
+	require.Greater(t, firstSize, uint64(len("first")))
+	require.Greater(t, size, firstSize)
+
+	spec.Cid = second
+	spec.Metadata = map[string]string{SubpathMetadataKey: "data/nested/c.txt"}
+	size, err = sp.GetVolumeSize(ctx, spec)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), size)
+
+	// the checkouts it measured are gone
+	entries, err := os.ReadDir(sp.LocalDir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestPrepareStorageInvalid(t *testing.T) {
+	repo, _, second := newTestRepo(t)
+	sp := newTestProvider(t)
+	ctx := context.Background()
+
+	for _, commit := range []string{"", "main", second[:12], strings.ToUpper(second)} {
+		_, err := NewStorageSpec(repo, commit, "/repo")
+		require.Error(t, err, commit)
+		_, err = sp.PrepareStorage(ctx, model.StorageSpec{Engine: model.StorageSourceGit, URL: repo, Cid: commit})
+		require.Error(t, err, commit)
+	}
+
+	// a commit that isn't in the repository
+	missing := strings.Repeat("0", 40)
+	_, err := sp.PrepareStorage(ctx, model.StorageSpec{Engine: model.StorageSourceGit, URL: repo, Cid: missing})
+	require.Error(t, err)
+
+	// a symlink can't point the job at the node's files
+	_, err = sp.PrepareStorage(ctx, model.StorageSpec{
+		Engine:   model.StorageSourceGit,
+		URL:      repo,
+		Cid:      second,
+		Metadata: map[string]string{SubpathMetadataKey: "link"},
+	})
+	require.Error(t, err)
+	entries, err := os.ReadDir(sp.LocalDir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestAllowedProtocols(t *testing.T) {
+	repo, _, second := newTestRepo(t)
+	sp := newTestProvider(t)
+	sp.config.AllowedProtocols = DefaultAllowedProtocols
+
+	spec, err := NewStorageSpec(repo, second, "/repo")
+	require.NoError(t, err)
+	_, err = sp.PrepareStorage(context.Background(), spec)
+	require.Error(t, err)
+
+	// nor as a plain path
+	spec.URL = strings.TrimPrefix(repo, "file://")
+	_, err = sp.PrepareStorage(context.Background(), spec)
+	require.Error(t, err)
+
+	// only https unless the node allows more
+	require.Equal(t, []string{"https"}, DefaultAllowedProtocols)
+	spec.URL = "ssh://git@localhost/repo.git"
+	_, err = sp.PrepareStorage(context.Background(), spec)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "transport 'ssh' not allowed")
+}
+
+// clones must never get the operator's credentials from their git config
+func TestIgnoresNodeGitConfig(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("WWW-Authenticate", `Basic realm="private"`)
+		res.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	home := t.TempDir()
+	marker := filepath.Join(home, "helper-ran")
+	writeFiles(t, home, map[string]string{".gitconfig": fmt.Sprintf(
+		"[credential]\n\thelper = \"!f() { touch %s; echo username=operator; echo password=token; }; f\"\n", marker,
+	)})
+	t.Setenv("HOME", home)
+
+	sp := newTestProvider(t)
+	sp.config.AllowedProtocols = []string{"http"}
+	spec, err := NewStorageSpec(server.URL+"/private.git", strings.Repeat("a", 40), "/repo")
+	require.NoError(t, err)
+	_, err = sp.PrepareStorage(context.Background(), spec)
+	require.Error(t, err)
+	require.NoFileExists(t, marker)
+}
+
+func TestExplode(t *testing.T) {
+	repo, _, second := newTestRepo(t)
+	sp := newTestProvider(t)
+	ctx := context.Background()
+
+	spec, err := NewStorageSpec(repo, second, "/repo/")
+	require.NoError(t, err)
+	specs, err := sp.Explode(ctx, spec)
+	require.NoError(t, err)
+	paths := []string{}
+	for _, exploded := range specs {
+		paths = append(paths, exploded.Path)
+	}
+	// no symlink
+	require.Equal(t, []string{
+		"/repo/README.md",
+		"/repo/data/a.txt",
+		"/repo/data/b.txt",
+		"/repo/data/nested/c.txt",
+		"/repo/database/other.txt",
+	}, paths)
+
+	spec.Path = "/inputs"
+	spec.Metadata = map[string]string{SubpathMetadataKey: "data"}
+	specs, err = sp.Explode(ctx, spec)
+	require.NoError(t, err)
+	contents := map[string]string{}
+	for _, exploded := range specs {
+		volume, err := sp.PrepareStorage(ctx, exploded)
+		require.NoError(t, err)
+		content, err := os.ReadFile(volume.Source)
+		require.NoError(t, err)
+		contents[volume.Target] = string(content)
+		require.NoError(t, sp.CleanupStorage(ctx, exploded, volume))
+	}
+	require.Equal(t, map[string]string{
+		"/inputs/a.txt":        "a",
+		"/inputs/b.txt":        "b",
+		"/inputs/nested/c.txt": "c",
+	}, contents)
+
+	// a file explodes to itself
+	specs, err = sp.Explode(ctx, specs[0])
+	require.NoError(t, err)
+	require.Len(t, specs, 1)
+	require.Equal(t, "/inputs/a.txt", specs[0].Path)
+
+	entries, err := os.ReadDir(sp.LocalDir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestInputCache(t *testing.T) {
+	repo, _, second := newTestRepo(t)
+	sp := newTestProvider(t)
+	cache, err := inputcache.NewInputCache(inputcache.Config{Dir: t.TempDir(), MaxSize: 1 << 30})
+	require.NoError(t, err)
+	sp.InputCache = cache
+	ctx := context.Background()
+
+	spec, err := NewStorageSpec(repo, second, "/repo")
+	require.NoError(t, err)
+	has, err := sp.HasStorageLocally(ctx, spec)
+	require.NoError(t, err)
+	require.False(t, has)
+
+	specs, err := sp.Explode(ctx, spec)
+	require.NoError(t, err)
+	has, err = sp.HasStorageLocally(ctx, spec)
+	require.NoError(t, err)
+	require.True(t, has)
+
+	// every file shares the one checkout
+	first, err := sp.PrepareStorage(ctx, specs[1])
+	require.NoError(t, err)
+	other, err := sp.PrepareStorage(ctx, specs[2])
+	require.NoError(t, err)
+	require.Equal(t, filepath.Dir(first.Source), filepath.Dir(other.Source))
+	require.NoError(t, sp.CleanupStorage(ctx, specs[1], first))
+	require.NoError(t, sp.CleanupStorage(ctx, specs[2], other))
+	require.FileExists(t, first.Source)
+
+	entries, err := os.ReadDir(sp.LocalDir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
@@ -0,0 +1,304 @@
+package inputcache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	sync "github.com/lukemarsden/golang-mutex-tracer"
+	"github.com/rs/zerolog/log"
+
+	"github.com/filecoin-project/bacalhau/pkg/model"
+)
+
+// configures where the node keeps downloaded inputs and how big that can get
+type Config struct {
+	// the folder the cached inputs live in
+	// if this is empty then input caching is disabled
+	Dir string
+	// the total number of bytes all cached inputs can use
+	// once we go over this the least recently used inputs
+	// that no shard is using are evicted
+	MaxSize uint64
+}
+
+// fetches the content for a cache entry into path
+// path does not exist yet and the content can be a file or a folder
+type FetchFunc func(ctx context.Context, path string) error
+
+type cacheEntry struct {
+	size     uint64
+	refs     int
+	lastUsed time.Time
+}
+
+// an in progress fetch that other callers can wait on
+type pendingFetch struct {
+	done chan struct{}
+	err  error
+}
+
+// InputCache is a node wide store of downloaded inputs shared by
+// the storage providers - an entry is kept for as long as a shard
+// is using it and after that until we need the space back
+type InputCache struct {
+	config    Config
+	entries   map[string]*cacheEntry
+	pending   map[string]*pendingFetch
+	totalSize uint64
+	mu        sync.Mutex
+}
+
+func NewInputCache(config Config) (*InputCache, error) {
+	if config.Dir == "" {
+		return nil, fmt.Errorf("input cache needs a directory")
+	}
+	if config.MaxSize == 0 {
+		return nil, fmt.Errorf("input cache needs a max size")
+	}
+	err := os.MkdirAll(config.Dir, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	cache := &InputCache{
+		config:  config,
+		entries: map[string]*cacheEntry{},
+		pending: map[string]*pendingFetch{},
+	}
+	cache.mu.EnableTracerWithOpts(sync.Opts{
+		Threshold: 10 * time.Millisecond,
+		Id:        "InputCache.mu",
+	})
+
+	// pick up any inputs we downloaded before we were restarted
+	items, err := os.ReadDir(config.Dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		itemPath := filepath.Join(config.Dir, item.Name())
+		// a leftover from a fetch that never finished
+		if strings.HasPrefix(item.Name(), ".") {
+			_ = os.RemoveAll(itemPath)
+			continue
+		}
+		info, err := item.Info()
+		if err != nil {
+			return nil, err
+		}
+		size, err := pathSize(itemPath)
+		if err != nil {
+			return nil, err
+		}
+		cache.entries[item.Name()] = &cacheEntry{
+			size:     size,
+			lastUsed: info.ModTime(),
+		}
+		cache.totalSize += size
+	}
+	cache.evict(0)
+
+	return cache, nil
+}
+
+// Key works out the cache key for a storage spec
+// IPFS content is keyed on its CID, URLs on a hash of the URL and its
+// checksum and git checkouts on their commit
+// a URL without a checksum can change under us so it is not cached, and
+// nor is a list of URLs as its checksum only pins the list
+// the second return value is false if the spec can't be cached
+func Key(spec model.StorageSpec) (string, bool) {
+	switch {
+	case spec.Engine == model.StorageSourceIPFS && spec.Cid != "":
+		return "ipfs-" + spec.Cid, true
+	case spec.Engine == model.StorageSourceURLDownload && spec.URL != "" &&
+		spec.Metadata["checksum"] != "" && spec.Metadata["explode"] == "":
+		sum := sha256.Sum256([]byte(spec.URL + "\n" + spec.Metadata["checksum"]))
+		return "url-" + hex.EncodeToString(sum[:]), true
+	case spec.Engine == model.StorageSourceGit && spec.Cid != "":
+		return "git-" + spec.Cid, true
+	default:
+		return "", false
+	}
+}
+
+// Has returns true if the content for key is already on disk
+func (cache *InputCache) Has(key string) bool {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	_, ok := cache.entries[key]
+	return ok
+}
+
+// Acquire returns the local path of the content for key, calling fetch
+// to download it if we don't have it yet - the entry is not evicted
+// until every Acquire has been matched by a Release
+func (cache *InputCache) Acquire(ctx context.Context, key string, fetch FetchFunc) (string, error) {
+	for {
+		cache.mu.Lock()
+		if entry, ok := cache.entries[key]; ok {
+			entry.refs++
+			entry.lastUsed = time.Now()
+			cache.mu.Unlock()
+			return cache.path(key), nil
+		}
+		if pending, ok := cache.pending[key]; ok {
+			// someone else is already downloading this - wait for them
+			cache.mu.Unlock()
+			select {
+			case <-pending.done:
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+			if pending.err != nil {
+				return "", pending.err
+			}
+			continue
+		}
+		pending := &pendingFetch{done: make(chan struct{})}
+		cache.pending[key] = pending
+		cache.mu.Unlock()
+
+		pending.err = cache.fetch(ctx, key, fetch)
+
+		cache.mu.Lock()
+		delete(cache.pending, key)
+		close(pending.done)
+		cache.mu.Unlock()
+
+		if pending.err != nil {
+			return "", pending.err
+		}
+	}
+}
+
+// Release says that a shard has finished with the content for key
+func (cache *InputCache) Release(key string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	entry, ok := cache.entries[key]
+	if !ok {
+		return
+	}
+	if entry.refs > 0 {
+		entry.refs--
+	}
+	entry.lastUsed = time.Now()
+	// we might have gone over budget while this was in use
+	cache.evict(0)
+}
+
+// Refs is the number of Acquires of key not yet matched by a Release
+func (cache *InputCache) Refs(key string) int {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	entry, ok := cache.entries[key]
+	if !ok {
+		return 0
+	}
+	return entry.refs
+}
+
+// Size is the number of bytes currently used by cached inputs
+func (cache *InputCache) Size() uint64 {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	return cache.totalSize
+}
+
+// download into a temporary path first so a half written
+// entry is never picked up, then move it into place
+func (cache *InputCache) fetch(ctx context.Context, key string, fetch FetchFunc) error {
+	tmpDir, err := os.MkdirTemp(cache.config.Dir, ".fetch-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	tmpPath := filepath.Join(tmpDir, "content")
+	err = fetch(ctx, tmpPath)
+	if err != nil {
+		return err
+	}
+	size, err := pathSize(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.evict(size)
+	if cache.totalSize+size > cache.config.MaxSize {
+		// everything else is in use - keep this anyway so the shard can
+		// run and let it be evicted once it has been released
+		log.Debug().Msgf("Input cache is over budget after fetching %s (%d bytes)", key, size)
+	}
+
+	err = os.Rename(tmpPath, cache.path(key))
+	if err != nil {
+		return err
+	}
+	cache.entries[key] = &cacheEntry{
+		size:     size,
+		lastUsed: time.Now(),
+	}
+	cache.totalSize += size
+	return nil
+}
+
+func (cache *InputCache) path(key string) string {
+	return filepath.Join(cache.config.Dir, key)
+}
+
+// remove the least recently used entries that are not in use until there
+// is room for another entry of the given size - must be called with the lock held
+func (cache *InputCache) evict(incoming uint64) {
+	if cache.totalSize+incoming <= cache.config.MaxSize {
+		return
+	}
+	keys := []string{}
+	for key, entry := range cache.entries {
+		if entry.refs == 0 {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return cache.entries[keys[i]].lastUsed.Before(cache.entries[keys[j]].lastUsed)
+	})
+	for _, key := range keys {
+		if cache.totalSize+incoming <= cache.config.MaxSize {
+			return
+		}
+		err := os.RemoveAll(cache.path(key))
+		if err != nil {
+			log.Warn().Msgf("Could not evict cached input %s: %s", key, err)
+			continue
+		}
+		log.Debug().Msgf("Evicted cached input %s", key)
+		cache.totalSize -= cache.entries[key].size
+		delete(cache.entries, key)
+	}
+}
+
+// the size of a file or everything in a folder
+func pathSize(path string) (uint64, error) {
+	var size uint64
+	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += uint64(info.Size())
+		}
+		return nil
+	})
+	return size, err
+}
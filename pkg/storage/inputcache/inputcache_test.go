@@ -0,0 +1,157 @@
+package inputcache
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/stretchr/testify/require"
+)
+
+// a fetch func that writes contents to a file and counts how often it was called
+func writeFile(contents string, calls *int32) FetchFunc {
+	return func(ctx context.Context, path string) error {
+		atomic.AddInt32(calls, 1)
+		return os.WriteFile(path, []byte(contents), 0600)
+	}
+}
+
+func TestKey(t *testing.T) {
+	key, ok := Key(model.StorageSpec{Engine: model.StorageSourceIPFS, Cid: "QmInput", Path: "/inputs"})
+	require.True(t, ok)
+	require.Equal(t, "ipfs-QmInput", key)
+
+	// where it is mounted doesn't matter
+	otherKey, ok := Key(model.StorageSpec{Engine: model.StorageSourceIPFS, Cid: "QmInput", Path: "/other"})
+	require.True(t, ok)
+	require.Equal(t, key, otherKey)
+
+	urlKey, ok := Key(model.StorageSpec{
+		Engine:   model.StorageSourceURLDownload,
+		URL:      "https://example.com/data.csv",
+		Metadata: map[string]string{"checksum": "sha256:abc"},
+	})
+	require.True(t, ok)
+	otherURLKey, ok := Key(model.StorageSpec{
+		Engine:   model.StorageSourceURLDownload,
+		URL:      "https://example.com/other.csv",
+		Metadata: map[string]string{"checksum": "sha256:abc"},
+	})
+	require.True(t, ok)
+	require.NotEqual(t, urlKey, otherURLKey)
+	otherChecksumKey, ok := Key(model.StorageSpec{
+		Engine:   model.StorageSourceURLDownload,
+		URL:      "https://example.com/data.csv",
+		Metadata: map[string]string{"checksum": "sha256:def"},
+	})
+	require.True(t, ok)
+	require.NotEqual(t, urlKey, otherChecksumKey)
+
+	// nothing pins what these download so they are fetched every time
+	_, ok = Key(model.StorageSpec{Engine: model.StorageSourceURLDownload, URL: "https://example.com/data.csv"})
+	require.False(t, ok)
+	_, ok = Key(model.StorageSpec{
+		Engine:   model.StorageSourceURLDownload,
+		URL:      "https://example.com/list.txt",
+		Metadata: map[string]string{"checksum": "sha256:abc", "explode": "lines"},
+	})
+	require.False(t, ok)
+
+	// every file of a commit shares its checkout
+	gitKey, ok := Key(model.StorageSpec{Engine: model.StorageSourceGit, URL: "https://example.com/repo.git", Cid: "abc"})
+	require.True(t, ok)
+	otherGitKey, ok := Key(model.StorageSpec{
+		Engine:   model.StorageSourceGit,
+		URL:      "https://example.com/repo.git",
+		Cid:      "abc",
+		Metadata: map[string]string{"subpath": "main.go"},
+	})
+	require.True(t, ok)
+	require.Equal(t, gitKey, otherGitKey)
+
+	_, ok = Key(model.StorageSpec{Engine: model.StorageSourceFilecoinUnsealed, Cid: "QmInput"})
+	require.False(t, ok)
+}
+
+func TestAcquireShares(t *testing.T) {
+	ctx := context.Background()
+	cache, err := NewInputCache(Config{Dir: t.TempDir(), MaxSize: 1024})
+	require.NoError(t, err)
+
+	var calls int32
+	require.False(t, cache.Has("a"))
+	path, err := cache.Acquire(ctx, "a", writeFile("hello", &calls))
+	require.NoError(t, err)
+	require.True(t, cache.Has("a"))
+
+	otherPath, err := cache.Acquire(ctx, "a", writeFile("hello", &calls))
+	require.NoError(t, err)
+	require.Equal(t, path, otherPath)
+	require.Equal(t, int32(1), calls)
+	require.Equal(t, uint64(5), cache.Size())
+
+	contents, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(contents))
+}
+
+func TestAcquireError(t *testing.T) {
+	ctx := context.Background()
+	cache, err := NewInputCache(Config{Dir: t.TempDir(), MaxSize: 1024})
+	require.NoError(t, err)
+
+	_, err = cache.Acquire(ctx, "a", func(ctx context.Context, path string) error {
+		return fmt.Errorf("download failed")
+	})
+	require.Error(t, err)
+	require.False(t, cache.Has("a"))
+}
+
+func TestEvictionSkipsInUse(t *testing.T) {
+	ctx := context.Background()
+	cache, err := NewInputCache(Config{Dir: t.TempDir(), MaxSize: 10})
+	require.NoError(t, err)
+
+	var calls int32
+	_, err = cache.Acquire(ctx, "a", writeFile("aaaaa", &calls))
+	require.NoError(t, err)
+	_, err = cache.Acquire(ctx, "b", writeFile("bbbbb", &calls))
+	require.NoError(t, err)
+	cache.Release("b")
+
+	// b is the only entry nobody is using so it makes way for c
+	_, err = cache.Acquire(ctx, "c", writeFile("ccccc", &calls))
+	require.NoError(t, err)
+	require.True(t, cache.Has("a"))
+	require.False(t, cache.Has("b"))
+	require.True(t, cache.Has("c"))
+
+	// everything is in use so we go over budget until something is released
+	_, err = cache.Acquire(ctx, "d", writeFile("ddddd", &calls))
+	require.NoError(t, err)
+	require.Equal(t, uint64(15), cache.Size())
+
+	cache.Release("a")
+	require.False(t, cache.Has("a"))
+	require.Equal(t, uint64(10), cache.Size())
+}
+
+func TestReloadFromDisk(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	cache, err := NewInputCache(Config{Dir: dir, MaxSize: 1024})
+	require.NoError(t, err)
+
+	var calls int32
+	_, err = cache.Acquire(ctx, "a", writeFile("hello", &calls))
+	require.NoError(t, err)
+	cache.Release("a")
+
+	reloaded, err := NewInputCache(Config{Dir: dir, MaxSize: 1024})
+	require.NoError(t, err)
+	require.True(t, reloaded.Has("a"))
+	require.Equal(t, uint64(5), reloaded.Size())
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/filecoin-project/bacalhau/pkg/ipfs"
 	"github.com/filecoin-project/bacalhau/pkg/model"
 	"github.com/filecoin-project/bacalhau/pkg/storage"
+	"github.com/filecoin-project/bacalhau/pkg/storage/inputcache"
 	"github.com/filecoin-project/bacalhau/pkg/system"
 	"github.com/rs/zerolog/log"
 	"go.opentelemetry.io/otel/trace"
@@ -20,10 +21,13 @@ import (
 // from a remote ipfs server and copies it to
 // to a local directory in preparation for
 // a job to run - it will remove the folder/file once complete
+// unless an InputCache is set, in which case the content is
+// kept in the cache and shared with other shards
 
 type StorageProvider struct {
 	LocalDir   string
 	IPFSClient *ipfs.Client
+	InputCache *inputcache.InputCache
 }
 
 func NewStorageProvider(cm *system.CleanupManager, ipfsAPIAddress string) (*StorageProvider, error) {
@@ -58,6 +62,11 @@ func (dockerIPFS *StorageProvider) IsInstalled(ctx context.Context) (bool, error
 func (dockerIPFS *StorageProvider) HasStorageLocally(ctx context.Context, volume model.StorageSpec) (bool, error) {
 	ctx, span := newSpan(ctx, "HasStorageLocally")
 	defer span.End()
+	if dockerIPFS.InputCache != nil {
+		if key, ok := inputcache.Key(volume); ok && dockerIPFS.InputCache.Has(key) {
+			return true, nil
+		}
+	}
 	return dockerIPFS.IPFSClient.HasCID(ctx, volume.Cid)
 }
 
@@ -106,6 +115,12 @@ func (dockerIPFS *StorageProvider) PrepareStorage(ctx context.Context, storageSp
 
 //nolint:lll // Exception to the long rule
 func (dockerIPFS *StorageProvider) CleanupStorage(ctx context.Context, storageSpec model.StorageSpec, volume storage.StorageVolume) error {
+	if dockerIPFS.InputCache != nil {
+		if key, ok := inputcache.Key(storageSpec); ok {
+			dockerIPFS.InputCache.Release(key)
+			return nil
+		}
+	}
 	return system.RunCommand("rm", []string{
 		"-rf", fmt.Sprintf("%s/%s", dockerIPFS.LocalDir, storageSpec.Cid),
 	})
@@ -156,6 +171,12 @@ func (dockerIPFS *StorageProvider) Explode(ctx context.Context, spec model.Stora
 }
 
 func (dockerIPFS *StorageProvider) copyFile(ctx context.Context, storageSpec model.StorageSpec) (storage.StorageVolume, error) {
+	if dockerIPFS.InputCache != nil {
+		if key, ok := inputcache.Key(storageSpec); ok {
+			return dockerIPFS.copyFileToCache(ctx, key, storageSpec)
+		}
+	}
+
 	outputPath := fmt.Sprintf("%s/%s", dockerIPFS.LocalDir, storageSpec.Cid)
 
 	// If the output path already exists, we already have the data, as
@@ -184,6 +205,28 @@ func (dockerIPFS *StorageProvider) copyFile(ctx context.Context, storageSpec mod
 	return volume, nil
 }
 
+func (dockerIPFS *StorageProvider) copyFileToCache(
+	ctx context.Context,
+	key string,
+	storageSpec model.StorageSpec,
+) (storage.StorageVolume, error) {
+	outputPath, err := dockerIPFS.InputCache.Acquire(ctx, key, func(ctx context.Context, path string) error {
+		_, err := system.Timeout(config.GetDownloadCidRequestTimeout(), func() (interface{}, error) {
+			return nil, dockerIPFS.IPFSClient.Get(ctx, storageSpec.Cid, path)
+		})
+		return err
+	})
+	if err != nil {
+		return storage.StorageVolume{}, err
+	}
+
+	return storage.StorageVolume{
+		Type:   storage.StorageVolumeConnectorBind,
+		Source: outputPath,
+		Target: storageSpec.Path,
+	}, nil
+}
+
 func newSpan(ctx context.Context, apiName string) (context.Context, trace.Span) {
 	return system.Span(ctx, "storage/ipfs/api_copy", apiName)
 }
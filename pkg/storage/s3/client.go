@@ -0,0 +1,344 @@
+package s3
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/filecoin-project/bacalhau/pkg/util/bandwidth"
+)
+
+const (
+	// the region requests are signed for when none is configured
+	DefaultRegion = "us-east-1"
+
+	// the hash of an empty body, which is all we ever send
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+	signingAlgorithm = "AWS4-HMAC-SHA256"
+
+	// how long a request - including reading its body - can take, which
+	// has to be long enough to download the largest object we'll fetch
+	DefaultRequestTimeout = time.Hour
+)
+
+var errObjectNotFound = errors.New("object not found")
+
+// Config says which S3-compatible service inputs are read from and the
+// credentials to read them with - it is set by whoever runs the node, jobs
+// only say which bucket and prefix they want
+type Config struct {
+	// the URL of the service, e.g. http://localhost:9000 for MinIO - empty
+	// means AWS. Buckets are addressed by path on anything but AWS.
+	Endpoint        string
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// the only buckets jobs can read from. If it is empty and the node has
+	// credentials jobs can't read any bucket - anonymous requests can only
+	// see public buckets so without credentials jobs can read any of them.
+	AllowedBuckets []string
+	// empty means DefaultRequestTimeout
+	RequestTimeout time.Duration
+}
+
+// whether jobs can read from bucket
+func (c Config) bucketAllowed(bucket string) bool {
+	if len(c.AllowedBuckets) == 0 {
+		return c.AccessKeyID == ""
+	}
+	for _, allowed := range c.AllowedBuckets {
+		if bucket == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+type object struct {
+	Key  string `xml:"Key"`
+	Size int64  `xml:"Size"`
+	ETag string `xml:"ETag"`
+}
+
+type listBucketResult struct {
+	Contents              []object `xml:"Contents"`
+	IsTruncated           bool     `xml:"IsTruncated"`
+	NextContinuationToken string   `xml:"NextContinuationToken"`
+}
+
+type errorResponse struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// a client for the few S3 calls we need - it signs requests with
+// signature version 4 if it has credentials and sends them anonymously
+// (which works for public buckets) if not
+type client struct {
+	config Config
+	http   *http.Client
+	// so tests can sign with a known time
+	now func() time.Time
+}
+
+func newClient(config Config) *client {
+	if config.Region == "" {
+		config.Region = DefaultRegion
+	}
+	if config.RequestTimeout <= 0 {
+		config.RequestTimeout = DefaultRequestTimeout
+	}
+	return &client{
+		config: config,
+		http:   &http.Client{Timeout: config.RequestTimeout},
+		now:    time.Now,
+	}
+}
+
+// the URL of a key in a bucket - the key can be empty for the bucket itself
+func (c *client) url(bucket, key string, query url.Values) (*url.URL, error) {
+	var u *url.URL
+	var path string
+	if c.config.Endpoint == "" {
+		u = &url.URL{Scheme: "https", Host: fmt.Sprintf("%s.s3.%s.amazonaws.com", bucket, c.config.Region)}
+		path = "/" + key
+	} else {
+		endpoint, err := url.Parse(c.config.Endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid S3 endpoint %s: %w", c.config.Endpoint, err)
+		}
+		u = &url.URL{Scheme: endpoint.Scheme, Host: endpoint.Host}
+		path = strings.TrimSuffix(endpoint.Path, "/") + "/" + bucket
+		if key != "" {
+			path += "/" + key
+		}
+	}
+	u.Path = path
+	u.RawPath = escape(path, false)
+	u.RawQuery = canonicalQuery(query)
+	return u, nil
+}
+
+func (c *client) do(ctx context.Context, method string, u *url.URL, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	c.sign(req)
+	res, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= http.StatusBadRequest {
+		defer res.Body.Close()
+		if res.StatusCode == http.StatusNotFound {
+			return nil, errObjectNotFound
+		}
+		var s3Err errorResponse
+		if method != http.MethodHead && xml.NewDecoder(res.Body).Decode(&s3Err) == nil && s3Err.Code != "" {
+			return nil, fmt.Errorf("%s %s: %s: %s", method, u.Path, s3Err.Code, s3Err.Message)
+		}
+		return nil, fmt.Errorf("%s %s: %s", method, u.Path, res.Status)
+	}
+	return res, nil
+}
+
+// the object at key, errObjectNotFound if there isn't one
+func (c *client) headObject(ctx context.Context, bucket, key, version string) (object, error) {
+	u, err := c.url(bucket, key, versionQuery(version))
+	if err != nil {
+		return object{}, err
+	}
+	res, err := c.do(ctx, http.MethodHead, u, nil)
+	if err != nil {
+		return object{}, err
+	}
+	res.Body.Close()
+	size, err := strconv.ParseInt(res.Header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return object{}, fmt.Errorf("HEAD %s: invalid Content-Length: %w", u.Path, err)
+	}
+	return object{Key: key, Size: size, ETag: res.Header.Get("ETag")}, nil
+}
+
+// every object whose key starts with prefix, in key order
+func (c *client) listObjects(ctx context.Context, bucket, prefix string) ([]object, error) {
+	objects := []object{}
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		u, err := c.url(bucket, "", query)
+		if err != nil {
+			return nil, err
+		}
+		res, err := c.do(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(res.Body).Decode(&result)
+		res.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error listing s3://%s/%s: %w", bucket, prefix, err)
+		}
+		objects = append(objects, result.Contents...)
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// download the object at key to a new file at path
+func (c *client) getObject(ctx context.Context, bucket, key, version, path string) error {
+	u, err := c.url(bucket, key, versionQuery(version))
+	if err != nil {
+		return err
+	}
+	res, err := c.do(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, bandwidth.Reader(ctx, res.Body))
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("error downloading s3://%s/%s: %w", bucket, key, err)
+	}
+	return file.Close()
+}
+
+func versionQuery(version string) url.Values {
+	if version == "" {
+		return nil
+	}
+	return url.Values{"versionId": {version}}
+}
+
+// sign adds a signature version 4 Authorization header to the request,
+// covering the host, the range and any x-amz- headers
+func (c *client) sign(req *http.Request) {
+	if c.config.AccessKeyID == "" {
+		return
+	}
+	now := c.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", emptyPayloadHash)
+	if c.config.SessionToken != "" {
+		req.Header.Set("x-amz-security-token", c.config.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if name == "range" || strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		emptyPayloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{date, c.config.Region, "s3", "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{signingAlgorithm, amzDate, scope, sha256Hex(canonicalRequest)}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+c.config.SecretAccessKey), date)
+	key = hmacSHA256(key, c.config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		signingAlgorithm, c.config.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// the query string sorted and encoded the way it is signed
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := []string{}
+	for _, key := range keys {
+		values := append([]string{}, query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, escape(key, true)+"="+escape(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// percent encode everything but the unreserved characters - and slashes
+// unless encodeSlash is set
+func escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
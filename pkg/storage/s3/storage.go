@@ -0,0 +1,292 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/filecoin-project/bacalhau/pkg/config"
+	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/filecoin-project/bacalhau/pkg/storage"
+	"github.com/filecoin-project/bacalhau/pkg/system"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// VersionMetadataKey is the key in a storage spec's metadata that
+// pins the object it names to one version
+const VersionMetadataKey = "version"
+
+// a storage driver that copies objects from a bucket in an S3-compatible
+// service to a local directory before the job runs. The spec's URL is
+// s3://bucket/key - if there is an object at key the job gets that file,
+// otherwise it gets a directory of every object under key as a prefix.
+// Prefixes are exploded into their objects so they can be sharded.
+type StorageProvider struct {
+	LocalDir string
+
+	client *client
+}
+
+func NewStorageProvider(cm *system.CleanupManager, s3Config Config) (*StorageProvider, error) {
+	dir, err := ioutil.TempDir(config.GetStoragePath(), "bacalhau-s3")
+	if err != nil {
+		return nil, err
+	}
+	storageHandler := &StorageProvider{
+		LocalDir: dir,
+		client:   newClient(s3Config),
+	}
+	log.Debug().Msgf("S3 driver created with output dir: %s", dir)
+	return storageHandler, nil
+}
+
+// NewStorageSpec makes the spec for an S3 input from an s3://bucket/key URL,
+// which may pin the object's version with a versionId query
+func NewStorageSpec(rawURL, path string) (model.StorageSpec, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return model.StorageSpec{}, err
+	}
+	version := u.Query().Get("versionId")
+	u.RawQuery = ""
+	spec := model.StorageSpec{
+		Engine: model.StorageSourceS3,
+		URL:    u.String(),
+		Path:   path,
+	}
+	if version != "" {
+		spec.Metadata = map[string]string{VersionMetadataKey: version}
+	}
+	_, _, err = parseURL(spec)
+	if err != nil {
+		return model.StorageSpec{}, err
+	}
+	return spec, nil
+}
+
+// IsS3URL is true for URLs the S3 storage provider reads
+func IsS3URL(rawURL string) bool {
+	return strings.HasPrefix(rawURL, "s3://")
+}
+
+func parseURL(spec model.StorageSpec) (bucket, key string, err error) {
+	u, err := url.Parse(spec.URL)
+	if err != nil {
+		return "", "", err
+	}
+	if u.Scheme != "s3" || u.Host == "" {
+		return "", "", fmt.Errorf("invalid S3 URL %s: expected s3://bucket/key", spec.URL)
+	}
+	if spec.Metadata[VersionMetadataKey] != "" && (u.Path == "" || strings.HasSuffix(u.Path, "/")) {
+		return "", "", fmt.Errorf("invalid S3 URL %s: only an object can have a version, not a prefix", spec.URL)
+	}
+	return u.Host, strings.TrimPrefix(u.Path, "/"), nil
+}
+
+// the objects a spec covers - and whether it is a single object rather
+// than a prefix
+func (sp *StorageProvider) resolve(ctx context.Context, spec model.StorageSpec) ([]object, bool, error) {
+	bucket, key, err := parseURL(spec)
+	if err != nil {
+		return nil, false, err
+	}
+	// the node's credentials may read buckets that aren't meant for jobs
+	if !sp.client.config.bucketAllowed(bucket) {
+		return nil, false, fmt.Errorf("jobs on this node can't read from S3 bucket %s", bucket)
+	}
+	version := spec.Metadata[VersionMetadataKey]
+	if key != "" && !strings.HasSuffix(key, "/") {
+		obj, err := sp.client.headObject(ctx, bucket, key, version)
+		if err == nil {
+			return []object{obj}, true, nil
+		}
+		if !errors.Is(err, errObjectNotFound) {
+			return nil, false, err
+		}
+		if version != "" {
+			return nil, false, fmt.Errorf("version %s of %s not found", version, spec.URL)
+		}
+		// data matches data/... but not database/...
+		key += "/"
+	}
+	objects, err := sp.client.listObjects(ctx, bucket, key)
+	if err != nil {
+		return nil, false, err
+	}
+	files := []object{}
+	for _, obj := range objects {
+		// the empty objects some tools make to look like folders
+		if strings.HasSuffix(obj.Key, "/") {
+			continue
+		}
+		files = append(files, obj)
+	}
+	if len(files) == 0 {
+		return nil, false, fmt.Errorf("nothing found at %s", spec.URL)
+	}
+	return files, false, nil
+}
+
+func (sp *StorageProvider) IsInstalled(ctx context.Context) (bool, error) {
+	return true, nil
+}
+
+func (sp *StorageProvider) HasStorageLocally(ctx context.Context, volume model.StorageSpec) (bool, error) {
+	return false, nil
+}
+
+// the total size of the objects, which S3 tells us exactly
+func (sp *StorageProvider) GetVolumeSize(ctx context.Context, volume model.StorageSpec) (uint64, error) {
+	ctx, span := newSpan(ctx, "GetVolumeSize")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, config.GetVolumeSizeRequestTimeout())
+	defer cancel()
+	objects, _, err := sp.resolve(ctx, volume)
+	if err != nil {
+		return 0, err
+	}
+	var size uint64
+	for _, obj := range objects {
+		size += uint64(obj.Size)
+	}
+	return size, nil
+}
+
+func (sp *StorageProvider) PrepareStorage(ctx context.Context, storageSpec model.StorageSpec) (storage.StorageVolume, error) {
+	ctx, span := newSpan(ctx, "PrepareStorage")
+	defer span.End()
+
+	objects, single, err := sp.resolve(ctx, storageSpec)
+	if err != nil {
+		return storage.StorageVolume{}, err
+	}
+	bucket, key, err := parseURL(storageSpec)
+	if err != nil {
+		return storage.StorageVolume{}, err
+	}
+
+	outputPath, err := ioutil.TempDir(sp.LocalDir, "*")
+	if err != nil {
+		return storage.StorageVolume{}, err
+	}
+
+	var source string
+	if single {
+		source = filepath.Join(outputPath, filepath.Base(key))
+		err = sp.client.getObject(ctx, bucket, key, storageSpec.Metadata[VersionMetadataKey], source)
+	} else {
+		source = filepath.Join(outputPath, "data")
+		err = sp.downloadPrefix(ctx, bucket, key, objects, source)
+	}
+	if err != nil {
+		os.RemoveAll(outputPath)
+		return storage.StorageVolume{}, err
+	}
+
+	return storage.StorageVolume{
+		Type:   storage.StorageVolumeConnectorBind,
+		Source: source,
+		Target: storageSpec.Path,
+	}, nil
+}
+
+// download every object under the prefix to the same relative path in dir
+func (sp *StorageProvider) downloadPrefix(ctx context.Context, bucket, prefix string, objects []object, dir string) error {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	for _, obj := range objects {
+		rel, err := relativeKey(prefix, obj.Key)
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(dir, rel)
+		err = os.MkdirAll(filepath.Dir(path), os.ModePerm)
+		if err != nil {
+			return err
+		}
+		err = sp.client.getObject(ctx, bucket, obj.Key, "", path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// where an object goes under the folder for its prefix - keys are just
+// strings so make sure none of them can write outside it
+func relativeKey(prefix, key string) (string, error) {
+	rel := filepath.Clean("/" + strings.TrimPrefix(key, prefix))
+	if rel == "/" {
+		return "", fmt.Errorf("invalid object key %s under %s", key, prefix)
+	}
+	return strings.TrimPrefix(rel, "/"), nil
+}
+
+func (sp *StorageProvider) CleanupStorage(
+	ctx context.Context,
+	storageSpec model.StorageSpec,
+	volume storage.StorageVolume,
+) error {
+	_, span := newSpan(ctx, "CleanupStorage")
+	defer span.End()
+
+	pathToCleanup := filepath.Dir(volume.Source)
+	log.Debug().Msgf("Cleaning up: %s", pathToCleanup)
+	return os.RemoveAll(pathToCleanup)
+}
+
+// we only read from buckets
+func (sp *StorageProvider) Upload(ctx context.Context, localPath string) (model.StorageSpec, error) {
+	return model.StorageSpec{}, fmt.Errorf("not implemented")
+}
+
+// a single object explodes to itself and a prefix to each of the objects
+// under it, mounted at their path relative to the prefix
+func (sp *StorageProvider) Explode(ctx context.Context, spec model.StorageSpec) ([]model.StorageSpec, error) {
+	ctx, span := newSpan(ctx, "Explode")
+	defer span.End()
+
+	objects, single, err := sp.resolve(ctx, spec)
+	if err != nil {
+		return nil, err
+	}
+	if single {
+		return []model.StorageSpec{spec}, nil
+	}
+	bucket, prefix, err := parseURL(spec)
+	if err != nil {
+		return nil, err
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	basePath := strings.TrimSuffix(spec.Path, "/")
+	specs := []model.StorageSpec{}
+	for _, obj := range objects {
+		rel, err := relativeKey(prefix, obj.Key)
+		if err != nil {
+			return nil, err
+		}
+		specs = append(specs, model.StorageSpec{
+			Engine: model.StorageSourceS3,
+			Name:   spec.Name,
+			URL:    (&url.URL{Scheme: "s3", Host: bucket, Path: "/" + obj.Key}).String(),
+			Path:   basePath + "/" + filepath.ToSlash(rel),
+		})
+	}
+	return specs, nil
+}
+
+func newSpan(ctx context.Context, apiName string) (context.Context, trace.Span) {
+	return system.Span(ctx, "storage/s3", apiName)
+}
+
+// Compile time interface check:
+var _ storage.StorageProvider = (*StorageProvider)(nil)
@@ -0,0 +1,268 @@
+package urldownload
+
+import (
+	"context"
+	"crypto/md5"  //nolint:gosec // only used to check downloads against checksums people give us
+	"crypto/sha1" //nolint:gosec // only used to check downloads against checksums people give us
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/filecoin-project/bacalhau/pkg/config"
+	"github.com/filecoin-project/bacalhau/pkg/util/bandwidth"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// ChecksumMetadataKey is the key in a storage spec's metadata for the
+	// checksum the download must match, as algorithm:hex e.g. sha256:2c26b4...
+	// md5, sha1, sha256 and sha512 are supported
+	ChecksumMetadataKey = "checksum"
+
+	// how many times a failed download is resumed before we give up
+	DefaultMaxRetries = 5
+	// how long we wait before the first retry, it doubles after each one
+	DefaultRetryBackoff = time.Second
+)
+
+// ChecksumAlgorithms are the hashes a checksum can use
+var ChecksumAlgorithms = []string{"md5", "sha1", "sha256", "sha512"}
+
+func newHash(algorithm string) (hash.Hash, bool) {
+	switch algorithm {
+	case "md5":
+		return md5.New(), true //nolint:gosec
+	case "sha1":
+		return sha1.New(), true //nolint:gosec
+	case "sha256":
+		return sha256.New(), true
+	case "sha512":
+		return sha512.New(), true
+	default:
+		return nil, false
+	}
+}
+
+// the hash for a checksum and the sum it must have
+func parseChecksum(checksum string) (hash.Hash, string, error) {
+	algorithm, expected, ok := strings.Cut(checksum, ":")
+	if !ok {
+		return nil, "", fmt.Errorf("invalid checksum %q: expected algorithm:hex", checksum)
+	}
+	h, ok := newHash(strings.ToLower(algorithm))
+	if !ok {
+		return nil, "", fmt.Errorf("invalid checksum %q: algorithm must be one of %s",
+			checksum, strings.Join(ChecksumAlgorithms, ", "))
+	}
+	expected = strings.ToLower(expected)
+	sum, err := hex.DecodeString(expected)
+	if err != nil || len(sum) != h.Size() {
+		return nil, "", fmt.Errorf("invalid checksum %q: not a %s sum", checksum, algorithm)
+	}
+	return h, expected, nil
+}
+
+// ValidateChecksum checks a checksum is one we can verify
+func ValidateChecksum(checksum string) error {
+	_, _, err := parseChecksum(checksum)
+	return err
+}
+
+func verifyChecksum(path, checksum string) error {
+	h, expected, err := parseChecksum(checksum)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(h, file)
+	if err != nil {
+		return err
+	}
+	actual := hex.EncodeToString(h.Sum(nil))
+	if actual != expected {
+		algorithm, _, _ := strings.Cut(checksum, ":")
+		return fmt.Errorf("checksum mismatch: expected %s but got %s:%s", checksum, algorithm, actual)
+	}
+	return nil
+}
+
+// a failed attempt at a download that is worth resuming
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retryableError) Unwrap() error {
+	return e.err
+}
+
+// download the URL to a new file at outputPath, resuming from where it got to
+// if the connection fails or the server has a temporary problem, and check it
+// matches the checksum if there is one. It fails as soon as it has more than
+// maxSize bytes, unless that is zero.
+func (sp *StorageProvider) download(ctx context.Context, rawURL, outputPath, checksum string, maxSize int64) error {
+	if checksum == "" && sp.RequireChecksum {
+		return fmt.Errorf("%s has no checksum and this node only downloads URLs with one", rawURL)
+	}
+	if checksum != "" {
+		if err := ValidateChecksum(checksum); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.OpenFile(outputPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644) //nolint:gomnd
+	if err != nil {
+		return err
+	}
+	err = sp.downloadWithRetries(ctx, rawURL, file, maxSize)
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil && checksum != "" {
+		err = verifyChecksum(outputPath, checksum)
+	}
+	if err != nil {
+		os.Remove(outputPath)
+		return fmt.Errorf("failed to download %s: %w", rawURL, err)
+	}
+	return nil
+}
+
+func (sp *StorageProvider) downloadWithRetries(ctx context.Context, rawURL string, file *os.File, maxSize int64) error {
+	backoff := sp.RetryBackoff
+	var size int64
+	for attempt := 0; ; attempt++ {
+		var err error
+		size, err = sp.downloadAttempt(ctx, rawURL, file, size, maxSize)
+		if err == nil {
+			return nil
+		}
+		var retryable *retryableError
+		if !errors.As(err, &retryable) || attempt >= sp.MaxRetries || ctx.Err() != nil {
+			return err
+		}
+		log.Debug().Msgf("Download of %s failed at byte %d, retrying in %s: %s", rawURL, size, backoff, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// carry on downloading into file from offset, returning how much of the
+// file we have now
+func (sp *StorageProvider) downloadAttempt(
+	ctx context.Context,
+	rawURL string,
+	file *os.File,
+	offset, maxSize int64,
+) (int64, error) {
+	sp.HTTPClient.SetTimeout(config.GetDownloadURLRequestTimeout())
+	req := sp.HTTPClient.R().
+		SetContext(ctx).
+		SetDoNotParseResponse(true).
+		// byte offsets have to mean the same thing every time
+		SetHeader("Accept-Encoding", "identity")
+	if offset > 0 {
+		req.SetHeader("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	res, err := req.Get(rawURL)
+	if err != nil {
+		return offset, &retryableError{err}
+	}
+	body := res.RawBody()
+	defer body.Close()
+
+	status := res.StatusCode()
+	switch {
+	case status == http.StatusPartialContent && offset > 0:
+		start, ok := contentRangeStart(res.Header().Get("Content-Range"))
+		if !ok || start != offset {
+			// not what we asked for, so start again
+			return 0, &retryableError{fmt.Errorf("unexpected Content-Range %q", res.Header().Get("Content-Range"))}
+		}
+	case status == http.StatusOK:
+		// the server doesn't do ranges or this is the first attempt
+		offset = 0
+	case status == http.StatusRequestTimeout || status == http.StatusTooManyRequests ||
+		status >= http.StatusInternalServerError || (status == http.StatusRequestedRangeNotSatisfiable && offset > 0):
+		if status == http.StatusRequestedRangeNotSatisfiable {
+			offset = 0
+		}
+		return offset, &retryableError{fmt.Errorf("server returned %s", res.Status())}
+	default:
+		// don't hand an error page to the job (or cache it) as if it was the file
+		return offset, fmt.Errorf("server returned %s", res.Status())
+	}
+
+	err = file.Truncate(offset)
+	if err != nil {
+		return offset, err
+	}
+	_, err = file.Seek(offset, io.SeekStart)
+	if err != nil {
+		return offset, err
+	}
+	reader := bandwidth.Reader(ctx, body)
+	if maxSize > 0 {
+		// one byte more than we allow, so we can tell it was too big
+		reader = io.LimitReader(reader, maxSize+1-offset)
+	}
+	n, err := io.Copy(file, reader)
+	if err != nil {
+		return offset + n, &retryableError{err}
+	}
+	if maxSize > 0 && offset+n > maxSize {
+		return offset + n, fmt.Errorf("bigger than %d bytes", maxSize)
+	}
+	return offset + n, nil
+}
+
+// the first byte of a "bytes start-end/size" content range
+func contentRangeStart(contentRange string) (int64, bool) {
+	if !strings.HasPrefix(contentRange, "bytes ") {
+		return 0, false
+	}
+	rangeSpec := strings.TrimPrefix(contentRange, "bytes ")
+	start, _, ok := strings.Cut(rangeSpec, "-")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(start, 10, 64)
+	return n, err == nil
+}
+
+// the size the server says the URL is, zero if it won't tell us
+func (sp *StorageProvider) headSize(ctx context.Context, rawURL string) uint64 {
+	res, err := sp.HTTPClient.R().
+		SetContext(ctx).
+		SetHeader("Accept-Encoding", "identity").
+		Head(rawURL)
+	if err != nil {
+		log.Debug().Msgf("Could not get the size of %s: %s", rawURL, err)
+		return 0
+	}
+	if res.IsError() || res.RawResponse.ContentLength < 0 {
+		return 0
+	}
+	return uint64(res.RawResponse.ContentLength)
+}
@@ -0,0 +1,193 @@
+package urldownload
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/filecoin-project/bacalhau/pkg/model"
+)
+
+// ExplodeMetadataKey is the key in a storage spec's metadata that makes its
+// URL a list of the URLs to download rather than the data itself
+const ExplodeMetadataKey = "explode"
+
+const (
+	// ExplodeLines is a text file of URLs, one per line - blank lines and
+	// lines starting with # are skipped. Each file is named after the last
+	// part of its URL's path.
+	ExplodeLines = "lines"
+	// ExplodeManifest is a JSON array of ManifestEntry
+	ExplodeManifest = "manifest"
+)
+
+const (
+	// the most we read of a list before deciding it isn't one
+	maxListSize = 10 * 1024 * 1024
+
+	// how long we keep a list we fetched - long enough for the same job to
+	// size, explode and prepare it without fetching it each time, and to be
+	// sure all of those see the same list
+	listCacheTTL = 10 * time.Minute
+)
+
+// a list we fetched and parsed
+type cachedList struct {
+	entries []ManifestEntry
+	fetched time.Time
+}
+
+// ManifestEntry is one file in a manifest
+type ManifestEntry struct {
+	URL string `json:"url"`
+	// where the file goes relative to the spec's path, defaults to the
+	// last part of the URL's path
+	Path string `json:"path,omitempty"`
+	// what the file must match, see ChecksumMetadataKey
+	Checksum string `json:"checksum,omitempty"`
+}
+
+func validateExplodeMode(mode string) error {
+	switch mode {
+	case "", ExplodeLines, ExplodeManifest:
+		return nil
+	default:
+		return fmt.Errorf("invalid explode mode %q: must be %s or %s", mode, ExplodeLines, ExplodeManifest)
+	}
+}
+
+// the list a spec points at, which is fetched if we haven't done so lately
+func (sp *StorageProvider) readList(ctx context.Context, spec model.StorageSpec) ([]ManifestEntry, error) {
+	key := strings.Join([]string{spec.URL, spec.Metadata[ChecksumMetadataKey], spec.Metadata[ExplodeMetadataKey]}, "\n")
+	sp.listsMu.Lock()
+	for other, list := range sp.lists {
+		if time.Since(list.fetched) > listCacheTTL {
+			delete(sp.lists, other)
+		}
+	}
+	list, ok := sp.lists[key]
+	sp.listsMu.Unlock()
+	if ok {
+		return list.entries, nil
+	}
+
+	entries, err := sp.fetchList(ctx, spec)
+	if err != nil {
+		return nil, err
+	}
+	sp.listsMu.Lock()
+	defer sp.listsMu.Unlock()
+	sp.lists[key] = cachedList{entries: entries, fetched: time.Now()}
+	return entries, nil
+}
+
+// fetch and parse the list a spec points at
+func (sp *StorageProvider) fetchList(ctx context.Context, spec model.StorageSpec) ([]ManifestEntry, error) {
+	dir, err := ioutil.TempDir(sp.LocalDir, "*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	listPath := filepath.Join(dir, "list")
+	err = sp.download(ctx, spec.URL, listPath, spec.Metadata[ChecksumMetadataKey], maxListSize)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(listPath)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := parseList(spec.Metadata[ExplodeMetadataKey], data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid list at %s: %w", spec.URL, err)
+	}
+	return entries, nil
+}
+
+func parseList(mode string, data []byte) ([]ManifestEntry, error) {
+	entries := []ManifestEntry{}
+	switch mode {
+	case ExplodeLines:
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			entries = append(entries, ManifestEntry{URL: line})
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	case ExplodeManifest:
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, validateExplodeMode(mode)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no URLs found")
+	}
+
+	seen := map[string]string{}
+	for i := range entries {
+		entry := &entries[i]
+		if _, err := IsURLSupported(entry.URL); err != nil {
+			return nil, err
+		}
+		if entry.Checksum != "" {
+			if err := ValidateChecksum(entry.Checksum); err != nil {
+				return nil, err
+			}
+		}
+		if entry.Path == "" {
+			u, err := url.Parse(entry.URL)
+			if err != nil {
+				return nil, err
+			}
+			entry.Path = path.Base(u.Path)
+		}
+		// the list comes from somewhere else so make sure nothing in it
+		// can be written outside the volume
+		entry.Path = strings.TrimPrefix(path.Clean("/"+entry.Path), "/")
+		if entry.Path == "" || entry.Path == "." {
+			return nil, fmt.Errorf("no file name for %s", entry.URL)
+		}
+		if other, ok := seen[entry.Path]; ok {
+			return nil, fmt.Errorf("%s and %s would both be %s", other, entry.URL, entry.Path)
+		}
+		seen[entry.Path] = entry.URL
+	}
+	return entries, nil
+}
+
+// download every file in a list into dir
+func (sp *StorageProvider) downloadList(ctx context.Context, spec model.StorageSpec, dir string) error {
+	entries, err := sp.readList(ctx, spec)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		outputPath := filepath.Join(dir, filepath.FromSlash(entry.Path))
+		err = os.MkdirAll(filepath.Dir(outputPath), os.ModePerm)
+		if err != nil {
+			return err
+		}
+		err = sp.download(ctx, entry.URL, outputPath, entry.Checksum, 0)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
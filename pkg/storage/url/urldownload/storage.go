@@ -5,13 +5,19 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/url"
+	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/filecoin-project/bacalhau/pkg/config"
 	"github.com/filecoin-project/bacalhau/pkg/model"
 	"github.com/filecoin-project/bacalhau/pkg/storage"
+	"github.com/filecoin-project/bacalhau/pkg/storage/archive"
+	"github.com/filecoin-project/bacalhau/pkg/storage/inputcache"
 	"github.com/filecoin-project/bacalhau/pkg/system"
 	"github.com/go-resty/resty/v2"
+	sync "github.com/lukemarsden/golang-mutex-tracer"
 	"github.com/rs/zerolog/log"
 )
 
@@ -19,10 +25,27 @@ import (
 // from a public URL source and copies it to
 // a local directory in preparation for
 // a job to run - it will remove the folder/file once complete
+// unless an InputCache is set and the spec has a checksum, in which case
+// the download is kept in the cache and shared with other shards.
+// Downloads are resumed with range requests if they fail part way and
+// checked against the spec's checksum if it has one. A spec whose
+// metadata has an explode mode is a list of URLs instead, which the job
+// gets as a folder and which explodes into one spec per URL.
 
 type StorageProvider struct {
 	LocalDir   string
 	HTTPClient *resty.Client
+	InputCache *inputcache.InputCache
+	// refuse to download anything that doesn't have a checksum - including
+	// a list, even if every URL in it has one, as otherwise whoever serves
+	// the list decides what is downloaded
+	RequireChecksum bool
+	MaxRetries      int
+	RetryBackoff    time.Duration
+
+	// the lists we fetched lately, by URL, checksum and explode mode
+	lists   map[string]cachedList
+	listsMu sync.Mutex
 }
 
 func NewStorageProvider(cm *system.CleanupManager) (*StorageProvider, error) {
@@ -37,25 +60,111 @@ func NewStorageProvider(cm *system.CleanupManager) (*StorageProvider, error) {
 	client.SetOutputDirectory(dir)
 
 	storageHandler := &StorageProvider{
-		HTTPClient: client,
-		LocalDir:   dir,
+		HTTPClient:   client,
+		LocalDir:     dir,
+		MaxRetries:   DefaultMaxRetries,
+		RetryBackoff: DefaultRetryBackoff,
+		lists:        map[string]cachedList{},
 	}
+	storageHandler.listsMu.EnableTracerWithOpts(sync.Opts{
+		Threshold: 10 * time.Millisecond,
+		Id:        "URLDownloadStorageProvider.listsMu",
+	})
 
 	log.Debug().Msgf("URL download driver created with output dir: %s", dir)
 	return storageHandler, nil
 }
 
+// NewStorageSpec makes the spec for a URL input. The URL's fragment can
+// hold a checksum, an explode mode and an archive format to extract as a
+// query, e.g. https://example.com/data.csv#sha256=2c26b4...,
+// https://example.com/urls.txt#explode=lines or
+// https://example.com/data.tar.gz#extract=auto - servers never see the
+// fragment so it is taken off the URL
+func NewStorageSpec(rawURL, path string) (model.StorageSpec, error) {
+	rawURL, fragment, _ := strings.Cut(rawURL, "#")
+	_, err := IsURLSupported(rawURL)
+	if err != nil {
+		return model.StorageSpec{}, err
+	}
+	spec := model.StorageSpec{
+		Engine: model.StorageSourceURLDownload,
+		URL:    rawURL,
+		Path:   path,
+	}
+	if fragment == "" {
+		return spec, nil
+	}
+	options, err := url.ParseQuery(fragment)
+	if err != nil {
+		return model.StorageSpec{}, fmt.Errorf("invalid options %q for %s: %w", fragment, rawURL, err)
+	}
+	spec.Metadata = map[string]string{}
+	for name := range options {
+		value := options.Get(name)
+		switch {
+		case name == ExplodeMetadataKey:
+			err = validateExplodeMode(value)
+			spec.Metadata[ExplodeMetadataKey] = value
+		case name == archive.ExtractMetadataKey:
+			err = archive.ValidateFormat(value)
+			spec.Metadata[archive.ExtractMetadataKey] = value
+		case isChecksumAlgorithm(name):
+			spec.Metadata[ChecksumMetadataKey] = name + ":" + value
+			err = ValidateChecksum(spec.Metadata[ChecksumMetadataKey])
+		default:
+			err = fmt.Errorf("unknown option %q for %s", name, rawURL)
+		}
+		if err != nil {
+			return model.StorageSpec{}, err
+		}
+	}
+	return spec, nil
+}
+
+func isChecksumAlgorithm(name string) bool {
+	for _, algorithm := range ChecksumAlgorithms {
+		if name == algorithm {
+			return true
+		}
+	}
+	return false
+}
+
 func (sp *StorageProvider) IsInstalled(ctx context.Context) (bool, error) {
 	return true, nil
 }
 
 func (sp *StorageProvider) HasStorageLocally(ctx context.Context, volume model.StorageSpec) (bool, error) {
+	if sp.InputCache != nil {
+		if key, ok := inputcache.Key(volume); ok {
+			return sp.InputCache.Has(key), nil
+		}
+	}
 	return false, nil
 }
 
-// Could do a HEAD request and check Content-Length, but in some cases that's not guaranteed to be the real end file size
+// the Content-Length the server gives for a HEAD request - or the total of
+// them for a list - which is zero if it doesn't give one
 func (sp *StorageProvider) GetVolumeSize(ctx context.Context, volume model.StorageSpec) (uint64, error) {
-	return 0, nil
+	ctx, cancel := context.WithTimeout(ctx, config.GetVolumeSizeRequestTimeout())
+	defer cancel()
+	_, err := IsURLSupported(volume.URL)
+	if err != nil {
+		return 0, err
+	}
+	if volume.Metadata[ExplodeMetadataKey] == "" {
+		return sp.headSize(ctx, volume.URL), nil
+	}
+	entries, err := sp.readList(ctx, volume)
+	if err != nil {
+		return 0, err
+	}
+	var size uint64
+	for _, entry := range entries {
+		size += sp.headSize(ctx, entry.URL)
+	}
+	return size, nil
 }
 
 func (sp *StorageProvider) PrepareStorage(ctx context.Context, storageSpec model.StorageSpec) (storage.StorageVolume, error) {
@@ -66,29 +175,64 @@ func (sp *StorageProvider) PrepareStorage(ctx context.Context, storageSpec model
 	if err != nil {
 		return storage.StorageVolume{}, err
 	}
+	err = validateExplodeMode(storageSpec.Metadata[ExplodeMetadataKey])
+	if err != nil {
+		return storage.StorageVolume{}, err
+	}
+
+	if sp.InputCache != nil {
+		if key, ok := inputcache.Key(storageSpec); ok {
+			var source string
+			source, err = sp.InputCache.Acquire(ctx, key, func(ctx context.Context, path string) error {
+				return sp.fetch(ctx, storageSpec, path)
+			})
+			if err != nil {
+				return storage.StorageVolume{}, err
+			}
+			return storage.StorageVolume{
+				Type:   storage.StorageVolumeConnectorBind,
+				Source: source,
+				Target: storageSpec.Path,
+			}, nil
+		}
+	}
 
 	outputPath, err := ioutil.TempDir(sp.LocalDir, "*")
 	if err != nil {
 		return storage.StorageVolume{}, err
 	}
 
-	sp.HTTPClient.SetTimeout(config.GetDownloadURLRequestTimeout())
-	_, err = sp.HTTPClient.R().
-		SetOutput(outputPath + "/file").
-		Get(storageSpec.URL)
+	source := outputPath + "/file"
+	if storageSpec.Metadata[ExplodeMetadataKey] != "" {
+		source = outputPath + "/data"
+	}
+	err = sp.fetch(ctx, storageSpec, source)
 	if err != nil {
+		os.RemoveAll(outputPath)
 		return storage.StorageVolume{}, err
 	}
 
 	volume := storage.StorageVolume{
 		Type:   storage.StorageVolumeConnectorBind,
-		Source: outputPath + "/file",
+		Source: source,
 		Target: storageSpec.Path,
 	}
 
 	return volume, nil
 }
 
+// download the file - or the folder of files for a list - to outputPath
+func (sp *StorageProvider) fetch(ctx context.Context, spec model.StorageSpec, outputPath string) error {
+	if spec.Metadata[ExplodeMetadataKey] == "" {
+		return sp.download(ctx, spec.URL, outputPath, spec.Metadata[ChecksumMetadataKey], 0)
+	}
+	err := os.MkdirAll(outputPath, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	return sp.downloadList(ctx, spec, outputPath)
+}
+
 // func (sp *StorageProvider) CleanupStorage(ctx context.Context, storageSpec model.StorageSpec, volume storage.StorageVolume) error {
 func (sp *StorageProvider) CleanupStorage(
 	ctx context.Context,
@@ -98,6 +242,13 @@ func (sp *StorageProvider) CleanupStorage(
 	_, span := system.GetTracer().Start(ctx, "pkg/storage/url/urldownload.CleanupStorage")
 	defer span.End()
 
+	if sp.InputCache != nil {
+		if key, ok := inputcache.Key(storageSpec); ok {
+			sp.InputCache.Release(key)
+			return nil
+		}
+	}
+
 	pathToCleanup := filepath.Dir(volume.Source)
 	log.Debug().Msgf("Cleaning up: %s", pathToCleanup)
 
@@ -111,17 +262,39 @@ func (sp *StorageProvider) Upload(ctx context.Context, localPath string) (model.
 	return model.StorageSpec{}, fmt.Errorf("not implemented")
 }
 
-// for the url download - explode will always result in a single item
-// mounted at the path specified in the spec
+// a single URL explodes to itself and a list to one spec per URL in it,
+// mounted at its path in the list under the path specified in the spec
 func (sp *StorageProvider) Explode(ctx context.Context, spec model.StorageSpec) ([]model.StorageSpec, error) {
-	return []model.StorageSpec{
-		{
+	if spec.Metadata[ExplodeMetadataKey] == "" {
+		return []model.StorageSpec{
+			{
+				Name:     spec.Name,
+				Engine:   model.StorageSourceURLDownload,
+				Path:     spec.Path,
+				URL:      spec.URL,
+				Metadata: spec.Metadata,
+			},
+		}, nil
+	}
+	entries, err := sp.readList(ctx, spec)
+	if err != nil {
+		return nil, err
+	}
+	basePath := strings.TrimSuffix(spec.Path, "/")
+	specs := []model.StorageSpec{}
+	for _, entry := range entries {
+		exploded := model.StorageSpec{
 			Name:   spec.Name,
 			Engine: model.StorageSourceURLDownload,
-			Path:   spec.Path,
-			URL:    spec.URL,
-		},
-	}, nil
+			Path:   basePath + "/" + entry.Path,
+			URL:    entry.URL,
+		}
+		if entry.Checksum != "" {
+			exploded.Metadata = map[string]string{ChecksumMetadataKey: entry.Checksum}
+		}
+		specs = append(specs, exploded)
+	}
+	return specs, nil
 }
 
 func IsURLSupported(rawURL string) (bool, error) {
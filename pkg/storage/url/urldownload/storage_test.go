@@ -8,10 +8,17 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/filecoin-project/bacalhau/pkg/storage"
+	"github.com/filecoin-project/bacalhau/pkg/storage/archive"
 	"github.com/filecoin-project/bacalhau/pkg/system"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewStorageProvider(t *testing.T) {
@@ -108,3 +115,297 @@ func TestPrepareStorage(t *testing.T) {
 		t.Errorf("Should be \"%s\", but is \"%s\"", testString, text)
 	}
 }
+
+const testContent = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+// sha256 of testContent
+const testChecksum = "sha256:74e7e5bb9d22d6db26bf76946d40fff3ea9f0346b884fd0694920fccfad15e33"
+
+// a server for testContent that cuts the first download off half way,
+// fails the next request and then serves ranges like a normal server
+type flakyServer struct {
+	requests []string
+}
+
+func (f *flakyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.requests = append(f.requests, r.Method+" "+r.Header.Get("Range"))
+	switch {
+	case r.Method == http.MethodGet && len(f.requests) == 1:
+		w.Header().Set("Content-Length", strconv.Itoa(len(testContent)))
+		_, _ = w.Write([]byte(testContent[:10]))
+		w.(http.Flusher).Flush()
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	case r.Method == http.MethodGet && len(f.requests) == 2:
+		w.WriteHeader(http.StatusServiceUnavailable)
+	default:
+		http.ServeContent(w, r, "data.txt", time.Time{}, strings.NewReader(testContent))
+	}
+}
+
+func newTestProvider(t *testing.T) *StorageProvider {
+	sp, err := NewStorageProvider(system.NewCleanupManager())
+	require.NoError(t, err)
+	sp.LocalDir = t.TempDir()
+	sp.RetryBackoff = time.Millisecond
+	return sp
+}
+
+func readVolume(t *testing.T, volume storage.StorageVolume) string {
+	content, err := os.ReadFile(volume.Source)
+	require.NoError(t, err)
+	return string(content)
+}
+
+func TestPrepareStorageResumes(t *testing.T) {
+	flaky := &flakyServer{}
+	ts := httptest.NewServer(flaky)
+	defer ts.Close()
+	sp := newTestProvider(t)
+
+	spec, err := NewStorageSpec(ts.URL+"/data.txt#"+strings.Replace(testChecksum, ":", "=", 1), "/inputs/data.txt")
+	require.NoError(t, err)
+	volume, err := sp.PrepareStorage(context.Background(), spec)
+	require.NoError(t, err)
+	require.Equal(t, testContent, readVolume(t, volume))
+	require.Equal(t, []string{"GET ", "GET bytes=10-", "GET bytes=10-"}, flaky.requests)
+}
+
+func TestPrepareStorageErrors(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte(testContent))
+	}))
+	defer ts.Close()
+	sp := newTestProvider(t)
+	sp.MaxRetries = 2
+	ctx := context.Background()
+
+	// not worth retrying
+	_, err := sp.PrepareStorage(ctx, model.StorageSpec{Engine: model.StorageSourceURLDownload, URL: ts.URL + "/missing"})
+	require.Error(t, err)
+	require.Equal(t, 1, requests)
+
+	requests = 0
+	_, err = sp.PrepareStorage(ctx, model.StorageSpec{Engine: model.StorageSourceURLDownload, URL: ts.URL + "/broken"})
+	require.Error(t, err)
+	require.Equal(t, 3, requests)
+
+	wrong := "sha256:" + strings.Repeat("0", 64)
+	_, err = sp.PrepareStorage(ctx, model.StorageSpec{
+		Engine:   model.StorageSourceURLDownload,
+		URL:      ts.URL + "/data.txt",
+		Metadata: map[string]string{ChecksumMetadataKey: wrong},
+	})
+	require.ErrorContains(t, err, "checksum mismatch")
+
+	sp.RequireChecksum = true
+	_, err = sp.PrepareStorage(ctx, model.StorageSpec{Engine: model.StorageSourceURLDownload, URL: ts.URL + "/data.txt"})
+	require.Error(t, err)
+	volume, err := sp.PrepareStorage(ctx, model.StorageSpec{
+		Engine:   model.StorageSourceURLDownload,
+		URL:      ts.URL + "/data.txt",
+		Metadata: map[string]string{ChecksumMetadataKey: testChecksum},
+	})
+	require.NoError(t, err)
+	require.Equal(t, testContent, readVolume(t, volume))
+	require.NoError(t, sp.CleanupStorage(ctx, model.StorageSpec{}, volume))
+
+	// nothing left behind by the failures
+	entries, err := os.ReadDir(sp.LocalDir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+// a server with some lists of its files, which counts how often each
+// path is asked for
+func newListServer(t *testing.T) (*httptest.Server, map[string]int) {
+	var ts *httptest.Server
+	var mu sync.Mutex
+	requests := map[string]int{}
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.Method+" "+r.URL.Path]++
+		mu.Unlock()
+		var content string
+		switch r.URL.Path {
+		case "/a.txt", "/other/a.txt":
+			content = "a"
+		case "/b.txt":
+			content = "bb"
+		case "/urls.txt":
+			content = "# some files\n" + ts.URL + "/a.txt\n\n" + ts.URL + "/b.txt\n"
+		case "/clash.txt":
+			content = ts.URL + "/a.txt\n" + ts.URL + "/other/a.txt\n"
+		case "/manifest.json":
+			content = fmt.Sprintf(`[
+				{"url": "%[1]s/a.txt", "path": "../../first/a.txt", "checksum": "sha256:ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb"},
+				{"url": "%[1]s/other/a.txt", "path": "second/a.txt"},
+				{"url": "%[1]s/b.txt"}
+			]`, ts.URL)
+		case "/huge.txt":
+			// a list that never ends
+			w.WriteHeader(http.StatusOK)
+			line := []byte(ts.URL + "/a.txt\n")
+			for written := 0; written <= maxListSize; written += len(line) {
+				if _, err := w.Write(line); err != nil {
+					return
+				}
+			}
+			return
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+	}))
+	t.Cleanup(ts.Close)
+	return ts, requests
+}
+
+func TestGetVolumeSize(t *testing.T) {
+	ts, _ := newListServer(t)
+	sp := newTestProvider(t)
+	ctx := context.Background()
+
+	size, err := sp.GetVolumeSize(ctx, model.StorageSpec{Engine: model.StorageSourceURLDownload, URL: ts.URL + "/b.txt"})
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), size)
+
+	spec, err := NewStorageSpec(ts.URL+"/urls.txt#explode=lines", "/inputs")
+	require.NoError(t, err)
+	size, err = sp.GetVolumeSize(ctx, spec)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), size)
+}
+
+func TestExplode(t *testing.T) {
+	ts, _ := newListServer(t)
+	sp := newTestProvider(t)
+	ctx := context.Background()
+
+	single := model.StorageSpec{
+		Engine:   model.StorageSourceURLDownload,
+		URL:      ts.URL + "/a.txt",
+		Path:     "/inputs/a.txt",
+		Metadata: map[string]string{ChecksumMetadataKey: testChecksum},
+	}
+	specs, err := sp.Explode(ctx, single)
+	require.NoError(t, err)
+	require.Equal(t, []model.StorageSpec{single}, specs)
+
+	spec, err := NewStorageSpec(ts.URL+"/urls.txt#explode=lines", "/inputs/")
+	require.NoError(t, err)
+	specs, err = sp.Explode(ctx, spec)
+	require.NoError(t, err)
+	require.Len(t, specs, 2)
+	require.Equal(t, ts.URL+"/a.txt", specs[0].URL)
+	require.Equal(t, "/inputs/a.txt", specs[0].Path)
+	require.Equal(t, "/inputs/b.txt", specs[1].Path)
+
+	// the job gets a folder of every file in the list if it isn't sharded
+	volume, err := sp.PrepareStorage(ctx, spec)
+	require.NoError(t, err)
+	content, err := os.ReadFile(filepath.Join(volume.Source, "b.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "bb", string(content))
+
+	spec, err = NewStorageSpec(ts.URL+"/manifest.json#explode=manifest", "/inputs")
+	require.NoError(t, err)
+	specs, err = sp.Explode(ctx, spec)
+	require.NoError(t, err)
+	paths := []string{}
+	for _, exploded := range specs {
+		paths = append(paths, exploded.Path)
+		volume, err := sp.PrepareStorage(ctx, exploded)
+		require.NoError(t, err)
+		require.NoError(t, sp.CleanupStorage(ctx, exploded, volume))
+	}
+	require.Equal(t, []string{"/inputs/first/a.txt", "/inputs/second/a.txt", "/inputs/b.txt"}, paths)
+	require.NotEmpty(t, specs[0].Metadata[ChecksumMetadataKey])
+
+	spec, err = NewStorageSpec(ts.URL+"/clash.txt#explode=lines", "/inputs")
+	require.NoError(t, err)
+	_, err = sp.Explode(ctx, spec)
+	require.Error(t, err)
+
+	spec, err = NewStorageSpec(ts.URL+"/huge.txt#explode=lines", "/inputs")
+	require.NoError(t, err)
+	_, err = sp.Explode(ctx, spec)
+	require.ErrorContains(t, err, "bigger than 10485760 bytes")
+}
+
+func TestListIsFetchedOnce(t *testing.T) {
+	ts, requests := newListServer(t)
+	sp := newTestProvider(t)
+	ctx := context.Background()
+
+	spec, err := NewStorageSpec(ts.URL+"/urls.txt#explode=lines", "/inputs")
+	require.NoError(t, err)
+	_, err = sp.GetVolumeSize(ctx, spec)
+	require.NoError(t, err)
+	_, err = sp.Explode(ctx, spec)
+	require.NoError(t, err)
+	volume, err := sp.PrepareStorage(ctx, spec)
+	require.NoError(t, err)
+	require.NoError(t, sp.CleanupStorage(ctx, spec, volume))
+	require.Equal(t, 1, requests["GET /urls.txt"])
+
+	// until it is old enough to fetch again
+	for key, list := range sp.lists {
+		list.fetched = list.fetched.Add(-listCacheTTL - time.Second)
+		sp.lists[key] = list
+	}
+	_, err = sp.Explode(ctx, spec)
+	require.NoError(t, err)
+	require.Equal(t, 2, requests["GET /urls.txt"])
+}
+
+func TestListRequiresChecksum(t *testing.T) {
+	ts, _ := newListServer(t)
+	sp := newTestProvider(t)
+	sp.RequireChecksum = true
+	ctx := context.Background()
+
+	// whatever the URLs in it have, the list needs a checksum of its own
+	spec, err := NewStorageSpec(ts.URL+"/manifest.json#explode=manifest", "/inputs")
+	require.NoError(t, err)
+	_, err = sp.Explode(ctx, spec)
+	require.ErrorContains(t, err, "has no checksum")
+}
+
+func TestNewStorageSpec(t *testing.T) {
+	spec, err := NewStorageSpec("https://example.com/data.csv#sha256=ABC"+strings.Repeat("0", 61), "/inputs")
+	require.NoError(t, err)
+	require.Equal(t, "https://example.com/data.csv", spec.URL)
+	require.Equal(t, "sha256:ABC"+strings.Repeat("0", 61), spec.Metadata[ChecksumMetadataKey])
+
+	spec, err = NewStorageSpec("https://example.com/data.tar.gz#extract=auto&sha1="+strings.Repeat("a", 40), "/inputs")
+	require.NoError(t, err)
+	require.Equal(t, "auto", spec.Metadata[archive.ExtractMetadataKey])
+	require.Equal(t, "sha1:"+strings.Repeat("a", 40), spec.Metadata[ChecksumMetadataKey])
+
+	spec, err = NewStorageSpec("https://example.com/urls.txt", "/inputs")
+	require.NoError(t, err)
+	require.Nil(t, spec.Metadata)
+
+	for _, invalid := range []string{
+		"https://example.com/data.csv#sha256=abc",
+		"https://example.com/data.csv#crc32=abcd1234",
+		"https://example.com/urls.txt#explode=everything",
+		"https://example.com/data.rar#extract=rar",
+		"ftp://example.com/data.csv",
+	} {
+		_, err = NewStorageSpec(invalid, "/inputs")
+		require.Error(t, err, invalid)
+	}
+}
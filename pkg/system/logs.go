@@ -0,0 +1,84 @@
+package system
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultMaxLogSize is how much of each of stdout and stderr
+// is kept when nothing else is configured
+const DefaultMaxLogSize int64 = 100 * 1024 * 1024
+
+// LogsTruncatedFilename is the file in a results folder that lists
+// the logs that hit their size limit, one per line - it is only
+// there if something was truncated
+const LogsTruncatedFilename = "logsTruncated"
+
+// LimitedWriter writes up to max bytes to the underlying writer then a
+// note saying the rest was dropped. It keeps reporting writes as successful
+// so whatever is writing to it, like a running job, carries on.
+type LimitedWriter struct {
+	w         io.Writer
+	name      string
+	remaining int64
+	truncated bool
+}
+
+func NewLimitedWriter(w io.Writer, name string, max int64) *LimitedWriter {
+	return &LimitedWriter{w: w, name: name, remaining: max}
+}
+
+func (l *LimitedWriter) Write(p []byte) (int, error) {
+	if l.truncated {
+		return len(p), nil
+	}
+	if int64(len(p)) <= l.remaining {
+		n, err := l.w.Write(p)
+		l.remaining -= int64(n)
+		return n, err
+	}
+	n, err := l.w.Write(p[:l.remaining])
+	l.remaining -= int64(n)
+	if err != nil {
+		return n, err
+	}
+	l.truncated = true
+	_, err = fmt.Fprintf(l.w, "\n[%s truncated, it was longer than the limit]\n", l.name)
+	if err != nil {
+		return n, err
+	}
+	return len(p), nil
+}
+
+// Truncated is true once something written has been dropped
+func (l *LimitedWriter) Truncated() bool {
+	return l.truncated
+}
+
+// WriteLogsTruncated records which logs in the results folder were
+// truncated - it writes nothing if none were
+func WriteLogsTruncated(resultsDir string, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	return os.WriteFile(
+		filepath.Join(resultsDir, LogsTruncatedFilename),
+		[]byte(strings.Join(names, "\n")+"\n"),
+		0644, //nolint:gomnd
+	)
+}
+
+// ReadLogsTruncated returns which logs in the results folder were truncated
+func ReadLogsTruncated(resultsDir string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(resultsDir, LogsTruncatedFilename))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(data)), nil
+}
@@ -0,0 +1,51 @@
+package system
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimitedWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewLimitedWriter(&buf, "stdout", 10)
+
+	n, err := w.Write([]byte("hello "))
+	require.NoError(t, err)
+	require.Equal(t, 6, n)
+	require.False(t, w.Truncated())
+
+	// the job is told everything was written so it carries on
+	n, err = w.Write([]byte("world, again"))
+	require.NoError(t, err)
+	require.Equal(t, 12, n)
+	require.True(t, w.Truncated())
+
+	n, err = w.Write([]byte("more"))
+	require.NoError(t, err)
+	require.Equal(t, 4, n)
+	require.Equal(t, "hello worl\n[stdout truncated, it was longer than the limit]\n", buf.String())
+}
+
+func TestLimitedWriterExactlyAtLimit(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewLimitedWriter(&buf, "stderr", 5)
+	_, err := w.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.False(t, w.Truncated())
+	require.Equal(t, "hello", buf.String())
+}
+
+func TestLogsTruncated(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, WriteLogsTruncated(dir, nil))
+	truncated, err := ReadLogsTruncated(dir)
+	require.NoError(t, err)
+	require.Empty(t, truncated)
+
+	require.NoError(t, WriteLogsTruncated(dir, []string{"stdout", "stderr"}))
+	truncated, err = ReadLogsTruncated(dir)
+	require.NoError(t, err)
+	require.Equal(t, []string{"stdout", "stderr"}, truncated)
+}
@@ -0,0 +1,35 @@
+package system
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// RefuseNonPublicAddress is a net.Dialer Control func that stops us
+// connecting to the node itself or anything on its network - it checks
+// the address after the name has been resolved, which also stops DNS
+// rebinding
+func RefuseNonPublicAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !IsPublicIP(ip) {
+		return fmt.Errorf("will not connect to non-public address %s", host)
+	}
+	return nil
+}
+
+// IsPublicIP is false for loopback, private, link local, multicast and
+// unspecified addresses
+func IsPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() ||
+		ip.IsPrivate() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() ||
+		ip.IsUnspecified())
+}
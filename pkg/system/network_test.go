@@ -0,0 +1,28 @@
+package system
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsPublicIP(t *testing.T) {
+	for _, address := range []string{"1.1.1.1", "140.82.112.3", "2606:4700:4700::1111"} {
+		require.True(t, IsPublicIP(net.ParseIP(address)), address)
+	}
+	for _, address := range []string{
+		"127.0.0.1", "::1", "10.0.0.1", "172.17.0.1", "192.168.1.1", "fd00::1",
+		"169.254.169.254", "fe80::1", "224.0.0.1", "ff02::1", "0.0.0.0", "::",
+		"::ffff:127.0.0.1",
+	} {
+		require.False(t, IsPublicIP(net.ParseIP(address)), address)
+	}
+}
+
+func TestRefuseNonPublicAddress(t *testing.T) {
+	require.NoError(t, RefuseNonPublicAddress("tcp", "1.1.1.1:443", nil))
+	require.Error(t, RefuseNonPublicAddress("tcp", "127.0.0.1:1234", nil))
+	require.Error(t, RefuseNonPublicAddress("tcp", "[::1]:1234", nil))
+	require.Error(t, RefuseNonPublicAddress("tcp", "not-an-address", nil))
+}
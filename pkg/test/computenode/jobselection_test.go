@@ -10,6 +10,7 @@ import (
 	"github.com/filecoin-project/bacalhau/pkg/computenode"
 	"github.com/filecoin-project/bacalhau/pkg/config"
 	noop_executor "github.com/filecoin-project/bacalhau/pkg/executor/noop"
+	jobutils "github.com/filecoin-project/bacalhau/pkg/job"
 	_ "github.com/filecoin-project/bacalhau/pkg/logger"
 	"github.com/filecoin-project/bacalhau/pkg/system"
 	testutils "github.com/filecoin-project/bacalhau/pkg/test/utils"
@@ -196,3 +197,68 @@ func (suite *ComputeNodeJobSelectionSuite) TestJobSelectionEmptySpec() {
 	})
 	require.Error(suite.T(), err)
 }
+
+// TestJobSelectionNodeSelectors tests that a node only selects jobs
+// whose node selectors match the node labels
+func (suite *ComputeNodeJobSelectionSuite) TestJobSelectionNodeSelectors() {
+	ctx := context.Background()
+	runTest := func(selector string, expectedResult bool) {
+		stack := testutils.NewNoopStack(ctx, suite.T(), computenode.ComputeNodeConfig{
+			Labels: map[string]string{
+				"region": "eu",
+				"tier":   "trusted",
+			},
+		}, noop_executor.ExecutorConfig{})
+		computeNode, cm := stack.Node.ComputeNode, stack.Node.CleanupManager
+		defer cm.Cleanup()
+
+		nodeSelectors, err := jobutils.ParseNodeSelector(selector)
+		require.NoError(suite.T(), err)
+
+		probeData := GetProbeData("")
+		probeData.Spec.NodeSelectors = nodeSelectors
+
+		result, _, err := computeNode.SelectJob(ctx, probeData)
+		require.NoError(suite.T(), err)
+		require.Equal(suite.T(), expectedResult, result, selector)
+	}
+
+	runTest("", true)
+	runTest("region=eu", true)
+	runTest("region=eu,tier=trusted", true)
+	runTest("region in (us, eu)", true)
+	runTest("region=us", false)
+	runTest("region=eu,tier!=trusted", false)
+	runTest("gpu", false)
+	runTest("!gpu", true)
+}
+
+// TestJobSelectionDraining tests that a draining node does not select
+// any new jobs until it is undrained
+func (suite *ComputeNodeJobSelectionSuite) TestJobSelectionDraining() {
+	ctx := context.Background()
+	stack := testutils.NewNoopStack(ctx, suite.T(), computenode.ComputeNodeConfig{}, noop_executor.ExecutorConfig{})
+	computeNode, cm := stack.Node.ComputeNode, stack.Node.CleanupManager
+	defer cm.Cleanup()
+
+	result, _, err := computeNode.SelectJob(ctx, GetProbeData(""))
+	require.NoError(suite.T(), err)
+	require.True(suite.T(), result)
+
+	status := computeNode.Drain(ctx)
+	require.True(suite.T(), status.Draining)
+	require.True(suite.T(), status.SafeToShutdown)
+	require.True(suite.T(), computeNode.GetNodeInfo(ctx).Draining)
+
+	result, _, err = computeNode.SelectJob(ctx, GetProbeData(""))
+	require.NoError(suite.T(), err)
+	require.False(suite.T(), result)
+
+	status = computeNode.Undrain(ctx)
+	require.False(suite.T(), status.Draining)
+	require.False(suite.T(), status.SafeToShutdown)
+
+	result, _, err = computeNode.SelectJob(ctx, GetProbeData(""))
+	require.NoError(suite.T(), err)
+	require.True(suite.T(), result)
+}
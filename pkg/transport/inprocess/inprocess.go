@@ -16,10 +16,11 @@ import (
 // Transport is a transport layer that operates entirely in-memory, for
 // testing purposes. Should not be used in production.
 type InProcessTransport struct {
-	id                 string
-	subscribeFunctions []transport.SubscribeFn
-	seenEvents         []model.JobEvent
-	mutex              sync.Mutex
+	id                         string
+	subscribeFunctions         []transport.SubscribeFn
+	nodeInfoSubscribeFunctions []transport.NodeInfoSubscribeFn
+	seenEvents                 []model.JobEvent
+	mutex                      sync.Mutex
 }
 
 /*
@@ -89,6 +90,21 @@ func (t *InProcessTransport) Subscribe(ctx context.Context, fn transport.Subscri
 	t.subscribeFunctions = append(t.subscribeFunctions, fn)
 }
 
+func (t *InProcessTransport) PublishNodeInfo(ctx context.Context, info model.NodeInfo) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for _, fn := range t.nodeInfoSubscribeFunctions {
+		go fn(ctx, info)
+	}
+	return nil
+}
+
+func (t *InProcessTransport) SubscribeNodeInfo(ctx context.Context, fn transport.NodeInfoSubscribeFn) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.nodeInfoSubscribeFunctions = append(t.nodeInfoSubscribeFunctions, fn)
+}
+
 /*
 encrypt / decrypt
 */
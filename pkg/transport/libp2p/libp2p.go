@@ -31,19 +31,23 @@ import (
 )
 
 const JobEventChannel = "bacalhau-job-event"
+const NodeInfoChannel = "bacalhau-node-info"
 
 type LibP2PTransport struct {
 	// Cleanup manager for resource teardown on exit:
 	cm *system.CleanupManager
 
-	subscribeFunctions   []transport.SubscribeFn
-	mutex                sync.RWMutex
-	host                 host.Host
-	peers                []multiaddr.Multiaddr
-	pubSub               *pubsub.PubSub
-	jobEventTopic        *pubsub.Topic
-	jobEventSubscription *pubsub.Subscription
-	privateKey           crypto.PrivKey
+	subscribeFunctions         []transport.SubscribeFn
+	nodeInfoSubscribeFunctions []transport.NodeInfoSubscribeFn
+	mutex                      sync.RWMutex
+	host                       host.Host
+	peers                      []multiaddr.Multiaddr
+	pubSub                     *pubsub.PubSub
+	jobEventTopic              *pubsub.Topic
+	jobEventSubscription       *pubsub.Subscription
+	nodeInfoTopic              *pubsub.Topic
+	nodeInfoSubscription       *pubsub.Subscription
+	privateKey                 crypto.PrivKey
 }
 
 func NewTransport(ctx context.Context, cm *system.CleanupManager, port int, peers []multiaddr.Multiaddr) (*LibP2PTransport, error) {
@@ -105,15 +109,28 @@ func NewTransportFromOptions(ctx context.Context,
 		return nil, err
 	}
 
+	nodeInfoTopic, err := ps.Join(NodeInfoChannel)
+	if err != nil {
+		return nil, err
+	}
+
+	nodeInfoSubscription, err := nodeInfoTopic.Subscribe()
+	if err != nil {
+		return nil, err
+	}
+
 	libp2pTransport := &LibP2PTransport{
-		cm:                   cm,
-		subscribeFunctions:   []transport.SubscribeFn{},
-		host:                 h,
-		peers:                peers,
-		privateKey:           prvKey,
-		pubSub:               ps,
-		jobEventTopic:        jobEventTopic,
-		jobEventSubscription: jobEventSubscription,
+		cm:                         cm,
+		subscribeFunctions:         []transport.SubscribeFn{},
+		nodeInfoSubscribeFunctions: []transport.NodeInfoSubscribeFn{},
+		host:                       h,
+		peers:                      peers,
+		privateKey:                 prvKey,
+		pubSub:                     ps,
+		jobEventTopic:              jobEventTopic,
+		jobEventSubscription:       jobEventSubscription,
+		nodeInfoTopic:              nodeInfoTopic,
+		nodeInfoSubscription:       nodeInfoSubscription,
 	}
 
 	libp2pTransport.mutex.EnableTracerWithOpts(sync.Opts{
@@ -168,6 +185,7 @@ func (t *LibP2PTransport) Start(ctx context.Context) error {
 	}
 
 	go t.listenForEvents(ctx)
+	go t.listenForNodeInfo(ctx)
 
 	log.Trace().Msg("Libp2p transport has started")
 
@@ -204,6 +222,29 @@ func (t *LibP2PTransport) Subscribe(ctx context.Context, fn transport.SubscribeF
 	t.subscribeFunctions = append(t.subscribeFunctions, fn)
 }
 
+func (t *LibP2PTransport) PublishNodeInfo(ctx context.Context, info model.NodeInfo) error {
+	ctx, span := system.GetTracer().Start(ctx, "pkg/transport/libp2p.PublishNodeInfo")
+	defer span.End()
+
+	bs, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	log.Trace().Msgf("Sending node info: %s", string(bs))
+	return t.nodeInfoTopic.Publish(ctx, bs)
+}
+
+func (t *LibP2PTransport) SubscribeNodeInfo(ctx context.Context, fn transport.NodeInfoSubscribeFn) {
+	//nolint:ineffassign,staticcheck
+	ctx, span := system.GetTracer().Start(ctx, "pkg/transport/libp2p.SubscribeNodeInfo")
+	defer span.End()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.nodeInfoSubscribeFunctions = append(t.nodeInfoSubscribeFunctions, fn)
+}
+
 func (t *LibP2PTransport) Encrypt(ctx context.Context, data, libp2pKeyBytes []byte) ([]byte, error) {
 	//nolint:ineffassign,staticcheck
 	ctx, span := system.GetTracer().Start(ctx, "pkg/transport/libp2p.Encrypt")
@@ -323,8 +364,6 @@ func (t *LibP2PTransport) writeJobEvent(ctx context.Context, event model.JobEven
 }
 
 func (t *LibP2PTransport) readMessage(msg *pubsub.Message) {
-	// TODO: we would enforce the claims to SourceNodeID here
-	// i.e. msg.ReceivedFrom() should match msg.Data.JobEvent.SourceNodeID
 	payload := jobEventEnvelope{}
 	err := json.Unmarshal(msg.Data, &payload)
 	if err != nil {
@@ -332,6 +371,15 @@ func (t *LibP2PTransport) readMessage(msg *pubsub.Message) {
 		return
 	}
 
+	// pubsub messages are signed by their author, so a node can only
+	// publish events as itself - who gets a job's secrets and which
+	// checkpoints it resumes from both depend on who sent an event
+	if msg.GetFrom().String() != payload.JobEvent.SourceNodeID {
+		log.Warn().Msgf("dropping %s event from %s signed by %s",
+			payload.JobEvent.EventName, payload.JobEvent.SourceNodeID, msg.GetFrom())
+		return
+	}
+
 	now := time.Now()
 	then := payload.SentTime
 	latency := now.Sub(then)
@@ -402,5 +450,44 @@ func (t *LibP2PTransport) listenForEvents(ctx context.Context) {
 	}
 }
 
+func (t *LibP2PTransport) readNodeInfo(msg *pubsub.Message) {
+	info := model.NodeInfo{}
+	err := json.Unmarshal(msg.Data, &info)
+	if err != nil {
+		log.Error().Msgf("error unmarshalling libp2p node info: %v", err)
+		return
+	}
+
+	// pubsub messages are signed by their author so we can check
+	// that the node is only advertising itself
+	if msg.GetFrom().String() != info.NodeID {
+		log.Warn().Msgf("dropping node info for %s signed by %s", info.NodeID, msg.GetFrom())
+		return
+	}
+	info.SenderPublicKey = msg.Key
+
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	for _, fn := range t.nodeInfoSubscribeFunctions {
+		go fn(context.Background(), info)
+	}
+}
+
+func (t *LibP2PTransport) listenForNodeInfo(ctx context.Context) {
+	for {
+		msg, err := t.nodeInfoSubscription.Next(ctx)
+		if err != nil {
+			if err == context.Canceled || err == context.DeadlineExceeded {
+				log.Trace().Msgf("libp2p transport shutting down: %v", err)
+			} else {
+				log.Error().Msgf(
+					"libp2p encountered an unexpected error, shutting down: %v", err)
+			}
+			return
+		}
+		go t.readNodeInfo(msg)
+	}
+}
+
 // Compile-time interface check:
 var _ transport.Transport = (*LibP2PTransport)(nil)
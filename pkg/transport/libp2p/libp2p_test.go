@@ -3,9 +3,11 @@ package libp2p
 import (
 	"context"
 	"fmt"
+	"sync"
 	"testing"
 	"time"
 
+	jobutils "github.com/filecoin-project/bacalhau/pkg/job"
 	_ "github.com/filecoin-project/bacalhau/pkg/logger"
 	"github.com/filecoin-project/bacalhau/pkg/model"
 	"github.com/filecoin-project/bacalhau/pkg/system"
@@ -98,3 +100,113 @@ func (suite *Libp2pTransportSuite) TestEncryption() {
 	})
 	require.NoError(suite.T(), err)
 }
+
+// start a transport that records the job events it hears, connected to peers
+func (suite *Libp2pTransportSuite) startRecordingTransport(
+	ctx context.Context,
+	cm *system.CleanupManager,
+	peers []multiaddr.Multiaddr,
+) (*LibP2PTransport, multiaddr.Multiaddr, func() []model.JobEvent) {
+	port, err := freeport.GetFreePort()
+	require.NoError(suite.T(), err)
+	tx, err := NewTransport(ctx, cm, port, peers)
+	require.NoError(suite.T(), err)
+	addr, err := multiaddr.NewMultiaddr(fmt.Sprintf("/ip4/127.0.0.1/tcp/%d/p2p/%s", port, tx.HostID()))
+	require.NoError(suite.T(), err)
+
+	var mu sync.Mutex
+	events := []model.JobEvent{}
+	tx.Subscribe(ctx, func(ctx context.Context, ev model.JobEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, ev)
+	})
+	require.NoError(suite.T(), tx.Start(ctx))
+	return tx, addr, func() []model.JobEvent {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]model.JobEvent{}, events...)
+	}
+}
+
+// a node can't publish events in another node's name, e.g. to have the
+// secrets for a shard sealed to its own key
+func (suite *Libp2pTransportSuite) TestForgedSourceIsDropped() {
+	cm := system.NewCleanupManager()
+	defer cm.Cleanup()
+	ctx := context.Background()
+
+	requester, requesterAddr, received := suite.startRecordingTransport(ctx, cm, nil)
+	accepted, _, _ := suite.startRecordingTransport(ctx, cm, []multiaddr.Multiaddr{requesterAddr})
+	attacker, _, _ := suite.startRecordingTransport(ctx, cm, []multiaddr.Multiaddr{requesterAddr})
+
+	time.Sleep(time.Second * 1)
+
+	require.NoError(suite.T(), attacker.Publish(ctx, model.JobEvent{
+		EventName:        model.JobEventSecretsRequested,
+		SourceNodeID:     accepted.HostID(),
+		TargetNodeID:     requester.HostID(),
+		SecretsPublicKey: []byte("forged"),
+	}))
+	require.NoError(suite.T(), attacker.Publish(ctx, model.JobEvent{
+		EventName:        model.JobEventSecretsRequested,
+		SourceNodeID:     attacker.HostID(),
+		TargetNodeID:     requester.HostID(),
+		SecretsPublicKey: []byte("genuine"),
+	}))
+
+	require.Eventually(suite.T(), func() bool {
+		for _, ev := range received() {
+			if string(ev.SecretsPublicKey) == "genuine" {
+				return true
+			}
+		}
+		return false
+	}, 10*time.Second, 100*time.Millisecond)
+	for _, ev := range received() {
+		require.NotEqual(suite.T(), "forged", string(ev.SecretsPublicKey))
+	}
+}
+
+// a node can't publish a checkpoint as if the accepted node had, so the
+// next node to run the shard never resumes from it
+func (suite *Libp2pTransportSuite) TestForgedCheckpointIsDropped() {
+	cm := system.NewCleanupManager()
+	defer cm.Cleanup()
+	ctx := context.Background()
+
+	requester, requesterAddr, received := suite.startRecordingTransport(ctx, cm, nil)
+	accepted, _, _ := suite.startRecordingTransport(ctx, cm, []multiaddr.Multiaddr{requesterAddr})
+	attacker, _, _ := suite.startRecordingTransport(ctx, cm, []multiaddr.Multiaddr{requesterAddr})
+	j := model.Job{ID: "job", RequesterNodeID: requester.HostID()}
+
+	time.Sleep(time.Second * 1)
+
+	require.NoError(suite.T(), requester.Publish(ctx, model.JobEvent{
+		JobID:        j.ID,
+		EventName:    model.JobEventBidAccepted,
+		SourceNodeID: requester.HostID(),
+		TargetNodeID: accepted.HostID(),
+	}))
+	// both claim to come from the accepted node
+	checkpoint := func(cid string) model.JobEvent {
+		return model.JobEvent{
+			JobID:        j.ID,
+			EventName:    model.JobEventCheckpointPublished,
+			SourceNodeID: accepted.HostID(),
+			Checkpoint:   &model.StorageSpec{Engine: model.StorageSourceIPFS, Cid: cid},
+		}
+	}
+	require.NoError(suite.T(), attacker.Publish(ctx, checkpoint("poisoned")))
+	require.NoError(suite.T(), accepted.Publish(ctx, checkpoint("genuine")))
+
+	require.Eventually(suite.T(), func() bool {
+		latest := jobutils.LatestCheckpoint(j, received(), 0)
+		return latest != nil && latest.Cid == "genuine"
+	}, 10*time.Second, 100*time.Millisecond)
+	for _, ev := range received() {
+		if ev.Checkpoint != nil {
+			require.NotEqual(suite.T(), "poisoned", ev.Checkpoint.Cid)
+		}
+	}
+}
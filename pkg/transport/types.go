@@ -10,6 +10,10 @@ import (
 // SubscribeFn is provided by an in-process listener as an event callback.
 type SubscribeFn func(context.Context, model.JobEvent)
 
+// NodeInfoSubscribeFn is provided by an in-process listener as a callback
+// for node info heartbeats.
+type NodeInfoSubscribeFn func(context.Context, model.NodeInfo)
+
 // Transport is an interface representing a communication channel between
 // nodes, through which they can submit, bid on and complete jobs.
 type Transport interface {
@@ -43,6 +47,13 @@ type Transport interface {
 	// lifetime of the process so no need for an unsubscribe right now.
 	Subscribe(ctx context.Context, fn SubscribeFn)
 
+	// This emits a node info heartbeat across the network to other nodes
+	PublishNodeInfo(ctx context.Context, info model.NodeInfo) error
+
+	// SubscribeNodeInfo registers a callback for node info heartbeats
+	// from any node, including this one.
+	SubscribeNodeInfo(ctx context.Context, fn NodeInfoSubscribeFn)
+
 	/////////////////////////////////////////////////////////////
 	/// Encrypt/Decrypt
 	/////////////////////////////////////////////////////////////
@@ -0,0 +1,60 @@
+// Package bandwidth limits how fast downloads read, with a limit carried in
+// their context so the storage providers doing the reading don't need to
+// know who asked for it
+package bandwidth
+
+import (
+	"context"
+	"io"
+	"math"
+
+	"golang.org/x/time/rate"
+)
+
+type limiterKey struct{}
+
+// NewLimiter allows bytesPerSecond, shared by every read that uses it
+func NewLimiter(bytesPerSecond uint64) *rate.Limiter {
+	burst := bytesPerSecond
+	if burst > math.MaxInt32 {
+		burst = math.MaxInt32
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), int(burst))
+}
+
+// WithLimiter returns a context whose downloads share the limiter
+func WithLimiter(ctx context.Context, limiter *rate.Limiter) context.Context {
+	if limiter == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, limiterKey{}, limiter)
+}
+
+// Reader wraps r so reads wait for the context's limiter, if it has one
+func Reader(ctx context.Context, r io.Reader) io.Reader {
+	limiter, ok := ctx.Value(limiterKey{}).(*rate.Limiter)
+	if !ok {
+		return r
+	}
+	return &limitedReader{ctx: ctx, reader: r, limiter: limiter}
+}
+
+type limitedReader struct {
+	ctx     context.Context
+	reader  io.Reader
+	limiter *rate.Limiter
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	// we can't wait for more than the limiter's burst at once
+	if len(p) > r.limiter.Burst() {
+		p = p[:r.limiter.Burst()]
+	}
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.WaitN(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
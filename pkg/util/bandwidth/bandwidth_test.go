@@ -0,0 +1,45 @@
+package bandwidth
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReaderWithoutLimiter(t *testing.T) {
+	r := bytes.NewReader([]byte("data"))
+	require.Equal(t, r, Reader(context.Background(), r))
+}
+
+func TestReaderIsLimited(t *testing.T) {
+	limiter := NewLimiter(1000)
+	ctx := WithLimiter(context.Background(), limiter)
+
+	// the first second's worth comes straight away and the rest is shared
+	// between the readers at 1000 bytes a second
+	start := time.Now()
+	errs := make(chan error)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := io.Copy(io.Discard, Reader(ctx, bytes.NewReader(make([]byte, 1000))))
+			errs <- err
+		}()
+	}
+	require.NoError(t, <-errs)
+	require.NoError(t, <-errs)
+	require.GreaterOrEqual(t, time.Since(start), 900*time.Millisecond)
+}
+
+func TestReaderStopsWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(WithLimiter(context.Background(), NewLimiter(10)))
+	r := Reader(ctx, bytes.NewReader(make([]byte, 1000)))
+	_, err := r.Read(make([]byte, 100))
+	require.NoError(t, err)
+	cancel()
+	_, err = r.Read(make([]byte, 100))
+	require.Error(t, err)
+}
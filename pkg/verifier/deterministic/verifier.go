@@ -3,7 +3,13 @@ package deterministic
 import (
 	"context"
 	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
 
+	"github.com/filecoin-project/bacalhau/pkg/executor"
 	"github.com/filecoin-project/bacalhau/pkg/job"
 	"github.com/filecoin-project/bacalhau/pkg/model"
 	"github.com/filecoin-project/bacalhau/pkg/system"
@@ -62,7 +68,7 @@ func (deterministicVerifier *DeterministicVerifier) GetShardProposal(
 	if len(job.RequesterPublicKey) == 0 {
 		return nil, errors.New("no RequesterPublicKey found in the job")
 	}
-	dirHash, err := dirhash.HashDir(shardResultPath, "results", dirhash.Hash1)
+	dirHash, err := hashResults(shardResultPath)
 	if err != nil {
 		return nil, err
 	}
@@ -73,6 +79,25 @@ func (deterministicVerifier *DeterministicVerifier) GetShardProposal(
 	return encryptedHash, nil
 }
 
+// hash everything in the results folder apart from the resource usage
+// which is different every time the shard runs
+func hashResults(shardResultPath string) (string, error) {
+	const prefix = "results"
+	files, err := dirhash.DirFiles(shardResultPath, prefix)
+	if err != nil {
+		return "", err
+	}
+	hashFiles := []string{}
+	for _, file := range files {
+		if file != path.Join(prefix, executor.ResourceUsageFilename) {
+			hashFiles = append(hashFiles, file)
+		}
+	}
+	return dirhash.Hash1(hashFiles, func(name string) (io.ReadCloser, error) {
+		return os.Open(filepath.Join(shardResultPath, strings.TrimPrefix(name, prefix)))
+	})
+}
+
 // each shard must have >= concurrency states
 // and they must be either JobStateError or JobStateVerifying
 func (deterministicVerifier *DeterministicVerifier) IsExecutionComplete(
@@ -0,0 +1,30 @@
+package deterministic
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/filecoin-project/bacalhau/pkg/executor"
+	"github.com/filecoin-project/bacalhau/pkg/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashResultsIgnoresResourceUsage(t *testing.T) {
+	writeResults := func(stdout string, usage model.ShardResourceUsage) string {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "stdout"), []byte(stdout), 0644))
+		require.NoError(t, executor.WriteResourceUsage(dir, usage))
+		return dir
+	}
+
+	first, err := hashResults(writeResults("hello", model.ShardResourceUsage{WallTime: 1}))
+	require.NoError(t, err)
+	second, err := hashResults(writeResults("hello", model.ShardResourceUsage{WallTime: 2}))
+	require.NoError(t, err)
+	different, err := hashResults(writeResults("goodbye", model.ShardResourceUsage{WallTime: 1}))
+	require.NoError(t, err)
+
+	require.Equal(t, first, second)
+	require.NotEqual(t, first, different)
+}